@@ -0,0 +1,70 @@
+package btree
+
+import (
+	"sort"
+	"time"
+
+	"my-go-db/storage"
+)
+
+// dump walks the tree of the last commit before it was opened without
+// holding the lock; the pages freed meanwhile are not reused until it is
+// closed.
+type dump struct {
+	db  *DB
+	c   *cursor
+	now int64
+}
+
+// OpenDump captures the live keys, so writes go on while they are dumped.
+func (db *DB) OpenDump() (storage.Dump, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	db.dumps++
+	return &dump{db: db, c: db.cursor(), now: time.Now().UnixNano()}, nil
+}
+
+// Each calls fn for every key live when the dump was opened, in key order.
+func (d *dump) Each(fn func(key string, item *storage.Item) error) error {
+	c := d.c
+	for c.seek(""); c.valid(); c.next() {
+		e := c.entry()
+		if !live(e, d.now) {
+			continue
+		}
+		value, err := d.db.value(e)
+		if err != nil {
+			return err
+		}
+		item := new(storage.Item)
+		if err := item.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		if err := fn(e.key, item); err != nil {
+			return err
+		}
+	}
+	return c.err
+}
+
+// Close makes the pages freed while the dumps ran reusable, once they are
+// all done.
+func (d *dump) Close() error {
+	db := d.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.dumps--; db.dumps == 0 {
+		db.free = append(db.free, db.held...)
+		db.held = nil
+		sort.Slice(db.free, func(i, j int) bool { return db.free[i] < db.free[j] })
+	}
+	return nil
+}
+
+// Restore stores item under key, keeping its expiration.
+func (db *DB) Restore(key string, item *storage.Item) error {
+	return db.put(key, item)
+}
@@ -0,0 +1,632 @@
+// Package btree is a storage.Engine keeping its data in a single file
+// organized as a B+tree of fixed size pages, for read heavy workloads.
+//
+// Pages are cached by a buffer pool with LRU replacement. Updates copy the
+// changed pages to free pages and commit by writing one of two meta pages,
+// so the file always holds a consistent tree; pages released by updates are
+// tracked in a free list and reused.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"my-go-db/storage"
+)
+
+var ErrClosed = errors.New("Storage is closed")
+
+type Options struct {
+	CacheSize int  // pages kept in the buffer pool, 1024 by default
+	NoSync    bool // do not fsync commits; a crash may lose or corrupt data
+
+	Compression storage.Compression // compression of large values, none by default
+	Recovery    storage.Recovery    // what to do with a torn commit, truncate by default
+	Encryption  storage.Encryption  // encryption of the pages, none by default
+}
+
+type DB struct {
+	mu   sync.RWMutex
+	f    *os.File
+	opts Options
+	pool *bufferPool
+
+	meta          *meta
+	free          []pgid // sorted
+	freelistPages []pgid
+	// dumps counts the running dumps. Pages freed meanwhile are held back
+	// from reuse, as the dumps may still read them.
+	dumps int
+	held  []pgid
+
+	// volatile has the expiration of every key with a ttl, so DeleteExpired
+	// does not have to read the whole tree.
+	volatile map[string]int64
+	closed   bool
+}
+
+// Open opens the data file at path, creating it if needed. opts may be nil.
+// With an encryption key a plain file, or one sealed with an old key, is
+// rewritten with the current key before it is used.
+func Open(path string, opts *Options) (*DB, error) {
+	db := &DB{volatile: make(map[string]int64)}
+	if opts != nil {
+		db.opts = *opts
+	}
+	if db.opts.CacheSize <= 0 {
+		db.opts.CacheSize = 1024
+	}
+	if err := db.opts.Compression.Validate(); err != nil {
+		return nil, err
+	}
+	if err := db.opts.Recovery.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := db.opts.Encryption.Cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	db.f = f
+	sealed, err := sealedFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if sealed && c == nil {
+		f.Close()
+		return nil, storage.ErrEncrypted
+	}
+	if sealed || info.Size() == 0 {
+		db.pool = newBufferPool(f, db.opts.CacheSize, c)
+	} else {
+		db.pool = newBufferPool(f, db.opts.CacheSize, nil)
+	}
+
+	if err := db.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if c != nil && (db.pool.cipher == nil || db.pool.keyID != c.KeyID()) {
+		if err := db.reseal(path); err != nil {
+			return nil, err
+		}
+		return Open(path, opts)
+	}
+	return db, nil
+}
+
+// reseal replaces the data file at path with a copy of the tree sealed
+// with the current key, which lets old keys be retired, and closes db.
+func (db *DB) reseal(path string) error {
+	defer db.f.Close()
+	tmp := path + ".reseal"
+	os.Remove(tmp)
+	next, err := Open(tmp, &Options{NoSync: db.opts.NoSync, Encryption: db.opts.Encryption})
+	if err != nil {
+		return err
+	}
+	tx := next.begin()
+	c := db.cursor()
+	for c.seek(""); c.valid(); c.next() {
+		e := c.entry()
+		value, err := db.value(e)
+		if err == nil {
+			err = tx.put(e.key, e.expiration, value)
+		}
+		if err != nil {
+			next.Close()
+			return err
+		}
+	}
+	if c.err == nil {
+		c.err = tx.commit()
+	}
+	if c.err != nil {
+		next.Close()
+		return c.err
+	}
+	if err := next.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (db *DB) load() error {
+	info, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return db.init()
+	}
+
+	torn, old := false, false
+	var keyErr error
+	for _, id := range []pgid{0, 1} {
+		b, err := db.pool.readPage(id)
+		if errors.Is(err, storage.ErrEncryptionKey) {
+			keyErr = err
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		m, ok := decodeMeta(b)
+		if ok && (db.meta == nil || m.txid > db.meta.txid) {
+			db.meta = m
+		}
+		torn = torn || !ok
+		old = old || oldMeta(b)
+	}
+	if db.meta == nil && keyErr != nil {
+		return keyErr
+	} else if db.meta == nil && old {
+		return ErrVersion
+	} else if db.meta == nil {
+		return ErrCorrupt
+	}
+	if err := db.recoverTail(info.Size(), torn); err != nil {
+		return err
+	}
+
+	for id := db.meta.freelist; id != 0; {
+		b, err := db.pool.readPage(id)
+		if err != nil {
+			return err
+		}
+		if b[0] != pageFreelist {
+			return ErrCorrupt
+		}
+		db.freelistPages = append(db.freelistPages, id)
+		count := int(binary.LittleEndian.Uint16(b[9:]))
+		if count > freelistCapacity {
+			return ErrCorrupt
+		}
+		for j := 0; j < count; j++ {
+			db.free = append(db.free, pgid(binary.LittleEndian.Uint64(b[freelistHeaderSize+8*j:])))
+		}
+		id = pgid(binary.LittleEndian.Uint64(b[1:]))
+	}
+	sort.Slice(db.free, func(i, j int) bool { return db.free[i] < db.free[j] })
+
+	c := db.cursor()
+	for c.seek(""); c.valid(); c.next() {
+		if e := c.entry(); e.expiration > 0 {
+			db.volatile[e.key] = e.expiration
+		}
+	}
+	return c.err
+}
+
+// recoverTail handles the damage an interrupted commit leaves: a torn meta
+// page, which was skipped in favor of the previous commit, and pages past
+// the end of the tree, which are truncated. In strict mode both make Open
+// fail. A file shorter than the tree is always an error.
+func (db *DB) recoverTail(size int64, torn bool) error {
+	end := int64(db.meta.pageCount) * db.pool.slot
+	if size < end {
+		return fmt.Errorf("%w: the file has %d bytes, the tree needs %d", ErrCorrupt, size, end)
+	}
+	if db.opts.Recovery.Strict() && torn {
+		return fmt.Errorf("%w: a meta page is damaged", ErrCorrupt)
+	}
+	if size == end {
+		return nil
+	}
+	if db.opts.Recovery.Strict() {
+		return fmt.Errorf("%w: %d bytes past the end of the tree", ErrCorrupt, size-end)
+	}
+	return db.f.Truncate(end)
+}
+
+// init writes an empty tree to a new file.
+func (db *DB) init() error {
+	root := &node{id: 2, leaf: true}
+	if err := db.pool.writePage(root.id, root.encode()); err != nil {
+		return err
+	}
+	for txid := uint64(0); txid < 2; txid++ {
+		db.meta = &meta{txid: txid, root: root.id, pageCount: 3}
+		if err := db.writeMeta(db.meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMeta commits a version of the tree after all its pages are synced.
+func (db *DB) writeMeta(m *meta) error {
+	if !db.opts.NoSync {
+		if err := db.f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := db.pool.writePage(pgid(m.txid%2), m.encode()); err != nil {
+		return err
+	}
+	if !db.opts.NoSync {
+		return db.f.Sync()
+	}
+	return nil
+}
+
+func (db *DB) overflowPages(id pgid) ([]pgid, error) {
+	pages := []pgid{}
+	for id != 0 {
+		b, err := db.pool.readPage(id)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != pageOverflow {
+			return nil, ErrCorrupt
+		}
+		pages = append(pages, id)
+		id = pgid(binary.LittleEndian.Uint64(b[1:]))
+	}
+	return pages, nil
+}
+
+func (db *DB) value(e *leafEntry) ([]byte, error) {
+	if e.overflow == 0 {
+		return e.value, nil
+	}
+	data := make([]byte, 0, e.size)
+	for id := e.overflow; id != 0; {
+		b, err := db.pool.readPage(id)
+		if err != nil {
+			return nil, err
+		}
+		n := int(binary.LittleEndian.Uint16(b[9:]))
+		if b[0] != pageOverflow || n > overflowCapacity {
+			return nil, ErrCorrupt
+		}
+		data = append(data, b[overflowHeaderSize:overflowHeaderSize+n]...)
+		id = pgid(binary.LittleEndian.Uint64(b[1:]))
+	}
+	if len(data) != e.size {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+// lookupLocked returns the entry of key, or nil.
+func (db *DB) lookupLocked(key string) (*leafEntry, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	n, err := db.pool.node(db.meta.root)
+	for err == nil && !n.leaf {
+		if len(n.children) == 0 {
+			return nil, nil
+		}
+		n, err = db.pool.node(n.children[n.childIndex(key)].id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i, found := n.search(key); found {
+		return &n.entries[i], nil
+	}
+	return nil, nil
+}
+
+func live(e *leafEntry, now int64) bool {
+	return e != nil && (e.expiration == 0 || e.expiration > now)
+}
+
+func (db *DB) put(key string, item *storage.Item) error {
+	value, err := db.opts.Compression.Encode(item)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	tx := db.begin()
+	if err := tx.put(key, item.Expiration(), value); err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	if exp := item.Expiration(); exp > 0 {
+		db.volatile[key] = exp
+	} else {
+		delete(db.volatile, key)
+	}
+	return nil
+}
+
+func (db *DB) SetString(key, value string, ttl int) error {
+	if value == "" {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.String = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetInt(key string, value, ttl int) error {
+	item := storage.NewItem(ttl)
+	item.Int = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetStringSlice(key string, value []string, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.StringSlice = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetIntSlice(key string, value []int, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.IntSlice = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetStringMap(key string, value map[string]string, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.StringMap = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetIntMap(key string, value map[string]int, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.IntMap = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetBitmap(key string, value []byte, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.Bitmap = value
+	return db.put(key, item)
+}
+
+// Get returns a decoded copy of the item stored under key, or nil if it is
+// missing or expired.
+func (db *DB) Get(key string) (*storage.Item, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, err := db.lookupLocked(key)
+	if err != nil || !live(e, now) {
+		return nil, err
+	}
+	value, err := db.value(e)
+	if err != nil {
+		return nil, err
+	}
+	item := new(storage.Item)
+	if err := item.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CompressionStats returns how the value of key is stored, or nil if the
+// key does not exist.
+func (db *DB) CompressionStats(key string) (*storage.CompressionStats, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, err := db.lookupLocked(key)
+	if err != nil || !live(e, now) {
+		return nil, err
+	}
+	value, err := db.value(e)
+	if err != nil {
+		return nil, err
+	}
+	stats := storage.EncodingStats(value)
+	return &stats, nil
+}
+
+func (db *DB) Remove(key string) error {
+	now := time.Now().UnixNano()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.lookupLocked(key)
+	if err != nil {
+		return err
+	}
+	if !live(e, now) {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	tx := db.begin()
+	if err := tx.delete(key); err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	delete(db.volatile, key)
+	return nil
+}
+
+func (db *DB) Keys() ([]string, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	keys := []string{}
+	c := db.cursor()
+	for c.seek(""); c.valid(); c.next() {
+		if e := c.entry(); live(e, now) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys, c.err
+}
+
+// Scan returns the keys selected by opts and the cursor of the next page,
+// which is empty when there are no more keys.
+func (db *DB) Scan(opts storage.ScanOptions) ([]string, string, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, "", ErrClosed
+	}
+
+	keys := []string{}
+	c := db.cursor()
+	if opts.Reverse {
+		c.seekLast(opts.SeekKey())
+	} else {
+		c.seek(opts.SeekKey())
+	}
+	for ; c.valid(); c.move(opts.Reverse) {
+		e := c.entry()
+		if opts.Done(e.key) {
+			break
+		}
+		if opts.Limit > 0 && len(keys) == opts.Limit {
+			return keys, keys[len(keys)-1], nil
+		}
+		if opts.Contains(e.key) && live(e, now) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys, "", c.err
+}
+
+// Expire sets a new ttl in seconds on an existing key, ttl <= 0 removes it.
+func (db *DB) Expire(key string, ttl int) error {
+	now := time.Now().UnixNano()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.lookupLocked(key)
+	if err != nil {
+		return err
+	}
+	if !live(e, now) {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	value, err := db.value(e)
+	if err != nil {
+		return err
+	}
+	item := new(storage.Item)
+	if err := item.UnmarshalBinary(value); err != nil {
+		return err
+	}
+	item.SetTTL(ttl)
+	if value, err = db.opts.Compression.Encode(item); err != nil {
+		return err
+	}
+
+	tx := db.begin()
+	if err := tx.put(key, item.Expiration(), value); err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	if exp := item.Expiration(); exp > 0 {
+		db.volatile[key] = exp
+	} else {
+		delete(db.volatile, key)
+	}
+	return nil
+}
+
+// TTL returns the remaining time to live of key in seconds, -1 if the key
+// does not expire and -2 if it does not exist.
+func (db *DB) TTL(key string) (int, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, err := db.lookupLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	if !live(e, now) {
+		return -2, nil
+	}
+	if e.expiration == 0 {
+		return -1, nil
+	}
+	return int((e.expiration - now + int64(time.Second) - 1) / int64(time.Second)), nil
+}
+
+// DeleteExpired removes the expired keys in one commit.
+func (db *DB) DeleteExpired() error {
+	now := time.Now().UnixNano()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	expired := []string{}
+	for key, exp := range db.volatile {
+		if exp <= now {
+			expired = append(expired, key)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	tx := db.begin()
+	for _, key := range expired {
+		if err := tx.delete(key); err != nil {
+			return err
+		}
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	for _, key := range expired {
+		delete(db.volatile, key)
+	}
+	return nil
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+	return db.f.Close()
+}
+
+var (
+	_ storage.Engine            = (*DB)(nil)
+	_ storage.ScanEngine        = (*DB)(nil)
+	_ storage.CompressionEngine = (*DB)(nil)
+	_ storage.BackupEngine      = (*DB)(nil)
+)
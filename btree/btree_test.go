@@ -0,0 +1,388 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"my-go-db/storage"
+	"my-go-db/storage/enginetest"
+)
+
+func open(t *testing.T, path string, opts *Options) *DB {
+	t.Helper()
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	return db
+}
+
+func TestDB_Engine(t *testing.T) {
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return open(t, filepath.Join(t.TempDir(), "btree.db"), nil)
+	})
+}
+
+// TestDB_Random compares the tree with a map over random writes and
+// removals, including values large enough for overflow pages.
+func TestDB_Random(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, &Options{NoSync: true, CacheSize: 16})
+	model := map[string]string{}
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key:%05d", r.Intn(2000))
+		if r.Intn(3) == 0 {
+			if _, ok := model[key]; ok {
+				if err := db.Remove(key); err != nil {
+					t.Fatal(err)
+				}
+				delete(model, key)
+			}
+			continue
+		}
+		value := strings.Repeat(string(rune('a'+r.Intn(26))), 1+r.Intn(100))
+		if r.Intn(20) == 0 {
+			value = strings.Repeat(value, 200)
+		}
+		if err := db.SetString(key, value, 0); err != nil {
+			t.Fatal(err)
+		}
+		model[key] = value
+	}
+
+	check := func(db *DB) {
+		for key, value := range model {
+			item, err := db.Get(key)
+			if err != nil || item == nil || item.String != value {
+				t.Fatal("Must be equal", key, err)
+			}
+		}
+		keys, _ := db.Keys()
+		if len(keys) != len(model) {
+			t.Error("Must be equal", len(model), len(keys))
+		}
+	}
+	check(db)
+	db.Close()
+
+	db = open(t, path, nil)
+	defer db.Close()
+	check(db)
+
+	for key := range model {
+		db.Remove(key)
+	}
+	keys, _ := db.Keys()
+	if len(keys) != 0 {
+		t.Error("Must be empty", len(keys))
+	}
+	if len(db.free) < int(db.meta.pageCount)/2 {
+		t.Error("Must reclaim the pages of removed keys", len(db.free), db.meta.pageCount)
+	}
+}
+
+func TestDB_ReusesPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, &Options{NoSync: true})
+	defer db.Close()
+
+	value := strings.Repeat("x", 3*pageSize)
+	for i := 0; i < 200; i++ {
+		db.SetString(fmt.Sprintf("key:%d", i%10), value, 0)
+	}
+	if db.meta.pageCount > 100 {
+		t.Error("Must reuse freed pages", db.meta.pageCount)
+	}
+}
+
+func TestDB_Scan(t *testing.T) {
+	mem := storage.New()
+	db := open(t, filepath.Join(t.TempDir(), "btree.db"), &Options{NoSync: true})
+	defer db.Close()
+	for _, i := range rand.Perm(1500) {
+		key := fmt.Sprintf("user:%04d", i)
+		mem.SetInt(key, i, 0)
+		db.SetInt(key, i, 0)
+	}
+	for _, key := range []string{"order:1", "order:2", "zzz"} {
+		mem.SetString(key, "v", 0)
+		db.SetString(key, "v", 0)
+	}
+
+	queries := []storage.ScanOptions{
+		{},
+		{Prefix: "user:01"},
+		{Prefix: "order:", Reverse: true},
+		{Start: "user:0100", End: "user:0200", Limit: 30},
+		{Start: "user:0100a", End: "user:0200", Reverse: true, Limit: 7},
+		{Prefix: "user:", Start: "user:1490", Cursor: "user:1495"},
+		{Prefix: "user:", Reverse: true, Cursor: "user:0005"},
+		{Prefix: "missing"},
+		{End: "a", Reverse: true},
+	}
+	for _, q := range queries {
+		for pages := 0; pages < 100; pages++ {
+			want, wantCursor, _ := mem.Scan(q)
+			keys, cursor, err := db.Scan(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, want) || cursor != wantCursor {
+				t.Fatalf("%+v: must be equal %v %q, got %v %q", q, want, wantCursor, keys, cursor)
+			}
+			if cursor == "" {
+				break
+			}
+			q.Cursor = cursor
+		}
+	}
+}
+
+func TestDB_TornMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, nil)
+	db.SetString("a", "1", 0)
+	db.SetString("b", "2", 0)
+	txid := db.meta.txid
+	db.Close()
+
+	// The last commit was interrupted while writing its meta page
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt([]byte("torn"), int64(txid%2)*pageSize+20)
+	f.Close()
+
+	db = open(t, path, nil)
+	defer db.Close()
+	if db.meta.txid != txid-1 {
+		t.Error("Must fall back to the previous commit", db.meta.txid)
+	}
+	if item, _ := db.Get("a"); item == nil || item.String != "1" {
+		t.Error("Must keep committed data", item)
+	}
+	if item, _ := db.Get("b"); item != nil {
+		t.Error("Must lose the interrupted commit", item)
+	}
+}
+
+func TestDB_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	os.WriteFile(path, []byte(strings.Repeat("garbage", 2000)), 0644)
+	if _, err := Open(path, nil); err != ErrCorrupt {
+		t.Error("Must return ErrCorrupt", err)
+	}
+}
+
+func TestDB_KeyTooLarge(t *testing.T) {
+	db := open(t, filepath.Join(t.TempDir(), "btree.db"), nil)
+	defer db.Close()
+	if err := db.SetString(strings.Repeat("k", maxKeySize+1), "v", 0); err != ErrKeyTooLarge {
+		t.Error("Must return ErrKeyTooLarge", err)
+	}
+}
+
+func TestDB_Compression(t *testing.T) {
+	compression := storage.Compression{Algorithm: "gzip", Threshold: 256}
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return open(t, filepath.Join(t.TempDir(), "btree.db"), &Options{Compression: compression})
+	})
+
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, &Options{Compression: compression})
+	value := strings.Repeat("compressible ", 2000)
+	db.SetString("key", value, 0)
+	db.Close()
+
+	db = open(t, path, nil)
+	defer db.Close()
+	if item, _ := db.Get("key"); item == nil || item.String != value {
+		t.Error("Must read compressed values without the option")
+	}
+	if stats, _ := db.CompressionStats("key"); stats == nil || stats.Algorithm != "gzip" || stats.Size > pageSize {
+		t.Error("Must store the value compressed", stats)
+	}
+}
+
+func TestDB_StrictRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, nil)
+	db.SetString("a", "1", 0)
+	db.SetString("b", "2", 0)
+	txid := db.meta.txid
+	db.Close()
+
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt([]byte("torn"), int64(txid%2)*pageSize+20)
+	f.Close()
+
+	strict := &Options{Recovery: storage.RecoverStrict}
+	if _, err := Open(path, strict); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("Must refuse a torn meta page", err)
+	}
+	report, err := Check(path, nil, false)
+	if err != nil || len(report.Problems) != 2 || report.Records != 1 {
+		t.Fatal("Must report the torn meta page and the pages of its commit", report, err)
+	}
+	if report, _ = Check(path, nil, true); !report.Repaired {
+		t.Error("Must repair the meta page", report)
+	}
+	if report, _ = Check(path, nil, false); !report.OK() {
+		t.Error("Must be intact after repair", report.Problems)
+	}
+
+	db = open(t, path, strict)
+	defer db.Close()
+	if item, _ := db.Get("a"); item == nil || item.String != "1" {
+		t.Error("Must keep committed data", item)
+	}
+}
+
+func TestCheck_DamagedPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, nil)
+	for i := 0; i < 500; i++ {
+		db.SetString(fmt.Sprintf("key:%03d", i), fmt.Sprintf("%050d", i), 0)
+	}
+	db.SetString("large", strings.Repeat("x", 3*pageSize), 0)
+	root, _ := db.pool.node(db.meta.root)
+	leaf, _ := db.pool.node(root.children[0].id)
+	db.Close()
+
+	report, err := Check(path, nil, false)
+	if err != nil || !report.OK() || report.Records != 501 {
+		t.Fatal("Must verify an intact file", report, err)
+	}
+
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt([]byte{0xff}, int64(leaf.id)*pageSize+100)
+	f.Close()
+	if _, err := Open(path, nil); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("Must detect the damaged page", err)
+	}
+
+	if report, _ = Check(path, nil, false); len(report.Problems) != 1 {
+		t.Fatal("Must report the damaged page", report.Problems)
+	}
+	if report, _ = Check(path, nil, true); !report.Repaired {
+		t.Fatal("Must rebuild the tree", report)
+	}
+	if report, _ = Check(path, nil, false); !report.OK() || report.Records != 501-len(leaf.entries) {
+		t.Error("Must keep the intact entries", report.Records, report.Problems)
+	}
+
+	db = open(t, path, nil)
+	defer db.Close()
+	if item, _ := db.Get(leaf.entries[0].key); item != nil {
+		t.Error("Must lose the entries of the damaged page", item)
+	}
+	if item, _ := db.Get("large"); item == nil || len(item.String) != 3*pageSize {
+		t.Error("Must keep values of overflow pages", item)
+	}
+	if item, _ := db.Get("key:499"); item == nil || item.String != fmt.Sprintf("%050d", 499) {
+		t.Error("Must keep the other entries", item)
+	}
+}
+
+func TestDB_Encryption(t *testing.T) {
+	key1 := storage.Encryption{Key: []byte(strings.Repeat("1", 32))}
+	opts := &Options{Encryption: key1}
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return open(t, filepath.Join(t.TempDir(), "btree.db"), opts)
+	})
+
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, opts)
+	for i := 0; i < 500; i++ {
+		db.SetString(fmt.Sprintf("email:%03d", i), fmt.Sprintf("user%03d@example.com", i), 0)
+	}
+	db.SetString("large", strings.Repeat("secret ", pageSize), 0)
+	root, _ := db.pool.node(db.meta.root)
+	leaf := root.children[0].id
+	db.Close()
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "email:") || strings.Contains(string(data), "example.com") || strings.Contains(string(data), "secret") {
+		t.Error("Must not store keys or values in plain text")
+	}
+
+	if _, err := Open(path, nil); err != storage.ErrEncrypted {
+		t.Error("Must require a key", err)
+	}
+	wrong := &Options{Encryption: storage.Encryption{Key: []byte(strings.Repeat("2", 32))}}
+	if _, err := Open(path, wrong); !errors.Is(err, storage.ErrEncryptionKey) {
+		t.Error("Must refuse a wrong key", err)
+	}
+	if report, err := Check(path, opts, false); err != nil || !report.OK() || report.Records != 501 {
+		t.Error("Must check encrypted files", report, err)
+	}
+
+	db = open(t, path, opts)
+	if item, _ := db.Get("email:007"); item == nil || item.String != "user007@example.com" {
+		t.Error("Must be equal `user007@example.com`", item)
+	}
+	if item, _ := db.Get("large"); item == nil || len(item.String) != 7*pageSize {
+		t.Error("Must read large values", item)
+	}
+	db.Close()
+
+	// A sealed page changed on disk fails to open
+	slot := int64(pageSize + new(storage.Cipher).Overhead())
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt([]byte{0xff}, int64(leaf)*slot+100)
+	f.Close()
+	report, err := Check(path, opts, false)
+	if err != nil || len(report.Problems) != 1 {
+		t.Fatal("Must report the damaged page", report, err)
+	}
+	if report, _ = Check(path, opts, true); !report.Repaired {
+		t.Error("Must repair the file", report)
+	}
+	if report, _ = Check(path, opts, false); !report.OK() {
+		t.Error("Must be intact after repair", report.Problems)
+	}
+}
+
+func TestDB_KeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btree.db")
+	db := open(t, path, nil)
+	for i := 0; i < 200; i++ {
+		db.SetString(fmt.Sprintf("key:%03d", i), fmt.Sprintf("value%03d", i), 10000)
+	}
+	db.Close()
+
+	// Encrypting plain data, then rotating the key
+	key1 := storage.Encryption{Key: []byte(strings.Repeat("1", 32))}
+	key2 := storage.Encryption{Key: []byte(strings.Repeat("2", 32)), OldKeys: [][]byte{key1.Key}}
+	for _, enc := range []storage.Encryption{key1, key2} {
+		db = open(t, path, &Options{Encryption: enc})
+		db.SetString("key:200", "new", 0)
+		db.Close()
+		if data, _ := os.ReadFile(path); strings.Contains(string(data), "key:") {
+			t.Error("Must rewrite the plain file encrypted")
+		}
+	}
+	if _, err := os.Stat(path + ".reseal"); !os.IsNotExist(err) {
+		t.Error("Must not leave the rewritten copy", err)
+	}
+
+	db = open(t, path, &Options{Encryption: storage.Encryption{Key: key2.Key}})
+	defer db.Close()
+	for i := 0; i < 200; i += 11 {
+		key := fmt.Sprintf("key:%03d", i)
+		if item, _ := db.Get(key); item == nil || item.String != fmt.Sprintf("value%03d", i) {
+			t.Error("Must keep the data", key, item)
+		}
+	}
+	if ttl, _ := db.TTL("key:000"); ttl <= 0 {
+		t.Error("Must keep the expiration", ttl)
+	}
+	if item, _ := db.Get("key:200"); item == nil || item.String != "new" {
+		t.Error("Must be equal `new`", item)
+	}
+}
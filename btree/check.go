@@ -0,0 +1,266 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+
+	"my-go-db/storage"
+)
+
+// checker walks every page reachable from a meta page.
+type checker struct {
+	db      *DB
+	report  *storage.CheckReport
+	seen    map[pgid]bool
+	damaged bool
+	// entries are the readable entries of the tree, kept when repairing
+	keep    bool
+	entries []leafEntry
+}
+
+func (c *checker) problem(format string, args ...interface{}) {
+	c.report.Problemf(format, args...)
+	c.damaged = true
+}
+
+// page reads page id if it belongs to the tree and was not seen yet.
+func (c *checker) page(id pgid) []byte {
+	if id < 2 || id >= c.db.meta.pageCount {
+		c.problem("page %d is out of range", id)
+		return nil
+	}
+	if c.seen[id] {
+		c.problem("page %d is used twice", id)
+		return nil
+	}
+	c.seen[id] = true
+	b, err := c.db.pool.readPage(id)
+	if err != nil {
+		c.problem("page %d: %v", id, err)
+		return nil
+	}
+	return b
+}
+
+// node checks the subtree of page id, whose keys must be at least lo and,
+// unless hi is "", less than hi.
+func (c *checker) node(id pgid, lo, hi string) {
+	b := c.page(id)
+	if b == nil {
+		return
+	}
+	n, err := decodeNode(id, b)
+	if err != nil {
+		c.problem("page %d: %v", id, err)
+		return
+	}
+	if !n.leaf {
+		for i, ch := range n.children {
+			from, to := lo, hi
+			if i > 0 {
+				from = n.keys[i]
+			}
+			if i+1 < len(n.keys) {
+				to = n.keys[i+1]
+			}
+			if i > 0 && (n.keys[i] < lo || hi != "" && n.keys[i] >= hi || i > 1 && n.keys[i] <= n.keys[i-1]) {
+				c.problem("page %d: key %d is out of order", id, i)
+			}
+			c.node(ch.id, from, to)
+		}
+		return
+	}
+	for i := range n.entries {
+		e := &n.entries[i]
+		if e.key < lo || hi != "" && e.key >= hi || i > 0 && e.key <= n.entries[i-1].key {
+			c.problem("page %d: key %q is out of order", id, e.key)
+			continue
+		}
+		value := c.overflow(e)
+		if value == nil && e.overflow != 0 {
+			continue
+		}
+		c.report.Records++
+		if c.keep {
+			c.entries = append(c.entries, leafEntry{key: e.key, expiration: e.expiration, value: value})
+		}
+	}
+}
+
+// overflow checks the chain of overflow pages of e and returns its value.
+func (c *checker) overflow(e *leafEntry) []byte {
+	if e.overflow == 0 {
+		return e.value
+	}
+	data := make([]byte, 0, e.size)
+	for id := e.overflow; id != 0; {
+		b := c.page(id)
+		if b == nil {
+			return nil
+		}
+		n := int(binary.LittleEndian.Uint16(b[9:]))
+		if b[0] != pageOverflow || n > overflowCapacity {
+			c.problem("page %d: not an overflow page of %q", id, e.key)
+			return nil
+		}
+		data = append(data, b[overflowHeaderSize:overflowHeaderSize+n]...)
+		id = pgid(binary.LittleEndian.Uint64(b[1:]))
+	}
+	if len(data) != e.size {
+		c.problem("value of %q has %d bytes, expected %d", e.key, len(data), e.size)
+		return nil
+	}
+	return data
+}
+
+// freelist checks the pages of the free list and the pages they list.
+func (c *checker) freelist(id pgid) {
+	free := make(map[pgid]bool)
+	for id != 0 {
+		b := c.page(id)
+		if b == nil {
+			return
+		}
+		count := int(binary.LittleEndian.Uint16(b[9:]))
+		if b[0] != pageFreelist || count > freelistCapacity {
+			c.problem("page %d: not a free list page", id)
+			return
+		}
+		for j := 0; j < count; j++ {
+			free[pgid(binary.LittleEndian.Uint64(b[freelistHeaderSize+8*j:]))] = true
+		}
+		id = pgid(binary.LittleEndian.Uint64(b[1:]))
+	}
+	for id := range free {
+		if id < 2 || id >= c.db.meta.pageCount || c.seen[id] {
+			c.problem("free page %d is out of range or in use", id)
+		}
+	}
+}
+
+// Check verifies the data file at path while the engine is not open: the
+// meta pages, the checksum and key order of every page of the tree, the
+// overflow chains, the free list and the length of the file. With repair
+// it truncates pages past the end of the tree, rewrites a damaged meta page
+// from the other one and rebuilds a damaged tree from the entries it can
+// still read, which are held in memory meanwhile. Only the Encryption of
+// opts is used, to read encrypted files; opts may be nil.
+func Check(path string, opts *Options, repair bool) (*storage.CheckReport, error) {
+	var o Options
+	if opts != nil {
+		o.Encryption = opts.Encryption
+	}
+	cipher, err := o.Encryption.Cipher()
+	if err != nil {
+		return nil, err
+	}
+	flag := os.O_RDONLY
+	if repair {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealedFile(f)
+	if err != nil {
+		return nil, err
+	}
+	if sealed && cipher == nil {
+		return nil, storage.ErrEncrypted
+	} else if !sealed {
+		cipher = nil
+	}
+
+	db := &DB{f: f, opts: o, pool: newBufferPool(f, 0, cipher)}
+	report := &storage.CheckReport{Files: 1}
+	torn := false
+	for _, id := range []pgid{0, 1} {
+		b, err := db.pool.readPage(id)
+		if errors.Is(err, storage.ErrEncryptionKey) {
+			return nil, err
+		}
+		m, ok := decodeMeta(b)
+		if err != nil || !ok {
+			if oldMeta(b) {
+				return nil, ErrVersion
+			}
+			report.Problemf("meta page %d is damaged", id)
+			torn = true
+			continue
+		}
+		if db.meta == nil || m.txid > db.meta.txid {
+			db.meta = m
+		}
+	}
+	if db.meta == nil {
+		return nil, ErrCorrupt
+	}
+
+	c := &checker{db: db, report: report, seen: make(map[pgid]bool), keep: repair}
+	c.node(db.meta.root, "", "")
+	c.freelist(db.meta.freelist)
+	end := int64(db.meta.pageCount) * db.pool.slot
+	if info.Size() < end {
+		c.problem("the file has %d bytes, the tree needs %d", info.Size(), end)
+	} else if info.Size() > end {
+		report.Problemf("%d bytes past the end of the tree", info.Size()-end)
+	}
+
+	if !repair || report.OK() {
+		return report, nil
+	}
+	report.Repaired = true
+	if c.damaged {
+		f.Close()
+		return report, rebuild(path, &o, c.entries)
+	}
+	if info.Size() > end {
+		if err := f.Truncate(end); err != nil {
+			return nil, err
+		}
+	}
+	if torn {
+		// A copy of the newest commit takes the place of the damaged meta
+		// page, which is the one the next commit writes
+		m := *db.meta
+		m.txid++
+		if err := db.writeMeta(&m); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// rebuild replaces the data file at path with a new tree of entries,
+// written with opts.
+func rebuild(path string, opts *Options, entries []leafEntry) error {
+	tmp := path + ".repair"
+	os.Remove(tmp)
+	db, err := Open(tmp, opts)
+	if err != nil {
+		return err
+	}
+	tx := db.begin()
+	for _, e := range entries {
+		if err := tx.put(e.key, e.expiration, e.value); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	if err := tx.commit(); err != nil {
+		db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,159 @@
+package btree
+
+// cursor walks the leaf entries of a committed tree in both directions. It
+// keeps the path from the root, so it needs no sibling links. The tree
+// must not change while the cursor is used, unless its pages are held back
+// from reuse as for dumps.
+type cursor struct {
+	db    *DB
+	root  pgid
+	stack []position
+	err   error
+}
+
+type position struct {
+	n *node
+	i int
+}
+
+func (db *DB) cursor() *cursor {
+	return &cursor{db: db, root: db.meta.root}
+}
+
+func (c *cursor) valid() bool {
+	return c.err == nil && len(c.stack) > 0
+}
+
+func (c *cursor) entry() *leafEntry {
+	top := c.stack[len(c.stack)-1]
+	return &top.n.entries[top.i]
+}
+
+func (c *cursor) load(id pgid) *node {
+	n, err := c.db.pool.node(id)
+	if err != nil {
+		c.err = err
+		c.stack = nil
+	}
+	return n
+}
+
+// seek moves to the first entry with a key >= key.
+func (c *cursor) seek(key string) {
+	c.stack = c.stack[:0]
+	n := c.load(c.root)
+	for n != nil && !n.leaf {
+		i := n.childIndex(key)
+		c.stack = append(c.stack, position{n, i})
+		n = c.load(n.children[i].id)
+	}
+	if n == nil {
+		return
+	}
+	i, _ := n.search(key)
+	c.stack = append(c.stack, position{n, i})
+	c.forward()
+}
+
+// seekLast moves to the last entry with a key <= key, or to the last entry
+// when key is empty.
+func (c *cursor) seekLast(key string) {
+	c.stack = c.stack[:0]
+	n := c.load(c.root)
+	for n != nil && !n.leaf {
+		i := len(n.children) - 1
+		if key != "" {
+			i = n.childIndex(key)
+		}
+		c.stack = append(c.stack, position{n, i})
+		n = c.load(n.children[i].id)
+	}
+	if n == nil {
+		return
+	}
+	i := len(n.entries) - 1
+	if key != "" {
+		i, _ = n.search(key)
+		if i == len(n.entries) || n.entries[i].key != key {
+			i--
+		}
+	}
+	c.stack = append(c.stack, position{n, i})
+	c.backward()
+}
+
+func (c *cursor) next() {
+	c.stack[len(c.stack)-1].i++
+	c.forward()
+}
+
+func (c *cursor) prev() {
+	c.stack[len(c.stack)-1].i--
+	c.backward()
+}
+
+func (c *cursor) move(reverse bool) {
+	if reverse {
+		c.prev()
+	} else {
+		c.next()
+	}
+}
+
+// forward moves past the ends of exhausted nodes to the next entry.
+func (c *cursor) forward() {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if top.i < top.n.count() {
+			break
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		if len(c.stack) > 0 {
+			c.stack[len(c.stack)-1].i++
+		}
+	}
+	for len(c.stack) > 0 {
+		top := c.stack[len(c.stack)-1]
+		if top.n.leaf {
+			return
+		}
+		n := c.load(top.n.children[top.i].id)
+		if n == nil {
+			return
+		}
+		c.stack = append(c.stack, position{n, 0})
+		if n.count() == 0 {
+			c.forward()
+			return
+		}
+	}
+}
+
+// backward moves before the starts of exhausted nodes to the previous entry.
+func (c *cursor) backward() {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if top.i >= 0 {
+			break
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		if len(c.stack) > 0 {
+			c.stack[len(c.stack)-1].i--
+		}
+	}
+	for len(c.stack) > 0 {
+		top := c.stack[len(c.stack)-1]
+		if top.n.leaf {
+			return
+		}
+		n := c.load(top.n.children[top.i].id)
+		if n == nil {
+			return
+		}
+		c.stack = append(c.stack, position{n, n.count() - 1})
+		if n.count() == 0 {
+			c.backward()
+			return
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+// The file is an array of fixed size pages. Pages 0 and 1 hold the two
+// meta pages; all others are tree nodes, overflow pages of large values or
+// pages of the free list, and end with the crc32 of the rest of the page.
+//
+// Encrypted files keep every page sealed with storage.Cipher in a slot of
+// pageSize plus the overhead of sealing, with the page id authenticated so
+// pages cannot be moved. Their meta pages hold sealedMagic followed by the
+// sealed first metaSize bytes of the meta page, so an encrypted file is
+// recognized before any page is opened.
+type pgid uint64
+
+const (
+	pageSize     = 4096
+	checksumSize = 4
+	pageCapacity = pageSize - checksumSize // usable bytes of pages other than meta pages
+	maxKeySize   = pageSize / 8
+	maxInline    = pageSize / 4       // larger values are moved to overflow pages
+	minFill      = pageSize / 4       // nodes below are merged with a sibling
+	metaMagic    = 0x6d79676462747265 // "mygdbtre"
+	metaVersion  = 2                  // version 1 had no page checksums
+	sealedMagic  = 0x6d79676462747365 // "mygdbtse", meta pages of encrypted files
+)
+
+const (
+	pageLeaf byte = iota + 1
+	pageBranch
+	pageOverflow
+	pageFreelist
+)
+
+var (
+	ErrCorrupt     = errors.New("Corrupt data file")
+	ErrKeyTooLarge = errors.New("Key is too large")
+	ErrVersion     = errors.New("Data file written by an older version without page checksums")
+)
+
+// meta is the root of a committed version of the tree. Commits write the
+// meta page not used by the previous commit, so a torn meta write leaves
+// the previous version intact.
+type meta struct {
+	txid      uint64
+	root      pgid
+	freelist  pgid // first page of the free list, 0 if none
+	pageCount pgid // pages in use, the file may be longer
+}
+
+// meta page: magic | version | page size | txid | root | freelist | page count | crc32
+const metaSize = 8 + 4 + 4 + 8*4 + 4
+
+func (m *meta) encode() []byte {
+	b := make([]byte, pageSize)
+	binary.LittleEndian.PutUint64(b[0:], metaMagic)
+	binary.LittleEndian.PutUint32(b[8:], metaVersion)
+	binary.LittleEndian.PutUint32(b[12:], pageSize)
+	binary.LittleEndian.PutUint64(b[16:], m.txid)
+	binary.LittleEndian.PutUint64(b[24:], uint64(m.root))
+	binary.LittleEndian.PutUint64(b[32:], uint64(m.freelist))
+	binary.LittleEndian.PutUint64(b[40:], uint64(m.pageCount))
+	binary.LittleEndian.PutUint32(b[48:], crc32.ChecksumIEEE(b[:48]))
+	return b
+}
+
+// oldMeta tells whether b is a meta page of an older version.
+func oldMeta(b []byte) bool {
+	return len(b) >= metaSize &&
+		binary.LittleEndian.Uint64(b[0:]) == metaMagic &&
+		binary.LittleEndian.Uint32(b[8:]) < metaVersion
+}
+
+func decodeMeta(b []byte) (*meta, bool) {
+	if len(b) < metaSize ||
+		binary.LittleEndian.Uint64(b[0:]) != metaMagic ||
+		binary.LittleEndian.Uint32(b[8:]) != metaVersion ||
+		binary.LittleEndian.Uint32(b[12:]) != pageSize ||
+		binary.LittleEndian.Uint32(b[48:]) != crc32.ChecksumIEEE(b[:48]) {
+		return nil, false
+	}
+	return &meta{
+		txid:      binary.LittleEndian.Uint64(b[16:]),
+		root:      pgid(binary.LittleEndian.Uint64(b[24:])),
+		freelist:  pgid(binary.LittleEndian.Uint64(b[32:])),
+		pageCount: pgid(binary.LittleEndian.Uint64(b[40:])),
+	}, true
+}
+
+// leafEntry is a key of a leaf. Small values are stored in the node,
+// larger ones in a chain of overflow pages starting at overflow.
+type leafEntry struct {
+	key        string
+	expiration int64
+	value      []byte
+	overflow   pgid
+	size       int // length of the value
+}
+
+// child is a reference from a branch to a node. node is set while the
+// child is modified by a transaction and not written yet.
+type child struct {
+	id   pgid
+	node *node
+}
+
+// node is a decoded tree page. Nodes read from the file are shared and
+// never modified; transactions change copies.
+type node struct {
+	id       pgid
+	leaf     bool
+	entries  []leafEntry // leaf
+	keys     []string    // branch: keys[i] <= every key of children[i], keys[0] is unused
+	children []child
+}
+
+func (n *node) count() int {
+	if n.leaf {
+		return len(n.entries)
+	}
+	return len(n.children)
+}
+
+func (n *node) firstKey() string {
+	if n.leaf {
+		return n.entries[0].key
+	}
+	return n.keys[0]
+}
+
+// search returns the position of key in a leaf.
+func (n *node) search(key string) (int, bool) {
+	i := sort.Search(len(n.entries), func(i int) bool {
+		return n.entries[i].key >= key
+	})
+	return i, i < len(n.entries) && n.entries[i].key == key
+}
+
+// childIndex returns the child of a branch which may hold key.
+func (n *node) childIndex(key string) int {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] > key
+	})
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func varintLen(v int64) int {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	return uvarintLen(u)
+}
+
+func (e *leafEntry) encodedSize() int {
+	n := uvarintLen(uint64(len(e.key))) + len(e.key) + varintLen(e.expiration) + 1
+	if e.overflow != 0 {
+		return n + uvarintLen(uint64(e.overflow)) + uvarintLen(uint64(e.size))
+	}
+	return n + uvarintLen(uint64(len(e.value))) + len(e.value)
+}
+
+const nodeHeaderSize = 3 // type | count uint16
+
+// size returns the length of the encoded node.
+func (n *node) size() int {
+	size := nodeHeaderSize
+	if n.leaf {
+		for i := range n.entries {
+			size += n.entries[i].encodedSize()
+		}
+		return size
+	}
+	for i, c := range n.children {
+		size += uvarintLen(uint64(len(n.keys[i]))) + len(n.keys[i])
+		if c.node != nil {
+			size += binary.MaxVarintLen64 // not allocated yet
+		} else {
+			size += uvarintLen(uint64(c.id))
+		}
+	}
+	return size
+}
+
+// encode writes a node whose children are all written.
+//
+//	leaf:   key length | key | expiration | 0 | value length | value
+//	        key length | key | expiration | 1 | overflow page | value length
+//	branch: key length | key | child page
+func (n *node) encode() []byte {
+	b := make([]byte, nodeHeaderSize, pageSize)
+	if n.leaf {
+		b[0] = pageLeaf
+	} else {
+		b[0] = pageBranch
+	}
+	binary.LittleEndian.PutUint16(b[1:], uint16(n.count()))
+	if n.leaf {
+		for _, e := range n.entries {
+			b = binary.AppendUvarint(b, uint64(len(e.key)))
+			b = append(b, e.key...)
+			b = binary.AppendVarint(b, e.expiration)
+			if e.overflow != 0 {
+				b = append(b, 1)
+				b = binary.AppendUvarint(b, uint64(e.overflow))
+				b = binary.AppendUvarint(b, uint64(e.size))
+			} else {
+				b = append(b, 0)
+				b = binary.AppendUvarint(b, uint64(len(e.value)))
+				b = append(b, e.value...)
+			}
+		}
+	} else {
+		for i, c := range n.children {
+			b = binary.AppendUvarint(b, uint64(len(n.keys[i])))
+			b = append(b, n.keys[i]...)
+			b = binary.AppendUvarint(b, uint64(c.id))
+		}
+	}
+	return b[:pageSize]
+}
+
+// reader reads the fields of a page, remembering the first error.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err, r.b = ErrCorrupt, nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err, r.b = ErrCorrupt, nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) bytes(n uint64) []byte {
+	if n > uint64(len(r.b)) {
+		r.err, r.b = ErrCorrupt, nil
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func decodeNode(id pgid, b []byte) (*node, error) {
+	if len(b) < nodeHeaderSize || b[0] != pageLeaf && b[0] != pageBranch {
+		return nil, ErrCorrupt
+	}
+	n := &node{id: id, leaf: b[0] == pageLeaf}
+	count := int(binary.LittleEndian.Uint16(b[1:]))
+	r := &reader{b: b[nodeHeaderSize:]}
+	for i := 0; i < count && r.err == nil; i++ {
+		key := string(r.bytes(r.uvarint()))
+		if !n.leaf {
+			n.keys = append(n.keys, key)
+			n.children = append(n.children, child{id: pgid(r.uvarint())})
+			continue
+		}
+		e := leafEntry{key: key, expiration: r.varint()}
+		if r.byte() == 1 {
+			e.overflow = pgid(r.uvarint())
+			e.size = int(r.uvarint())
+		} else {
+			e.value = append([]byte{}, r.bytes(r.uvarint())...)
+			e.size = len(e.value)
+		}
+		n.entries = append(n.entries, e)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return n, nil
+}
+
+func checksumPage(b []byte) {
+	binary.LittleEndian.PutUint32(b[pageCapacity:], crc32.ChecksumIEEE(b[:pageCapacity]))
+}
+
+func validPage(b []byte) bool {
+	return binary.LittleEndian.Uint32(b[pageCapacity:]) == crc32.ChecksumIEEE(b[:pageCapacity])
+}
+
+// Overflow page: type | next page | data length uint16 | data
+const (
+	overflowHeaderSize = 1 + 8 + 2
+	overflowCapacity   = pageCapacity - overflowHeaderSize
+)
+
+// Free list page: type | next page | count uint16 | page ids
+const (
+	freelistHeaderSize = 1 + 8 + 2
+	freelistCapacity   = (pageCapacity - freelistHeaderSize) / 8
+)
+
+func freelistPages(ids int) int {
+	return (ids + freelistCapacity - 1) / freelistCapacity
+}
@@ -0,0 +1,191 @@
+package btree
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"my-go-db/storage"
+)
+
+// bufferPool caches decoded nodes of the file. When it is full the least
+// recently used page is replaced. Pages are never changed in place, so
+// cached nodes stay valid until their page is freed by a commit.
+type bufferPool struct {
+	mu       sync.Mutex
+	f        *os.File
+	capacity int
+	lru      *list.List // of *node, most recently used first
+	frames   map[pgid]*list.Element
+
+	cipher *storage.Cipher // seals the pages, nil for plain files
+	slot   int64           // bytes a page takes in the file
+	keyID  uint32          // key of the last meta page read or written
+
+	hits   int
+	misses int
+}
+
+// newBufferPool returns the pool of f, whose pages are sealed with c unless
+// c is nil.
+func newBufferPool(f *os.File, capacity int, c *storage.Cipher) *bufferPool {
+	p := &bufferPool{
+		f:        f,
+		capacity: capacity,
+		lru:      list.New(),
+		frames:   make(map[pgid]*list.Element),
+		cipher:   c,
+		slot:     pageSize,
+	}
+	if c != nil {
+		p.slot += int64(c.Overhead())
+	}
+	return p
+}
+
+// readPage reads page id, verifying its checksum unless it is a meta page.
+func (p *bufferPool) readPage(id pgid) ([]byte, error) {
+	b := make([]byte, p.slot)
+	if _, err := p.f.ReadAt(b, int64(id)*p.slot); err != nil {
+		return nil, err
+	}
+	if p.cipher != nil {
+		var err error
+		if b, err = p.open(id, b); err != nil {
+			return nil, err
+		}
+	}
+	if id > 1 && !validPage(b) {
+		return nil, fmt.Errorf("%w: checksum mismatch in page %d", ErrCorrupt, id)
+	}
+	return b, nil
+}
+
+// writePage writes page id, adding its checksum unless it is a meta page.
+func (p *bufferPool) writePage(id pgid, b []byte) error {
+	if id > 1 {
+		checksumPage(b)
+	}
+	if p.cipher != nil {
+		b = p.seal(id, b)
+	}
+	_, err := p.f.WriteAt(b, int64(id)*p.slot)
+	return err
+}
+
+// pageAD is the data authenticated with page id, so a sealed page only
+// opens in its own slot.
+func pageAD(id pgid) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(id))
+}
+
+// seal returns the slot of page id holding b sealed.
+func (p *bufferPool) seal(id pgid, b []byte) []byte {
+	slot := make([]byte, 0, p.slot)
+	if id < 2 {
+		slot = binary.LittleEndian.AppendUint64(slot, sealedMagic)
+		b = b[:metaSize]
+		p.keyID = p.cipher.KeyID()
+	}
+	return p.cipher.Seal(slot, b, pageAD(id))[:p.slot]
+}
+
+// open returns the page sealed in the slot of page id. A damaged meta page
+// is returned zeroed, so it is skipped like a torn plain one.
+func (p *bufferPool) open(id pgid, slot []byte) ([]byte, error) {
+	sealed := slot
+	if id < 2 {
+		if binary.LittleEndian.Uint64(slot) != sealedMagic {
+			return make([]byte, pageSize), nil
+		}
+		sealed = slot[8 : 8+metaSize+p.cipher.Overhead()]
+	}
+	b, err := p.cipher.Open(make([]byte, 0, pageSize), sealed, pageAD(id))
+	switch {
+	case err == storage.ErrDecrypt && id < 2:
+		return make([]byte, pageSize), nil
+	case err == storage.ErrDecrypt:
+		return nil, fmt.Errorf("%w: page %d cannot be decrypted", ErrCorrupt, id)
+	case err != nil:
+		return nil, fmt.Errorf("Page %d: %w", id, err)
+	}
+	if id < 2 {
+		p.keyID = storage.SealedKeyID(sealed)
+	}
+	return b[:pageSize], nil
+}
+
+// sealedFile tells whether f holds encrypted pages, from the magic of
+// either of its meta pages.
+func sealedFile(f *os.File) (bool, error) {
+	b := make([]byte, 8)
+	for _, off := range []int64{0, pageSize + int64(new(storage.Cipher).Overhead())} {
+		n, err := f.ReadAt(b, off)
+		if n < len(b) {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		if binary.LittleEndian.Uint64(b) == sealedMagic {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// node returns the node stored in page id.
+func (p *bufferPool) node(id pgid) (*node, error) {
+	p.mu.Lock()
+	if el, ok := p.frames[id]; ok {
+		p.lru.MoveToFront(el)
+		p.hits++
+		p.mu.Unlock()
+		return el.Value.(*node), nil
+	}
+	p.misses++
+	p.mu.Unlock()
+
+	b, err := p.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := decodeNode(id, b)
+	if err != nil {
+		return nil, err
+	}
+	p.put(n)
+	return n, nil
+}
+
+// put caches a node, evicting the least recently used one if needed.
+func (p *bufferPool) put(n *node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if el, ok := p.frames[n.id]; ok {
+		el.Value = n
+		p.lru.MoveToFront(el)
+		return
+	}
+	p.frames[n.id] = p.lru.PushFront(n)
+	for p.lru.Len() > p.capacity {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.frames, oldest.Value.(*node).id)
+	}
+}
+
+// drop forgets freed pages.
+func (p *bufferPool) drop(ids []pgid) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, id := range ids {
+		if el, ok := p.frames[id]; ok {
+			p.lru.Remove(el)
+			delete(p.frames, id)
+		}
+	}
+}
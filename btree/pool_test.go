@@ -0,0 +1,29 @@
+package btree
+
+import "testing"
+
+func TestBufferPool_LRU(t *testing.T) {
+	p := newBufferPool(nil, 2, nil)
+	a, b, c := &node{id: 10}, &node{id: 11}, &node{id: 12}
+	p.put(a)
+	p.put(b)
+	if n, _ := p.node(10); n != a {
+		t.Fatal("Must return the cached node")
+	}
+	p.put(c)
+
+	if _, ok := p.frames[11]; ok {
+		t.Error("Must evict the least recently used page")
+	}
+	if _, ok := p.frames[10]; !ok {
+		t.Error("Must keep the recently used page")
+	}
+	if p.hits != 1 {
+		t.Error("Must count hits", p.hits)
+	}
+
+	p.drop([]pgid{10, 12})
+	if p.lru.Len() != 0 || len(p.frames) != 0 {
+		t.Error("Must drop freed pages", p.lru.Len())
+	}
+}
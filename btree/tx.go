@@ -0,0 +1,379 @@
+package btree
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// tx is a write transaction. Pages are copied on write: changed nodes and
+// their ancestors are written to free pages and the commit switches the
+// meta page to the new root. Pages released by a transaction can only be
+// reused once its meta page is written, because until then the previous
+// version of the tree still refers to them.
+type tx struct {
+	db        *DB
+	root      child
+	free      []pgid // pages free for this transaction, sorted
+	freed     []pgid // pages released by this transaction
+	pageCount pgid
+	written   []*node
+}
+
+func (db *DB) begin() *tx {
+	return &tx{
+		db:        db,
+		root:      child{id: db.meta.root},
+		free:      append([]pgid{}, db.free...),
+		pageCount: db.meta.pageCount,
+	}
+}
+
+func (tx *tx) allocate() pgid {
+	if len(tx.free) > 0 {
+		id := tx.free[0]
+		tx.free = tx.free[1:]
+		return id
+	}
+	id := tx.pageCount
+	tx.pageCount++
+	return id
+}
+
+func (tx *tx) read(c *child) (*node, error) {
+	if c.node != nil {
+		return c.node, nil
+	}
+	return tx.db.pool.node(c.id)
+}
+
+// writable returns a copy of the child owned by the transaction.
+func (tx *tx) writable(c *child) (*node, error) {
+	if c.node != nil {
+		return c.node, nil
+	}
+	n, err := tx.db.pool.node(c.id)
+	if err != nil {
+		return nil, err
+	}
+	cp := &node{
+		leaf:     n.leaf,
+		entries:  append([]leafEntry{}, n.entries...),
+		keys:     append([]string{}, n.keys...),
+		children: append([]child{}, n.children...),
+	}
+	tx.freed = append(tx.freed, c.id)
+	c.id, c.node = 0, cp
+	return cp, nil
+}
+
+// writeOverflow stores a large value in a chain of overflow pages. The
+// chain is written back to front, so every page knows its successor.
+func (tx *tx) writeOverflow(data []byte) (pgid, error) {
+	var next pgid
+	chunks := (len(data) + overflowCapacity - 1) / overflowCapacity
+	for i := chunks - 1; i >= 0; i-- {
+		chunk := data[i*overflowCapacity:]
+		if len(chunk) > overflowCapacity {
+			chunk = chunk[:overflowCapacity]
+		}
+		b := make([]byte, pageSize)
+		b[0] = pageOverflow
+		binary.LittleEndian.PutUint64(b[1:], uint64(next))
+		binary.LittleEndian.PutUint16(b[9:], uint16(len(chunk)))
+		copy(b[overflowHeaderSize:], chunk)
+
+		id := tx.allocate()
+		if err := tx.db.pool.writePage(id, b); err != nil {
+			return 0, err
+		}
+		next = id
+	}
+	return next, nil
+}
+
+// release frees the overflow pages of an entry leaving the tree.
+func (tx *tx) release(e *leafEntry) error {
+	if e.overflow == 0 {
+		return nil
+	}
+	pages, err := tx.db.overflowPages(e.overflow)
+	if err != nil {
+		return err
+	}
+	tx.freed = append(tx.freed, pages...)
+	return nil
+}
+
+func (tx *tx) put(key string, expiration int64, value []byte) error {
+	if len(key) > maxKeySize {
+		return ErrKeyTooLarge
+	}
+	e := leafEntry{key: key, expiration: expiration, size: len(value)}
+	if len(value) > maxInline {
+		var err error
+		if e.overflow, err = tx.writeOverflow(value); err != nil {
+			return err
+		}
+	} else {
+		e.value = value
+	}
+
+	right, err := tx.insert(&tx.root, e)
+	if err != nil {
+		return err
+	}
+	if right != nil {
+		left := tx.root
+		tx.root = child{node: &node{
+			keys:     []string{"", right.firstKey()},
+			children: []child{left, {node: right}},
+		}}
+	}
+	return nil
+}
+
+// insert puts e into the subtree of c and returns the new right sibling
+// of c when it had to be split.
+func (tx *tx) insert(c *child, e leafEntry) (*node, error) {
+	n, err := tx.writable(c)
+	if err != nil {
+		return nil, err
+	}
+	if n.leaf {
+		i, found := n.search(e.key)
+		if found {
+			if err := tx.release(&n.entries[i]); err != nil {
+				return nil, err
+			}
+			n.entries[i] = e
+		} else {
+			n.entries = append(n.entries, leafEntry{})
+			copy(n.entries[i+1:], n.entries[i:])
+			n.entries[i] = e
+		}
+	} else {
+		i := n.childIndex(e.key)
+		right, err := tx.insert(&n.children[i], e)
+		if err != nil {
+			return nil, err
+		}
+		if right != nil {
+			n.keys = append(n.keys, "")
+			copy(n.keys[i+2:], n.keys[i+1:])
+			n.keys[i+1] = right.firstKey()
+			n.children = append(n.children, child{})
+			copy(n.children[i+2:], n.children[i+1:])
+			n.children[i+1] = child{node: right}
+		}
+	}
+	if n.size() <= pageCapacity {
+		return nil, nil
+	}
+	return n.split(), nil
+}
+
+// split moves the upper half of n by size to a new node.
+func (n *node) split() *node {
+	half, size, k := n.size()/2, nodeHeaderSize, 0
+	for k < n.count()-1 {
+		if n.leaf {
+			size += n.entries[k].encodedSize()
+		} else {
+			size += len(n.keys[k]) + 2*binary.MaxVarintLen64
+		}
+		k++
+		if size >= half {
+			break
+		}
+	}
+	right := &node{leaf: n.leaf}
+	if n.leaf {
+		right.entries = append([]leafEntry{}, n.entries[k:]...)
+		n.entries = n.entries[:k:k]
+	} else {
+		right.keys = append([]string{}, n.keys[k:]...)
+		right.children = append([]child{}, n.children[k:]...)
+		n.keys = n.keys[:k:k]
+		n.children = n.children[:k:k]
+	}
+	return right
+}
+
+// delete removes key, which must exist.
+func (tx *tx) delete(key string) error {
+	if err := tx.remove(&tx.root, key); err != nil {
+		return err
+	}
+	for {
+		root, err := tx.read(&tx.root)
+		if err != nil {
+			return err
+		}
+		switch {
+		case root.leaf || len(root.children) > 1:
+			return nil
+		case len(root.children) == 0:
+			tx.root = child{node: &node{leaf: true}}
+		default:
+			tx.root = root.children[0]
+		}
+	}
+}
+
+func (tx *tx) remove(c *child, key string) error {
+	n, err := tx.writable(c)
+	if err != nil {
+		return err
+	}
+	if n.leaf {
+		i, found := n.search(key)
+		if !found {
+			return nil
+		}
+		if err := tx.release(&n.entries[i]); err != nil {
+			return err
+		}
+		n.entries = append(n.entries[:i], n.entries[i+1:]...)
+		return nil
+	}
+	i := n.childIndex(key)
+	if err := tx.remove(&n.children[i], key); err != nil {
+		return err
+	}
+	return tx.rebalance(n, i)
+}
+
+// rebalance drops the child i of n when it became empty, or merges it with
+// a sibling when it is less than a quarter full and they fit into a page.
+func (tx *tx) rebalance(n *node, i int) error {
+	c := n.children[i].node
+	if c.count() == 0 {
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		return nil
+	}
+	if c.size() >= minFill || len(n.children) == 1 {
+		return nil
+	}
+
+	l, r := i-1, i
+	if i == 0 {
+		l, r = 0, 1
+	}
+	sibling := &n.children[l]
+	if l == i {
+		sibling = &n.children[r]
+	}
+	other, err := tx.read(sibling)
+	if err != nil {
+		return err
+	}
+	if c.size()+other.size()+len(n.keys[r])+binary.MaxVarintLen64 > pageCapacity {
+		return nil
+	}
+
+	left, err := tx.writable(&n.children[l])
+	if err != nil {
+		return err
+	}
+	right, err := tx.writable(&n.children[r])
+	if err != nil {
+		return err
+	}
+	if left.leaf {
+		left.entries = append(left.entries, right.entries...)
+	} else {
+		right.keys[0] = n.keys[r]
+		left.keys = append(left.keys, right.keys...)
+		left.children = append(left.children, right.children...)
+	}
+	n.keys = append(n.keys[:r], n.keys[r+1:]...)
+	n.children = append(n.children[:r], n.children[r+1:]...)
+	return nil
+}
+
+// write assigns pages to the modified nodes below c, children first.
+func (tx *tx) write(c *child) error {
+	n := c.node
+	if n == nil {
+		return nil
+	}
+	for i := range n.children {
+		if err := tx.write(&n.children[i]); err != nil {
+			return err
+		}
+	}
+	n.id = tx.allocate()
+	if err := tx.db.pool.writePage(n.id, n.encode()); err != nil {
+		return err
+	}
+	c.id, c.node = n.id, nil
+	tx.written = append(tx.written, n)
+	return nil
+}
+
+func (tx *tx) commit() error {
+	db := tx.db
+	if err := tx.write(&tx.root); err != nil {
+		return err
+	}
+
+	// The free list is rewritten by every commit, including the pages held
+	// back for dumps
+	released := len(tx.freed)
+	tx.freed = append(tx.freed, db.freelistPages...)
+	pages := []pgid{}
+	for freelistPages(len(tx.free)+len(tx.freed)+len(db.held)) > len(pages) {
+		pages = append(pages, tx.allocate())
+	}
+	free := append(append(append([]pgid{}, tx.free...), tx.freed...), db.held...)
+	sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+	for k, id := range pages {
+		ids := free[k*freelistCapacity:]
+		if len(ids) > freelistCapacity {
+			ids = ids[:freelistCapacity]
+		}
+		var next pgid
+		if k+1 < len(pages) {
+			next = pages[k+1]
+		}
+		b := make([]byte, pageSize)
+		b[0] = pageFreelist
+		binary.LittleEndian.PutUint64(b[1:], uint64(next))
+		binary.LittleEndian.PutUint16(b[9:], uint16(len(ids)))
+		for j, free := range ids {
+			binary.LittleEndian.PutUint64(b[freelistHeaderSize+8*j:], uint64(free))
+		}
+		if err := db.pool.writePage(id, b); err != nil {
+			return err
+		}
+	}
+
+	m := &meta{
+		txid:      db.meta.txid + 1,
+		root:      tx.root.id,
+		pageCount: tx.pageCount,
+	}
+	if len(pages) > 0 {
+		m.freelist = pages[0]
+	}
+	if err := db.writeMeta(m); err != nil {
+		return err
+	}
+
+	db.meta = m
+	if db.dumps > 0 {
+		// Dumps do not read the free list, so only the tree pages are held
+		db.free = append(tx.free, db.freelistPages...)
+		sort.Slice(db.free, func(i, j int) bool { return db.free[i] < db.free[j] })
+		db.held = append(db.held, tx.freed[:released]...)
+	} else {
+		db.free = free
+	}
+	db.freelistPages = pages
+	db.pool.drop(tx.freed)
+	for _, n := range tx.written {
+		db.pool.put(n)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package lsm
+
+import (
+	"os"
+	"time"
+
+	"my-go-db/storage"
+)
+
+// dropTableLocked closes and removes a table replaced by a compaction. While
+// dumps run it is kept until they are done.
+func (db *DB) dropTableLocked(num uint64) {
+	if db.dumps > 0 {
+		db.obsolete = append(db.obsolete, num)
+		return
+	}
+	db.tables[num].close()
+	delete(db.tables, num)
+	os.Remove(db.path(num, "sst"))
+}
+
+// dump reads a copy of the memtable and the tables of the moment it was
+// opened, without holding the lock.
+type dump struct {
+	db  *DB
+	it  iterator
+	now int64
+}
+
+// OpenDump captures the live keys, so writes go on while they are dumped.
+func (db *DB) OpenDump() (storage.Dump, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	db.dumps++
+	return &dump{db: db, it: db.iterLocked(), now: time.Now().UnixNano()}, nil
+}
+
+// Each calls fn for every key live when the dump was opened, in key order.
+func (d *dump) Each(fn func(key string, item *storage.Item) error) error {
+	for d.it.next() {
+		e := d.it.entry()
+		if !e.live(d.now) {
+			continue
+		}
+		item := new(storage.Item)
+		if err := item.UnmarshalBinary(e.value); err != nil {
+			return err
+		}
+		if err := fn(e.key, item); err != nil {
+			return err
+		}
+	}
+	return d.it.error()
+}
+
+// Close drops the tables replaced while the dumps ran, once they are all
+// done.
+func (d *dump) Close() error {
+	db := d.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.dumps--; db.dumps == 0 && !db.closed {
+		obsolete := db.obsolete
+		db.obsolete = nil
+		for _, num := range obsolete {
+			db.dropTableLocked(num)
+		}
+	}
+	return nil
+}
+
+// Restore stores item under key, keeping its expiration.
+func (db *DB) Restore(key string, item *storage.Item) error {
+	return db.put(key, item)
+}
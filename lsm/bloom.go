@@ -0,0 +1,68 @@
+package lsm
+
+import "hash/fnv"
+
+// bloom is a Bloom filter over the keys of a table. The k probes are
+// derived from one 64 bit FNV hash by double hashing.
+type bloom struct {
+	bits []byte
+	k    uint32
+}
+
+func newBloom(keys, bitsPerKey int) *bloom {
+	n := keys * bitsPerKey
+	if n < 64 {
+		n = 64
+	}
+	// k = ln2 * bits/key is optimal
+	k := uint32(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	return &bloom{bits: make([]byte, (n+7)/8), k: k}
+}
+
+func bloomHash(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+func (b *bloom) add(key string) {
+	h, delta := bloomHash(key)
+	n := uint32(len(b.bits) * 8)
+	for i := uint32(0); i < b.k; i++ {
+		pos := h % n
+		b.bits[pos/8] |= 1 << (pos % 8)
+		h += delta
+	}
+}
+
+// mayContain reports false only when key was never added.
+func (b *bloom) mayContain(key string) bool {
+	h, delta := bloomHash(key)
+	n := uint32(len(b.bits) * 8)
+	for i := uint32(0); i < b.k; i++ {
+		pos := h % n
+		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// The encoding is the bit array followed by k.
+func (b *bloom) encode() []byte {
+	return append(append([]byte{}, b.bits...), byte(b.k))
+}
+
+func decodeBloom(data []byte) (*bloom, bool) {
+	if len(data) < 2 || data[len(data)-1] == 0 {
+		return nil, false
+	}
+	return &bloom{bits: data[:len(data)-1], k: uint32(data[len(data)-1])}, true
+}
@@ -0,0 +1,160 @@
+package lsm
+
+import (
+	"os"
+	"path/filepath"
+
+	"my-go-db/storage"
+)
+
+// Check verifies the data files in dir while the engine is not open: the
+// records of the logs, the blocks and key order of the tables and the
+// tables listed in the manifest. With repair it truncates logs at their
+// first damaged record, rewrites damaged tables with the entries of their
+// intact blocks and drops unreadable tables from the manifest; the entries
+// of damaged blocks are lost. Only the Encryption of opts is used, to read
+// encrypted files; opts may be nil.
+func Check(dir string, opts *Options, repair bool) (*storage.CheckReport, error) {
+	if _, err := os.Stat(filepath.Join(dir, manifestName)); err != nil {
+		return nil, err
+	}
+	o := opts.withDefaults()
+	c, err := o.Encryption.Cipher()
+	if err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{dir: dir, opts: o, cipher: c, manifest: m}
+	report := new(storage.CheckReport)
+
+	logs, err := db.files("log")
+	if err != nil {
+		return nil, err
+	}
+	for _, num := range logs {
+		if num < m.LogNumber {
+			continue
+		}
+		report.Files++
+		records := 0
+		intact, size, err := replayWAL(db.path(num, "log"), db.cipher, func(*entry) { records++ })
+		if err != nil {
+			return nil, err
+		}
+		report.Records += records
+		if intact < size {
+			report.Problemf("log %06d: %d damaged bytes at offset %d", num, size-intact, intact)
+			if repair {
+				if err := os.Truncate(db.path(num, "log"), intact); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	obsolete := []uint64{}
+	for level, files := range m.Levels {
+		kept := []*fileMeta{}
+		for _, f := range files {
+			report.Files++
+			entries, n, damaged := db.checkTable(f.Num, report)
+			report.Records += n
+			if !damaged {
+				kept = append(kept, f)
+				continue
+			}
+			if !repair {
+				continue
+			}
+			obsolete = append(obsolete, f.Num)
+			if len(entries) == 0 {
+				continue
+			}
+			rewritten, err := db.writeTable(entries)
+			if err != nil {
+				return nil, err
+			}
+			kept = append(kept, rewritten)
+		}
+		m.Levels[level] = kept
+	}
+	if repair && len(obsolete) > 0 {
+		if err := m.save(dir); err != nil {
+			return nil, err
+		}
+		for _, num := range obsolete {
+			os.Remove(db.path(num, "sst"))
+		}
+	}
+	report.Repaired = repair && !report.OK()
+	return report, nil
+}
+
+// checkTable verifies table num. It returns the entries of its intact
+// blocks for rewriting a damaged table, the number of entries read and
+// whether the table is damaged.
+func (db *DB) checkTable(num uint64, report *storage.CheckReport) ([]*entry, int, bool) {
+	t, err := openTable(db.path(num, "sst"), db.cipher)
+	if err != nil {
+		report.Problemf("table %06d: %v", num, err)
+		return nil, 0, true
+	}
+	defer t.close()
+
+	var entries []*entry
+	n, damaged, last := 0, false, ""
+	for i, h := range t.index {
+		block, err := t.readBlock(i)
+		if err != nil {
+			report.Problemf("table %06d: block %d at offset %d: %v", num, i, h.offset, err)
+			damaged = true
+			continue
+		}
+		var blockEntries []*entry
+		for len(block) > 0 {
+			e, l, err := readEntry(block)
+			if err == nil && n > 0 && e.key <= last {
+				err = errCorruptTable
+			}
+			if err != nil {
+				report.Problemf("table %06d: block %d at offset %d: %v", num, i, h.offset, err)
+				damaged, blockEntries = true, nil
+				break
+			}
+			blockEntries = append(blockEntries, e)
+			last = e.key
+			n++
+			block = block[l:]
+		}
+		entries = append(entries, blockEntries...)
+	}
+	if !damaged {
+		entries = nil
+	}
+	return entries, n, damaged
+}
+
+// writeTable writes entries sorted by key to a new table.
+func (db *DB) writeTable(entries []*entry) (*fileMeta, error) {
+	num := db.newFileNum()
+	w, err := newTableWriter(db.path(num, "sst"), db.opts.BlockSize, db.opts.BloomBits, db.cipher)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if err := w.add(e); err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	f, err := w.finish()
+	if err != nil {
+		w.abort()
+		return nil, err
+	}
+	f.Num = num
+	return f, nil
+}
@@ -0,0 +1,289 @@
+package lsm
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// writeTables writes the entries of it to new tables of about maxSize
+// bytes, or a single table if maxSize is 0, and returns them opened. When
+// bottom is set no older data exists below the output, so tombstones and
+// expired entries are dropped; otherwise expired entries become tombstones,
+// because older versions of their keys may still exist further down. It
+// does not need db.mu: the tables are only added to db.tables by the caller.
+func (db *DB) writeTables(it iterator, maxSize int64, bottom bool) ([]*fileMeta, []*table, error) {
+	now := time.Now().UnixNano()
+	files := []*fileMeta{}
+	tables := []*table{}
+	var w *tableWriter
+	var num uint64
+
+	finish := func() error {
+		meta, err := w.finish()
+		if err != nil {
+			return err
+		}
+		meta.Num = num
+		t, err := openTable(db.path(num, "sst"), db.cipher)
+		if err != nil {
+			return err
+		}
+		files = append(files, meta)
+		tables = append(tables, t)
+		w = nil
+		return nil
+	}
+	fail := func(err error) ([]*fileMeta, []*table, error) {
+		if w != nil {
+			w.abort()
+		}
+		for i, f := range files {
+			tables[i].close()
+			os.Remove(db.path(f.Num, "sst"))
+		}
+		return nil, nil, err
+	}
+
+	for it.next() {
+		e := it.entry()
+		if !e.live(now) {
+			if bottom {
+				continue
+			}
+			if e.kind == kindPut {
+				e = &entry{key: e.key, kind: kindDelete}
+			}
+		}
+		if w == nil {
+			num = db.newFileNum()
+			var err error
+			if w, err = newTableWriter(db.path(num, "sst"), db.opts.BlockSize, db.opts.BloomBits, db.cipher); err != nil {
+				return fail(err)
+			}
+		}
+		if err := w.add(e); err != nil {
+			return fail(err)
+		}
+		if maxSize > 0 && w.size() >= maxSize {
+			if err := finish(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := it.error(); err != nil {
+		return fail(err)
+	}
+	if w != nil {
+		if err := finish(); err != nil {
+			return fail(err)
+		}
+	}
+	return files, tables, nil
+}
+
+// addTablesLocked makes the tables written by writeTables readable.
+// db.mu must be held.
+func (db *DB) addTablesLocked(files []*fileMeta, tables []*table) {
+	for i, f := range files {
+		db.tables[f.Num] = tables[i]
+	}
+}
+
+func (db *DB) levelBytes(level int) int64 {
+	var size int64
+	for _, f := range db.manifest.Levels[level] {
+		size += f.Size
+	}
+	return size
+}
+
+func (db *DB) maxLevelBytes(level int) int64 {
+	size := db.opts.LevelSize
+	for i := 1; i < level; i++ {
+		size *= 10
+	}
+	return size
+}
+
+// pickCompaction returns the level and the tables of the next compaction,
+// or nil when the tree is in shape.
+func (db *DB) pickCompaction() (int, []*fileMeta) {
+	levels := db.manifest.Levels
+	if len(levels[0]) >= db.opts.L0Tables {
+		return 0, levels[0]
+	}
+	for level := 1; level < numLevels-1; level++ {
+		files := levels[level]
+		if len(files) == 0 || db.levelBytes(level) <= db.maxLevelBytes(level) {
+			continue
+		}
+		for _, f := range files {
+			if f.Smallest > db.compactKey[level] {
+				return level, []*fileMeta{f}
+			}
+		}
+		return level, files[:1]
+	}
+	return 0, nil
+}
+
+// maybeCompactLocked starts compacting in the background when the tree is
+// out of shape and no compaction runs. db.mu must be held.
+func (db *DB) maybeCompactLocked() {
+	if db.compacting || db.closed || db.compactErr != nil {
+		return
+	}
+	if _, inputs := db.pickCompaction(); inputs == nil {
+		return
+	}
+	db.compacting = true
+	db.compactions.Add(1)
+	go db.compactLoop()
+}
+
+// compactLoop runs compactions one at a time until the tree is in shape.
+// A failed compaction is kept in db.compactErr and fails later writes, as
+// level 0 would otherwise grow without bound; reopening the engine retries.
+func (db *DB) compactLoop() {
+	defer db.compactions.Done()
+	for {
+		db.mu.Lock()
+		level, inputs := db.pickCompaction()
+		if inputs == nil || db.closed {
+			db.compacting = false
+			db.mu.Unlock()
+			return
+		}
+		db.mu.Unlock()
+
+		if err := db.compact(level, inputs); err != nil {
+			db.mu.Lock()
+			db.compacting, db.compactErr = false, err
+			db.mu.Unlock()
+			return
+		}
+	}
+}
+
+// waitCompactions waits for the background compactions to finish.
+func (db *DB) waitCompactions() {
+	db.compactions.Wait()
+}
+
+// compact merges the inputs of level with the overlapping tables of the
+// next level into new tables of the next level. The tables are written
+// without holding db.mu, which is only taken to pick the overlapping tables
+// and to swap in the new manifest. Only one compaction runs at a time and
+// flushes only append to level 0, so the inputs are still in place then.
+func (db *DB) compact(level int, inputs []*fileMeta) error {
+	smallest, largest := inputs[0].Smallest, inputs[0].Largest
+	for _, f := range inputs[1:] {
+		if f.Smallest < smallest {
+			smallest = f.Smallest
+		}
+		if f.Largest > largest {
+			largest = f.Largest
+		}
+	}
+
+	db.mu.Lock()
+	overlapping := []*fileMeta{}
+	for _, f := range db.manifest.Levels[level+1] {
+		if f.overlaps(smallest, largest) {
+			overlapping = append(overlapping, f)
+		}
+	}
+	iters := []iterator{}
+	for i := len(inputs) - 1; i >= 0; i-- {
+		iters = append(iters, db.tables[inputs[i].Num].iter())
+	}
+	if len(overlapping) > 0 {
+		iters = append(iters, db.levelIter(overlapping))
+	}
+	bottom := true
+	for _, files := range db.manifest.Levels[level+2:] {
+		if len(files) > 0 {
+			bottom = false
+		}
+	}
+	db.mu.Unlock()
+
+	outputs, tables, err := db.writeTables(newMergeIter(iters...), db.opts.TableSize, bottom)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.addTablesLocked(outputs, tables)
+	obsolete := append(append([]*fileMeta{}, inputs...), overlapping...)
+	db.manifest.Levels[level] = without(db.manifest.Levels[level], inputs)
+	next := append(without(db.manifest.Levels[level+1], overlapping), outputs...)
+	sort.Slice(next, func(i, j int) bool { return next[i].Smallest < next[j].Smallest })
+	db.manifest.Levels[level+1] = next
+	if err := db.manifest.save(db.dir); err != nil {
+		return err
+	}
+	db.compactKey[level] = largest
+
+	for _, f := range obsolete {
+		db.dropTableLocked(f.Num)
+	}
+	return nil
+}
+
+// rotateLocked rewrites the tables not sealed with the current key, which
+// are plain tables written before encryption was enabled and tables sealed
+// with an old key. Run when the engine is opened, it completes a key
+// rotation, after which the old keys are no longer needed.
+func (db *DB) rotateLocked() error {
+	if db.cipher == nil {
+		return nil
+	}
+	obsolete := []uint64{}
+	for _, files := range db.manifest.Levels {
+		for i, f := range files {
+			t := db.tables[f.Num]
+			if t.cipher != nil && t.keyID == db.cipher.KeyID() {
+				continue
+			}
+			// A table rewritten alone keeps its keys and so its place in
+			// the level. Tombstones are kept, older data may lie below.
+			outputs, tables, err := db.writeTables(t.iter(), 0, false)
+			if err != nil {
+				return err
+			}
+			db.addTablesLocked(outputs, tables)
+			if len(outputs) != 1 {
+				return errCorruptTable
+			}
+			files[i] = outputs[0]
+			obsolete = append(obsolete, f.Num)
+		}
+	}
+	if len(obsolete) == 0 {
+		return nil
+	}
+	if err := db.manifest.save(db.dir); err != nil {
+		return err
+	}
+	for _, num := range obsolete {
+		db.dropTableLocked(num)
+	}
+	return nil
+}
+
+func without(files, remove []*fileMeta) []*fileMeta {
+	removed := make(map[uint64]bool, len(remove))
+	for _, f := range remove {
+		removed[f.Num] = true
+	}
+	kept := []*fileMeta{}
+	for _, f := range files {
+		if !removed[f.Num] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
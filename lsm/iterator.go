@@ -0,0 +1,103 @@
+package lsm
+
+// iterator walks entries in increasing key order. next must be called
+// before the first entry.
+type iterator interface {
+	next() bool
+	entry() *entry
+	error() error
+}
+
+type sliceIter struct {
+	entries []*entry
+	i       int
+}
+
+func (it *sliceIter) next() bool {
+	it.i++
+	return it.i < len(it.entries)
+}
+
+func (it *sliceIter) entry() *entry {
+	return it.entries[it.i]
+}
+
+func (it *sliceIter) error() error {
+	return nil
+}
+
+// concatIter walks iterators over consecutive key ranges one after another,
+// e.g. the tables of a level above 0.
+type concatIter struct {
+	iters []iterator
+	err   error
+}
+
+func (it *concatIter) next() bool {
+	for len(it.iters) > 0 {
+		if it.iters[0].next() {
+			return true
+		}
+		if it.err = it.iters[0].error(); it.err != nil {
+			return false
+		}
+		it.iters = it.iters[1:]
+	}
+	return false
+}
+
+func (it *concatIter) entry() *entry {
+	return it.iters[0].entry()
+}
+
+func (it *concatIter) error() error {
+	return it.err
+}
+
+// mergeIter merges iterators ordered from the newest to the oldest data.
+// When several of them hold a key only the newest entry is returned.
+type mergeIter struct {
+	iters []iterator
+	valid []bool
+	cur   *entry
+}
+
+func newMergeIter(iters ...iterator) *mergeIter {
+	m := &mergeIter{iters: iters, valid: make([]bool, len(iters))}
+	for i, it := range iters {
+		m.valid[i] = it.next()
+	}
+	return m
+}
+
+func (m *mergeIter) next() bool {
+	best := -1
+	for i, it := range m.iters {
+		if m.valid[i] && (best < 0 || it.entry().key < m.iters[best].entry().key) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return false
+	}
+	m.cur = m.iters[best].entry()
+	for i, it := range m.iters {
+		if m.valid[i] && it.entry().key == m.cur.key {
+			m.valid[i] = it.next()
+		}
+	}
+	return true
+}
+
+func (m *mergeIter) entry() *entry {
+	return m.cur
+}
+
+func (m *mergeIter) error() error {
+	for _, it := range m.iters {
+		if err := it.error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,563 @@
+// Package lsm is a disk backed storage.Engine built as a log-structured
+// merge tree, for datasets larger than memory.
+//
+// Writes go to a write ahead log and a memtable. A full memtable is flushed
+// to an immutable sorted table in level 0; tables are merged into the next
+// levels by leveled compaction, in the background. Removed keys are written as tombstones.
+// Expired keys are skipped by reads and turned into tombstones by flushes
+// and compactions; tombstones are dropped once they reach the last level.
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"my-go-db/storage"
+)
+
+var (
+	ErrClosed  = errors.New("Storage is closed")
+	ErrCorrupt = errors.New("Corrupt data file")
+)
+
+type Options struct {
+	MemtableSize int   // bytes of writes buffered before a flush, 4 MB by default
+	BlockSize    int   // target size of table blocks, 4 KB by default
+	BloomBits    int   // bits per key of table filters, 10 by default
+	L0Tables     int   // level 0 tables triggering a compaction, 4 by default
+	LevelSize    int64 // maximum bytes of level 1, 10 MB by default; each next level is 10 times larger
+	TableSize    int64 // target size of tables written by compactions, 2 MB by default
+	SyncWrites   bool  // fsync the log after every write
+
+	Compression storage.Compression // compression of large values, none by default
+	Recovery    storage.Recovery    // what to do with a damaged end of the log, truncate by default
+	Encryption  storage.Encryption  // encryption of the files, none by default
+}
+
+func (o *Options) withDefaults() Options {
+	opts := Options{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = 4 << 20
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 4 << 10
+	}
+	if opts.BloomBits <= 0 {
+		opts.BloomBits = 10
+	}
+	if opts.L0Tables <= 0 {
+		opts.L0Tables = 4
+	}
+	if opts.LevelSize <= 0 {
+		opts.LevelSize = 10 << 20
+	}
+	if opts.TableSize <= 0 {
+		opts.TableSize = 2 << 20
+	}
+	return opts
+}
+
+type DB struct {
+	mu       sync.RWMutex
+	dir      string
+	opts     Options
+	cipher   *storage.Cipher
+	manifest *manifest
+	tables   map[uint64]*table
+	mem      *memtable
+	log      *wal
+	closed   bool
+
+	// compactKey is the largest key compacted per level, compactions of a
+	// level walk its key space round robin.
+	compactKey []string
+	// compacting is set while a background compaction runs; compactErr is
+	// the error that stopped the last one.
+	compacting  bool
+	compactErr  error
+	compactions sync.WaitGroup
+	// dumps counts the running dumps; tables they read are only dropped
+	// once they are all done.
+	dumps    int
+	obsolete []uint64
+}
+
+// Open opens the engine stored in dir, creating it if needed, and recovers
+// writes not flushed before a crash from the log. opts may be nil.
+func Open(dir string, opts *Options) (*DB, error) {
+	o := opts.withDefaults()
+	if err := o.Compression.Validate(); err != nil {
+		return nil, err
+	}
+	if err := o.Recovery.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := o.Encryption.Cipher()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:        dir,
+		opts:       o,
+		cipher:     c,
+		manifest:   m,
+		tables:     make(map[uint64]*table),
+		mem:        newMemtable(),
+		compactKey: make([]string, numLevels),
+	}
+	if err := db.recover(); err != nil {
+		db.closeFiles()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) path(num uint64, ext string) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%06d.%s", num, ext))
+}
+
+// files returns the numbers of the files in dir with the extension.
+func (db *DB) files(ext string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(db.dir, "*."+ext))
+	if err != nil {
+		return nil, err
+	}
+	nums := []uint64{}
+	for _, name := range names {
+		num, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), "."+ext), 10, 64)
+		if err == nil {
+			nums = append(nums, num)
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+func (db *DB) recover() error {
+	for _, level := range db.manifest.Levels {
+		for _, f := range level {
+			t, err := openTable(db.path(f.Num, "sst"), db.cipher)
+			if err != nil {
+				return fmt.Errorf("Could not open table %d: %v", f.Num, err)
+			}
+			db.tables[f.Num] = t
+		}
+	}
+
+	logs, err := db.files("log")
+	if err != nil {
+		return err
+	}
+	for _, num := range logs {
+		if num >= db.manifest.LogNumber {
+			intact, size, err := replayWAL(db.path(num, "log"), db.cipher, db.mem.put)
+			if err != nil {
+				return err
+			}
+			if intact < size && db.opts.Recovery.Strict() {
+				return fmt.Errorf("%w: log %06d has %d damaged bytes at offset %d", ErrCorrupt, num, size-intact, intact)
+			}
+		}
+	}
+	// Flushing the recovered writes starts a new log
+	if err := db.flushLocked(); err != nil {
+		return err
+	}
+	if err := db.removeObsolete(); err != nil {
+		return err
+	}
+	if err := db.rotateLocked(); err != nil {
+		return err
+	}
+	db.maybeCompactLocked()
+	return nil
+}
+
+// removeObsolete deletes logs and tables left over by a crash.
+func (db *DB) removeObsolete() error {
+	logs, err := db.files("log")
+	if err != nil {
+		return err
+	}
+	for _, num := range logs {
+		if num != db.manifest.LogNumber {
+			os.Remove(db.path(num, "log"))
+		}
+	}
+	tables, err := db.files("sst")
+	if err != nil {
+		return err
+	}
+	for _, num := range tables {
+		if db.tables[num] == nil {
+			os.Remove(db.path(num, "sst"))
+		}
+	}
+	os.Remove(filepath.Join(db.dir, manifestName+".tmp"))
+	return nil
+}
+
+func (db *DB) newFileNum() uint64 {
+	return db.manifest.newFileNum()
+}
+
+// flushLocked writes the memtable to a level 0 table and switches to a new
+// log. If the manifest cannot be saved the new tables and log are removed
+// and the memtable stays on the current log. db.mu must be held.
+func (db *DB) flushLocked() error {
+	var files []*fileMeta
+	var tables []*table
+	if len(db.mem.entries) > 0 {
+		var err error
+		if files, tables, err = db.writeTables(db.mem.iter(), 0, false); err != nil {
+			return err
+		}
+	}
+	discard := func() {
+		for i, f := range files {
+			tables[i].close()
+			os.Remove(db.path(f.Num, "sst"))
+		}
+	}
+
+	num := db.newFileNum()
+	log, err := createWAL(db.path(num, "log"), db.opts.SyncWrites, db.cipher)
+	if err != nil {
+		discard()
+		return err
+	}
+	level0, oldNum := db.manifest.Levels[0], db.manifest.LogNumber
+	db.manifest.Levels[0] = append(level0[:len(level0):len(level0)], files...)
+	db.manifest.LogNumber = num
+	if err := db.manifest.save(db.dir); err != nil {
+		db.manifest.Levels[0], db.manifest.LogNumber = level0, oldNum
+		log.close()
+		os.Remove(db.path(num, "log"))
+		discard()
+		return err
+	}
+	db.addTablesLocked(files, tables)
+	if db.log != nil {
+		db.log.close()
+		os.Remove(db.path(oldNum, "log"))
+	}
+	db.log = log
+	db.mem = newMemtable()
+	return nil
+}
+
+// writeLocked logs and applies entries. db.mu must be held.
+func (db *DB) writeLocked(entries ...*entry) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if db.compactErr != nil {
+		return db.compactErr
+	}
+	if err := db.log.append(entries...); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		db.mem.put(e)
+	}
+	if db.mem.size < db.opts.MemtableSize {
+		return nil
+	}
+	if err := db.flushLocked(); err != nil {
+		return err
+	}
+	db.maybeCompactLocked()
+	return nil
+}
+
+// getLocked returns the newest entry of key, which may be a tombstone.
+func (db *DB) getLocked(key string) (*entry, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if e := db.mem.get(key); e != nil {
+		return e, nil
+	}
+	level0 := db.manifest.Levels[0]
+	for i := len(level0) - 1; i >= 0; i-- {
+		if f := level0[i]; f.overlaps(key, key) {
+			if e, err := db.tables[f.Num].get(key); e != nil || err != nil {
+				return e, err
+			}
+		}
+	}
+	for _, files := range db.manifest.Levels[1:] {
+		i := sort.Search(len(files), func(i int) bool {
+			return files[i].Largest >= key
+		})
+		if i < len(files) && files[i].Smallest <= key {
+			if e, err := db.tables[files[i].Num].get(key); e != nil || err != nil {
+				return e, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// iterLocked merges the memtable and all tables, newest data first.
+func (db *DB) iterLocked() iterator {
+	iters := []iterator{db.mem.iter()}
+	level0 := db.manifest.Levels[0]
+	for i := len(level0) - 1; i >= 0; i-- {
+		iters = append(iters, db.tables[level0[i].Num].iter())
+	}
+	for _, files := range db.manifest.Levels[1:] {
+		if len(files) > 0 {
+			iters = append(iters, db.levelIter(files))
+		}
+	}
+	return newMergeIter(iters...)
+}
+
+func (db *DB) levelIter(files []*fileMeta) iterator {
+	iters := make([]iterator, len(files))
+	for i, f := range files {
+		iters[i] = db.tables[f.Num].iter()
+	}
+	return &concatIter{iters: iters}
+}
+
+func (db *DB) put(key string, item *storage.Item) error {
+	value, err := db.opts.Compression.Encode(item)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.writeLocked(&entry{key: key, kind: kindPut, expiration: item.Expiration(), value: value})
+}
+
+func (db *DB) SetString(key, value string, ttl int) error {
+	if value == "" {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.String = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetInt(key string, value, ttl int) error {
+	item := storage.NewItem(ttl)
+	item.Int = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetStringSlice(key string, value []string, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.StringSlice = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetIntSlice(key string, value []int, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.IntSlice = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetStringMap(key string, value map[string]string, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.StringMap = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetIntMap(key string, value map[string]int, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.IntMap = value
+	return db.put(key, item)
+}
+
+func (db *DB) SetBitmap(key string, value []byte, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.Bitmap = value
+	return db.put(key, item)
+}
+
+// Get returns a decoded copy of the item stored under key, or nil if it is
+// missing or expired.
+func (db *DB) Get(key string) (*storage.Item, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	e, err := db.getLocked(key)
+	db.mu.RUnlock()
+	if err != nil || e == nil || !e.live(now) {
+		return nil, err
+	}
+	item := new(storage.Item)
+	if err := item.UnmarshalBinary(e.value); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CompressionStats returns how the value of key is stored, or nil if the
+// key does not exist.
+func (db *DB) CompressionStats(key string) (*storage.CompressionStats, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	e, err := db.getLocked(key)
+	db.mu.RUnlock()
+	if err != nil || e == nil || !e.live(now) {
+		return nil, err
+	}
+	stats := storage.EncodingStats(e.value)
+	return &stats, nil
+}
+
+func (db *DB) Remove(key string) error {
+	now := time.Now().UnixNano()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.getLocked(key)
+	if err != nil {
+		return err
+	}
+	if e == nil || !e.live(now) {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	return db.writeLocked(&entry{key: key, kind: kindDelete})
+}
+
+// Keys returns the live keys in order. It reads the whole tree.
+func (db *DB) Keys() ([]string, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	keys := []string{}
+	it := db.iterLocked()
+	for it.next() {
+		if e := it.entry(); e.live(now) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys, it.error()
+}
+
+// Expire sets a new ttl in seconds on an existing key, ttl <= 0 removes it.
+func (db *DB) Expire(key string, ttl int) error {
+	now := time.Now().UnixNano()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, err := db.getLocked(key)
+	if err != nil {
+		return err
+	}
+	if e == nil || !e.live(now) {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	item := new(storage.Item)
+	if err := item.UnmarshalBinary(e.value); err != nil {
+		return err
+	}
+	item.SetTTL(ttl)
+	value, err := db.opts.Compression.Encode(item)
+	if err != nil {
+		return err
+	}
+	return db.writeLocked(&entry{key: key, kind: kindPut, expiration: item.Expiration(), value: value})
+}
+
+// TTL returns the remaining time to live of key in seconds, -1 if the key
+// does not expire and -2 if it does not exist.
+func (db *DB) TTL(key string) (int, error) {
+	now := time.Now().UnixNano()
+	db.mu.RLock()
+	e, err := db.getLocked(key)
+	db.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	if e == nil || !e.live(now) {
+		return -2, nil
+	}
+	if e.expiration == 0 {
+		return -1, nil
+	}
+	return int((e.expiration - now + int64(time.Second) - 1) / int64(time.Second)), nil
+}
+
+// DeleteExpired has nothing to do: expired keys are skipped by reads and
+// dropped by flushes and compactions, without reading the whole tree.
+func (db *DB) DeleteExpired() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close closes the files of the engine once a running compaction is done.
+// Unflushed writes are kept in the log.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	db.waitCompactions()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closeFiles()
+}
+
+func (db *DB) closeFiles() error {
+	var err error
+	if db.log != nil {
+		err = db.log.close()
+	}
+	for _, t := range db.tables {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+var (
+	_ storage.Engine            = (*DB)(nil)
+	_ storage.CompressionEngine = (*DB)(nil)
+	_ storage.BackupEngine      = (*DB)(nil)
+)
@@ -0,0 +1,464 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"my-go-db/storage"
+	"my-go-db/storage/enginetest"
+)
+
+// small makes the memtable and levels tiny, so tests cover flushes and
+// compactions with little data.
+var small = &Options{
+	MemtableSize: 4 << 10,
+	BlockSize:    256,
+	L0Tables:     2,
+	LevelSize:    16 << 10,
+	TableSize:    8 << 10,
+}
+
+func open(t *testing.T, dir string, opts *Options) *DB {
+	t.Helper()
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	return db
+}
+
+func TestDB_Engine(t *testing.T) {
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return open(t, t.TempDir(), nil)
+	})
+}
+
+func TestDB_EngineSmallTables(t *testing.T) {
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return open(t, t.TempDir(), small)
+	})
+}
+
+func checkString(t *testing.T, db *DB, key, want string) {
+	t.Helper()
+	item, err := db.Get(key)
+	if err != nil {
+		t.Fatal("Get:", err)
+	}
+	if want == "" {
+		if item != nil {
+			t.Errorf("Key %s must be removed, got %v", key, item.String)
+		}
+	} else if item == nil || item.String != want {
+		t.Errorf("Key %s must be equal %s, got %v", key, want, item)
+	}
+}
+
+func TestDB_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, small)
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			db.SetString(fmt.Sprintf("key:%04d", i), fmt.Sprintf("value-%d-%d", round, i), 0)
+		}
+	}
+	for i := 0; i < 1000; i += 3 {
+		db.Remove(fmt.Sprintf("key:%04d", i))
+	}
+	db.waitCompactions()
+
+	deeper := 0
+	for _, files := range db.manifest.Levels[1:] {
+		deeper += len(files)
+	}
+	if deeper == 0 {
+		t.Fatal("Must compact tables into deeper levels", db.manifest.Levels)
+	}
+	if len(db.manifest.Levels[0]) >= small.L0Tables {
+		t.Error("Must keep level 0 small", len(db.manifest.Levels[0]))
+	}
+
+	check := func(db *DB) {
+		for i := 0; i < 1000; i++ {
+			want := fmt.Sprintf("value-2-%d", i)
+			if i%3 == 0 {
+				want = ""
+			}
+			checkString(t, db, fmt.Sprintf("key:%04d", i), want)
+		}
+		keys, _ := db.Keys()
+		if len(keys) != 666 {
+			t.Error("Must be equal 666", len(keys))
+		}
+	}
+	check(db)
+
+	db.Close()
+	check(open(t, dir, small))
+}
+
+func TestDB_TombstonesDroppedAtBottom(t *testing.T) {
+	db := open(t, t.TempDir(), small)
+	for i := 0; i < 500; i++ {
+		db.SetString(fmt.Sprintf("key:%04d", i), "some value", 0)
+	}
+	for i := 0; i < 500; i++ {
+		db.Remove(fmt.Sprintf("key:%04d", i))
+	}
+	db.waitCompactions()
+	db.mu.Lock()
+	db.flushLocked()
+	db.mu.Unlock()
+	db.compact(0, db.manifest.Levels[0])
+
+	for level, files := range db.manifest.Levels {
+		if len(files) > 0 {
+			t.Error("Must drop every tombstone", level, files)
+		}
+	}
+}
+
+func TestDB_CrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, nil)
+	db.SetString("a", "1", 0)
+	db.SetIntSlice("b", []int{1, 2}, 0)
+	db.SetString("c", "3", 0)
+	db.Remove("c")
+	db.SetString("volatile", "v", 100)
+	// The process dies without closing the engine
+	db.log.f.Sync()
+
+	db = open(t, dir, nil)
+	checkString(t, db, "a", "1")
+	checkString(t, db, "c", "")
+	if item, _ := db.Get("b"); item == nil || len(item.IntSlice) != 2 {
+		t.Error("Must recover b", item)
+	}
+	if ttl, _ := db.TTL("volatile"); ttl < 99 {
+		t.Error("Must recover the ttl", ttl)
+	}
+	db.Close()
+}
+
+func TestDB_FlushSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, nil)
+	db.SetString("a", "1", 0)
+	level0, logNum := len(db.manifest.Levels[0]), db.manifest.LogNumber
+
+	// A directory in the way of the new manifest makes saving it fail
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	os.Mkdir(tmp, 0755)
+	db.mu.Lock()
+	err := db.flushLocked()
+	db.mu.Unlock()
+	if err == nil {
+		t.Fatal("Must fail to save the manifest")
+	}
+	if len(db.manifest.Levels[0]) != level0 || db.manifest.LogNumber != logNum || len(db.tables) != level0 {
+		t.Error("Must keep the manifest as saved", db.manifest.Levels[0], db.manifest.LogNumber)
+	}
+	if tables, _ := db.files("sst"); len(tables) != level0 {
+		t.Error("Must remove the new tables", tables)
+	}
+	if logs, _ := db.files("log"); len(logs) != 1 || logs[0] != logNum {
+		t.Error("Must remove the new log", logs)
+	}
+
+	// Writes go on to the current log and survive the next save
+	os.Remove(tmp)
+	db.SetString("b", "2", 0)
+	db.mu.Lock()
+	db.manifest.save(dir)
+	db.mu.Unlock()
+	db.Close()
+
+	db = open(t, dir, nil)
+	defer db.Close()
+	checkString(t, db, "a", "1")
+	checkString(t, db, "b", "2")
+}
+
+func TestDB_TornLog(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, nil)
+	db.SetString("a", "1", 0)
+	db.SetString("b", "2", 0)
+	path := db.log.f.Name()
+	db.Close()
+
+	// A partially written record at the end of the log
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-3], 0644)
+
+	db = open(t, dir, nil)
+	checkString(t, db, "a", "1")
+	checkString(t, db, "b", "")
+	db.SetString("c", "3", 0)
+	db.Close()
+
+	db = open(t, dir, nil)
+	checkString(t, db, "a", "1")
+	checkString(t, db, "c", "3")
+	db.Close()
+}
+
+func TestDB_CrashDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, nil)
+	db.SetString("a", "1", 0)
+	db.Close()
+
+	// Tables written by a compaction the manifest never saw
+	orphan := filepath.Join(dir, "999999.sst")
+	os.WriteFile(orphan, []byte("partial"), 0644)
+
+	db = open(t, dir, nil)
+	defer db.Close()
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("Must remove the orphan table", err)
+	}
+	checkString(t, db, "a", "1")
+}
+
+func TestDB_ExpiredDroppedByCompaction(t *testing.T) {
+	db := open(t, t.TempDir(), small)
+	db.SetString("a", "1", 0)
+	db.mu.Lock()
+	db.writeLocked(&entry{key: "b", kind: kindPut, expiration: 1, value: []byte("v")})
+	db.flushLocked()
+	if e, _ := db.getLocked("b"); e == nil || e.kind != kindDelete {
+		t.Error("Must flush expired keys as tombstones", e)
+	}
+	db.mu.Unlock()
+	db.compact(0, db.manifest.Levels[0])
+
+	if e, _ := db.getLocked("b"); e != nil {
+		t.Error("Must drop expired keys at the bottom", e)
+	}
+	checkString(t, db, "a", "1")
+	db.Close()
+}
+
+func TestDB_Closed(t *testing.T) {
+	db := open(t, t.TempDir(), nil)
+	db.Close()
+	if err := db.SetString("a", "1", 0); err != ErrClosed {
+		t.Error("Must return ErrClosed", err)
+	}
+	if _, err := db.Get("a"); err != ErrClosed {
+		t.Error("Must return ErrClosed", err)
+	}
+}
+
+func TestDB_Compression(t *testing.T) {
+	dir := t.TempDir()
+	opts := *small
+	opts.Compression = storage.Compression{Algorithm: "flate", Threshold: 128}
+	db := open(t, dir, &opts)
+	for i := 0; i < 200; i++ {
+		db.SetString(fmt.Sprintf("key:%03d", i), fmt.Sprintf("%0500d", i), 0)
+	}
+	db.Close()
+
+	db = open(t, dir, small)
+	defer db.Close()
+	for i := 0; i < 200; i += 7 {
+		checkString(t, db, fmt.Sprintf("key:%03d", i), fmt.Sprintf("%0500d", i))
+	}
+	stats, err := db.CompressionStats("key:042")
+	if err != nil || stats == nil || stats.Algorithm != "flate" || stats.Size >= stats.RawSize {
+		t.Error("Must store the value compressed", stats, err)
+	}
+	if _, err := Open(t.TempDir(), &Options{Compression: storage.Compression{Algorithm: "lz4"}}); err == nil {
+		t.Error("Must reject unknown algorithms")
+	}
+}
+
+func TestDB_StrictRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, nil)
+	db.SetString("a", "1", 0)
+	db.SetString("b", "2", 0)
+	path := db.log.f.Name()
+	db.Close()
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-3], 0644)
+
+	strict := &Options{Recovery: storage.RecoverStrict}
+	if _, err := Open(dir, strict); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("Must refuse a damaged log", err)
+	}
+	report, err := Check(dir, nil, false)
+	if err != nil || report.OK() || report.Records != 1 {
+		t.Fatal("Must report the damaged log", report, err)
+	}
+	if report, _ = Check(dir, nil, true); !report.Repaired {
+		t.Error("Must repair the log", report)
+	}
+	if report, _ = Check(dir, nil, false); !report.OK() {
+		t.Error("Must be intact after repair", report.Problems)
+	}
+
+	db = open(t, dir, strict)
+	defer db.Close()
+	checkString(t, db, "a", "1")
+	checkString(t, db, "b", "")
+}
+
+func TestCheck_Tables(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, small)
+	for i := 0; i < 300; i++ {
+		db.SetString(fmt.Sprintf("key:%03d", i), fmt.Sprintf("%050d", i), 0)
+	}
+	db.Close()
+	db = open(t, dir, small)
+	db.Close()
+
+	report, err := Check(dir, nil, false)
+	if err != nil || !report.OK() || report.Records < 300 {
+		t.Fatal("Must verify intact tables", report, err)
+	}
+
+	// Flip a byte in the first block of a table
+	var damaged *fileMeta
+	for _, level := range db.manifest.Levels {
+		if len(level) > 0 {
+			damaged = level[0]
+			break
+		}
+	}
+	path := db.path(damaged.Num, "sst")
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	f.WriteAt([]byte{0xff}, 20)
+	f.Close()
+
+	// Open reads no data blocks, reading the keys finds the damage
+	db = open(t, dir, small)
+	if _, err := db.Keys(); err != errBlockCRC {
+		t.Error("Must detect the damaged block", err)
+	}
+	db.Close()
+
+	if report, _ = Check(dir, nil, false); len(report.Problems) != 1 {
+		t.Fatal("Must report the damaged block", report.Problems)
+	}
+	if report, _ = Check(dir, nil, true); !report.Repaired {
+		t.Fatal("Must repair the table", report)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Must replace the damaged table", err)
+	}
+	if report, _ = Check(dir, nil, false); !report.OK() {
+		t.Error("Must be intact after repair", report.Problems)
+	}
+
+	db = open(t, dir, small)
+	defer db.Close()
+	if item, err := db.Get(damaged.Smallest); err != nil || item != nil {
+		t.Error("Must lose the entries of the damaged block", item, err)
+	}
+	i, _ := strconv.Atoi(strings.TrimPrefix(damaged.Largest, "key:"))
+	checkString(t, db, damaged.Largest, fmt.Sprintf("%050d", i))
+}
+
+func TestDB_EngineEncrypted(t *testing.T) {
+	opts := *small
+	opts.Encryption = storage.Encryption{Key: make([]byte, 32)}
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return open(t, t.TempDir(), &opts)
+	})
+}
+
+// containsFile tells whether a file of dir contains s.
+func containsFile(t *testing.T, dir, s string) bool {
+	t.Helper()
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Encryption(t *testing.T) {
+	dir := t.TempDir()
+	key1 := storage.Encryption{Key: []byte(strings.Repeat("1", 32))}
+	opts := *small
+	opts.Encryption = key1
+	db := open(t, dir, &opts)
+	for i := 0; i < 200; i++ {
+		db.SetString(fmt.Sprintf("email:%03d", i), fmt.Sprintf("user%03d@example.com", i), 0)
+	}
+	db.waitCompactions()
+	if len(db.manifest.Levels[1]) == 0 || len(db.mem.entries) == 0 {
+		t.Fatal("Must have tables and a log")
+	}
+	if containsFile(t, dir, "email:") || containsFile(t, dir, "example.com") {
+		t.Error("Must not store keys or values in plain text")
+	}
+	db.Close()
+
+	if _, err := Open(dir, small); err != storage.ErrEncrypted {
+		t.Error("Must require a key", err)
+	}
+	wrong := *small
+	wrong.Encryption = storage.Encryption{Key: []byte(strings.Repeat("2", 32))}
+	if _, err := Open(dir, &wrong); !errors.Is(err, storage.ErrEncryptionKey) {
+		t.Error("Must refuse a wrong key", err)
+	}
+	if report, err := Check(dir, &opts, false); err != nil || !report.OK() || report.Records == 0 {
+		t.Error("Must check encrypted files", report, err)
+	}
+
+	db = open(t, dir, &opts)
+	defer db.Close()
+	checkString(t, db, "email:007", "user007@example.com")
+	checkString(t, db, "email:199", "user199@example.com")
+}
+
+func TestDB_KeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, small)
+	for i := 0; i < 200; i++ {
+		db.SetString(fmt.Sprintf("key:%03d", i), fmt.Sprintf("value%03d", i), 0)
+	}
+	db.Close()
+
+	// Encrypting plain data, then rotating the key
+	key1 := storage.Encryption{Key: []byte(strings.Repeat("1", 32))}
+	key2 := storage.Encryption{Key: []byte(strings.Repeat("2", 32)), OldKeys: [][]byte{key1.Key}}
+	for _, enc := range []storage.Encryption{key1, key2} {
+		opts := *small
+		opts.Encryption = enc
+		db = open(t, dir, &opts)
+		db.SetString("key:200", "new", 0)
+		db.Close()
+		if containsFile(t, dir, "key:") {
+			t.Error("Must rewrite plain tables encrypted")
+		}
+	}
+
+	opts := *small
+	opts.Encryption = storage.Encryption{Key: key2.Key}
+	db = open(t, dir, &opts)
+	defer db.Close()
+	for i := 0; i < 200; i += 11 {
+		checkString(t, db, fmt.Sprintf("key:%03d", i), fmt.Sprintf("value%03d", i))
+	}
+	checkString(t, db, "key:200", "new")
+}
@@ -0,0 +1,126 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"my-go-db/storage"
+)
+
+// The manifest lists the live tables of every level and the log holding the
+// writes not flushed yet. It is replaced atomically on every change, so a
+// crash leaves either the old or the new set of files; files of the other
+// set are removed when the engine is opened again. Encrypted manifests are
+// manifestMagic followed by the sealed JSON.
+const (
+	manifestName  = "MANIFEST"
+	manifestMagic = "mfmygdbe"
+	numLevels     = 7
+)
+
+type fileMeta struct {
+	Num      uint64 `json:"num"`
+	Size     int64  `json:"size"`
+	Smallest string `json:"smallest"`
+	Largest  string `json:"largest"`
+}
+
+func (f *fileMeta) overlaps(smallest, largest string) bool {
+	return f.Largest >= smallest && f.Smallest <= largest
+}
+
+type manifest struct {
+	NextFile  uint64        `json:"next_file"`
+	LogNumber uint64        `json:"log_number"`
+	Levels    [][]*fileMeta `json:"levels"` // level 0 in flush order, others by key
+
+	cipher *storage.Cipher // seals the manifest when saved, nil for none
+	// numMu guards NextFile, as compactions number their tables without
+	// holding the lock of the engine.
+	numMu sync.Mutex
+}
+
+func newManifest(c *storage.Cipher) *manifest {
+	return &manifest{NextFile: 1, Levels: make([][]*fileMeta, numLevels), cipher: c}
+}
+
+// loadManifest reads the manifest of dir, which must have been sealed with
+// one of the keys of c if it is encrypted. As it is read first, opening
+// with a wrong key fails here.
+func loadManifest(dir string, c *storage.Cipher) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return newManifest(c), nil
+	} else if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(manifestMagic)) {
+		if c == nil {
+			return nil, storage.ErrEncrypted
+		}
+		if data, err = c.Open(nil, data[len(manifestMagic):], nil); err != nil {
+			return nil, fmt.Errorf("Manifest: %w", err)
+		}
+	}
+	m := newManifest(c)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	for len(m.Levels) < numLevels {
+		m.Levels = append(m.Levels, nil)
+	}
+	return m, nil
+}
+
+func (m *manifest) newFileNum() uint64 {
+	m.numMu.Lock()
+	defer m.numMu.Unlock()
+	num := m.NextFile
+	m.NextFile++
+	return num
+}
+
+func (m *manifest) save(dir string) error {
+	m.numMu.Lock()
+	data, err := json.Marshal(m)
+	m.numMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if m.cipher != nil {
+		data = m.cipher.Seal([]byte(manifestMagic), data, nil)
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, manifestName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,120 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+type kind byte
+
+const (
+	kindPut    kind = 1
+	kindDelete kind = 2 // tombstone shadowing older versions of the key
+)
+
+var errCorruptEntry = errors.New("Corrupt entry")
+
+// entry is a version of a key as stored in the log, the memtable and the
+// tables. The expiration is copied out of the encoded item, so expired
+// entries can be skipped without decoding them.
+type entry struct {
+	key        string
+	kind       kind
+	expiration int64
+	value      []byte // storage.Item in its binary encoding
+}
+
+func (e *entry) live(now int64) bool {
+	return e.kind == kindPut && (e.expiration == 0 || e.expiration > now)
+}
+
+// size estimates the memory taken by the entry.
+func (e *entry) size() int {
+	return len(e.key) + len(e.value) + 32
+}
+
+// appendEntry encodes e as
+//
+//	key length | key | kind | expiration (varint) | value length | value
+func appendEntry(b []byte, e *entry) []byte {
+	b = binary.AppendUvarint(b, uint64(len(e.key)))
+	b = append(b, e.key...)
+	b = append(b, byte(e.kind))
+	b = binary.AppendVarint(b, e.expiration)
+	b = binary.AppendUvarint(b, uint64(len(e.value)))
+	return append(b, e.value...)
+}
+
+// readEntry decodes the entry at the start of b and returns its length.
+func readEntry(b []byte) (*entry, int, error) {
+	e := new(entry)
+	pos := 0
+
+	n, l := binary.Uvarint(b)
+	if l <= 0 || n > uint64(len(b)-l) {
+		return nil, 0, errCorruptEntry
+	}
+	pos += l
+	e.key = string(b[pos : pos+int(n)])
+	pos += int(n)
+
+	if pos >= len(b) {
+		return nil, 0, errCorruptEntry
+	}
+	e.kind = kind(b[pos])
+	pos++
+	if e.kind != kindPut && e.kind != kindDelete {
+		return nil, 0, errCorruptEntry
+	}
+
+	exp, l := binary.Varint(b[pos:])
+	if l <= 0 {
+		return nil, 0, errCorruptEntry
+	}
+	e.expiration = exp
+	pos += l
+
+	n, l = binary.Uvarint(b[pos:])
+	if l <= 0 || n > uint64(len(b)-pos-l) {
+		return nil, 0, errCorruptEntry
+	}
+	pos += l
+	e.value = append([]byte{}, b[pos:pos+int(n)]...)
+	pos += int(n)
+	return e, pos, nil
+}
+
+// memtable holds the latest writes until they are flushed to a table.
+type memtable struct {
+	entries map[string]*entry
+	size    int
+}
+
+func newMemtable() *memtable {
+	return &memtable{entries: make(map[string]*entry)}
+}
+
+func (m *memtable) put(e *entry) {
+	if old, ok := m.entries[e.key]; ok {
+		m.size -= old.size()
+	}
+	m.entries[e.key] = e
+	m.size += e.size()
+}
+
+func (m *memtable) get(key string) *entry {
+	return m.entries[key]
+}
+
+// iter returns the entries in key order.
+func (m *memtable) iter() iterator {
+	entries := make([]*entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return &sliceIter{entries: entries, i: -1}
+}
@@ -0,0 +1,382 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sort"
+
+	"my-go-db/storage"
+)
+
+// A table is an immutable file of entries sorted by key:
+//
+//	data blocks | index block | bloom filter | footer
+//
+// Data blocks hold consecutive entries and are about blockSize bytes, each
+// followed by the crc32c of its entries. The index has the last key, offset
+// and length of every data block, so a lookup reads a single block. The
+// footer has the offsets and lengths of the index and the filter, the
+// crc32c of the index, the filter and these four numbers, and tableMagic,
+// all little endian uint64.
+//
+// Encrypted tables end with tableMagicSealed instead. Their data blocks,
+// index and filter are sealed, with their offset as additional data, and
+// the blocks have no checksum: the authentication tag covers it.
+const (
+	tableMagic       = 0x32626467796d6c73 // "slmygdb2"
+	tableMagicSealed = 0x65626467796d6c73 // "slmygdbe"
+	tableMagicV1     = 0x31626467796d6c73 // "slmygdb1", without checksums
+	footerSize       = 6 * 8
+	blockTrailerSize = 4
+)
+
+var (
+	errCorruptTable = errors.New("Corrupt table")
+	errTableVersion = errors.New("Table written by an older version without checksums")
+	errBlockCRC     = errors.New("Block checksum mismatch")
+)
+
+type blockHandle struct {
+	lastKey string
+	offset  int64
+	length  int64
+}
+
+type tableWriter struct {
+	f         *os.File
+	w         *bufio.Writer
+	offset    int64
+	blockSize int
+	bloomBits int
+	cipher    *storage.Cipher
+
+	block    []byte
+	lastKey  string
+	index    []byte
+	keys     []string
+	smallest string
+}
+
+// newTableWriter creates a table, sealed with c unless it is nil.
+func newTableWriter(path string, blockSize, bloomBits int, c *storage.Cipher) (*tableWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		f:         f,
+		w:         bufio.NewWriter(f),
+		blockSize: blockSize,
+		bloomBits: bloomBits,
+		cipher:    c,
+	}, nil
+}
+
+// add appends an entry, keys must be added in increasing order.
+func (w *tableWriter) add(e *entry) error {
+	if len(w.keys) == 0 {
+		w.smallest = e.key
+	}
+	w.keys = append(w.keys, e.key)
+	w.block = appendEntry(w.block, e)
+	w.lastKey = e.key
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	data := w.seal(w.block, w.offset)
+	if w.cipher == nil {
+		data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	w.index = binary.AppendUvarint(w.index, uint64(len(w.lastKey)))
+	w.index = append(w.index, w.lastKey...)
+	w.index = binary.AppendUvarint(w.index, uint64(w.offset))
+	w.index = binary.AppendUvarint(w.index, uint64(len(data)))
+	w.offset += int64(len(data))
+	w.block = data[:0]
+	return nil
+}
+
+// seal returns data sealed for offset, or data itself without a cipher.
+func (w *tableWriter) seal(data []byte, offset int64) []byte {
+	if w.cipher == nil {
+		return data
+	}
+	return w.cipher.Seal(nil, data, recordAD(offset))
+}
+
+// size returns the number of bytes written so far.
+func (w *tableWriter) size() int64 {
+	return w.offset + int64(len(w.block))
+}
+
+// finish writes the index, filter and footer and syncs the file.
+func (w *tableWriter) finish() (*fileMeta, error) {
+	if err := w.flushBlock(); err != nil {
+		return nil, err
+	}
+	filter := newBloom(len(w.keys), w.bloomBits)
+	for _, k := range w.keys {
+		filter.add(k)
+	}
+	index := w.seal(w.index, w.offset)
+	bloomData := w.seal(filter.encode(), w.offset+int64(len(index)))
+	magic := uint64(tableMagic)
+	if w.cipher != nil {
+		magic = tableMagicSealed
+	}
+
+	footer := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(footer[0:], uint64(w.offset))
+	binary.LittleEndian.PutUint64(footer[8:], uint64(len(index)))
+	binary.LittleEndian.PutUint64(footer[16:], uint64(w.offset)+uint64(len(index)))
+	binary.LittleEndian.PutUint64(footer[24:], uint64(len(bloomData)))
+	binary.LittleEndian.PutUint64(footer[32:], uint64(metaChecksum(index, bloomData, footer[:32])))
+	binary.LittleEndian.PutUint64(footer[40:], magic)
+
+	for _, b := range [][]byte{index, bloomData, footer} {
+		if _, err := w.w.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := w.f.Sync(); err != nil {
+		return nil, err
+	}
+	meta := &fileMeta{
+		Size:     w.offset + int64(len(index)+len(bloomData)+footerSize),
+		Smallest: w.smallest,
+		Largest:  w.lastKey,
+	}
+	return meta, w.f.Close()
+}
+
+func metaChecksum(index, filter, footer []byte) uint32 {
+	crc := crc32.Update(0, crcTable, index)
+	crc = crc32.Update(crc, crcTable, filter)
+	return crc32.Update(crc, crcTable, footer)
+}
+
+// abort removes a partially written table.
+func (w *tableWriter) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// table is an open table file. Blocks are read with ReadAt, so concurrent
+// lookups need no locking.
+type table struct {
+	f      *os.File
+	index  []blockHandle
+	filter *bloom
+	cipher *storage.Cipher // opens the blocks of sealed tables, nil for plain ones
+	keyID  uint32          // id of the key that sealed the table
+}
+
+// openTable opens a table, with c if it is sealed.
+func openTable(path string, c *storage.Cipher) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readTable(f, c)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func readTable(f *os.File, c *storage.Cipher) (*table, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < footerSize {
+		return nil, errCorruptTable
+	}
+	footer := make([]byte, footerSize)
+	if _, err := f.ReadAt(footer, info.Size()-footerSize); err != nil {
+		return nil, err
+	}
+	t := &table{f: f}
+	switch binary.LittleEndian.Uint64(footer[40:]) {
+	case tableMagic:
+	case tableMagicSealed:
+		if c == nil {
+			return nil, storage.ErrEncrypted
+		}
+		t.cipher = c
+	case tableMagicV1:
+		return nil, errTableVersion
+	default:
+		return nil, errCorruptTable
+	}
+	indexOffset := binary.LittleEndian.Uint64(footer[0:])
+	indexLength := binary.LittleEndian.Uint64(footer[8:])
+	bloomOffset := binary.LittleEndian.Uint64(footer[16:])
+	bloomLength := binary.LittleEndian.Uint64(footer[24:])
+	if indexOffset+indexLength != bloomOffset || bloomOffset+bloomLength != uint64(info.Size()-footerSize) {
+		return nil, errCorruptTable
+	}
+
+	meta := make([]byte, indexLength+bloomLength)
+	if _, err := f.ReadAt(meta, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+	if metaChecksum(meta[:indexLength], meta[indexLength:], footer[:32]) != uint32(binary.LittleEndian.Uint64(footer[32:])) {
+		return nil, errCorruptTable
+	}
+	data, filter := meta[:indexLength], meta[indexLength:]
+	if t.cipher != nil {
+		t.keyID = storage.SealedKeyID(data)
+		if data, err = c.Open(nil, data, recordAD(int64(indexOffset))); err != nil {
+			return nil, err
+		}
+		if filter, err = c.Open(nil, filter, recordAD(int64(bloomOffset))); err != nil {
+			return nil, err
+		}
+	}
+	var ok bool
+	if t.filter, ok = decodeBloom(filter); !ok {
+		return nil, errCorruptTable
+	}
+
+	for len(data) > 0 {
+		var h blockHandle
+		n, l := binary.Uvarint(data)
+		if l <= 0 || n > uint64(len(data)-l) {
+			return nil, errCorruptTable
+		}
+		h.lastKey = string(data[l : l+int(n)])
+		data = data[l+int(n):]
+		off, l1 := binary.Uvarint(data)
+		if l1 <= 0 {
+			return nil, errCorruptTable
+		}
+		length, l2 := binary.Uvarint(data[l1:])
+		if l2 <= 0 || length < t.trailerSize() || off+length > indexOffset {
+			return nil, errCorruptTable
+		}
+		data = data[l1+l2:]
+		h.offset, h.length = int64(off), int64(length)
+		t.index = append(t.index, h)
+	}
+	return t, nil
+}
+
+// trailerSize is the number of bytes blocks have besides their entries.
+func (t *table) trailerSize() uint64 {
+	if t.cipher != nil {
+		return uint64(t.cipher.Overhead())
+	}
+	return blockTrailerSize
+}
+
+// readBlock returns the entries of block i after verifying its checksum.
+func (t *table) readBlock(i int) ([]byte, error) {
+	h := t.index[i]
+	block := make([]byte, h.length)
+	if _, err := t.f.ReadAt(block, h.offset); err != nil {
+		return nil, err
+	}
+	if t.cipher != nil {
+		data, err := t.cipher.Open(nil, block, recordAD(h.offset))
+		if err == storage.ErrDecrypt {
+			return nil, errBlockCRC
+		}
+		return data, err
+	}
+	data := block[:len(block)-blockTrailerSize]
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(block[len(data):]) {
+		return nil, errBlockCRC
+	}
+	return data, nil
+}
+
+// get returns the entry of key, or nil if the table has none.
+func (t *table) get(key string) (*entry, error) {
+	if !t.filter.mayContain(key) {
+		return nil, nil
+	}
+	i := sort.Search(len(t.index), func(i int) bool {
+		return t.index[i].lastKey >= key
+	})
+	if i == len(t.index) {
+		return nil, nil
+	}
+	block, err := t.readBlock(i)
+	if err != nil {
+		return nil, err
+	}
+	for len(block) > 0 {
+		e, n, err := readEntry(block)
+		if err != nil {
+			return nil, errCorruptTable
+		}
+		if e.key == key {
+			return e, nil
+		} else if e.key > key {
+			break
+		}
+		block = block[n:]
+	}
+	return nil, nil
+}
+
+func (t *table) iter() iterator {
+	return &tableIter{t: t}
+}
+
+func (t *table) close() error {
+	return t.f.Close()
+}
+
+type tableIter struct {
+	t     *table
+	block int
+	data  []byte
+	cur   *entry
+	err   error
+}
+
+func (it *tableIter) next() bool {
+	for len(it.data) == 0 {
+		if it.err != nil || it.block >= len(it.t.index) {
+			return false
+		}
+		it.data, it.err = it.t.readBlock(it.block)
+		it.block++
+	}
+	e, n, err := readEntry(it.data)
+	if err != nil {
+		it.err = errCorruptTable
+		it.data = nil
+		return false
+	}
+	it.cur = e
+	it.data = it.data[n:]
+	return true
+}
+
+func (it *tableIter) entry() *entry {
+	return it.cur
+}
+
+func (it *tableIter) error() error {
+	return it.err
+}
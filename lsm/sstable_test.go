@@ -0,0 +1,95 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTable_GetAndIter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.sst")
+	w, err := newTableWriter(path, 128, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i += 2 {
+		w.add(&entry{key: fmt.Sprintf("k%04d", i), kind: kindPut, value: []byte{byte(i)}})
+	}
+	meta, err := w.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Smallest != "k0000" || meta.Largest != "k0498" {
+		t.Error("Must record the key range", meta)
+	}
+
+	tbl, err := openTable(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.close()
+	if len(tbl.index) < 10 {
+		t.Error("Must split entries into blocks", len(tbl.index))
+	}
+
+	for i := 0; i < 500; i++ {
+		e, err := tbl.get(fmt.Sprintf("k%04d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 && (e == nil || e.value[0] != byte(i)) {
+			t.Error("Must find", i, e)
+		} else if i%2 == 1 && e != nil {
+			t.Error("Must not find", i, e)
+		}
+	}
+
+	it, n := tbl.iter(), 0
+	for it.next() {
+		if it.entry().key != fmt.Sprintf("k%04d", n*2) {
+			t.Fatal("Must iterate in order", it.entry().key)
+		}
+		n++
+	}
+	if n != 250 || it.error() != nil {
+		t.Error("Must iterate all entries", n, it.error())
+	}
+}
+
+func TestTable_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.sst")
+	w, _ := newTableWriter(path, 128, 10, nil)
+	w.add(&entry{key: "a", kind: kindPut})
+	w.finish()
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-1], 0644)
+	if _, err := openTable(path, nil); err == nil {
+		t.Error("Must detect a truncated table")
+	}
+}
+
+func TestBloom(t *testing.T) {
+	b := newBloom(1000, 10)
+	for i := 0; i < 1000; i++ {
+		b.add(fmt.Sprintf("key%d", i))
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if !b.mayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatal("Must contain added keys", i)
+		}
+		if b.mayContain(fmt.Sprintf("other%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 30 {
+		t.Error("Too many false positives", falsePositives)
+	}
+
+	decoded, ok := decodeBloom(b.encode())
+	if !ok || !decoded.mayContain("key1") || decoded.k != b.k {
+		t.Error("Must decode the filter")
+	}
+}
@@ -0,0 +1,133 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+
+	"my-go-db/storage"
+)
+
+// Every write is appended to the write ahead log before it reaches the
+// memtable. A record is
+//
+//	crc32c of the payload | payload length | payload (one entry)
+//
+// both numbers little endian uint32. A crash can leave a torn record at the
+// end of the log; replay stops at the first record that does not check out.
+//
+// Encrypted logs start with walMagic and their payloads are sealed, with
+// the offset of the record as additional data so records cannot be moved.
+const (
+	walHeaderSize = 8
+	walMagic      = "wlmygdbe"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type wal struct {
+	f      *os.File
+	sync   bool
+	cipher *storage.Cipher
+	offset int64
+	buf    []byte
+	plain  []byte
+}
+
+func createWAL(path string, sync bool, c *storage.Cipher) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &wal{f: f, sync: sync, cipher: c}
+	if c != nil {
+		if _, err := f.WriteString(walMagic); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w.offset = int64(len(walMagic))
+	}
+	return w, nil
+}
+
+// append writes the entries as one write call, so they are lost or kept
+// together when the process dies.
+func (w *wal) append(entries ...*entry) error {
+	w.buf = w.buf[:0]
+	for _, e := range entries {
+		start := len(w.buf)
+		w.buf = append(w.buf, make([]byte, walHeaderSize)...)
+		if w.cipher != nil {
+			w.plain = appendEntry(w.plain[:0], e)
+			w.buf = w.cipher.Seal(w.buf, w.plain, recordAD(w.offset+int64(start)))
+		} else {
+			w.buf = appendEntry(w.buf, e)
+		}
+		payload := w.buf[start+walHeaderSize:]
+		binary.LittleEndian.PutUint32(w.buf[start:], crc32.Checksum(payload, crcTable))
+		binary.LittleEndian.PutUint32(w.buf[start+4:], uint32(len(payload)))
+	}
+	if _, err := w.f.Write(w.buf); err != nil {
+		return err
+	}
+	w.offset += int64(len(w.buf))
+	if w.sync {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.f.Close()
+}
+
+func recordAD(offset int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(offset))
+}
+
+// replayWAL calls fn for every intact record of the log in order, opening
+// the records of encrypted logs with c. It returns the length of the intact
+// records and of the whole log, which is longer if replay stopped at a
+// damaged record.
+func replayWAL(path string, c *storage.Cipher, fn func(*entry)) (int64, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, intact := int64(len(data)), int64(0)
+	encrypted := bytes.HasPrefix(data, []byte(walMagic))
+	if encrypted {
+		if c == nil {
+			return 0, 0, storage.ErrEncrypted
+		}
+		data = data[len(walMagic):]
+		intact = int64(len(walMagic))
+	}
+	for len(data) >= walHeaderSize {
+		crc := binary.LittleEndian.Uint32(data)
+		n := binary.LittleEndian.Uint32(data[4:])
+		if uint64(n) > uint64(len(data)-walHeaderSize) {
+			break
+		}
+		payload := data[walHeaderSize : walHeaderSize+int(n)]
+		if crc32.Checksum(payload, crcTable) != crc {
+			break
+		}
+		if encrypted {
+			if payload, err = c.Open(nil, payload, recordAD(intact)); err == storage.ErrEncryptionKey {
+				return 0, 0, err
+			} else if err != nil {
+				break
+			}
+		}
+		e, l, err := readEntry(payload)
+		if err != nil || l != len(payload) {
+			break
+		}
+		fn(e)
+		data = data[walHeaderSize+int(n):]
+		intact += int64(walHeaderSize + n)
+	}
+	return intact, size, nil
+}
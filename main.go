@@ -1,11 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"my-go-db/btree"
+	"my-go-db/lsm"
 	"my-go-db/server"
+	"my-go-db/storage"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"flag"
 	"bufio"
 )
@@ -88,6 +96,30 @@ func CMD_KEYS(c *server.Client) {
 	fmt.Printf("%v\n", keys)
 }
 
+// RANDOMKEY [count]
+func CMD_RANDOMKEY(c *server.Client, args []string) {
+	count := 1
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Bad count value. Must be integer")
+			return
+		}
+		count = n
+	}
+	keys, err := c.RandomKeys(count)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	if len(keys) == 0 {
+		fmt.Println("(nil)")
+	}
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+}
+
 func CMD_SET(c *server.Client, key, input string, ttl int) {
 	// SET INT
 	if intValue, err := strconv.Atoi(input); err == nil {
@@ -117,7 +149,7 @@ func CMD_SET(c *server.Client, key, input string, ttl int) {
 	// SET INT MAP
 	if intMap, ok := parseToIntMap(input); ok {
 		if err := c.SetIntMap(key, intMap, ttl); err != nil {
-			fmt.Printf("Error: ", err.Error())
+			fmt.Println("Error: ", err.Error())
 		}
 		return
 	}
@@ -125,7 +157,7 @@ func CMD_SET(c *server.Client, key, input string, ttl int) {
 	// SET STRING MAP
 	if stringMap, ok := parseToStringMap(input); ok {
 		if err := c.SetStringMap(key, stringMap, ttl); err != nil {
-			fmt.Printf("Error: ", err.Error())
+			fmt.Println("Error: ", err.Error())
 		}
 		return
 	}
@@ -153,30 +185,1407 @@ func CMD_REMOVE(c *server.Client, key string) {
 }
 
 
+// parseInts converts every argument to int, reporting the first bad one.
+func parseInts(args []string) ([]int, bool) {
+	res := make([]int, len(args))
+	for i, a := range args {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			fmt.Printf("Bad value %q. Must be integer\n", a)
+			return nil, false
+		}
+		res[i] = v
+	}
+	return res, true
+}
+
+// SETBIT key offset value
+func CMD_SETBIT(c *server.Client, args []string) {
+	ints, ok := parseInts(args[1:])
+	if !ok {
+		return
+	}
+	if old, err := c.SetBit(args[0], ints[0], ints[1]); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(old)
+	}
+}
+
+// GETBIT key offset
+func CMD_GETBIT(c *server.Client, args []string) {
+	ints, ok := parseInts(args[1:])
+	if !ok {
+		return
+	}
+	if bit, err := c.GetBit(args[0], ints[0]); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(bit)
+	}
+}
+
+// BITCOUNT key [start end]
+func CMD_BITCOUNT(c *server.Client, args []string) {
+	start, end := 0, -1
+	if len(args) == 3 {
+		ints, ok := parseInts(args[1:])
+		if !ok {
+			return
+		}
+		start, end = ints[0], ints[1]
+	}
+	if count, err := c.BitCount(args[0], start, end); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(count)
+	}
+}
+
+// BITPOS key bit [start end]
+func CMD_BITPOS(c *server.Client, args []string) {
+	ints, ok := parseInts(args[1:])
+	if !ok {
+		return
+	}
+	start, end := 0, -1
+	if len(ints) == 3 {
+		start, end = ints[1], ints[2]
+	}
+	if pos, err := c.BitPos(args[0], ints[0], start, end); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(pos)
+	}
+}
+
+// BITOP op dest key [key ...]
+func CMD_BITOP(c *server.Client, args []string) {
+	if size, err := c.BitOp(args[0], args[1], args[2:]...); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(size)
+	}
+}
+
+// GEOADD key lon lat member [lon lat member ...]
+func CMD_GEOADD(c *server.Client, args []string) {
+	locations := []*server.GeoLocation{}
+	for i := 1; i+2 < len(args); i += 3 {
+		lon, err1 := strconv.ParseFloat(args[i], 64)
+		lat, err2 := strconv.ParseFloat(args[i+1], 64)
+		if err1 != nil || err2 != nil {
+			fmt.Println("Bad coordinates. Must be numbers")
+			return
+		}
+		locations = append(locations, &server.GeoLocation{Name: args[i+2], Longitude: lon, Latitude: lat})
+	}
+	if added, err := c.GeoAdd(args[0], locations...); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(added)
+	}
+}
+
+// GEOPOS key member [member ...]
+func CMD_GEOPOS(c *server.Client, args []string) {
+	locations, err := c.GeoPos(args[0], args[1:]...)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for _, l := range locations {
+		if l == nil {
+			fmt.Println("(nil)")
+		} else {
+			fmt.Printf("%v %v\n", l.Longitude, l.Latitude)
+		}
+	}
+}
+
+// GEODIST key member1 member2 [m|km|ft|mi]
+func CMD_GEODIST(c *server.Client, args []string) {
+	unit := "m"
+	if len(args) == 4 {
+		unit = args[3]
+	}
+	if dist, err := c.GeoDist(args[0], args[1], args[2], unit); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(dist)
+	}
+}
+
+// GEOSEARCH key FROMMEMBER member | FROMLONLAT lon lat
+//           BYRADIUS radius unit | BYBOX width height unit [ASC|DESC] [COUNT n]
+func CMD_GEOSEARCH(c *server.Client, args []string) {
+	q := server.GeoSearchQuery{}
+	floats := func(args []string) ([]float64, bool) {
+		res := make([]float64, len(args))
+		for i, a := range args {
+			f, err := strconv.ParseFloat(a, 64)
+			if err != nil {
+				fmt.Printf("Bad value %q. Must be number\n", a)
+				return nil, false
+			}
+			res[i] = f
+		}
+		return res, true
+	}
+
+	for i := 1; i < len(args); {
+		rest := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if rest < 1 {
+				break
+			}
+			q.Member = args[i+1]
+			i += 2
+			continue
+		case "FROMLONLAT":
+			if rest < 2 {
+				break
+			}
+			f, ok := floats(args[i+1 : i+3])
+			if !ok {
+				return
+			}
+			q.Longitude, q.Latitude = f[0], f[1]
+			i += 3
+			continue
+		case "BYRADIUS":
+			if rest < 2 {
+				break
+			}
+			f, ok := floats(args[i+1 : i+2])
+			if !ok {
+				return
+			}
+			q.Radius, q.Unit = f[0], args[i+2]
+			i += 3
+			continue
+		case "BYBOX":
+			if rest < 3 {
+				break
+			}
+			f, ok := floats(args[i+1 : i+3])
+			if !ok {
+				return
+			}
+			q.Width, q.Height, q.Unit = f[0], f[1], args[i+3]
+			i += 4
+			continue
+		case "ASC":
+			i++
+			continue
+		case "DESC":
+			q.Desc = true
+			i++
+			continue
+		case "COUNT":
+			if rest < 1 {
+				break
+			}
+			ints, ok := parseInts(args[i+1 : i+2])
+			if !ok {
+				return
+			}
+			q.Count = ints[0]
+			i += 2
+			continue
+		}
+		fmt.Println("Syntax error near", args[i])
+		return
+	}
+
+	locations, err := c.GeoSearch(args[0], q)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for _, l := range locations {
+		fmt.Printf("%s %v\n", l.Name, l.Distance)
+	}
+}
+
+// MEMORY USAGE key | MEMORY TOP [count] | MEMORY STATS
+func CMD_MEMORY(c *server.Client, args []string) {
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "USAGE" && len(args) == 2:
+		if m, err := c.MemoryUsage(args[1]); err != nil {
+			fmt.Println("Error:", err.Error())
+		} else {
+			fmt.Println(m.Bytes)
+		}
+	case sub == "TOP" && len(args) <= 2:
+		count := 10
+		if len(args) == 2 {
+			ints, ok := parseInts(args[1:])
+			if !ok {
+				return
+			}
+			count = ints[0]
+		}
+		top, err := c.MemoryTop(count)
+		if err != nil {
+			fmt.Println("Error:", err.Error())
+			return
+		}
+		for _, m := range top {
+			fmt.Printf("%s %s %d\n", m.Key, m.Type, m.Bytes)
+		}
+	case sub == "STATS" && len(args) == 1:
+		report, err := c.MemoryStats()
+		if err != nil {
+			fmt.Println("Error:", err.Error())
+			return
+		}
+		fmt.Printf("total %d bytes in %d keys\n", report.Bytes, report.Keys)
+		types := make([]string, 0, len(report.Types))
+		for name := range report.Types {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		for _, name := range types {
+			t := report.Types[name]
+			fmt.Printf("%s %d bytes in %d keys\n", name, t.Bytes, t.Keys)
+		}
+	default:
+		fmt.Println("Usage: MEMORY USAGE key | MEMORY TOP [count] | MEMORY STATS")
+	}
+}
+
+// RENAME src dst | RENAMENX src dst
+func CMD_RENAME(c *server.Client, args []string, nx bool) {
+	if !nx {
+		if err := c.Rename(args[0], args[1]); err != nil {
+			fmt.Println("Error:", err.Error())
+		} else {
+			fmt.Println("Done")
+		}
+		return
+	}
+	if done, err := c.RenameNX(args[0], args[1]); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(done)
+	}
+}
+
+// COPY src dst [REPLACE]
+func CMD_COPY(c *server.Client, args []string) {
+	replace := len(args) == 3 && strings.ToUpper(args[2]) == "REPLACE"
+	if len(args) == 3 && !replace {
+		fmt.Println("Syntax error near", args[2])
+		return
+	}
+	if done, err := c.Copy(args[0], args[1], replace); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(done)
+	}
+}
+
+// MOVE key db
+func CMD_MOVE(c *server.Client, args []string) {
+	ints, ok := parseInts(args[1:])
+	if !ok {
+		return
+	}
+	if done, err := c.Move(args[0], ints[0]); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(done)
+	}
+}
+
+// SELECT db
+func CMD_SELECT(c *server.Client, args []string) {
+	ints, ok := parseInts(args)
+	if !ok {
+		return
+	}
+	c.Select(ints[0])
+	prompt = fmt.Sprintf("%s:%s[%d]$ >>> ", host, port, ints[0])
+	if ints[0] == 0 {
+		prompt = fmt.Sprintf("%s:%s$ >>> ", host, port)
+	}
+}
+
+// parseEntry guesses the type of input like SET does.
+func parseEntry(key, input string) *server.BulkEntry {
+	e := &server.BulkEntry{Key: key}
+	if intValue, err := strconv.Atoi(input); err == nil {
+		e.Int = intValue
+	} else if intSlice, ok := parseToIntSlice(input); ok {
+		e.IntList = intSlice
+	} else if stringSlice, ok := parseToStringSlice(input); ok {
+		e.StringList = stringSlice
+	} else if intMap, ok := parseToIntMap(input); ok {
+		e.IntDict = intMap
+	} else if stringMap, ok := parseToStringMap(input); ok {
+		e.StringDict = stringMap
+	} else {
+		e.String = input
+	}
+	return e
+}
+
+// MGET key [key ...]
+func CMD_MGET(c *server.Client, args []string) {
+	entries, err := c.MGet(args...)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for _, e := range entries {
+		if e.Found {
+			fmt.Printf("%s %v\n", e.Key, e.Value())
+		} else {
+			fmt.Printf("%s (nil)\n", e.Key)
+		}
+	}
+}
+
+// MSET key value [key value ...] | MSETNX key value [key value ...]
+func CMD_MSET(c *server.Client, args []string, nx bool) {
+	entries := []*server.BulkEntry{}
+	for i := 0; i+1 < len(args); i += 2 {
+		entries = append(entries, parseEntry(args[i], args[i+1]))
+	}
+	results, err := c.MSet(entries, nx)
+	for _, e := range results {
+		if e.Error != "" {
+			fmt.Printf("%s: %s\n", e.Key, e.Error)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+	} else if len(results) > 0 {
+		fmt.Println(results[0].Found)
+	}
+}
+
+// MDEL key [key ...]
+func CMD_MDEL(c *server.Client, args []string) {
+	entries, err := c.MDel(args...)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.Found {
+			removed++
+		}
+	}
+	fmt.Println(removed)
+}
+
+// INSPECT key
+func CMD_INSPECT(c *server.Client, key string) {
+	info, err := c.Object(key)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println("type:", info.Type)
+	fmt.Println("encoding:", info.Encoding)
+	fmt.Println("bytes:", info.Bytes)
+	fmt.Println("ttl:", info.TTL)
+	fmt.Println("created:", info.CreatedAt.Format(time.RFC3339))
+	fmt.Println("updated:", info.UpdatedAt.Format(time.RFC3339))
+	fmt.Println("accessed:", info.AccessedAt.Format(time.RFC3339))
+	fmt.Println("idle:", info.IdleSeconds)
+	fmt.Println("hits:", info.Hits)
+}
+
+// IDLE [count]
+func CMD_IDLE(c *server.Client, args []string) {
+	count := 10
+	if len(args) == 1 {
+		ints, ok := parseInts(args)
+		if !ok {
+			return
+		}
+		count = ints[0]
+	}
+	keys, err := c.IdleKeys(count)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for _, k := range keys {
+		fmt.Printf("%s %d\n", k.Key, k.IdleSeconds)
+	}
+}
+
+// EVICT idle_seconds
+func CMD_EVICT(c *server.Client, args []string) {
+	ints, ok := parseInts(args)
+	if !ok {
+		return
+	}
+	if evicted, err := c.EvictIdle(ints[0]); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(evicted)
+	}
+}
+
+// TS.CREATE key [retention]
+func CMD_TSCREATE(c *server.Client, args []string) {
+	retention := int64(0)
+	if len(args) == 2 {
+		r, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("Bad retention value. Must be integer milliseconds")
+			return
+		}
+		retention = r
+	}
+	if err := c.TSCreate(args[0], retention); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println("OK")
+	}
+}
+
+// TS.ADD key timestamp|* value [timestamp|* value ...]
+func CMD_TSADD(c *server.Client, args []string) {
+	samples := []*server.TSSample{}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for i := 1; i+1 < len(args); i += 2 {
+		ts := now
+		if args[i] != "*" {
+			t, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				fmt.Println("Bad timestamp. Must be integer milliseconds or *")
+				return
+			}
+			ts = t
+		}
+		value, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil {
+			fmt.Println("Bad value. Must be number")
+			return
+		}
+		samples = append(samples, &server.TSSample{Timestamp: ts, Value: value})
+	}
+	if added, err := c.TSAdd(args[0], samples...); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println(added)
+	}
+}
+
+// TS.RANGE key from|- to|+ [AGGREGATION avg|min|max|sum|count bucket]
+func CMD_TSRANGE(c *server.Client, args []string) {
+	q := server.TSRangeQuery{}
+	bounds := []struct {
+		arg   string
+		open  string
+		value *int64
+	}{
+		{args[1], "-", &q.From},
+		{args[2], "+", &q.To},
+	}
+	for _, b := range bounds {
+		if b.arg == b.open {
+			continue
+		}
+		t, err := strconv.ParseInt(b.arg, 10, 64)
+		if err != nil {
+			fmt.Printf("Bad timestamp %q. Must be integer milliseconds or %s\n", b.arg, b.open)
+			return
+		}
+		*b.value = t
+	}
+	if len(args) == 6 {
+		if strings.ToUpper(args[3]) != "AGGREGATION" {
+			fmt.Println("Usage: TS.RANGE key from to [AGGREGATION aggregation bucket]")
+			return
+		}
+		bucket, err := strconv.ParseInt(args[5], 10, 64)
+		if err != nil {
+			fmt.Println("Bad bucket value. Must be integer milliseconds")
+			return
+		}
+		q.Aggregation, q.Bucket = strings.ToLower(args[4]), bucket
+	}
+	samples, err := c.TSRange(args[0], q)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for _, s := range samples {
+		fmt.Println(s.Timestamp, s.Value)
+	}
+}
+
+// TS.CREATERULE key dest avg|min|max|sum|count bucket
+func CMD_TSCREATERULE(c *server.Client, args []string) {
+	bucket, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		fmt.Println("Bad bucket value. Must be integer milliseconds")
+		return
+	}
+	rule := &server.TSRule{Destination: args[1], Aggregation: strings.ToLower(args[2]), Bucket: bucket}
+	if err := c.TSCreateRule(args[0], rule); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println("OK")
+	}
+}
+
+// TS.DELETERULE key dest
+func CMD_TSDELETERULE(c *server.Client, args []string) {
+	if err := c.TSDeleteRule(args[0], args[1]); err != nil {
+		fmt.Println("Error:", err.Error())
+	} else {
+		fmt.Println("OK")
+	}
+}
+
+// TS.INFO key
+func CMD_TSINFO(c *server.Client, args []string) {
+	info, err := c.TSInfo(args[0])
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println("retention:", info.Retention)
+	fmt.Println("samples:", info.Samples)
+	fmt.Println("first:", info.First)
+	fmt.Println("last:", info.Last)
+	for _, r := range info.Rules {
+		fmt.Printf("rule: %s %s %d\n", r.Destination, r.Aggregation, r.Bucket)
+	}
+}
+
+func printCRDT(info *server.CRDTInfo, err error) {
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println(info)
+}
+
+// CRDT.INCR key gcounter|pncounter delta
+func CMD_CRDTINCR(c *server.Client, args []string) {
+	delta, err := strconv.Atoi(args[2])
+	if err != nil {
+		fmt.Println("Bad delta value. Must be integer")
+		return
+	}
+	printCRDT(c.CRDTIncrement(args[0], args[1], delta))
+}
+
+// CRDT.ADD key member [member ...]
+// CRDT.REM key member [member ...]
+func CMD_CRDTADD(c *server.Client, args []string, remove bool) {
+	if remove {
+		printCRDT(c.CRDTRemove(args[0], args[1:]...))
+	} else {
+		printCRDT(c.CRDTAdd(args[0], args[1:]...))
+	}
+}
+
+// CRDT.SET key value
+func CMD_CRDTSET(c *server.Client, args []string) {
+	printCRDT(c.CRDTSet(args[0], args[1]))
+}
+
+// CRDT.GET key
+func CMD_CRDTGET(c *server.Client, args []string) {
+	printCRDT(c.CRDTExport(args[0]))
+}
+
+// CRDT.MERGE key host port
+//
+// Merges the CRDT of key on the server at host:port into this one.
+func CMD_CRDTMERGE(c *server.Client, args []string) {
+	info, err := server.NewClient(args[1], args[2]).CRDTExport(args[0])
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	printCRDT(c.CRDTMerge(args[0], info.State))
+}
+
+// SEQ.CREATE name start step
+func CMD_SEQCREATE(c *server.Client, args []string) {
+	start, err1 := strconv.ParseInt(args[1], 10, 64)
+	step, err2 := strconv.ParseInt(args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		fmt.Println("Bad start or step value. Must be integer")
+		return
+	}
+	if _, err := c.CreateSequence(args[0], start, step); err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println("OK")
+}
+
+// SEQ.NEXT name [count]
+func CMD_SEQNEXT(c *server.Client, args []string) {
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Bad count value. Must be integer")
+			return
+		}
+		count = n
+	}
+	block, err := c.NextIDs(args[0], count)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for i := 0; i < block.Count; i++ {
+		fmt.Println(block.First + int64(i)*block.Step)
+	}
+}
+
+// SEQ.GET name
+func CMD_SEQGET(c *server.Client, args []string) {
+	info, err := c.GetSequence(args[0])
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println("next:", info.Next)
+	fmt.Println("step:", info.Step)
+}
+
+// SEQ.DEL name
+func CMD_SEQDEL(c *server.Client, args []string) {
+	if err := c.DeleteSequence(args[0]); err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println("OK")
+}
+
+// SNOWFLAKE [count]
+// ULID [count]
+func CMD_IDS(c *server.Client, args []string, ulid bool) {
+	count := 1
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Bad count value. Must be integer")
+			return
+		}
+		count = n
+	}
+	if ulid {
+		ids, err := c.ULIDs(count)
+		if err != nil {
+			fmt.Println("Error:", err.Error())
+			return
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+		return
+	}
+	ids, err := c.SnowflakeIDs(count)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+}
+
+// EVAL script numkeys [key ...] [arg ...]
+func CMD_EVAL(c *server.Client, args []string) {
+	n := scriptTokens(args)
+	if n == 0 {
+		fmt.Println("Bad script. Must be one or more balanced forms")
+		return
+	}
+	keys, scriptArgs, ok := scriptKeys(args[n:])
+	if !ok {
+		return
+	}
+	result, err := c.Eval(strings.Join(args[:n], " "), keys, scriptArgs)
+	printScriptResult(result, err)
+}
+
+// EVALSHA sha numkeys [key ...] [arg ...]
+func CMD_EVALSHA(c *server.Client, args []string) {
+	keys, scriptArgs, ok := scriptKeys(args[1:])
+	if !ok {
+		return
+	}
+	result, err := c.EvalSHA(args[0], keys, scriptArgs)
+	printScriptResult(result, err)
+}
+
+// SCRIPT LOAD script
+func CMD_SCRIPT(c *server.Client, args []string) {
+	if strings.ToUpper(args[0]) != "LOAD" || scriptTokens(args[1:]) != len(args)-1 {
+		fmt.Println("Usage: SCRIPT LOAD script")
+		return
+	}
+	sha, err := c.ScriptLoad(strings.Join(args[1:], " "))
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		return
+	}
+	fmt.Println(sha)
+}
+
+// scriptTokens returns how many of tokens make up the script: they end once
+// every parenthesis outside string literals is closed.
+func scriptTokens(tokens []string) int {
+	depth := 0
+	inString := false
+	for i, t := range tokens {
+		for j := 0; j < len(t); j++ {
+			switch {
+			case inString && t[j] == '\\':
+				j++
+			case t[j] == '"':
+				inString = !inString
+			case inString:
+			case t[j] == '(':
+				depth++
+			case t[j] == ')':
+				depth--
+			}
+		}
+		if depth < 0 {
+			return 0
+		}
+		if depth == 0 && !inString {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// scriptKeys splits numkeys [key ...] [arg ...] into keys and args.
+func scriptKeys(args []string) ([]string, []string, bool) {
+	if len(args) == 0 {
+		fmt.Println("Missing numkeys")
+		return nil, nil, false
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > len(args)-1 {
+		fmt.Println("Bad numkeys value. Must be the number of keys that follow")
+		return nil, nil, false
+	}
+	return args[1 : n+1], args[n+1:], true
+}
+
+func printScriptResult(result interface{}, err error) {
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+	} else if result == nil {
+		fmt.Println("(nil)")
+	} else {
+		fmt.Println(result)
+	}
+}
+
+func runCommand(client *server.Client, input []string) {
+	cmd, args := strings.ToUpper(input[0]), input[1:]
+	switch {
+	case cmd == "KEYS" && len(args) == 0:
+		CMD_KEYS(client)
+	case cmd == "RANDOMKEY" && len(args) <= 1:
+		CMD_RANDOMKEY(client, args)
+	case cmd == "GET" && len(args) == 1:
+		CMD_GET(client, args[0])
+	case cmd == "REMOVE" && len(args) == 1:
+		CMD_REMOVE(client, args[0])
+	case cmd == "SET" && (len(args) == 2 || len(args) == 3):
+		ttl := 0
+		if len(args) == 3 {
+			ttl2, err := strconv.Atoi(args[2])
+			if err != nil {
+				fmt.Println("Bad ttl value. Must be integer")
+				return
+			}
+			ttl = ttl2
+		}
+		CMD_SET(client, args[0], args[1], ttl)
+	case cmd == "SETBIT" && len(args) == 3:
+		CMD_SETBIT(client, args)
+	case cmd == "GETBIT" && len(args) == 2:
+		CMD_GETBIT(client, args)
+	case cmd == "BITCOUNT" && (len(args) == 1 || len(args) == 3):
+		CMD_BITCOUNT(client, args)
+	case cmd == "BITPOS" && (len(args) == 2 || len(args) == 4):
+		CMD_BITPOS(client, args)
+	case cmd == "BITOP" && len(args) >= 3:
+		CMD_BITOP(client, args)
+	case cmd == "GEOADD" && len(args) >= 4 && len(args)%3 == 1:
+		CMD_GEOADD(client, args)
+	case cmd == "GEOPOS" && len(args) >= 2:
+		CMD_GEOPOS(client, args)
+	case cmd == "GEODIST" && (len(args) == 3 || len(args) == 4):
+		CMD_GEODIST(client, args)
+	case cmd == "GEOSEARCH" && len(args) >= 4:
+		CMD_GEOSEARCH(client, args)
+	case cmd == "MEMORY" && len(args) >= 1:
+		CMD_MEMORY(client, args)
+	case (cmd == "RENAME" || cmd == "RENAMENX") && len(args) == 2:
+		CMD_RENAME(client, args, cmd == "RENAMENX")
+	case cmd == "COPY" && (len(args) == 2 || len(args) == 3):
+		CMD_COPY(client, args)
+	case cmd == "MOVE" && len(args) == 2:
+		CMD_MOVE(client, args)
+	case cmd == "SELECT" && len(args) == 1:
+		CMD_SELECT(client, args)
+	case cmd == "MGET" && len(args) >= 1:
+		CMD_MGET(client, args)
+	case (cmd == "MSET" || cmd == "MSETNX") && len(args) >= 2 && len(args)%2 == 0:
+		CMD_MSET(client, args, cmd == "MSETNX")
+	case cmd == "MDEL" && len(args) >= 1:
+		CMD_MDEL(client, args)
+	case (cmd == "INSPECT" || cmd == "OBJECT") && len(args) == 1:
+		CMD_INSPECT(client, args[0])
+	case cmd == "IDLE" && len(args) <= 1:
+		CMD_IDLE(client, args)
+	case cmd == "EVICT" && len(args) == 1:
+		CMD_EVICT(client, args)
+	case cmd == "TS.CREATE" && (len(args) == 1 || len(args) == 2):
+		CMD_TSCREATE(client, args)
+	case cmd == "TS.ADD" && len(args) >= 3 && len(args)%2 == 1:
+		CMD_TSADD(client, args)
+	case cmd == "TS.RANGE" && (len(args) == 3 || len(args) == 6):
+		CMD_TSRANGE(client, args)
+	case cmd == "TS.CREATERULE" && len(args) == 4:
+		CMD_TSCREATERULE(client, args)
+	case cmd == "TS.DELETERULE" && len(args) == 2:
+		CMD_TSDELETERULE(client, args)
+	case cmd == "TS.INFO" && len(args) == 1:
+		CMD_TSINFO(client, args)
+	case cmd == "EVAL" && len(args) >= 2:
+		CMD_EVAL(client, args)
+	case cmd == "EVALSHA" && len(args) >= 2:
+		CMD_EVALSHA(client, args)
+	case cmd == "SCRIPT" && len(args) >= 2:
+		CMD_SCRIPT(client, args)
+	case cmd == "CRDT.INCR" && len(args) == 3:
+		CMD_CRDTINCR(client, args)
+	case (cmd == "CRDT.ADD" || cmd == "CRDT.REM") && len(args) >= 2:
+		CMD_CRDTADD(client, args, cmd == "CRDT.REM")
+	case cmd == "CRDT.SET" && len(args) == 2:
+		CMD_CRDTSET(client, args)
+	case cmd == "CRDT.GET" && len(args) == 1:
+		CMD_CRDTGET(client, args)
+	case cmd == "CRDT.MERGE" && len(args) == 3:
+		CMD_CRDTMERGE(client, args)
+	case cmd == "SEQ.CREATE" && len(args) == 3:
+		CMD_SEQCREATE(client, args)
+	case cmd == "SEQ.NEXT" && (len(args) == 1 || len(args) == 2):
+		CMD_SEQNEXT(client, args)
+	case cmd == "SEQ.GET" && len(args) == 1:
+		CMD_SEQGET(client, args)
+	case cmd == "SEQ.DEL" && len(args) == 1:
+		CMD_SEQDEL(client, args)
+	case (cmd == "SNOWFLAKE" || cmd == "ULID") && len(args) <= 1:
+		CMD_IDS(client, args, cmd == "ULID")
+	default:
+		fmt.Println("Unknown command or wrong number of arguments")
+	}
+}
+
+
 func printUsage() {
 	fmt.Println("my-go-db is a tool to run server or client to server")
+	fmt.Println("Usage: my-go-db [flags] server|client")
+	fmt.Println("       my-go-db [-repair] check [datadir]")
+	fmt.Println("       my-go-db [-full] backup <backupdir>")
+	fmt.Println("       my-go-db -engine lsm|btree [-data datadir] restore <backupdir> [backupid]")
 }
 
 
 var host string
 var port string
+var engine string
+var dataDir string
+var indexes indexFlags
+var compression storage.Compression
+var databases int
+var maxIdle time.Duration
+var scriptTimeout time.Duration
+var nodeID string
+var workerID int
+var sequenceFile string
+var limits server.Limits
+var recovery string
+var repair bool
+var fullBackup bool
+var keyFile string
+var oldKeyFiles stringFlags
+var encryption storage.Encryption
+
+// Environment variables holding hex encoded encryption keys, used when no
+// key file is given.
+const (
+	keyEnv     = "MYGODB_ENCRYPTION_KEY"
+	oldKeysEnv = "MYGODB_OLD_ENCRYPTION_KEYS" // comma separated
+)
+
+
+// indexFlags collects -index name,prefix,field[,type] declarations.
+type indexFlags []storage.IndexSpec
+
+func (f *indexFlags) String() string {
+	return fmt.Sprintf("%v", *f)
+}
+
+func (f *indexFlags) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return fmt.Errorf("Expected name,prefix,field[,type], got %q", value)
+	}
+	spec := storage.IndexSpec{Name: parts[0], Prefix: parts[1], Field: parts[2]}
+	if len(parts) == 4 {
+		spec.Type = parts[3]
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+
+// stringFlags collects the values of a repeated flag.
+type stringFlags []string
+
+func (f *stringFlags) String() string {
+	return strings.Join(*f, listSep)
+}
+
+func (f *stringFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
 
 
 func init() {
 	flag.StringVar(&host, "host", "localhost", "Server's host")
 	flag.StringVar(&port, "port", "8080", "Server's port")
+	flag.StringVar(&engine, "engine", "memory", "Storage engine of the server: memory, lsm or btree")
+	flag.StringVar(&dataDir, "data", "data", "Data directory of disk based storage engines")
+	flag.Var(&indexes, "index", "Secondary index to maintain as name,prefix,field[,string|int]. May be repeated")
+	flag.IntVar(&databases, "databases", 1, "Number of logical databases, kept in db<N> subdirectories of -data by disk based engines")
+	flag.DurationVar(&maxIdle, "max-idle", 0, "Evict keys not accessed for this long, e.g. 24h. 0 disables eviction")
+	flag.StringVar(&compression.Algorithm, "compress", "none", "Compression of large values: none, flate, gzip or zlib")
+	flag.IntVar(&compression.Level, "compress-level", 0, "Compression level from -2 (huffman only) to 9 (best), 0 is the default level")
+	flag.IntVar(&compression.Threshold, "compress-threshold", storage.DefaultCompressionThreshold, "Values of at least this many bytes are compressed")
+	flag.DurationVar(&scriptTimeout, "script-timeout", 5*time.Second, "Abort scripts running longer than this and undo their writes. 0 disables the limit")
+	flag.StringVar(&nodeID, "node-id", "", "Id this server writes CRDTs under, unique among the replicas merging them. Random by default")
+	flag.IntVar(&workerID, "worker-id", 0, "Worker id from 0 to 1023 of the snowflake ids of this server, unique among the servers generating them")
+	flag.StringVar(&sequenceFile, "sequence-file", "", "File keeping the state of sequences, snowflake ids and lock fencing tokens across restarts, sequences.json in -data by default")
+	flag.IntVar(&limits.MaxKeyLength, "max-key-length", 0, "Maximum key length in bytes. 0 is unlimited")
+	flag.IntVar(&limits.MaxValueSize, "max-value-size", 0, "Maximum size in bytes of strings, bitmaps and list or dict elements. 0 is unlimited")
+	flag.IntVar(&limits.MaxElements, "max-elements", 0, "Maximum number of elements of lists, dicts and geo sets. 0 is unlimited")
+	flag.Int64Var(&limits.MaxBodySize, "max-body-size", 0, "Maximum request body size in bytes. 0 is unlimited")
+	flag.StringVar(&recovery, "recovery", "truncate", "What disk based engines do with data damaged by a crash: truncate it, or strict to refuse to start")
+	flag.BoolVar(&repair, "repair", false, "Let check repair the damage it finds")
+	flag.BoolVar(&fullBackup, "full", false, "Let backup take a full backup instead of an incremental one")
+	flag.StringVar(&keyFile, "encryption-key-file", "", "File with the hex encoded AES key encrypting the files of the lsm and btree engines, "+keyEnv+" by default")
+	flag.Var(&oldKeyFiles, "old-encryption-key-file", "File with a previous encryption key, to re-encrypt data after a key rotation. May be repeated, "+oldKeysEnv+" by default")
 	flag.Parse()
 }
 
 
+// loadEncryption reads the encryption keys from the key files or the
+// environment.
+func loadEncryption() (storage.Encryption, error) {
+	var e storage.Encryption
+	var err error
+	if keyFile != "" {
+		if e.Key, err = storage.ReadEncryptionKey(keyFile); err != nil {
+			return e, fmt.Errorf("%s: %v", keyFile, err)
+		}
+	} else if env := os.Getenv(keyEnv); env != "" {
+		if e.Key, err = storage.ParseEncryptionKey(env); err != nil {
+			return e, fmt.Errorf("%s: %v", keyEnv, err)
+		}
+	}
+	for _, path := range oldKeyFiles {
+		key, err := storage.ReadEncryptionKey(path)
+		if err != nil {
+			return e, fmt.Errorf("%s: %v", path, err)
+		}
+		e.OldKeys = append(e.OldKeys, key)
+	}
+	if env := os.Getenv(oldKeysEnv); len(oldKeyFiles) == 0 && env != "" {
+		for _, s := range strings.Split(env, listSep) {
+			key, err := storage.ParseEncryptionKey(s)
+			if err != nil {
+				return e, fmt.Errorf("%s: %v", oldKeysEnv, err)
+			}
+			e.OldKeys = append(e.OldKeys, key)
+		}
+	}
+	return e, e.Validate()
+}
+
+// openEngine creates the storage engine selected by the -engine flag for a
+// database kept in dataDir.
+func openEngine(dataDir string) (storage.Engine, error) {
+	switch engine {
+	case "memory":
+		s := storage.New()
+		if err := s.SetCompression(compression); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "lsm":
+		return lsm.Open(dataDir, &lsm.Options{Compression: compression, Recovery: storage.Recovery(recovery), Encryption: encryption})
+	case "btree":
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, err
+		}
+		return btree.Open(filepath.Join(dataDir, "btree.db"), &btree.Options{Compression: compression, Recovery: storage.Recovery(recovery), Encryption: encryption})
+	}
+	return nil, fmt.Errorf("Unknown storage engine %q", engine)
+}
+
+// databaseDir returns the data directory of the logical database db.
+func databaseDir(db int) string {
+	if db == 0 {
+		return dataDir
+	}
+	return filepath.Join(dataDir, fmt.Sprintf("db%d", db))
+}
+
 func startServer() {
+	if databases < 1 {
+		fmt.Println("Error: -databases must be at least 1")
+		os.Exit(1)
+	}
+	engines := []storage.Engine{}
+	for db := 0; db < databases; db++ {
+		e, err := openEngine(databaseDir(db))
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		engines = append(engines, e)
+	}
 	fmt.Println("Starting server on port", port)
-	s := server.New(":" + port)
+	s := server.New(":"+port, engines...)
+	s.SetMaxIdle(maxIdle)
+	s.SetScriptTimeout(scriptTimeout)
+	if err := s.SetLimits(limits); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if nodeID != "" {
+		if err := s.SetNodeID(nodeID); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	if err := s.SetWorkerID(workerID); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if sequenceFile == "" {
+		sequenceFile = filepath.Join(dataDir, "sequences.json")
+	}
+	if err := s.SetSequenceFile(sequenceFile); err != nil {
+		fmt.Println("Could not load sequences:", err)
+		os.Exit(1)
+	}
+	for _, spec := range indexes {
+		if err := s.CreateIndex(spec); err != nil {
+			fmt.Printf("Could not create index %s: %v\n", spec.Name, err)
+			os.Exit(1)
+		}
+	}
 	s.Start()
 	s.WaitStop()
 }
 
 
+// checkData verifies the data files of every database kept in dir by a
+// disk based engine, repairing them with -repair. The server must not be
+// running. It returns false if damage remains.
+func checkData(dir string) bool {
+	dirs, _ := filepath.Glob(filepath.Join(dir, "db[0-9]*"))
+	dirs = append([]string{dir}, dirs...)
+	ok, found := true, false
+	for _, d := range dirs {
+		var report *storage.CheckReport
+		var err error
+		if _, statErr := os.Stat(filepath.Join(d, "MANIFEST")); statErr == nil {
+			report, err = lsm.Check(d, &lsm.Options{Encryption: encryption}, repair)
+		} else if _, statErr := os.Stat(filepath.Join(d, "btree.db")); statErr == nil {
+			report, err = btree.Check(filepath.Join(d, "btree.db"), &btree.Options{Encryption: encryption}, repair)
+		} else {
+			continue
+		}
+		found = true
+		if err != nil {
+			fmt.Printf("%s: %v\n", d, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%s: %d files, %d records checked\n", d, report.Files, report.Records)
+		for _, problem := range report.Problems {
+			fmt.Printf("  %s\n", problem)
+		}
+		switch {
+		case report.OK():
+			fmt.Println("  OK")
+		case report.Repaired:
+			fmt.Println("  Repaired")
+		default:
+			fmt.Println("  Damaged, run again with -repair")
+			ok = false
+		}
+	}
+	if !found {
+		fmt.Printf("No lsm or btree data files in %s\n", dir)
+		return false
+	}
+	return ok
+}
+
+
+// backupFile is a backup kept in a backup directory, named after its id
+// with a .full or .incr extension.
+type backupFile struct {
+	path   string
+	header storage.BackupHeader
+}
+
+// readBackup reads the header of a backup file and, with fn, its records.
+// It fails if the file is incomplete or corrupt.
+func readBackup(path string, fn func(*storage.BackupRecord) error) (storage.BackupHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return storage.BackupHeader{}, err
+	}
+	defer f.Close()
+	r, err := storage.NewBackupReader(f)
+	if err != nil {
+		return storage.BackupHeader{}, fmt.Errorf("%s: %v", path, err)
+	}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return r.Header(), nil
+		}
+		if err != nil {
+			return r.Header(), fmt.Errorf("%s: %v", path, err)
+		}
+		if fn != nil {
+			if err := fn(rec); err != nil {
+				return r.Header(), err
+			}
+		}
+	}
+}
+
+// listBackups returns the backups in dir by id.
+func listBackups(dir string) ([]*backupFile, error) {
+	full, err := filepath.Glob(filepath.Join(dir, "*.full"))
+	if err != nil {
+		return nil, err
+	}
+	incr, _ := filepath.Glob(filepath.Join(dir, "*.incr"))
+	backups := []*backupFile{}
+	for _, path := range append(full, incr...) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r, err := storage.NewBackupReader(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		backups = append(backups, &backupFile{path: path, header: r.Header()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].header.ID < backups[j].header.ID
+	})
+	return backups, nil
+}
+
+// backup streams a backup from the server into dir: an incremental one
+// following the newest backup there, or a full one with -full, when dir has
+// none or when the server cannot take the incremental one.
+func backup(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	var since uint64
+	if !fullBackup && len(backups) > 0 {
+		since = backups[len(backups)-1].header.ID
+	}
+
+	client := server.NewClient(host, port)
+	tmp := filepath.Join(dir, "backup.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	err = client.Backup(f, since)
+	if errors.Is(err, server.ErrBackupBase) {
+		fmt.Println(err)
+		since = 0
+		if err = f.Truncate(0); err == nil {
+			if _, err = f.Seek(0, io.SeekStart); err == nil {
+				err = client.Backup(f, 0)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	records := 0
+	h, err := readBackup(tmp, func(*storage.BackupRecord) error {
+		records++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	kind := "full"
+	if !h.Full() {
+		kind = "incr"
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%020d.%s", h.ID, kind))
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	fmt.Printf("Backup %d (%s, %d records) written to %s\n", h.ID, kind, records, path)
+	return nil
+}
+
+// restoreChain returns the backups to apply to restore the backup point:
+// the newest full backup not after point, followed by the incremental
+// backups building on it up to point. A point of 0 is the newest backup.
+func restoreChain(backups []*backupFile, point uint64) ([]*backupFile, error) {
+	var chain []*backupFile
+	for _, b := range backups {
+		if point != 0 && b.header.ID > point {
+			break
+		}
+		if b.header.Full() {
+			chain = []*backupFile{b}
+		} else if len(chain) > 0 && b.header.Base == chain[len(chain)-1].header.ID {
+			chain = append(chain, b)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("No full backup to restore from")
+	}
+	if last := chain[len(chain)-1].header.ID; point != 0 && last != point {
+		return nil, fmt.Errorf("Backup %d cannot be restored, the closest point is %d", point, last)
+	}
+	return chain, nil
+}
+
+// restore rebuilds the empty data directory of the -engine from the backups
+// in dir, as of the backup point, or the newest backup if point is "".
+func restore(dir, point string) error {
+	if engine != "lsm" && engine != "btree" {
+		return errors.New("Restore needs -engine lsm or btree")
+	}
+	var id uint64
+	if point != "" {
+		var err error
+		if id, err = strconv.ParseUint(point, 10, 64); err != nil {
+			return fmt.Errorf("Backup id %q must be a number", point)
+		}
+	}
+	if entries, err := os.ReadDir(dataDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("Data directory %s is not empty", dataDir)
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	chain, err := restoreChain(backups, id)
+	if err != nil {
+		return err
+	}
+	// Check every backup first not to leave half a restore behind.
+	for _, b := range chain {
+		if _, err := readBackup(b.path, nil); err != nil {
+			return err
+		}
+	}
+
+	engines := make([]storage.Engine, chain[0].header.Databases)
+	defer func() {
+		for _, e := range engines {
+			if e != nil {
+				e.Close()
+			}
+		}
+	}()
+	for db := range engines {
+		e, err := openEngine(databaseDir(db))
+		if err != nil {
+			return err
+		}
+		if _, ok := e.(storage.BackupEngine); !ok {
+			return fmt.Errorf("Engine %s cannot restore backups", engine)
+		}
+		engines[db] = e
+	}
+	for _, b := range chain {
+		records := 0
+		_, err := readBackup(b.path, func(rec *storage.BackupRecord) error {
+			if rec.DB >= len(engines) {
+				return fmt.Errorf("%s: database %d out of range", b.path, rec.DB)
+			}
+			records++
+			e := engines[rec.DB]
+			if rec.Item == nil {
+				e.Remove(rec.Key)
+				return nil
+			}
+			return e.(storage.BackupEngine).Restore(rec.Key, rec.Item)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied backup %d from %s: %d records\n", b.header.ID, b.path, records)
+	}
+	for db, e := range engines {
+		engines[db] = nil
+		if err := e.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Restored %s with %d databases, start the server with -databases %d\n",
+		dataDir, len(engines), len(engines))
+	return nil
+}
+
+
 func startClient() {
 	fmt.Printf("Connecting to server http://%s:%s\n", host, port)
 
@@ -188,37 +1597,8 @@ func startClient() {
 	scanner := bufio.NewScanner(os.Stdin)
 	printPromt()
 	for scanner.Scan() {
-		input := strings.Split(scanner.Text(), " ")
-		switch len(input) {
-		case 1:
-			cmd := input[0]
-			switch strings.ToUpper(cmd) {
-			case "KEYS":
-				CMD_KEYS(client)
-			}
-		case 2:
-			cmd, key := input[0], input[1]
-			switch strings.ToUpper(cmd) {
-			case "GET":
-				CMD_GET(client, key)
-			case "REMOVE":
-				CMD_REMOVE(client, key)
-			}
-		case 3, 4:
-			cmd, key, value := input[0], input[1], input[2]
-			ttl := 0
-			if len(input) == 4 {
-				ttl2, err := strconv.Atoi(input[3])
-				if err != nil {
-					fmt.Println("Bad ttl value. Must be integer")
-					continue
-				}
-				ttl = ttl2
-			}
-			switch strings.ToUpper(cmd) {
-			case "SET":
-				CMD_SET(client, key, value, ttl)
-			}
+		if line := scanner.Text(); line != "" {
+			runCommand(client, strings.Split(line, " "))
 		}
 		printPromt()
 	}
@@ -229,16 +1609,51 @@ func startClient() {
 
 
 func main() {
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		printUsage()
 		return
 	}
 
-	switch os.Args[1] {
+	switch flag.Arg(0) {
+	case "server", "check", "restore":
+		var err error
+		if encryption, err = loadEncryption(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	switch flag.Arg(0) {
 	case "server":
 		startServer()
 	case "client":
 		startClient()
+	case "check":
+		dir := dataDir
+		if flag.NArg() > 1 {
+			dir = flag.Arg(1)
+		}
+		if !checkData(dir) {
+			os.Exit(1)
+		}
+	case "backup":
+		if flag.NArg() != 2 {
+			printUsage()
+			os.Exit(1)
+		}
+		if err := backup(flag.Arg(1)); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "restore":
+		if flag.NArg() != 2 && flag.NArg() != 3 {
+			printUsage()
+			os.Exit(1)
+		}
+		if err := restore(flag.Arg(1), flag.Arg(2)); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 	default:
 		printUsage()
 	}
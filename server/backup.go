@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// ErrBackupBase is returned for incremental backups that do not follow the
+// last backup taken, which happens after a restart or another client's
+// backup. A full backup must be taken then.
+var ErrBackupBase = errors.New("Backup does not follow the last backup, take a full backup")
+
+var errBadSince = errors.New("Parameter since must be a backup id")
+
+// backupBase is what incremental backups are computed against: the digest
+// of the value of every key in the last backup, per database. It is only
+// kept in memory, so after a restart the next backup must be a full one.
+// Incremental backups still read every key to compare it with its digest;
+// they only save writing the unchanged ones.
+type backupBase struct {
+	id      uint64
+	digests []map[string]uint64
+}
+
+func itemDigest(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	return h.Sum64()
+}
+
+// GET /admin/backup?since=<id>
+//
+// Streams a backup of every database: a full one, or with since the keys
+// changed since that backup. The content of every database is captured
+// before any is streamed, so they are backed up as of nearly the same
+// moment, while writes go on. The stream ends with a checksum, so one cut
+// by an error is rejected when read.
+func (s *Server) backup(c echo.Context) error {
+	engines := make([]storage.BackupEngine, len(s.databases))
+	for i, db := range s.databases {
+		engine, ok := db.(storage.BackupEngine)
+		if !ok {
+			return notSupported(c)
+		}
+		engines[i] = engine
+	}
+	var since uint64
+	if v := c.QueryParam("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil || since == 0 {
+			return errorResponse(c, http.StatusBadRequest, errBadSince)
+		}
+	}
+
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	var base *backupBase
+	if since != 0 {
+		if s.lastBackup == nil || s.lastBackup.id != since {
+			return errorResponse(c, http.StatusConflict, ErrBackupBase)
+		}
+		base = s.lastBackup
+	}
+	dumps := make([]storage.Dump, 0, len(engines))
+	defer func() {
+		for _, dump := range dumps {
+			dump.Close()
+		}
+	}()
+	for _, engine := range engines {
+		dump, err := engine.OpenDump()
+		if err != nil {
+			return errorResponse(c, http.StatusInternalServerError, err)
+		}
+		dumps = append(dumps, dump)
+	}
+	now := time.Now()
+	id := uint64(now.UnixNano())
+	if s.lastBackup != nil && id <= s.lastBackup.id {
+		id = s.lastBackup.id + 1
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	resp.WriteHeader(http.StatusOK)
+	w, err := storage.NewBackupWriter(resp, storage.BackupHeader{
+		ID:        id,
+		Base:      since,
+		Created:   now,
+		Databases: len(dumps),
+	})
+	if err != nil {
+		return err
+	}
+	next := &backupBase{id: id, digests: make([]map[string]uint64, len(dumps))}
+	for db, dump := range dumps {
+		digests := make(map[string]uint64)
+		err := dump.Each(func(key string, item *storage.Item) error {
+			value, err := item.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			digest := itemDigest(value)
+			digests[key] = digest
+			if base != nil {
+				if old, ok := base.digests[db][key]; ok && old == digest {
+					return nil
+				}
+			}
+			return w.Put(db, key, value)
+		})
+		if err != nil {
+			s.echo.Logger.Error(err)
+			return nil
+		}
+		if base != nil {
+			if err := backupDeletes(w, db, base.digests[db], digests); err != nil {
+				return nil
+			}
+		}
+		next.digests[db] = digests
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	s.lastBackup = next
+	return nil
+}
+
+// backupDeletes writes the removal of the keys of old missing from current.
+func backupDeletes(w *storage.BackupWriter, db int, old, current map[string]uint64) error {
+	var removed []string
+	for key := range old {
+		if _, ok := current[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		if err := w.Delete(db, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
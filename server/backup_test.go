@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"my-go-db/storage"
+)
+
+// readBackup takes a backup of s and returns its header and records.
+func readBackup(t *testing.T, s *Server, target string) (storage.BackupHeader, []*storage.BackupRecord) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatal("Must take a backup", rec.Code, rec.Body.String())
+	}
+	br, err := storage.NewBackupReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []*storage.BackupRecord{}
+	for {
+		r, err := br.Next()
+		if err == io.EOF {
+			return br.Header(), records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+}
+
+func TestServer_Backup(t *testing.T) {
+	s := New(":0", storage.New(), storage.New())
+	for db := 0; db < 2; db++ {
+		for i := 0; i < 3; i++ {
+			request(t, s, "POST", fmt.Sprintf("/storage/key%d?db=%d", i, db), fmt.Sprintf(`{"string": "v%d"}`, i))
+		}
+	}
+
+	full, records := readBackup(t, s, "/admin/backup")
+	if !full.Full() || full.Databases != 2 || len(records) != 6 {
+		t.Fatal("Must back up every database", full, len(records))
+	}
+	if r := records[3]; r.DB != 1 || r.Key != "key0" || r.Item.String != "v0" {
+		t.Error("Must back up the keys in order", r)
+	}
+
+	request(t, s, "POST", "/storage/key1?db=1", `{"string": "changed"}`)
+	request(t, s, "DELETE", "/storage/key2?db=0", "")
+	header, records := readBackup(t, s, fmt.Sprintf("/admin/backup?since=%d", full.ID))
+	if header.Base != full.ID || len(records) != 2 {
+		t.Fatal("Must back up the changes", header, len(records))
+	}
+	if r := records[0]; r.DB != 0 || r.Key != "key2" || r.Item != nil {
+		t.Error("Must back up the removal", r)
+	}
+	if r := records[1]; r.DB != 1 || r.Key != "key1" || r.Item.String != "changed" {
+		t.Error("Must back up the new value", r)
+	}
+
+	status, resp := request(t, s, "GET", fmt.Sprintf("/admin/backup?since=%d", full.ID), "")
+	if status != http.StatusConflict || resp.Code != codeBackupBase {
+		t.Error("Must follow the last backup", status, resp.Code)
+	}
+}
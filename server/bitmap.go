@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+var errBadOffset = errors.New("Offset must be integer")
+
+// GET /storage/:key/bit/:offset
+func (s *Server) getBit(c echo.Context) error {
+	bitmaps, ok := s.engine(c).(storage.BitmapEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	offset, err := strconv.Atoi(c.Param("offset"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, errBadOffset)
+	}
+
+	bit, err := bitmaps.GetBit(c.Param("key"), offset)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Bit:     bit,
+	})
+}
+
+// POST /storage/:key/bit/:offset
+func (s *Server) setBit(c echo.Context) error {
+	bitmaps, ok := s.engine(c).(storage.BitmapEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	offset, err := strconv.Atoi(c.Param("offset"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, errBadOffset)
+	}
+
+	old, err := bitmaps.SetBit(c.Param("key"), offset, reqBody.Bit)
+	if err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Bit:     old,
+	})
+}
+
+// GET /storage/:key/bitcount?start=0&end=-1
+func (s *Server) bitCount(c echo.Context) error {
+	bitmaps, ok := s.engine(c).(storage.BitmapEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	start, err := queryInt(c, "start", 0)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	end, err := queryInt(c, "end", -1)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	count, err := bitmaps.BitCount(c.Param("key"), start, end)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Count:   count,
+	})
+}
+
+// GET /storage/:key/bitpos?bit=1&start=0&end=-1
+func (s *Server) bitPos(c echo.Context) error {
+	bitmaps, ok := s.engine(c).(storage.BitmapEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	bit, err := queryInt(c, "bit", 1)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	start, err := queryInt(c, "start", 0)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	end, err := queryInt(c, "end", -1)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	pos, err := bitmaps.BitPos(c.Param("key"), bit, start, end)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success:  true,
+		Position: pos,
+	})
+}
+
+// POST /storage/:key/bitop
+func (s *Server) bitOp(c echo.Context) error {
+	bitmaps, ok := s.engine(c).(storage.BitmapEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	op := storage.BitOperation(strings.ToUpper(reqBody.Op))
+	size, err := bitmaps.BitOp(op, c.Param("key"), reqBody.Keys...)
+	if err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Count:   size,
+	})
+}
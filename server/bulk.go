@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+var (
+	errNoKeys          = errors.New("At least one key is required")
+	errUnsupportedType = errors.New("Unsupported type")
+	errNullEntry       = errors.New("Entry must be an object")
+	errEmptyValue      = errors.New("Value must not be empty")
+)
+
+// entryItem converts a bulk entry to an item, following the rules of
+// POST /storage/:key. Empty lists, dicts and bitmaps, which the setters
+// treat as no value, are rejected.
+func entryItem(e *BulkEntry) (*storage.Item, error) {
+	item := storage.NewItem(e.TTL)
+	switch {
+	case e.String != "":
+		item.String = e.String
+	case e.Int > 0:
+		item.Int = e.Int
+	case len(e.StringList) > 0:
+		item.StringSlice = e.StringList
+	case len(e.IntList) > 0:
+		item.IntSlice = e.IntList
+	case len(e.StringDict) > 0:
+		item.StringMap = e.StringDict
+	case len(e.IntDict) > 0:
+		item.IntMap = e.IntDict
+	case len(e.Bitmap) > 0:
+		item.Bitmap = e.Bitmap
+	case e.StringList != nil || e.IntList != nil || e.StringDict != nil || e.IntDict != nil || e.Bitmap != nil:
+		return nil, errEmptyValue
+	default:
+		return nil, errUnsupportedType
+	}
+	return item, nil
+}
+
+// setEntry stores a bulk entry with the setters of engine.
+func setEntry(engine storage.Engine, e *BulkEntry) error {
+	switch {
+	case e.String != "":
+		return engine.SetString(e.Key, e.String, e.TTL)
+	case e.Int > 0:
+		return engine.SetInt(e.Key, e.Int, e.TTL)
+	case len(e.StringList) > 0:
+		return engine.SetStringSlice(e.Key, e.StringList, e.TTL)
+	case len(e.IntList) > 0:
+		return engine.SetIntSlice(e.Key, e.IntList, e.TTL)
+	case len(e.StringDict) > 0:
+		return engine.SetStringMap(e.Key, e.StringDict, e.TTL)
+	case len(e.IntDict) > 0:
+		return engine.SetIntMap(e.Key, e.IntDict, e.TTL)
+	case len(e.Bitmap) > 0:
+		return engine.SetBitmap(e.Key, e.Bitmap, e.TTL)
+	}
+	return errUnsupportedType
+}
+
+func itemEntry(key string, item *storage.Item) *BulkEntry {
+	e := &BulkEntry{Key: key, Found: item != nil}
+	if item != nil {
+		e.String = item.String
+		e.Int = item.Int
+		e.StringList = item.StringSlice
+		e.IntList = item.IntSlice
+		e.StringDict = item.StringMap
+		e.IntDict = item.IntMap
+		e.Bitmap = item.Bitmap
+		if item.Geo != nil {
+			e.Locations = geoLocations(item.Geo.Members())
+		}
+		if item.TimeSeries != nil {
+			e.Samples = tsSamples(item.TimeSeries.Samples())
+		}
+		if item.CRDT != nil {
+			e.CRDT, _ = crdtInfo(item.CRDT, false)
+		}
+	}
+	return e
+}
+
+// GET /storage/_bulk?key=a&key=b
+//
+// Engines without bulk operations read the keys one by one.
+func (s *Server) bulkGet(c echo.Context) error {
+	keys := c.QueryParams()["key"]
+	if len(keys) == 0 {
+		return errorResponse(c, http.StatusBadRequest, errNoKeys)
+	}
+	resp := &ResponseBody{Success: true, Entries: make([]*BulkEntry, len(keys))}
+	engine := s.engine(c)
+	if bulk, ok := engine.(storage.BulkEngine); ok {
+		items, err := bulk.MGet(keys)
+		if err != nil {
+			return errorResponse(c, http.StatusInternalServerError, err)
+		}
+		for i, item := range items {
+			resp.Entries[i] = itemEntry(keys[i], item)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+	for i, key := range keys {
+		item, err := engine.Get(key)
+		resp.Entries[i] = itemEntry(key, item)
+		if err != nil {
+			resp.Entries[i].Error = err.Error()
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// POST /storage/_bulk {"entries": [{"key": "a", "string": "x", "ttl": 10}], "nx": true}
+//
+// Entries are checked before anything is stored. With nx nothing is stored
+// if any key exists, which needs an engine with bulk operations; other
+// engines store the entries one by one.
+func (s *Server) bulkSet(c echo.Context) error {
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if len(reqBody.Entries) == 0 {
+		return errorResponse(c, http.StatusBadRequest, errNoKeys)
+	}
+
+	resp := &ResponseBody{Entries: make([]*BulkEntry, len(reqBody.Entries))}
+	entries := make([]storage.KeyValue, len(reqBody.Entries))
+	status := http.StatusOK
+	for i, e := range reqBody.Entries {
+		var item *storage.Item
+		var err error
+		if e == nil {
+			resp.Entries[i] = &BulkEntry{}
+			err = errNullEntry
+		} else {
+			resp.Entries[i] = &BulkEntry{Key: e.Key}
+			if item, err = entryItem(e); err == nil {
+				err = s.limits.Check(e.Key, item)
+			}
+		}
+		if err != nil {
+			resp.Entries[i].Error = err.Error()
+			if status == http.StatusOK {
+				status = limitStatus(err, http.StatusBadRequest)
+				resp.Code = errorCode(err)
+			}
+		}
+		entries[i] = storage.KeyValue{Key: resp.Entries[i].Key, Item: item}
+	}
+	if status != http.StatusOK {
+		resp.Message = "Invalid entries"
+		return c.JSON(status, resp)
+	}
+
+	engine := s.engine(c)
+	bulk, ok := engine.(storage.BulkEngine)
+	if !ok && reqBody.NX {
+		return notSupported(c)
+	}
+	if ok {
+		done, err := bulk.MSet(entries, reqBody.NX)
+		if err != nil {
+			return errorResponse(c, limitStatus(err, http.StatusInternalServerError), err)
+		}
+		for _, e := range resp.Entries {
+			e.Found = done
+		}
+		resp.Success = true
+		if done {
+			resp.Count = len(entries)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	for i, e := range reqBody.Entries {
+		if err := setEntry(engine, e); err != nil {
+			resp.Entries[i].Error = err.Error()
+		} else {
+			resp.Entries[i].Found = true
+			resp.Count++
+		}
+	}
+	resp.Success = resp.Count == len(entries)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// DELETE /storage/_bulk?key=a&key=b
+func (s *Server) bulkDelete(c echo.Context) error {
+	keys := c.QueryParams()["key"]
+	if len(keys) == 0 {
+		return errorResponse(c, http.StatusBadRequest, errNoKeys)
+	}
+	resp := &ResponseBody{Success: true, Entries: make([]*BulkEntry, len(keys))}
+	engine := s.engine(c)
+	var removed []bool
+	if bulk, ok := engine.(storage.BulkEngine); ok {
+		var err error
+		if removed, err = bulk.MDel(keys); err != nil {
+			return errorResponse(c, http.StatusInternalServerError, err)
+		}
+	} else {
+		removed = make([]bool, len(keys))
+		for i, key := range keys {
+			removed[i] = engine.Remove(key) == nil
+		}
+	}
+	for i, key := range keys {
+		resp.Entries[i] = &BulkEntry{Key: key, Found: removed[i]}
+		if removed[i] {
+			resp.Count++
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"my-go-db/storage"
+	"my-go-db/storage/enginetest"
+)
+
+func TestServer_BulkSetInvalid(t *testing.T) {
+	for _, engine := range []storage.Engine{storage.New(), enginetest.NewFake()} {
+		s := New(":0", engine)
+		tests := []struct {
+			body string
+			err  error
+		}{
+			{`{"entries": [{"key": "a", "string": "x"}, null]}`, errNullEntry},
+			{`{"entries": [{"key": "a", "string": "x"}, {"key": "b", "string_list": []}]}`, errEmptyValue},
+			{`{"entries": [{"key": "a", "string": "x"}, {"key": "b", "int_dict": {}}]}`, errEmptyValue},
+			{`{"entries": [{"key": "a", "string": "x"}, {"key": "b"}]}`, errUnsupportedType},
+		}
+		for _, tt := range tests {
+			status, resp := request(t, s, "POST", "/storage/_bulk", tt.body)
+			if status != http.StatusBadRequest || resp.Success || len(resp.Entries) != 2 || resp.Entries[1].Error != tt.err.Error() {
+				t.Error("Must reject the entry", tt.body, status, resp.Entries)
+			}
+			if resp.Entries[0].Error != "" {
+				t.Error("Must accept the valid entry", resp.Entries[0].Error)
+			}
+		}
+		if keys, _ := engine.Keys(); len(keys) != 0 {
+			t.Error("Must store nothing", keys)
+		}
+	}
+}
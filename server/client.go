@@ -2,17 +2,30 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"my-go-db/storage"
 )
 
 
 type Client struct {
 	serverURL   string
 	storageURL  string
+	db          int
 }
 
 func NewClient(host, port string) *Client {
@@ -23,10 +36,49 @@ func NewClient(host, port string) *Client {
 	}
 }
 
+// Select makes the following requests use the logical database db.
+func (c *Client) Select(db int) {
+	c.db = db
+}
+
+// withDB adds the selected database to url.
+func (c *Client) withDB(url string) string {
+	if c.db == 0 {
+		return url
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%sdb=%d", url, sep, c.db)
+}
+
 func (c *Client) GetValue(key string) (interface{}, error) {
-	url := fmt.Sprintf("%s/%s", c.storageURL, key)
+	return c.getValue(fmt.Sprintf("%s/%s", c.storageURL, key))
+}
+
+// GetValueAt reads key as of an open snapshot.
+func (c *Client) GetValueAt(key string, snapshot int64) (interface{}, error) {
+	return c.getValue(fmt.Sprintf("%s/%s?snapshot=%d", c.storageURL, key, snapshot))
+}
+
+// OpenSnapshot opens a read snapshot at the current revision and returns its
+// id. Snapshots unused for a few minutes are released by the server.
+func (c *Client) OpenSnapshot() (int64, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.serverURL+"/snapshots", nil)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Snapshot, nil
+}
+
+func (c *Client) ReleaseSnapshot(snapshot int64) error {
+	_, err := c.doRequest(http.MethodDelete, fmt.Sprintf("%s/snapshots/%d", c.serverURL, snapshot), nil)
+	return err
+}
 
-	resp, err := http.Get(url)
+func (c *Client) getValue(url string) (interface{}, error) {
+	resp, err := http.Get(c.withDB(url))
 	if err != nil {
 		log.Printf("Error :%v\n", err.Error())
 		return nil, err
@@ -45,6 +97,9 @@ func (c *Client) GetValue(key string) (interface{}, error) {
 		log.Printf("Error: %v\n", err.Error())
 		return nil, err
 	}
+	if !respBody.Success && resp.StatusCode != http.StatusNotFound {
+		return nil, responseError(resp.StatusCode, &respBody)
+	}
 
 	if respBody.String != "" {
 		return respBody.String, nil
@@ -64,6 +119,18 @@ func (c *Client) GetValue(key string) (interface{}, error) {
 	if respBody.IntDict != nil {
 		return respBody.IntDict, nil
 	}
+	if respBody.Bitmap != nil {
+		return respBody.Bitmap, nil
+	}
+	if respBody.Locations != nil {
+		return respBody.Locations, nil
+	}
+	if respBody.Samples != nil {
+		return respBody.Samples, nil
+	}
+	if respBody.CRDT != nil {
+		return respBody.CRDT, nil
+	}
 	return nil, nil
 }
 
@@ -116,8 +183,505 @@ func (c *Client) SetIntMap(key string, value map[string]int, ttl int) error {
 	return err
 }
 
+func (c *Client) SetBitmap(key string, value []byte, ttl int) error {
+	reqBody := new(RequestBody)
+	reqBody.Bitmap = value
+	reqBody.TTL = ttl
+	_, err := c.doPost(key, reqBody)
+	return err
+}
+
+func (c *Client) SetBit(key string, offset, value int) (int, error) {
+	reqBody := new(RequestBody)
+	reqBody.Bit = value
+	url := fmt.Sprintf("%s/bit/%d", c.getKeyUrl(key), offset)
+	respBody, err := c.doRequest(http.MethodPost, url, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Bit, nil
+}
+
+func (c *Client) GetBit(key string, offset int) (int, error) {
+	url := fmt.Sprintf("%s/bit/%d", c.getKeyUrl(key), offset)
+	respBody, err := c.doRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Bit, nil
+}
+
+// BitCount counts set bits in the bytes from start to end inclusive.
+func (c *Client) BitCount(key string, start, end int) (int, error) {
+	url := fmt.Sprintf("%s/bitcount?start=%d&end=%d", c.getKeyUrl(key), start, end)
+	respBody, err := c.doRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Count, nil
+}
+
+// BitPos returns the offset of the first bit equal to bit, or -1.
+func (c *Client) BitPos(key string, bit, start, end int) (int, error) {
+	url := fmt.Sprintf("%s/bitpos?bit=%d&start=%d&end=%d", c.getKeyUrl(key), bit, start, end)
+	respBody, err := c.doRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Position, nil
+}
+
+// BitOp stores op over keys in dest and returns the result size in bytes.
+func (c *Client) BitOp(op, dest string, keys ...string) (int, error) {
+	reqBody := new(RequestBody)
+	reqBody.Op = op
+	reqBody.Keys = keys
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(dest)+"/bitop", reqBody)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Count, nil
+}
+
+// GeoAdd adds or moves locations and returns the number of new members.
+func (c *Client) GeoAdd(key string, locations ...*GeoLocation) (int, error) {
+	reqBody := new(RequestBody)
+	reqBody.Locations = locations
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/geo", reqBody)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Count, nil
+}
+
+// GeoPos returns the positions of members, with nil for missing ones.
+func (c *Client) GeoPos(key string, members ...string) ([]*GeoLocation, error) {
+	query := url.Values{"member": members}
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/geo/pos?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Locations, nil
+}
+
+func (c *Client) GeoDist(key, member1, member2, unit string) (float64, error) {
+	query := url.Values{}
+	query.Set("from", member1)
+	query.Set("to", member2)
+	query.Set("unit", unit)
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/geo/dist?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Distance, nil
+}
+
+// GeoSearch returns the locations inside the area sorted by distance.
+func (c *Client) GeoSearch(key string, q GeoSearchQuery) ([]*GeoLocation, error) {
+	query := url.Values{}
+	if q.Member != "" {
+		query.Set("member", q.Member)
+	} else {
+		query.Set("lon", strconv.FormatFloat(q.Longitude, 'f', -1, 64))
+		query.Set("lat", strconv.FormatFloat(q.Latitude, 'f', -1, 64))
+	}
+	if q.Radius > 0 {
+		query.Set("radius", strconv.FormatFloat(q.Radius, 'f', -1, 64))
+	} else {
+		query.Set("width", strconv.FormatFloat(q.Width, 'f', -1, 64))
+		query.Set("height", strconv.FormatFloat(q.Height, 'f', -1, 64))
+	}
+	query.Set("unit", q.Unit)
+	if q.Count > 0 {
+		query.Set("count", strconv.Itoa(q.Count))
+	}
+	if q.Desc {
+		query.Set("order", "desc")
+	}
+
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/geo/search?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if respBody.Locations == nil {
+		return []*GeoLocation{}, nil
+	}
+	return respBody.Locations, nil
+}
+
+// TSCreate creates an empty time series with a retention in milliseconds,
+// 0 keeping samples forever, or changes the retention of an existing one.
+func (c *Client) TSCreate(key string, retention int64) error {
+	_, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/ts", &RequestBody{Retention: &retention})
+	return err
+}
+
+// TSAdd adds samples to a time series and returns the number of new
+// timestamps.
+func (c *Client) TSAdd(key string, samples ...*TSSample) (int, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/ts", &RequestBody{Samples: samples})
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Count, nil
+}
+
+// TSRange returns the samples of a time series selected by q.
+func (c *Client) TSRange(key string, q TSRangeQuery) ([]*TSSample, error) {
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(q.From, 10))
+	if q.To > 0 {
+		query.Set("to", strconv.FormatInt(q.To, 10))
+	}
+	if q.Aggregation != "" {
+		query.Set("aggregation", q.Aggregation)
+		query.Set("bucket", strconv.FormatInt(q.Bucket, 10))
+	}
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/ts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if respBody.Samples == nil {
+		return []*TSSample{}, nil
+	}
+	return respBody.Samples, nil
+}
+
+// TSInfo returns the retention, size and rules of a time series.
+func (c *Client) TSInfo(key string) (*TSInfo, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/ts/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.TimeSeries, nil
+}
+
+// TSCreateRule downsamples the time series of key into rule.Destination.
+func (c *Client) TSCreateRule(key string, rule *TSRule) error {
+	reqBody := &RequestBody{
+		Destination: rule.Destination,
+		Aggregation: rule.Aggregation,
+		Bucket:      rule.Bucket,
+	}
+	_, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/ts/rules", reqBody)
+	return err
+}
+
+// TSDeleteRule stops downsampling the time series of key into dest.
+func (c *Client) TSDeleteRule(key, dest string) error {
+	_, err := c.doRequest(http.MethodDelete, c.getKeyUrl(key)+"/ts/rules/"+dest, nil)
+	return err
+}
+
+// AcquireLock takes the lock name for owner for the lease and returns it
+// with its fencing token. It fails with ErrLockHeld if another owner holds
+// it. See Lock for a lock renewed in the background.
+func (c *Client) AcquireLock(name, owner string, lease time.Duration) (*LockInfo, error) {
+	return c.lockRequest(context.Background(), c.lockURL(name), owner, lease)
+}
+
+// RenewLock extends the lease of a lock held by owner. It fails with
+// ErrLockNotHeld if owner lost the lock.
+func (c *Client) RenewLock(name, owner string, lease time.Duration) (*LockInfo, error) {
+	return c.lockRequest(context.Background(), c.lockURL(name)+"/renew", owner, lease)
+}
+
+func (c *Client) lockURL(name string) string {
+	return c.serverURL + "/locks/" + url.PathEscape(name)
+}
+
+func (c *Client) lockRequest(ctx context.Context, u, owner string, lease time.Duration) (*LockInfo, error) {
+	reqBody := &RequestBody{Owner: owner, Lease: int64(lease / time.Millisecond)}
+	respBody, err := c.doRequestContext(ctx, http.MethodPost, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Lock, nil
+}
+
+// ReleaseLock releases a lock held by owner.
+func (c *Client) ReleaseLock(name, owner string) error {
+	u := c.lockURL(name) + "?owner=" + url.QueryEscape(owner)
+	_, err := c.doRequest(http.MethodDelete, u, nil)
+	return err
+}
+
+// Lock acquires the lock name under a random owner id and renews it in the
+// background every third of the lease until Unlock. It fails with
+// ErrLockHeld if the lock is held. The lock is reported lost while a third
+// of the lease is left, so work under it has time to stop before another
+// owner can take it.
+func (c *Client) Lock(name string, lease time.Duration) (*Lock, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	owner := hex.EncodeToString(id)
+	start := time.Now()
+	info, err := c.AcquireLock(name, owner, lease)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lock{
+		c:     c,
+		name:  name,
+		owner: owner,
+		lease: lease,
+		token: info.Token,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		lost:  make(chan struct{}),
+	}
+	go l.renew(start.Add(lease))
+	return l, nil
+}
+
+// Lock is a lock held through Client.Lock.
+type Lock struct {
+	c     *Client
+	name  string
+	owner string
+	lease time.Duration
+	token int64
+
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+}
+
+// Token returns the fencing token of the lock. Pass it along with writes
+// made under the lock, so they can be rejected once a newer owner wrote.
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// Owner returns the owner id the lock is held under.
+func (l *Lock) Owner() string {
+	return l.owner
+}
+
+// Lost is closed when the lock could not be renewed while more than a
+// third of its lease was left, or the server reported it lost. Work under
+// the lock must stop then.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// renew renews the lock until Unlock, a third of the lease after the last
+// renewal. Failed renewals are retried until only a third of the lease is
+// left, which is also the deadline of every request, so a slow server
+// cannot delay the loss past it. expires is when the lease ends at the
+// latest, counted from before the request that set it.
+func (l *Lock) renew(expires time.Time) {
+	defer close(l.done)
+	margin := l.lease / 3
+	timer := time.NewTimer(margin)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+		}
+		deadline := expires.Add(-margin)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		start := time.Now()
+		_, err := l.c.lockRequest(ctx, l.c.lockURL(l.name)+"/renew", l.owner, l.lease)
+		cancel()
+		switch {
+		case err == nil:
+			expires = start.Add(l.lease)
+			timer.Reset(margin)
+		case errors.Is(err, ErrLockNotHeld) || !time.Now().Before(deadline):
+			close(l.lost)
+			return
+		default:
+			timer.Reset(min(margin/4, time.Until(deadline)))
+		}
+	}
+}
+
+// Unlock stops renewing the lock and releases it. It fails with
+// ErrLockNotHeld if the lock was lost, after releasing it in case the
+// server still holds it.
+func (l *Lock) Unlock() error {
+	select {
+	case <-l.stop:
+		return ErrLockNotHeld
+	default:
+		close(l.stop)
+	}
+	<-l.done
+	err := l.c.ReleaseLock(l.name, l.owner)
+	select {
+	case <-l.lost:
+		return ErrLockNotHeld
+	default:
+		return err
+	}
+}
+
+// RateLimit checks a request of cost against the limiter of key and
+// consumes it if it is allowed, atomically on the server.
+func (c *Client) RateLimit(key string, limiter *RateLimiter, cost int) (*RateLimitResult, error) {
+	reqBody := &RequestBody{
+		Algorithm: limiter.Algorithm,
+		Limit:     limiter.Limit,
+		Period:    int64(limiter.Period / time.Millisecond),
+		Cost:      cost,
+	}
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/ratelimit", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.RateLimit, nil
+}
+
+func (c *Client) crdtUpdate(key string, reqBody *RequestBody) (*CRDTInfo, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/crdt", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.CRDT, nil
+}
+
+// CRDTIncrement adds delta to the gcounter or pncounter of key, creating it
+// as a typ if needed.
+func (c *Client) CRDTIncrement(key, typ string, delta int) (*CRDTInfo, error) {
+	return c.crdtUpdate(key, &RequestBody{Op: "incr", Type: typ, Int: delta})
+}
+
+// CRDTAdd adds members to the orset of key.
+func (c *Client) CRDTAdd(key string, members ...string) (*CRDTInfo, error) {
+	return c.crdtUpdate(key, &RequestBody{Op: "add", StringList: members})
+}
+
+// CRDTRemove removes members from the orset of key.
+func (c *Client) CRDTRemove(key string, members ...string) (*CRDTInfo, error) {
+	return c.crdtUpdate(key, &RequestBody{Op: "remove", StringList: members})
+}
+
+// CRDTSet writes value to the lwwregister of key.
+func (c *Client) CRDTSet(key, value string) (*CRDTInfo, error) {
+	return c.crdtUpdate(key, &RequestBody{Op: "set", String: value})
+}
+
+// CRDTExport returns the CRDT of key with its state, to be merged into
+// another replica with CRDTMerge.
+func (c *Client) CRDTExport(key string) (*CRDTInfo, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/crdt", nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.CRDT, nil
+}
+
+// CRDTMerge merges a state exported by another replica into the CRDT of
+// key and returns the result.
+func (c *Client) CRDTMerge(key string, state []byte) (*CRDTInfo, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/crdt/merge", &RequestBody{State: state})
+	if err != nil {
+		return nil, err
+	}
+	return respBody.CRDT, nil
+}
+
+func (c *Client) sequenceURL(name string) string {
+	return c.serverURL + "/sequences/" + url.PathEscape(name)
+}
+
+// CreateSequence creates the sequence name handing out start, start+step,
+// ... It fails with ErrSequenceExists if it exists.
+func (c *Client) CreateSequence(name string, start, step int64) (*SequenceInfo, error) {
+	reqBody := &RequestBody{Start: &start, Step: &step}
+	respBody, err := c.doRequest(http.MethodPost, c.sequenceURL(name), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Sequence, nil
+}
+
+// NextIDs reserves count ids of the sequence name, creating it from 1 with
+// a step of 1 if needed. See Sequence to hand them out one by one.
+func (c *Client) NextIDs(name string, count int) (*IDBlock, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.sequenceURL(name)+"/next", &RequestBody{Count: count})
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Block, nil
+}
+
+// GetSequence returns the next id and step of the sequence name.
+func (c *Client) GetSequence(name string) (*SequenceInfo, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.sequenceURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Sequence, nil
+}
+
+func (c *Client) DeleteSequence(name string) error {
+	_, err := c.doRequest(http.MethodDelete, c.sequenceURL(name), nil)
+	return err
+}
+
+// Sequence returns a sequence handing out the ids of the sequence name
+// from blocks of size ids reserved on the server, so most ids need no
+// request. Ids of a block not handed out are skipped.
+func (c *Client) Sequence(name string, size int) *Sequence {
+	return &Sequence{c: c, name: name, size: size}
+}
+
+// Sequence hands out ids reserved through Client.Sequence. It is safe for
+// concurrent use.
+type Sequence struct {
+	c    *Client
+	name string
+	size int
+
+	mu    sync.Mutex
+	block IDBlock
+}
+
+// Next returns the next id of the sequence.
+func (s *Sequence) Next() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.block.Count == 0 {
+		block, err := s.c.NextIDs(s.name, s.size)
+		if err != nil {
+			return 0, err
+		}
+		s.block = *block
+	}
+	id := s.block.First
+	s.block.First += s.block.Step
+	s.block.Count--
+	return id, nil
+}
+
+// SnowflakeIDs generates count snowflake ids: 63 bit integers ordered by
+// time, unique among servers with distinct worker ids.
+func (c *Client) SnowflakeIDs(count int) ([]int64, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.serverURL+"/ids/snowflake", &RequestBody{Count: count})
+	if err != nil {
+		return nil, err
+	}
+	return respBody.IDs, nil
+}
+
+// ULIDs generates count ULIDs: 26 character strings ordered by time.
+func (c *Client) ULIDs(count int) ([]string, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.serverURL+"/ids/ulid", &RequestBody{Count: count})
+	if err != nil {
+		return nil, err
+	}
+	return respBody.ULIDs, nil
+}
+
 func (c *Client) GetKeys() []string {
-	resp, err := http.Get(c.storageURL + "/")
+	resp, err := http.Get(c.withDB(c.storageURL + "/"))
 	if err != nil {
 		log.Println("GetKeys() error: ", err.Error())
 		return nil
@@ -139,11 +703,335 @@ func (c *Client) GetKeys() []string {
 	return respBody.Keys
 }
 
+// Scan returns a page of keys in order and the cursor of the next page, which
+// is empty after the last page.
+func (c *Client) Scan(q ScanQuery) ([]string, string, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"prefix": q.Prefix,
+		"start":  q.Start,
+		"end":    q.End,
+		"cursor": q.Cursor,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if q.Reverse {
+		query.Set("reverse", "true")
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Snapshot > 0 {
+		query.Set("snapshot", strconv.FormatInt(q.Snapshot, 10))
+	}
+
+	respBody, err := c.doRequest(http.MethodGet, c.storageURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if respBody.Keys == nil {
+		return []string{}, respBody.Cursor, nil
+	}
+	return respBody.Keys, respBody.Cursor, nil
+}
+
+func (c *Client) GetIndexes() ([]*IndexDefinition, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.serverURL+"/indexes", nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Indexes, nil
+}
+
+func (c *Client) CreateIndex(index *IndexDefinition) error {
+	reqBody := new(RequestBody)
+	reqBody.Index = index
+	_, err := c.doRequest(http.MethodPost, c.serverURL+"/indexes/"+url.PathEscape(index.Name), reqBody)
+	return err
+}
+
+func (c *Client) DropIndex(name string) error {
+	_, err := c.doRequest(http.MethodDelete, c.serverURL+"/indexes/"+url.PathEscape(name), nil)
+	return err
+}
+
+// FindByIndex returns the keys whose indexed field equals value.
+func (c *Client) FindByIndex(name, value string) ([]string, error) {
+	query := url.Values{"value": {value}}
+	return c.lookupIndex(name, query)
+}
+
+// FindByIndexRange returns the keys whose indexed field is between min and
+// max inclusive. Empty bounds are open, limit 0 means no limit.
+func (c *Client) FindByIndexRange(name, min, max string, limit int) ([]string, error) {
+	query := url.Values{}
+	if min != "" {
+		query.Set("min", min)
+	}
+	if max != "" {
+		query.Set("max", max)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return c.lookupIndex(name, query)
+}
+
+func (c *Client) lookupIndex(name string, query url.Values) ([]string, error) {
+	u := fmt.Sprintf("%s/indexes/%s/keys?%s", c.serverURL, url.PathEscape(name), query.Encode())
+	respBody, err := c.doRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if respBody.Keys == nil {
+		return []string{}, nil
+	}
+	return respBody.Keys, nil
+}
+
+// MemoryUsage returns the estimated memory used by key.
+func (c *Client) MemoryUsage(key string) (*MemoryUsage, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/memory", nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(respBody.Memory) != 1 {
+		return nil, errors.New("Unexpected response")
+	}
+	return respBody.Memory[0], nil
+}
+
+// MemoryTop returns the count largest keys, largest first.
+func (c *Client) MemoryTop(count int) ([]*MemoryUsage, error) {
+	u := fmt.Sprintf("%s/admin/memory/top?count=%d", c.serverURL, count)
+	respBody, err := c.doRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Memory, nil
+}
+
+func (c *Client) MemoryStats() (*MemoryReport, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.serverURL+"/admin/memory", nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.MemoryReport, nil
+}
+
+// Rename renames src to dst, replacing dst.
+func (c *Client) Rename(src, dst string) error {
+	_, err := c.keyspaceRequest(src, "rename", &RequestBody{Destination: dst})
+	return err
+}
+
+// RenameNX renames src to dst unless dst exists.
+func (c *Client) RenameNX(src, dst string) (bool, error) {
+	return c.keyspaceRequest(src, "rename", &RequestBody{Destination: dst, NX: true})
+}
+
+// Copy copies the value and ttl of src to dst. Unless replace is set it
+// returns false if dst exists.
+func (c *Client) Copy(src, dst string, replace bool) (bool, error) {
+	return c.keyspaceRequest(src, "copy", &RequestBody{Destination: dst, Replace: replace})
+}
+
+// Move moves key from the selected database to db. It returns false if key
+// does not exist or db already has it.
+func (c *Client) Move(key string, db int) (bool, error) {
+	return c.keyspaceRequest(key, "move", &RequestBody{DB: db})
+}
+
+func (c *Client) keyspaceRequest(key, op string, reqBody *RequestBody) (bool, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.getKeyUrl(key)+"/"+op, reqBody)
+	if err != nil {
+		return false, err
+	}
+	return respBody.Count == 1, nil
+}
+
+// MGet reads keys in one request. Entries come in the order of keys, with
+// Found false for missing keys.
+func (c *Client) MGet(keys ...string) ([]*BulkEntry, error) {
+	query := url.Values{"key": keys}
+	respBody, err := c.doRequest(http.MethodGet, c.storageURL+"/_bulk?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Entries, nil
+}
+
+// RandomKeys returns up to count distinct keys picked at random.
+func (c *Client) RandomKeys(count int) ([]string, error) {
+	query := url.Values{"count": {strconv.Itoa(count)}}
+	respBody, err := c.doRequest(http.MethodGet, c.storageURL+"/_random?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Keys, nil
+}
+
+// MSet stores entries in one request. With nx nothing is stored if any key
+// exists. The returned entries tell which keys were stored, with the reason
+// for the others; they are also returned with an error.
+func (c *Client) MSet(entries []*BulkEntry, nx bool) ([]*BulkEntry, error) {
+	reqBody := new(RequestBody)
+	reqBody.Entries = entries
+	reqBody.NX = nx
+	respBody, err := c.doRequest(http.MethodPost, c.storageURL+"/_bulk", reqBody)
+	if respBody == nil {
+		return nil, err
+	}
+	return respBody.Entries, err
+}
+
+// MDel removes keys in one request. The entries tell which keys existed.
+func (c *Client) MDel(keys ...string) ([]*BulkEntry, error) {
+	query := url.Values{"key": keys}
+	respBody, err := c.doRequest(http.MethodDelete, c.storageURL+"/_bulk?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Entries, nil
+}
+
+// Object returns the metadata of key.
+func (c *Client) Object(key string) (*ObjectInfo, error) {
+	respBody, err := c.doRequest(http.MethodGet, c.getKeyUrl(key)+"/object", nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Object, nil
+}
+
+// IdleKeys returns the count keys not accessed for the longest time.
+func (c *Client) IdleKeys(count int) ([]*IdleKey, error) {
+	u := fmt.Sprintf("%s/admin/idle?count=%d", c.serverURL, count)
+	respBody, err := c.doRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return respBody.Idle, nil
+}
+
+// EvictIdle removes the keys not accessed for idle seconds and returns
+// their number.
+func (c *Client) EvictIdle(idle int) (int, error) {
+	u := fmt.Sprintf("%s/admin/evict?idle=%d", c.serverURL, idle)
+	respBody, err := c.doRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	return respBody.Count, nil
+}
+
+// Backup streams a backup of every database to w: a full one if since is
+// 0, otherwise the changes since the backup with that id. It fails with
+// ErrBackupBase if since is not the last backup taken. A stream cut by an
+// error is incomplete; read it back with storage.NewBackupReader to check.
+func (c *Client) Backup(w io.Writer, since uint64) error {
+	u := c.serverURL + "/admin/backup"
+	if since != 0 {
+		u += "?since=" + strconv.FormatUint(since, 10)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody := new(ResponseBody)
+		if err := json.NewDecoder(resp.Body).Decode(respBody); err != nil {
+			return fmt.Errorf("Backup failed: %s", resp.Status)
+		}
+		return responseError(resp.StatusCode, respBody)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ScriptLoad compiles src on the server and returns its sha, which EvalSHA
+// runs.
+func (c *Client) ScriptLoad(src string) (string, error) {
+	respBody, err := c.doRequest(http.MethodPost, c.serverURL+"/script", &RequestBody{Script: src})
+	if err != nil {
+		return "", err
+	}
+	return respBody.SHA, nil
+}
+
+// EvalSHA runs the loaded script sha atomically with keys and args as KEYS
+// and ARGV, and returns its result: nil, a bool, int, string, or a list or
+// dict of them. It fails with ErrNoScript if the server does not have the
+// script.
+func (c *Client) EvalSHA(sha string, keys, args []string) (interface{}, error) {
+	u := c.serverURL + "/script/" + sha
+	respBody, err := c.doRequest(http.MethodPost, u, &RequestBody{Keys: keys, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return scriptResult(respBody.Result)
+}
+
+// Eval runs the script src like EvalSHA, loading it first if the server
+// does not have it yet.
+func (c *Client) Eval(src string, keys, args []string) (interface{}, error) {
+	result, err := c.EvalSHA(storage.ScriptSHA(src), keys, args)
+	if !errors.Is(err, ErrNoScript) {
+		return result, err
+	}
+	sha, err := c.ScriptLoad(src)
+	if err != nil {
+		return nil, err
+	}
+	return c.EvalSHA(sha, keys, args)
+}
+
+// scriptResult decodes the result of a script. Scripts have no numbers but
+// integers, so numbers are decoded as ints.
+func scriptResult(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return scriptInts(v)
+}
+
+func scriptInts(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err
+	case []interface{}:
+		for i := range v {
+			if v[i], err = scriptInts(v[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range v {
+			if v[k], err = scriptInts(v[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
 func (c *Client) Remove(key string) error {
 	url := c.getKeyUrl(key)
 
 	client := new(http.Client)
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	req, err := http.NewRequest(http.MethodDelete, c.withDB(url), nil)
 	if err != nil {
 		log.Println("Remove error", err.Error())
 		return err
@@ -170,7 +1058,7 @@ func (c *Client) Remove(key string) error {
 		return err
 	}
 	if !respBody.Success {
-		return fmt.Errorf(respBody.Message)
+		return responseError(resp.StatusCode, respBody)
 	}
 	return nil
 }
@@ -188,7 +1076,7 @@ func (c *Client) doPost(key string, reqBody *RequestBody) (*ResponseBody, error)
 	}
 
 	url := c.getKeyUrl(key)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBytes))
+	resp, err := http.Post(c.withDB(url), "application/json", bytes.NewBuffer(reqBytes))
 	if err != nil {
 		log.Println("doPost error:", err.Error())
 		return nil, err
@@ -207,7 +1095,62 @@ func (c *Client) doPost(key string, reqBody *RequestBody) (*ResponseBody, error)
 		log.Println("Unmarhsal error:", err.Error())
 		return nil, err
 	}
-
+	if !respBody.Success {
+		return respBody, responseError(resp.StatusCode, respBody)
+	}
 	return respBody, nil
 }
 
+
+// doRequest sends reqBody (if any) to url and returns the decoded response.
+// Responses with success=false are turned into errors.
+func (c *Client) doRequest(method, url string, reqBody *RequestBody) (*ResponseBody, error) {
+	return c.doRequestContext(context.Background(), method, url, reqBody)
+}
+
+// doRequestContext is doRequest bounded by ctx.
+func (c *Client) doRequestContext(ctx context.Context, method, url string, reqBody *RequestBody) (*ResponseBody, error) {
+	var body io.Reader
+	if reqBody != nil {
+		reqBytes, err := json.Marshal(reqBody)
+		log.Printf("RequestBody: %v", string(reqBytes))
+		if err != nil {
+			log.Println("Marshal error", err.Error())
+			return nil, err
+		}
+		body = bytes.NewBuffer(reqBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.withDB(url), body)
+	if err != nil {
+		log.Println("doRequest error:", err.Error())
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println("doRequest error:", err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("ioutil.ReadAll error:", err.Error())
+		return nil, err
+	}
+
+	respBody := new(ResponseBody)
+	log.Printf("ResponseBody :%v", string(respBytes))
+	if err = json.Unmarshal(respBytes, respBody); err != nil {
+		log.Println("Unmarhsal error:", err.Error())
+		return nil, err
+	}
+	if !respBody.Success {
+		return respBody, responseError(resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
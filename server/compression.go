@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// GET /storage/:key/compression
+func (s *Server) compressionStats(c echo.Context) error {
+	engine, ok := s.engine(c).(storage.CompressionEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	stats, err := engine.CompressionStats(c.Param("key"))
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	if stats == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Compression: &CompressionInfo{
+			Algorithm: stats.Algorithm,
+			RawSize:   stats.RawSize,
+			Size:      stats.Size,
+		},
+	})
+}
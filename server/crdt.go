@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+var (
+	errCRDTOp    = errors.New("Field op must be incr, add, remove or set")
+	errNoMembers = errors.New("Field string_list must list the members")
+	errNoState   = errors.New("Field state is required")
+)
+
+// SetNodeID sets the id the databases write CRDTs under, which must be
+// unique among the replicas merging their states. It must be called before
+// Start.
+func (s *Server) SetNodeID(id string) error {
+	for _, db := range s.databases {
+		if crdts, ok := db.(storage.CRDTEngine); ok {
+			if err := crdts.SetNodeID(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func crdtInfo(c *storage.CRDT, withState bool) (*CRDTInfo, error) {
+	info := &CRDTInfo{Type: c.Type()}
+	switch v := c.Value().(type) {
+	case int:
+		info.Counter = v
+	case []string:
+		info.Members = v
+	case string:
+		info.Register = v
+	}
+	if withState {
+		state, err := c.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		info.State = state
+	}
+	return info, nil
+}
+
+// crdtResponse answers with the CRDT of key.
+func crdtResponse(c echo.Context, crdts storage.CRDTEngine, key string, withState bool) error {
+	crdt, err := crdts.CRDTGet(key)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if crdt == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	info, err := crdtInfo(crdt, withState)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, CRDT: info})
+}
+
+// POST /storage/:key/crdt
+//
+// Applies op to the CRDT of key, creating it if needed: incr adds int to a
+// counter of the given type, add and remove change the members of an orset
+// listed in string_list, and set writes string to an lwwregister.
+func (s *Server) crdtUpdate(c echo.Context) error {
+	crdts, ok := s.engine(c).(storage.CRDTEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	key := c.Param("key")
+	var err error
+	switch reqBody.Op {
+	case "incr":
+		_, err = crdts.CRDTIncrement(key, reqBody.Type, int64(reqBody.Int))
+	case "add", "remove":
+		if len(reqBody.StringList) == 0 {
+			return errorResponse(c, http.StatusBadRequest, errNoMembers)
+		}
+		if reqBody.Op == "add" {
+			_, err = crdts.CRDTAdd(key, reqBody.StringList...)
+		} else {
+			_, err = crdts.CRDTRemove(key, reqBody.StringList...)
+		}
+	case "set":
+		err = crdts.CRDTSet(key, reqBody.String)
+	default:
+		err = errCRDTOp
+	}
+	if err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return crdtResponse(c, crdts, key, false)
+}
+
+// GET /storage/:key/crdt
+//
+// Exports the CRDT of key with its state.
+func (s *Server) crdtExport(c echo.Context) error {
+	crdts, ok := s.engine(c).(storage.CRDTEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	return crdtResponse(c, crdts, c.Param("key"), true)
+}
+
+// POST /storage/:key/crdt/merge
+//
+// Merges the state exported by another replica into the CRDT of key.
+func (s *Server) crdtMerge(c echo.Context) error {
+	crdts, ok := s.engine(c).(storage.CRDTEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.State == nil {
+		return errorResponse(c, http.StatusBadRequest, errNoState)
+	}
+	state, err := storage.ParseCRDT(reqBody.State)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	key := c.Param("key")
+	if err := crdts.CRDTMerge(key, state); err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return crdtResponse(c, crdts, key, true)
+}
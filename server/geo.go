@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+func geoLocations(members []storage.GeoMember) []*GeoLocation {
+	locations := make([]*GeoLocation, len(members))
+	for i, m := range members {
+		locations[i] = &GeoLocation{Name: m.Name, Longitude: m.Longitude, Latitude: m.Latitude}
+	}
+	return locations
+}
+
+// POST /storage/:key/geo
+func (s *Server) geoAdd(c echo.Context) error {
+	geo, ok := s.engine(c).(storage.GeoEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	members := make([]storage.GeoMember, 0, len(reqBody.Locations))
+	for _, l := range reqBody.Locations {
+		if l == nil || l.Name == "" {
+			return errorResponse(c, http.StatusBadRequest, errors.New("Location name is required"))
+		}
+		members = append(members, storage.GeoMember{Name: l.Name, Longitude: l.Longitude, Latitude: l.Latitude})
+	}
+
+	added, err := geo.GeoAdd(c.Param("key"), members...)
+	if err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Count:   added,
+	})
+}
+
+// GET /storage/:key/geo/pos?member=a&member=b
+func (s *Server) geoPos(c echo.Context) error {
+	geo, ok := s.engine(c).(storage.GeoEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	positions, err := geo.GeoPos(c.Param("key"), c.QueryParams()["member"]...)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	locations := make([]*GeoLocation, len(positions))
+	for i, p := range positions {
+		if p != nil {
+			locations[i] = &GeoLocation{Name: p.Name, Longitude: p.Longitude, Latitude: p.Latitude}
+		}
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success:   true,
+		Locations: locations,
+	})
+}
+
+// GET /storage/:key/geo/dist?from=a&to=b&unit=km
+func (s *Server) geoDist(c echo.Context) error {
+	geo, ok := s.engine(c).(storage.GeoEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	dist, ok, err := geo.GeoDist(c.Param("key"), c.QueryParam("from"), c.QueryParam("to"), c.QueryParam("unit"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if !ok {
+		return errorResponse(c, http.StatusNotFound, errors.New("Not found"))
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success:  true,
+		Distance: dist,
+	})
+}
+
+// GET /storage/:key/geo/search?member=a&radius=2&unit=km
+// GET /storage/:key/geo/search?lon=15&lat=37&width=10&height=5&count=10&order=desc
+func (s *Server) geoSearch(c echo.Context) error {
+	geo, ok := s.engine(c).(storage.GeoEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	q := storage.GeoQuery{
+		Member: c.QueryParam("member"),
+		Unit:   c.QueryParam("unit"),
+		Desc:   strings.ToLower(c.QueryParam("order")) == "desc",
+	}
+
+	var err error
+	floats := []struct {
+		name  string
+		value *float64
+	}{
+		{"lon", &q.Longitude},
+		{"lat", &q.Latitude},
+		{"radius", &q.Radius},
+		{"width", &q.Width},
+		{"height", &q.Height},
+	}
+	for _, f := range floats {
+		if *f.value, err = queryFloat(c, f.name, 0); err != nil {
+			return errorResponse(c, http.StatusBadRequest, err)
+		}
+	}
+	if q.Count, err = queryInt(c, "count", 0); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	results, err := geo.GeoSearch(c.Param("key"), q)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	locations := make([]*GeoLocation, len(results))
+	for i, r := range results {
+		locations[i] = &GeoLocation{
+			Name:      r.Name,
+			Longitude: r.Longitude,
+			Latitude:  r.Latitude,
+			Distance:  r.Distance,
+		}
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success:   true,
+		Locations: locations,
+	})
+}
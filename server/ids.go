@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Snowflake ids are 63 bit integers ordered by time: 41 bits of
+// milliseconds since snowflakeEpoch, 10 bits of worker id and 12 bits
+// counting the ids of the same millisecond. Workers generating ids into
+// the same space need distinct worker ids.
+//
+// ULIDs are 26 character strings ordered by time: 48 bits of unix
+// milliseconds and 80 random bits, in Crockford's base32. Within a
+// millisecond the random bits are incremented, so a server hands them out
+// in increasing order.
+const (
+	snowflakeEpoch      = int64(1577836800000) // 2020-01-01 in unix milliseconds
+	snowflakeWorkerBits = 10
+	snowflakeStepBits   = 12
+	maxWorkerID         = 1<<snowflakeWorkerBits - 1
+	// snowflakeReserve is how far ahead of the ids the state file reserves
+	// time.
+	snowflakeReserve = int64(10 * time.Second / time.Millisecond)
+
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+var errBadWorker = errors.New("Worker id must be between 0 and 1023")
+
+// snowflakeState is the last id generated. Ids are never generated from a
+// time before it, even when the clock goes back or more than 4096 ids are
+// needed in a millisecond.
+type snowflakeState struct {
+	worker  int64
+	last    int64 // milliseconds since snowflakeEpoch
+	step    int64
+	ceiling int64 // first time not reserved in the state file
+}
+
+// restart continues from the time reserved in the state file.
+func (g *snowflakeState) restart(ceiling int64) {
+	g.last, g.step, g.ceiling = ceiling, -1, ceiling
+}
+
+type ulidState struct {
+	last    int64 // unix milliseconds
+	entropy [10]byte
+}
+
+// snowflakes generates count snowflake ids.
+func (t *sequenceTable) snowflakes(count int) ([]int64, error) {
+	if count < 1 || count > maxSequenceBlock {
+		return nil, errBadCount
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.snowflake
+	ids := make([]int64, count)
+	for i := range ids {
+		now := time.Now().UnixMilli() - snowflakeEpoch
+		if now > g.last {
+			g.last, g.step = now, 0
+		} else if g.step++; g.step == 1<<snowflakeStepBits {
+			g.last, g.step = g.last+1, 0
+		}
+		ids[i] = g.last<<(snowflakeWorkerBits+snowflakeStepBits) | g.worker<<snowflakeStepBits | g.step
+	}
+	if g.last >= g.ceiling {
+		ceiling := t.snowflake.ceiling
+		t.snowflake.ceiling = g.last + snowflakeReserve
+		if err := t.saveLocked(); err != nil {
+			t.snowflake.ceiling = ceiling
+			return nil, err
+		}
+		g.ceiling = t.snowflake.ceiling
+	}
+	t.snowflake = g
+	return ids, nil
+}
+
+// ulids generates count ULIDs.
+func (t *sequenceTable) ulids(count int) ([]string, error) {
+	if count < 1 || count > maxSequenceBlock {
+		return nil, errBadCount
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := &t.ulid
+	ids := make([]string, count)
+	for i := range ids {
+		now := time.Now().UnixMilli()
+		if now <= g.last && increment(g.entropy[:]) {
+			now = g.last
+		} else {
+			if now <= g.last {
+				now = g.last + 1
+			}
+			if _, err := rand.Read(g.entropy[:]); err != nil {
+				return nil, err
+			}
+			g.last = now
+		}
+		ids[i] = encodeULID(now, g.entropy)
+	}
+	return ids, nil
+}
+
+// increment adds 1 to the big endian number b, reporting false if it
+// overflows.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeULID(ms int64, entropy [10]byte) string {
+	var id [16]byte
+	binary.BigEndian.PutUint16(id[:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	copy(id[6:], entropy[:])
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// SetWorkerID sets the worker id of the snowflake ids of the server, 0 by
+// default. It must be called before Start.
+func (s *Server) SetWorkerID(id int) error {
+	if id < 0 || id > maxWorkerID {
+		return errBadWorker
+	}
+	s.sequences.mu.Lock()
+	s.sequences.snowflake.worker = int64(id)
+	s.sequences.mu.Unlock()
+	return nil
+}
+
+// idCount reads the number of ids requested, 1 by default.
+func idCount(c echo.Context) (int, error) {
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return 0, err
+	}
+	if reqBody.Count == 0 {
+		return 1, nil
+	}
+	return reqBody.Count, nil
+}
+
+// POST /ids/snowflake
+func (s *Server) snowflakeIDs(c echo.Context) error {
+	count, err := idCount(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	ids, err := s.sequences.snowflakes(count)
+	if err != nil {
+		return errorResponse(c, sequenceStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, IDs: ids})
+}
+
+// POST /ids/ulid
+func (s *Server) ulidIDs(c echo.Context) error {
+	count, err := idCount(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	ids, err := s.sequences.ulids(count)
+	if err != nil {
+		return errorResponse(c, sequenceStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, ULIDs: ids})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSequenceTable_Snowflakes(t *testing.T) {
+	tbl := newSequenceTable()
+	tbl.snowflake.worker = 7
+	// More ids than fit in a millisecond
+	ids, err := tbl.snowflakes(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The clock going back
+	tbl.snowflake.last += 1000
+	more, _ := tbl.snowflakes(5000)
+	ids = append(ids, more...)
+	for i, id := range ids {
+		if i > 0 && id <= ids[i-1] {
+			t.Fatal("Must be increasing", i, ids[i-1], id)
+		}
+		if worker := id >> snowflakeStepBits & maxWorkerID; worker != 7 {
+			t.Fatal("Must keep the worker id", id, worker)
+		}
+	}
+	if _, err := tbl.snowflakes(0); err != errBadCount {
+		t.Error("Must return errBadCount", err)
+	}
+}
+
+func TestSequenceTable_SnowflakesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	tbl := newSequenceTable()
+	if err := tbl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := tbl.snowflakes(5000)
+	ceiling := tbl.snowflake.ceiling
+	if ceiling <= ids[len(ids)-1]>>(snowflakeWorkerBits+snowflakeStepBits) {
+		t.Fatal("Must reserve time ahead", ceiling)
+	}
+
+	// Restarted within the same millisecond
+	tbl = newSequenceTable()
+	if err := tbl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	next, _ := tbl.snowflakes(5000)
+	if next[0] <= ids[len(ids)-1] || next[0]>>(snowflakeWorkerBits+snowflakeStepBits) < ceiling {
+		t.Error("Must continue past the reserved time", ceiling, next[0])
+	}
+	for i := 1; i < len(next); i++ {
+		if next[i] <= next[i-1] {
+			t.Fatal("Must be increasing", i)
+		}
+	}
+}
+
+func TestSequenceTable_ULIDs(t *testing.T) {
+	tbl := newSequenceTable()
+	ids, err := tbl.ulids(5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ids of a millisecond are ordered by their incremented random bits
+	now := time.Now().UnixMilli()
+	tbl.ulid.last = now + 1000
+	same, _ := tbl.ulids(100)
+	for _, id := range same {
+		if id[:10] != same[0][:10] {
+			t.Fatal("Must keep the millisecond", same[0], id)
+		}
+	}
+	ids = append(ids, same...)
+	// Exhausted random bits move on to the next millisecond
+	for i := range tbl.ulid.entropy {
+		tbl.ulid.entropy[i] = 0xff
+	}
+	next, _ := tbl.ulids(1)
+	if next[0][:10] == same[0][:10] {
+		t.Error("Must move to the next millisecond", same[0], next[0])
+	}
+	ids = append(ids, next...)
+
+	if !sort.StringsAreSorted(ids) {
+		t.Error("Must be ordered")
+	}
+	for i, id := range ids {
+		if len(id) != 26 || i > 0 && id == ids[i-1] {
+			t.Fatal("Must be distinct ULIDs", id)
+		}
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	if id := encodeULID(1, [10]byte{}); id != "00000000010000000000000000" {
+		t.Error("Must be equal `00000000010000000000000000`", id)
+	}
+	if id := encodeULID(1<<48-1, [10]byte{0: 0xff, 9: 1}); id != "7ZZZZZZZZZZZW00000000000001" {
+		t.Log(id)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// CreateIndex declares a secondary index, building it from the stored data.
+// It is used to restore the configured indexes on startup.
+func (s *Server) CreateIndex(spec storage.IndexSpec) error {
+	for _, db := range s.databases {
+		ix, ok := db.(storage.IndexEngine)
+		if !ok {
+			return errNotSupported
+		}
+		if err := ix.CreateIndex(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexStatus(err error) int {
+	switch err {
+	case storage.ErrIndexNotFound:
+		return http.StatusNotFound
+	case storage.ErrIndexExists:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// GET /indexes
+func (s *Server) getIndexes(c echo.Context) error {
+	ix, ok := s.engine(c).(storage.IndexEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	indexes := []*IndexDefinition{}
+	for _, spec := range ix.Indexes() {
+		indexes = append(indexes, &IndexDefinition{
+			Name:   spec.Name,
+			Prefix: spec.Prefix,
+			Field:  spec.Field,
+			Type:   spec.Type,
+		})
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Indexes: indexes,
+	})
+}
+
+// POST /indexes/:name
+func (s *Server) createIndex(c echo.Context) error {
+	ix, ok := s.engine(c).(storage.IndexEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.Index == nil {
+		return errorResponse(c, http.StatusBadRequest, errors.New("Index definition is required"))
+	}
+
+	err := ix.CreateIndex(storage.IndexSpec{
+		Name:   c.Param("name"),
+		Prefix: reqBody.Index.Prefix,
+		Field:  reqBody.Index.Field,
+		Type:   reqBody.Index.Type,
+	})
+	if err != nil {
+		return errorResponse(c, indexStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Message: "Done",
+	})
+}
+
+// DELETE /indexes/:name
+func (s *Server) dropIndex(c echo.Context) error {
+	ix, ok := s.engine(c).(storage.IndexEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	if err := ix.DropIndex(c.Param("name")); err != nil {
+		return errorResponse(c, indexStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+	})
+}
+
+// GET /indexes/:name/keys?value=DE
+// GET /indexes/:name/keys?min=18&max=30&limit=100
+func (s *Server) lookupIndex(c echo.Context) error {
+	ix, ok := s.engine(c).(storage.IndexEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	limit, err := queryInt(c, "limit", 0)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+
+	// An empty value is looked up too, it is not an open bound
+	var keys []string
+	if value, ok := c.QueryParams()["value"]; ok {
+		if keys, err = ix.Lookup(c.Param("name"), value[0]); err == nil && limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+	} else {
+		keys, err = ix.LookupRange(c.Param("name"), c.QueryParam("min"), c.QueryParam("max"), limit)
+	}
+	if err != nil {
+		return errorResponse(c, indexStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Keys:    keys,
+	})
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"my-go-db/storage"
+)
+
+func TestServer_LookupIndex(t *testing.T) {
+	st := storage.New()
+	st.SetStringMap("user:1", map[string]string{"country": "DE"}, 0)
+	st.SetStringMap("user:2", map[string]string{"country": ""}, 0)
+	st.SetStringMap("user:3", map[string]string{"country": "DE"}, 0)
+	s := New(":0", st)
+	if err := s.CreateIndex(storage.IndexSpec{Name: "country", Prefix: "user:", Field: "country"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		keys   []string
+	}{
+		{"/indexes/country/keys?value=DE", []string{"user:1", "user:3"}},
+		{"/indexes/country/keys?value=DE&limit=1", []string{"user:1"}},
+		{"/indexes/country/keys?value=", []string{"user:2"}},
+		{"/indexes/country/keys", []string{"user:2", "user:1", "user:3"}},
+		{"/indexes/country/keys?min=A&max=Z", []string{"user:1", "user:3"}},
+	}
+	for _, tt := range tests {
+		status, resp := request(t, s, "GET", tt.target, "")
+		if status != http.StatusOK || !reflect.DeepEqual(resp.Keys, tt.keys) {
+			t.Error("Must be equal", tt.target, tt.keys, resp.Keys, resp.Message)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+var errNoDestination = errors.New("Destination key is required")
+
+// selectDatabase resolves the db query parameter of every request.
+func (s *Server) selectDatabase(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		db, err := queryInt(c, "db", 0)
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, err)
+		}
+		if db < 0 || db >= len(s.databases) {
+			return errorResponse(c, http.StatusBadRequest, fmt.Errorf("Database %d does not exist", db))
+		}
+		c.Set("db", db)
+		return next(c)
+	}
+}
+
+// engine returns the database selected by the request.
+func (s *Server) engine(c echo.Context) storage.Engine {
+	return s.databases[c.Get("db").(int)]
+}
+
+func keyspaceStatus(err error) int {
+	if err == storage.ErrSameDatabase || err == storage.ErrMoveTarget {
+		return http.StatusBadRequest
+	}
+	return limitStatus(err, http.StatusNotFound)
+}
+
+// keyspaceResponse answers with count 1 if the operation was done and 0 if
+// it was skipped because of the destination.
+func keyspaceResponse(c echo.Context, done bool, err error) error {
+	if err != nil {
+		return errorResponse(c, keyspaceStatus(err), err)
+	}
+	resp := &ResponseBody{Success: true}
+	if done {
+		resp.Count = 1
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// POST /storage/:key/rename {"destination": "new", "nx": true}
+func (s *Server) renameKey(c echo.Context) error {
+	keyspace, ok := s.engine(c).(storage.KeyspaceEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.Destination == "" {
+		return errorResponse(c, http.StatusBadRequest, errNoDestination)
+	}
+	done, err := keyspace.Rename(c.Param("key"), reqBody.Destination, reqBody.NX)
+	return keyspaceResponse(c, done, err)
+}
+
+// POST /storage/:key/copy {"destination": "new", "replace": true}
+func (s *Server) copyKey(c echo.Context) error {
+	keyspace, ok := s.engine(c).(storage.KeyspaceEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.Destination == "" {
+		return errorResponse(c, http.StatusBadRequest, errNoDestination)
+	}
+	done, err := keyspace.Copy(c.Param("key"), reqBody.Destination, reqBody.Replace)
+	return keyspaceResponse(c, done, err)
+}
+
+// POST /storage/:key/move?db=0 {"db": 1}
+func (s *Server) moveKey(c echo.Context) error {
+	keyspace, ok := s.engine(c).(storage.KeyspaceEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.DB < 0 || reqBody.DB >= len(s.databases) {
+		return errorResponse(c, http.StatusBadRequest, fmt.Errorf("Database %d does not exist", reqBody.DB))
+	}
+	done, err := keyspace.Move(c.Param("key"), s.databases[reqBody.DB])
+	return keyspaceResponse(c, done, err)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+var (
+	ErrBodyTooLarge     = errors.New("Request body is too large")
+	errNegativeBodySize = errors.New("Maximum body size must not be negative")
+)
+
+// Error codes sent in the code field of responses, see errorCode.
+const (
+	codeKeyTooLong      = "key_too_long"
+	codeValueTooLarge   = "value_too_large"
+	codeTooManyElements = "too_many_elements"
+	codeBodyTooLarge    = "body_too_large"
+	codeBackupBase      = "backup_base"
+	codeNoScript        = "no_script"
+	codeScriptTimeout   = "script_timeout"
+	codeLockHeld        = "lock_held"
+	codeLockNotHeld     = "lock_not_held"
+	codeSequenceExists  = "sequence_exists"
+)
+
+var codeErrors = map[string]error{
+	codeKeyTooLong:      storage.ErrKeyTooLong,
+	codeValueTooLarge:   storage.ErrValueTooLarge,
+	codeTooManyElements: storage.ErrTooManyElements,
+	codeBodyTooLarge:    ErrBodyTooLarge,
+	codeBackupBase:      ErrBackupBase,
+	codeNoScript:        ErrNoScript,
+	codeScriptTimeout:   storage.ErrScriptTimeout,
+	codeLockHeld:        ErrLockHeld,
+	codeLockNotHeld:     ErrLockNotHeld,
+	codeSequenceExists:  ErrSequenceExists,
+}
+
+// Limits are the storage limits checked by the server and the databases,
+// plus the size of request bodies in bytes. Zero fields are unlimited.
+type Limits struct {
+	storage.Limits
+	MaxBodySize int64
+}
+
+// SetLimits makes the server reject writes exceeding l, and passes the
+// storage limits to the databases supporting them. It must be called before
+// Start.
+func (s *Server) SetLimits(l Limits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	if l.MaxBodySize < 0 {
+		return errNegativeBodySize
+	}
+	for _, db := range s.databases {
+		if limited, ok := db.(storage.LimitEngine); ok {
+			if err := limited.SetLimits(l.Limits); err != nil {
+				return err
+			}
+		}
+	}
+	s.limits = l
+	return nil
+}
+
+// limitBody rejects request bodies larger than the maximum body size.
+func (s *Server) limitBody(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		max := s.limits.MaxBodySize
+		req := c.Request()
+		if max == 0 || req.Body == nil {
+			return next(c)
+		}
+		if req.ContentLength > max {
+			return errorResponse(c, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, err)
+		}
+		if int64(len(body)) > max {
+			return errorResponse(c, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return next(c)
+	}
+}
+
+// errorCode returns the code of limit errors, or "".
+func errorCode(err error) string {
+	for code, target := range codeErrors {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return ""
+}
+
+// limitStatus returns the status of limit errors: 400 for keys too long and
+// 413 for values too large. Other errors get status.
+func limitStatus(err error, status int) int {
+	switch {
+	case errors.Is(err, storage.ErrKeyTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrValueTooLarge), errors.Is(err, storage.ErrTooManyElements):
+		return http.StatusRequestEntityTooLarge
+	}
+	return status
+}
+
+// Error is returned by Client when the server answers with success=false.
+// It matches the storage limit errors, ErrBodyTooLarge and ErrBackupBase
+// with errors.Is:
+//
+//	if errors.Is(err, storage.ErrValueTooLarge) { ... }
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Code != "" && codeErrors[e.Code] == target
+}
+
+func responseError(status int, respBody *ResponseBody) error {
+	return &Error{Status: status, Code: respBody.Code, Message: respBody.Message}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// maxLockLease bounds leases, so a crashed owner cannot keep a lock
+// forever.
+const maxLockLease = 24 * time.Hour
+
+var (
+	ErrLockHeld    = errors.New("Lock is held by another owner")
+	ErrLockNotHeld = errors.New("Lock is not held by this owner, it was released or its lease expired")
+	errNoOwner     = errors.New("Field owner is required")
+	errBadLease    = errors.New("Field lease must be a positive number of milliseconds, at most a day")
+)
+
+// lockTable holds the locks of the server, shared by all databases. A lock
+// is held by an owner until it releases it or its lease expires.
+//
+// Every acquisition gets a fencing token greater than the tokens of the
+// acquisitions before it, so a resource can reject writes from an owner
+// whose lease expired without it noticing. Tokens come from the sequence
+// table, which reserves them in its state file, so they keep increasing
+// across restarts.
+type lockTable struct {
+	mu     sync.Mutex
+	locks  map[string]*heldLock
+	tokens func() (int64, error)
+}
+
+type heldLock struct {
+	owner   string
+	token   int64
+	expires time.Time
+}
+
+func newLockTable(tokens func() (int64, error)) *lockTable {
+	return &lockTable{
+		locks:  make(map[string]*heldLock),
+		tokens: tokens,
+	}
+}
+
+// liveLocked returns the lock of name unless it is free. t.mu must be held.
+func (t *lockTable) liveLocked(name string, now time.Time) *heldLock {
+	l := t.locks[name]
+	if l != nil && !now.Before(l.expires) {
+		delete(t.locks, name)
+		return nil
+	}
+	return l
+}
+
+// acquire takes the lock of name for owner. An owner acquiring a lock it
+// holds renews it and keeps its token.
+func (t *lockTable) acquire(name, owner string, lease time.Duration) (*heldLock, error) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := t.liveLocked(name, now)
+	if l == nil {
+		token, err := t.tokens()
+		if err != nil {
+			return nil, err
+		}
+		l = &heldLock{owner: owner, token: token}
+		t.locks[name] = l
+	} else if l.owner != owner {
+		return nil, ErrLockHeld
+	}
+	l.expires = now.Add(lease)
+	c := *l
+	return &c, nil
+}
+
+// renew extends the lease of a lock held by owner.
+func (t *lockTable) renew(name, owner string, lease time.Duration) (*heldLock, error) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := t.liveLocked(name, now)
+	if l == nil || l.owner != owner {
+		return nil, ErrLockNotHeld
+	}
+	l.expires = now.Add(lease)
+	c := *l
+	return &c, nil
+}
+
+func (t *lockTable) release(name, owner string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := t.liveLocked(name, time.Now())
+	if l == nil || l.owner != owner {
+		return ErrLockNotHeld
+	}
+	delete(t.locks, name)
+	return nil
+}
+
+func (t *lockTable) get(name string) *heldLock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := t.liveLocked(name, time.Now())
+	if l == nil {
+		return nil
+	}
+	c := *l
+	return &c
+}
+
+func (t *lockTable) deleteExpired() {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name := range t.locks {
+		t.liveLocked(name, now)
+	}
+}
+
+func lockInfo(name string, l *heldLock) *LockInfo {
+	lease := time.Until(l.expires) / time.Millisecond
+	if lease < 0 {
+		lease = 0
+	}
+	return &LockInfo{Name: name, Owner: l.owner, Token: l.token, Lease: int64(lease)}
+}
+
+func checkLock(owner string, lease time.Duration) error {
+	if owner == "" {
+		return errNoOwner
+	}
+	if lease < time.Millisecond || lease > maxLockLease {
+		return errBadLease
+	}
+	return nil
+}
+
+// AcquireLock takes the lock name for owner for the lease and returns it
+// with its fencing token. It fails with ErrLockHeld if another owner holds
+// the lock. An owner acquiring a lock it holds renews it.
+func (s *Server) AcquireLock(name, owner string, lease time.Duration) (*LockInfo, error) {
+	if err := checkLock(owner, lease); err != nil {
+		return nil, err
+	}
+	l, err := s.locks.acquire(name, owner, lease)
+	if err != nil {
+		return nil, err
+	}
+	return lockInfo(name, l), nil
+}
+
+// RenewLock extends the lease of the lock name held by owner. It fails with
+// ErrLockNotHeld if owner lost the lock.
+func (s *Server) RenewLock(name, owner string, lease time.Duration) (*LockInfo, error) {
+	if err := checkLock(owner, lease); err != nil {
+		return nil, err
+	}
+	l, err := s.locks.renew(name, owner, lease)
+	if err != nil {
+		return nil, err
+	}
+	return lockInfo(name, l), nil
+}
+
+// ReleaseLock releases the lock name held by owner. It fails with
+// ErrLockNotHeld if owner does not hold it.
+func (s *Server) ReleaseLock(name, owner string) error {
+	if owner == "" {
+		return errNoOwner
+	}
+	return s.locks.release(name, owner)
+}
+
+// leaseDuration converts a lease in milliseconds, without overflowing.
+func leaseDuration(ms int64) time.Duration {
+	if ms > int64(maxLockLease/time.Millisecond) {
+		return maxLockLease + 1
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// lockStatus returns 409 for lock conflicts, 400 for bad requests and 500
+// when no fencing token could be reserved.
+func lockStatus(err error) int {
+	if err == ErrLockHeld || err == ErrLockNotHeld {
+		return http.StatusConflict
+	}
+	if err == errNoOwner || err == errBadLease {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// POST /locks/:name
+func (s *Server) acquireLock(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	info, err := s.AcquireLock(name, reqBody.Owner, leaseDuration(reqBody.Lease))
+	if err != nil {
+		return errorResponse(c, lockStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Lock: info})
+}
+
+// POST /locks/:name/renew
+func (s *Server) renewLock(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	info, err := s.RenewLock(name, reqBody.Owner, leaseDuration(reqBody.Lease))
+	if err != nil {
+		return errorResponse(c, lockStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Lock: info})
+}
+
+// DELETE /locks/:name?owner=a
+func (s *Server) releaseLock(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if err := s.ReleaseLock(name, c.QueryParam("owner")); err != nil {
+		return errorResponse(c, lockStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true})
+}
+
+// GET /locks/:name
+func (s *Server) getLock(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	l := s.locks.get(name)
+	if l == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Lock: lockInfo(name, l)})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my-go-db/storage/enginetest"
+)
+
+func TestServer_Locks(t *testing.T) {
+	s := New(":0", enginetest.NewFake())
+
+	status, resp := request(t, s, "POST", "/locks/a", `{"owner": "one", "lease": 100}`)
+	if status != http.StatusOK || resp.Lock == nil || resp.Lock.Owner != "one" {
+		t.Fatal("Must acquire the lock", status, resp.Message)
+	}
+	first := resp.Lock.Token
+
+	// Only the owner renews or releases the lock
+	if status, resp = request(t, s, "POST", "/locks/a", `{"owner": "two", "lease": 100}`); status != http.StatusConflict || resp.Message != ErrLockHeld.Error() {
+		t.Error("Must refuse another owner", status, resp.Message)
+	}
+	if status, resp = request(t, s, "POST", "/locks/a/renew", `{"owner": "two", "lease": 100}`); status != http.StatusConflict || resp.Message != ErrLockNotHeld.Error() {
+		t.Error("Must not renew for another owner", status, resp.Message)
+	}
+	if status, _ = request(t, s, "DELETE", "/locks/a?owner=two", ""); status != http.StatusConflict {
+		t.Error("Must not release for another owner", status)
+	}
+	if status, resp = request(t, s, "POST", "/locks/a/renew", `{"owner": "one", "lease": 100}`); status != http.StatusOK || resp.Lock.Token != first {
+		t.Error("Must renew and keep the token", status, resp.Lock)
+	}
+	if status, resp = request(t, s, "POST", "/locks/a", `{"owner": "", "lease": 100}`); status != http.StatusBadRequest {
+		t.Error("Must require an owner", status, resp.Message)
+	}
+	if status, resp = request(t, s, "POST", "/locks/a", `{"owner": "one", "lease": 0}`); status != http.StatusBadRequest {
+		t.Error("Must require a lease", status, resp.Message)
+	}
+
+	// The lease expires without renewals
+	time.Sleep(150 * time.Millisecond)
+	if status, _ = request(t, s, "GET", "/locks/a", ""); status != http.StatusNotFound {
+		t.Error("Must expire the lock", status)
+	}
+	if status, _ = request(t, s, "POST", "/locks/a/renew", `{"owner": "one", "lease": 100}`); status != http.StatusConflict {
+		t.Error("Must not renew an expired lock", status)
+	}
+	status, resp = request(t, s, "POST", "/locks/a", `{"owner": "two", "lease": 1000}`)
+	if status != http.StatusOK || resp.Lock.Token <= first {
+		t.Fatal("Must acquire the lock with a greater token", status, resp.Lock)
+	}
+	second := resp.Lock.Token
+	if status, _ = request(t, s, "DELETE", "/locks/a?owner=one", ""); status != http.StatusConflict {
+		t.Error("Must not release for the previous owner", status)
+	}
+	if status, _ = request(t, s, "DELETE", "/locks/a?owner=two", ""); status != http.StatusOK {
+		t.Error("Must release the lock", status)
+	}
+
+	tokens := []int64{second}
+	for _, name := range []string{"a", "b", "a"} {
+		_, resp = request(t, s, "POST", "/locks/"+name, `{"owner": "three", "lease": 1000}`)
+		if resp.Lock == nil || resp.Lock.Token <= tokens[len(tokens)-1] {
+			t.Fatal("Must hand out increasing tokens", resp.Lock, tokens)
+		}
+		tokens = append(tokens, resp.Lock.Token)
+		request(t, s, "DELETE", "/locks/"+name+"?owner=three", "")
+	}
+}
+
+func TestServer_LockTokensRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	s := New(":0", enginetest.NewFake())
+	if err := s.SetSequenceFile(path); err != nil {
+		t.Fatal(err)
+	}
+	// Tokens past the clock of the next start, as after the clock went back
+	s.sequences.tokens.next = 1 << 62
+	s.sequences.tokens.ceiling = 1 << 62
+	info, err := s.AcquireLock("a", "one", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = New(":0", enginetest.NewFake())
+	if err := s.SetSequenceFile(path); err != nil {
+		t.Fatal(err)
+	}
+	next, err := s.AcquireLock("a", "two", time.Second)
+	if err != nil || next.Token <= info.Token {
+		t.Error("Must continue past the tokens reserved before the restart", info.Token, next, err)
+	}
+}
+
+// lockClient returns a client of s behind an http server. Renewals hang
+// while hang is set, until the client gives up on them.
+func lockClient(t *testing.T, s *Server, hang *atomic.Bool) *Client {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() && strings.HasSuffix(r.URL.Path, "/renew") {
+			// Until the body is read the server does not notice the
+			// client giving up
+			io.ReadAll(r.Body)
+			<-r.Context().Done()
+			return
+		}
+		s.echo.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	return NewClient(u.Hostname(), u.Port())
+}
+
+func TestClient_Lock(t *testing.T) {
+	s := New(":0", enginetest.NewFake())
+	hang := new(atomic.Bool)
+	c := lockClient(t, s, hang)
+
+	name := "jobs/a b?%"
+	l, err := c.Lock(name, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Lock(name, time.Second); !errors.Is(err, ErrLockHeld) {
+		t.Error("Must refuse a held lock", err)
+	}
+	if held := s.locks.get(name); held == nil || held.owner != l.Owner() || held.token != l.Token() {
+		t.Fatal("Must hold the lock under its exact name", held)
+	}
+
+	// Renewals keep the lock past its lease
+	select {
+	case <-l.Lost():
+		t.Fatal("Must renew the lock")
+	case <-time.After(time.Second):
+	}
+	if err := l.Unlock(); err != nil {
+		t.Error("Must release the lock", err)
+	}
+	if s.locks.get(name) != nil {
+		t.Error("Must free the lock")
+	}
+
+	// The server dropping the lock is noticed at the next renewal
+	l, _ = c.Lock(name, 300*time.Millisecond)
+	s.locks.release(name, l.Owner())
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Must report the lock lost")
+	}
+	if err := l.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Error("Must return ErrLockNotHeld", err)
+	}
+
+	// Renewals that do not answer report the loss while the server still
+	// holds the lock for a third of the lease
+	l, _ = c.Lock(name, 600*time.Millisecond)
+	hang.Store(true)
+	start := time.Now()
+	select {
+	case <-l.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Must report the lock lost")
+	}
+	if s.locks.get(name) == nil {
+		t.Error("Must report the loss before the lease expires", time.Since(start))
+	}
+	hang.Store(false)
+	if err := l.Unlock(); err != ErrLockNotHeld || s.locks.get(name) != nil {
+		t.Error("Must return ErrLockNotHeld and release the lock", err)
+	}
+}
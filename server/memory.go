@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+const defaultMemoryTop = 10
+
+func memoryUsage(m storage.KeyMemory) *MemoryUsage {
+	return &MemoryUsage{Key: m.Key, Type: m.Type, Bytes: m.Size}
+}
+
+// GET /storage/:key/memory
+func (s *Server) keyMemory(c echo.Context) error {
+	engine, ok := s.engine(c).(storage.MemoryEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	usage, err := engine.MemoryUsage(c.Param("key"))
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	if usage == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Memory:  []*MemoryUsage{memoryUsage(*usage)},
+	})
+}
+
+// GET /admin/memory/top?count=10
+func (s *Server) memoryTop(c echo.Context) error {
+	engine, ok := s.engine(c).(storage.MemoryEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	count, err := queryInt(c, "count", defaultMemoryTop)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	top, err := engine.MemoryTop(count)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	resp := &ResponseBody{Success: true, Memory: []*MemoryUsage{}}
+	for _, m := range top {
+		resp.Memory = append(resp.Memory, memoryUsage(m))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GET /admin/memory
+func (s *Server) memoryStats(c echo.Context) error {
+	engine, ok := s.engine(c).(storage.MemoryEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	stats, err := engine.MemoryStats()
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	report := &MemoryReport{
+		Bytes: stats.Total,
+		Keys:  stats.Keys,
+		Types: make(map[string]*TypeMemory, len(stats.Types)),
+	}
+	for name, t := range stats.Types {
+		report.Types[name] = &TypeMemory{Keys: t.Keys, Bytes: t.Bytes}
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success:      true,
+		MemoryReport: report,
+	})
+}
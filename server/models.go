@@ -1,5 +1,13 @@
 package server
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"my-go-db/storage"
+)
+
 type RequestBody struct {
 	String        string            `json:"string,omitempty"`
 	Int           int               `json:"int,omitempty"`
@@ -7,13 +15,51 @@ type RequestBody struct {
 	IntList       []int             `json:"int_list,omitempty"`
 	StringDict    map[string]string `json:"string_dict,omitempty"`
 	IntDict       map[string]int    `json:"int_dict,omitempty"`
+	Bitmap        []byte            `json:"bitmap,omitempty"`
 
 	TTL           int               `json:"ttl,omitempty"`
+
+	Bit           int               `json:"bit,omitempty"`
+	Op            string            `json:"op,omitempty"`
+	Keys          []string          `json:"keys,omitempty"`
+	Locations     []*GeoLocation    `json:"locations,omitempty"`
+	Index         *IndexDefinition  `json:"index,omitempty"`
+
+	Destination   string            `json:"destination,omitempty"`
+	NX            bool              `json:"nx,omitempty"`
+	Replace       bool              `json:"replace,omitempty"`
+	DB            int               `json:"db,omitempty"`
+
+	Entries       []*BulkEntry      `json:"entries,omitempty"`
+
+	Script        string            `json:"script,omitempty"`
+	Args          []string          `json:"args,omitempty"`
+
+	Samples       []*TSSample       `json:"samples,omitempty"`
+	Retention     *int64            `json:"retention,omitempty"`
+	Aggregation   string            `json:"aggregation,omitempty"`
+	Bucket        int64             `json:"bucket,omitempty"`
+
+	Owner         string            `json:"owner,omitempty"`
+	Lease         int64             `json:"lease,omitempty"`
+
+	Algorithm     string            `json:"algorithm,omitempty"`
+	Limit         int               `json:"limit,omitempty"`
+	Period        int64             `json:"period,omitempty"`
+	Cost          int               `json:"cost,omitempty"`
+
+	Type          string            `json:"type,omitempty"`
+	State         []byte            `json:"state,omitempty"`
+
+	Start         *int64            `json:"start,omitempty"`
+	Step          *int64            `json:"step,omitempty"`
+	Count         int               `json:"count,omitempty"`
 }
 
 type ResponseBody struct {
 	Success       bool              `json:"success"`
 	Message       string            `json:"message,omitempty"`
+	Code          string            `json:"code,omitempty"`
 
 	String        string            `json:"string,omitempty"`
 	Int           int               `json:"int,omitempty"`
@@ -21,6 +67,291 @@ type ResponseBody struct {
 	IntList       []int             `json:"int_list,omitempty"`
 	StringDict    map[string]string `json:"string_dict,omitempty"`
 	IntDict       map[string]int    `json:"int_dict,omitempty"`
+	Bitmap        []byte            `json:"bitmap,omitempty"`
 
 	Keys          []string          `json:"keys,omitempty"`
+	Cursor        string            `json:"cursor,omitempty"`
+
+	Bit           int               `json:"bit,omitempty"`
+	Count         int               `json:"count,omitempty"`
+	Position      int               `json:"position,omitempty"`
+
+	Locations     []*GeoLocation    `json:"locations,omitempty"`
+	Distance      float64           `json:"distance,omitempty"`
+
+	Indexes       []*IndexDefinition `json:"indexes,omitempty"`
+
+	Snapshot      int64             `json:"snapshot,omitempty"`
+	Revision      int64             `json:"revision,omitempty"`
+
+	Compression   *CompressionInfo  `json:"compression,omitempty"`
+
+	Memory        []*MemoryUsage    `json:"memory,omitempty"`
+	MemoryReport  *MemoryReport     `json:"memory_report,omitempty"`
+
+	Entries       []*BulkEntry      `json:"entries,omitempty"`
+
+	Object        *ObjectInfo       `json:"object,omitempty"`
+	Idle          []*IdleKey        `json:"idle,omitempty"`
+
+	SHA           string            `json:"sha,omitempty"`
+	Result        json.RawMessage   `json:"result,omitempty"`
+
+	Samples       []*TSSample       `json:"samples,omitempty"`
+	TimeSeries    *TSInfo           `json:"timeseries,omitempty"`
+
+	Lock          *LockInfo         `json:"lock,omitempty"`
+
+	CRDT          *CRDTInfo         `json:"crdt,omitempty"`
+
+	Sequence      *SequenceInfo     `json:"sequence,omitempty"`
+	Block         *IDBlock          `json:"block,omitempty"`
+	IDs           []int64           `json:"ids,omitempty"`
+	ULIDs         []string          `json:"ulids,omitempty"`
+
+	RateLimit     *RateLimitResult  `json:"rate_limit,omitempty"`
+}
+
+// ObjectInfo is the metadata of a key. Hits counts reads and writes since
+// the key was created.
+type ObjectInfo struct {
+	Type          string            `json:"type"`
+	Encoding      string            `json:"encoding"`
+	Bytes         int               `json:"bytes"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	AccessedAt    time.Time         `json:"accessed_at"`
+	IdleSeconds   int64             `json:"idle_seconds"`
+	Hits          int64             `json:"hits"`
+	TTL           int               `json:"ttl"`
+}
+
+type IdleKey struct {
+	Key           string            `json:"key"`
+	IdleSeconds   int64             `json:"idle_seconds"`
+}
+
+// BulkEntry is a key with its value in /storage/_bulk requests and
+// responses. Found tells whether a key was read, stored or deleted, Error
+// why it was not.
+type BulkEntry struct {
+	Key           string            `json:"key"`
+	String        string            `json:"string,omitempty"`
+	Int           int               `json:"int,omitempty"`
+	StringList    []string          `json:"string_list,omitempty"`
+	IntList       []int             `json:"int_list,omitempty"`
+	StringDict    map[string]string `json:"string_dict,omitempty"`
+	IntDict       map[string]int    `json:"int_dict,omitempty"`
+	Bitmap        []byte            `json:"bitmap,omitempty"`
+	Locations     []*GeoLocation    `json:"locations,omitempty"`
+	Samples       []*TSSample       `json:"samples,omitempty"`
+	CRDT          *CRDTInfo         `json:"crdt,omitempty"`
+	TTL           int               `json:"ttl,omitempty"`
+
+	Found         bool              `json:"found"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// Value returns the value of the entry like Client.GetValue, or nil.
+func (e *BulkEntry) Value() interface{} {
+	switch {
+	case e.String != "":
+		return e.String
+	case e.Int > 0:
+		return e.Int
+	case e.StringList != nil:
+		return e.StringList
+	case e.IntList != nil:
+		return e.IntList
+	case e.StringDict != nil:
+		return e.StringDict
+	case e.IntDict != nil:
+		return e.IntDict
+	case e.Bitmap != nil:
+		return e.Bitmap
+	case e.Locations != nil:
+		return e.Locations
+	case e.Samples != nil:
+		return e.Samples
+	case e.CRDT != nil:
+		return e.CRDT
+	}
+	return nil
+}
+
+// MemoryUsage is the estimated number of bytes used by a key and its value.
+type MemoryUsage struct {
+	Key           string            `json:"key"`
+	Type          string            `json:"type"`
+	Bytes         int               `json:"bytes"`
+}
+
+// MemoryReport is the estimated memory used by the keyspace, in total and
+// per value type.
+type MemoryReport struct {
+	Bytes         int64             `json:"bytes"`
+	Keys          int               `json:"keys"`
+	Types         map[string]*TypeMemory `json:"types"`
+}
+
+type TypeMemory struct {
+	Keys          int               `json:"keys"`
+	Bytes         int64             `json:"bytes"`
+}
+
+// CompressionInfo tells how a value is stored: RawSize is the size of its
+// encoding and Size the number of bytes actually kept.
+type CompressionInfo struct {
+	Algorithm     string            `json:"algorithm"`
+	RawSize       int               `json:"raw_size"`
+	Size          int               `json:"size"`
+}
+
+// IndexDefinition declares a secondary index over Field of the string_dict
+// (type "string") or int_dict (type "int") values under keys with Prefix.
+type IndexDefinition struct {
+	Name          string            `json:"name"`
+	Prefix        string            `json:"prefix"`
+	Field         string            `json:"field"`
+	Type          string            `json:"type,omitempty"`
+}
+
+type GeoLocation struct {
+	Name          string            `json:"name"`
+	Longitude     float64           `json:"longitude"`
+	Latitude      float64           `json:"latitude"`
+	Distance      float64           `json:"distance,omitempty"`
+}
+
+func (l *GeoLocation) String() string {
+	return fmt.Sprintf("%s(%v %v)", l.Name, l.Longitude, l.Latitude)
+}
+
+// TSSample is a sample of a time series, Timestamp in milliseconds since the
+// epoch.
+type TSSample struct {
+	Timestamp     int64             `json:"timestamp"`
+	Value         float64           `json:"value"`
+}
+
+func (s *TSSample) String() string {
+	return fmt.Sprintf("(%d %v)", s.Timestamp, s.Value)
+}
+
+// TSRule downsamples a time series into Destination with one sample per
+// Bucket milliseconds aggregated by avg, min, max, sum or count.
+type TSRule struct {
+	Destination   string            `json:"destination"`
+	Aggregation   string            `json:"aggregation"`
+	Bucket        int64             `json:"bucket"`
+}
+
+// TSInfo describes a time series. Retention is in milliseconds, 0 keeps
+// samples forever; First and Last are the timestamps of its oldest and
+// newest samples.
+type TSInfo struct {
+	Retention     int64             `json:"retention"`
+	Samples       int               `json:"samples"`
+	First         int64             `json:"first"`
+	Last          int64             `json:"last"`
+	Rules         []*TSRule         `json:"rules"`
+}
+
+// TSRangeQuery is the query of GET /storage/:key/ts. From and To are
+// inclusive timestamps, To 0 meaning no upper bound. With an Aggregation
+// the samples are aggregated over buckets of Bucket milliseconds.
+type TSRangeQuery struct {
+	From          int64
+	To            int64
+	Aggregation   string
+	Bucket        int64
+}
+
+// LockInfo is a held lock. Token is the fencing token of the acquisition and
+// Lease the milliseconds left before the lock expires.
+type LockInfo struct {
+	Name          string            `json:"name"`
+	Owner         string            `json:"owner"`
+	Token         int64             `json:"token"`
+	Lease         int64             `json:"lease"`
+}
+
+// RateLimiter allows Limit requests per Period, with the token_bucket or
+// sliding_window algorithm.
+type RateLimiter struct {
+	Algorithm     string
+	Limit         int
+	Period        time.Duration
+}
+
+// RateLimitResult tells whether a request was allowed, how many more are
+// allowed right now and, for denied ones, the milliseconds until it would
+// be.
+type RateLimitResult struct {
+	Allowed       bool              `json:"allowed"`
+	Remaining     int               `json:"remaining"`
+	RetryAfter    int64             `json:"retry_after"`
+}
+
+// ScanQuery is the query of GET /storage. Start and End are inclusive bounds,
+// Cursor is the cursor returned with the previous page. A non-zero Snapshot
+// scans an open snapshot.
+type ScanQuery struct {
+	Prefix        string
+	Start         string
+	End           string
+	Reverse       bool
+	Limit         int
+	Cursor        string
+	Snapshot      int64
+}
+
+// GeoSearchQuery is the query of GET /storage/:key/geo/search. The center is
+// Member when set, otherwise Longitude/Latitude; a positive Radius searches a
+// circle, otherwise Width x Height.
+type GeoSearchQuery struct {
+	Member        string
+	Longitude     float64
+	Latitude      float64
+	Radius        float64
+	Width         float64
+	Height        float64
+	Unit          string
+	Count         int
+	Desc          bool
+}
+
+// CRDTInfo is a CRDT of type gcounter or pncounter, with its value in
+// Counter, orset, with its value in Members, or lwwregister, with its value
+// in Register. State is its state, for merging into another replica.
+type CRDTInfo struct {
+	Type          string            `json:"type"`
+	Counter       int               `json:"counter"`
+	Members       []string          `json:"members,omitempty"`
+	Register      string            `json:"register,omitempty"`
+	State         []byte            `json:"state,omitempty"`
+}
+
+func (i *CRDTInfo) String() string {
+	switch i.Type {
+	case storage.CRDTORSet:
+		return fmt.Sprintf("%s %v", i.Type, i.Members)
+	case storage.CRDTLWWRegister:
+		return fmt.Sprintf("%s %s", i.Type, i.Register)
+	}
+	return fmt.Sprintf("%s %d", i.Type, i.Counter)
+}
+
+// SequenceInfo is a sequence handing out Next, Next+Step, ...
+type SequenceInfo struct {
+	Name          string            `json:"name"`
+	Next          int64             `json:"next"`
+	Step          int64             `json:"step"`
+}
+
+// IDBlock is a block of Count ids of a sequence: First, First+Step, ...
+type IDBlock struct {
+	First         int64             `json:"first"`
+	Step          int64             `json:"step"`
+	Count         int               `json:"count"`
 }
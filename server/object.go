@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// evictInterval is how often keys idle for longer than the maximum set with
+// SetMaxIdle are evicted.
+const evictInterval = time.Second
+
+var (
+	errBadIdle      = errors.New("Parameter idle must be a positive number of seconds")
+	errBadIdleCount = errors.New("Parameter count must be positive")
+)
+
+// SetMaxIdle makes the server evict keys not accessed for maxIdle from the
+// databases supporting it. 0 disables eviction. It must be called before
+// Start.
+func (s *Server) SetMaxIdle(maxIdle time.Duration) {
+	s.maxIdle = maxIdle
+}
+
+func (s *Server) evictIdle() {
+	for range time.Tick(evictInterval) {
+		for _, db := range s.databases {
+			if objects, ok := db.(storage.ObjectEngine); ok {
+				if _, err := objects.EvictIdle(s.maxIdle); err != nil {
+					s.echo.Logger.Error(err)
+				}
+			}
+		}
+	}
+}
+
+// GET /storage/:key/object
+func (s *Server) getObject(c echo.Context) error {
+	objects, ok := s.engine(c).(storage.ObjectEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	key := c.Param("key")
+	info, err := objects.Object(key)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	if info == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	ttl, err := s.engine(c).TTL(key)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Object: &ObjectInfo{
+			Type:        info.Type,
+			Encoding:    info.Encoding,
+			Bytes:       info.Size,
+			CreatedAt:   info.Created,
+			UpdatedAt:   info.Updated,
+			AccessedAt:  info.Accessed,
+			IdleSeconds: int64(time.Since(info.Accessed) / time.Second),
+			Hits:        info.Hits,
+			TTL:         ttl,
+		},
+	})
+}
+
+// GET /admin/idle?count=10
+func (s *Server) idleKeys(c echo.Context) error {
+	objects, ok := s.engine(c).(storage.ObjectEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	count, err := queryInt(c, "count", 10)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if count < 1 {
+		return errorResponse(c, http.StatusBadRequest, errBadIdleCount)
+	}
+	keys, err := objects.IdleKeys(count)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	resp := &ResponseBody{Success: true, Idle: []*IdleKey{}}
+	for _, k := range keys {
+		resp.Idle = append(resp.Idle, &IdleKey{Key: k.Key, IdleSeconds: int64(k.Idle / time.Second)})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// POST /admin/evict?idle=3600
+func (s *Server) evictKeys(c echo.Context) error {
+	objects, ok := s.engine(c).(storage.ObjectEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	idle, err := queryInt(c, "idle", 0)
+	if err != nil || idle <= 0 {
+		return errorResponse(c, http.StatusBadRequest, errBadIdle)
+	}
+	evicted, err := objects.EvictIdle(time.Duration(idle) * time.Second)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Count:   evicted,
+	})
+}
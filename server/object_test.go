@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"my-go-db/storage"
+)
+
+func TestServer_IdleKeysCount(t *testing.T) {
+	s := New(":0", storage.New())
+	request(t, s, "POST", "/storage/a", `{"string": "x"}`)
+	for _, count := range []string{"0", "-1"} {
+		status, resp := request(t, s, "GET", "/admin/idle?count="+count, "")
+		if status != http.StatusBadRequest || resp.Message != errBadIdleCount.Error() {
+			t.Error("Must reject the count", count, status, resp.Message)
+		}
+	}
+	if status, resp := request(t, s, "GET", "/admin/idle?count=1", ""); status != http.StatusOK || len(resp.Idle) != 1 {
+		t.Error("Must return the idle key", status, resp.Idle)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// GET /storage/_random?count=1
+//
+// Returns up to count distinct keys picked at random. Engines without
+// random sampling shuffle all their keys.
+func (s *Server) randomKeys(c echo.Context) error {
+	count, err := queryInt(c, "count", 1)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if count <= 0 {
+		return errorResponse(c, http.StatusBadRequest, storage.ErrRandomCount)
+	}
+	engine := s.engine(c)
+	var keys []string
+	if random, ok := engine.(storage.RandomKeyEngine); ok {
+		keys, err = random.RandomKeys(count)
+	} else if keys, err = engine.Keys(); err == nil {
+		rand.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+		if len(keys) > count {
+			keys = keys[:count]
+		}
+	}
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Keys: keys})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// POST /storage/:key/ratelimit
+//
+// Checks a request against the limiter of key and consumes it if allowed.
+// Denied requests are answered with 200 too, see the allowed field. Cost
+// is 1 unless given, and the period is in milliseconds.
+func (s *Server) rateLimit(c echo.Context) error {
+	limits, ok := s.engine(c).(storage.RateLimitEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.Period > math.MaxInt64/int64(time.Millisecond) {
+		return errorResponse(c, http.StatusBadRequest, storage.ErrRateLimit)
+	}
+	limiter := storage.RateLimiter{
+		Algorithm: reqBody.Algorithm,
+		Limit:     reqBody.Limit,
+		Period:    time.Duration(reqBody.Period) * time.Millisecond,
+	}
+	cost := reqBody.Cost
+	if cost == 0 {
+		cost = 1
+	}
+	res, err := limits.RateLimit(c.Param("key"), limiter, cost)
+	if err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		RateLimit: &RateLimitResult{
+			Allowed:    res.Allowed,
+			Remaining:  res.Remaining,
+			RetryAfter: int64((res.RetryAfter + time.Millisecond - 1) / time.Millisecond),
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+// defaultScriptTimeout is how long a script may run unless SetScriptTimeout
+// says otherwise. The database is locked while a script runs.
+const defaultScriptTimeout = 5 * time.Second
+
+// ErrNoScript is returned for a sha of a script that was not loaded, or was
+// loaded before the server restarted. Load the script and retry.
+var ErrNoScript = errors.New("No script with this sha, load it first")
+
+var errNoScriptSource = errors.New("Field script must not be empty")
+
+// SetScriptTimeout sets how long a script may run before it is aborted and
+// its writes undone. 0 removes the limit. It must be called before Start.
+func (s *Server) SetScriptTimeout(timeout time.Duration) {
+	s.scriptTimeout = timeout
+}
+
+// POST /script
+//
+// Compiles the script and keeps it, so it can be run by its sha.
+func (s *Server) loadScript(c echo.Context) error {
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.Script == "" {
+		return errorResponse(c, http.StatusBadRequest, errNoScriptSource)
+	}
+	sc, err := storage.CompileScript(reqBody.Script)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	s.scriptsMu.Lock()
+	s.scripts[sc.SHA()] = sc
+	s.scriptsMu.Unlock()
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, SHA: sc.SHA()})
+}
+
+// POST /script/:sha
+//
+// Runs a loaded script atomically against the selected database, with the
+// keys and args of the request as KEYS and ARGV.
+func (s *Server) evalScript(c echo.Context) error {
+	engine, ok := s.engine(c).(storage.ScriptEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	s.scriptsMu.RLock()
+	sc := s.scripts[c.Param("sha")]
+	s.scriptsMu.RUnlock()
+	if sc == nil {
+		return errorResponse(c, http.StatusNotFound, ErrNoScript)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	result, err := engine.Eval(sc, reqBody.Keys, reqBody.Args, s.scriptTimeout)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, storage.ErrScriptTimeout) {
+			status = http.StatusServiceUnavailable
+		}
+		return errorResponse(c, limitStatus(err, status), err)
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Result: raw})
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	// sequenceReserve is how many steps of a sequence are reserved in the
+	// state file at a time, so most ids are handed out without writing it.
+	sequenceReserve = 1000
+	// maxSequenceBlock bounds the ids reserved by one request.
+	maxSequenceBlock = 1 << 20
+)
+
+var (
+	ErrSequenceExists    = errors.New("Sequence already exists")
+	ErrSequenceExhausted = errors.New("Sequence would overflow")
+	errBadStep           = errors.New("Field step must be positive")
+	errBadCount          = errors.New("Field count must be between 1 and 1048576")
+)
+
+// sequenceTable holds the named sequences, the id generators and the
+// fencing tokens of locks of the server, shared by all databases.
+//
+// With a state file, ids are reserved in it ahead of being handed out: a
+// sequence and the fencing tokens save the id they will restart from and
+// the snowflake generator the time it will restart from. After a restart
+// ids continue past the reserved ones, so they never repeat, at the cost of
+// a gap.
+type sequenceTable struct {
+	mu        sync.Mutex
+	path      string
+	sequences map[string]*sequence
+	snowflake snowflakeState
+	ulid      ulidState
+	tokens    sequence
+}
+
+type sequence struct {
+	next    int64 // next id handed out
+	step    int64
+	ceiling int64 // first id not reserved in the state file
+}
+
+// sequenceFile is the content of the state file.
+type sequenceFile struct {
+	Sequences map[string]*savedSequence `json:"sequences"`
+	Snowflake int64                     `json:"snowflake"`
+	Tokens    int64                     `json:"tokens"`
+}
+
+type savedSequence struct {
+	Next int64 `json:"next"`
+	Step int64 `json:"step"`
+}
+
+// newSequenceTable returns a table whose fencing tokens start at the
+// current time in nanoseconds, so without a state file they still keep
+// increasing across restarts unless the clock goes back.
+func newSequenceTable() *sequenceTable {
+	now := time.Now().UnixNano()
+	return &sequenceTable{
+		sequences: make(map[string]*sequence),
+		tokens:    sequence{next: now, step: 1, ceiling: now},
+	}
+}
+
+// load reads the state file at path, if it exists, and keeps saving to it.
+func (t *sequenceTable) load(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		f := sequenceFile{}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		for name, saved := range f.Sequences {
+			t.sequences[name] = &sequence{next: saved.Next, step: saved.Step, ceiling: saved.Next}
+		}
+		t.snowflake.restart(f.Snowflake)
+		if f.Tokens > t.tokens.next {
+			t.tokens.next, t.tokens.ceiling = f.Tokens, f.Tokens
+		}
+	}
+	t.path = path
+	return nil
+}
+
+// saveLocked writes the state file, atomically. t.mu must be held.
+func (t *sequenceTable) saveLocked() error {
+	if t.path == "" {
+		return nil
+	}
+	f := sequenceFile{
+		Sequences: make(map[string]*savedSequence, len(t.sequences)),
+		Snowflake: t.snowflake.ceiling,
+		Tokens:    t.tokens.ceiling,
+	}
+	for name, seq := range t.sequences {
+		f.Sequences[name] = &savedSequence{Next: seq.ceiling, Step: seq.step}
+	}
+	data, err := json.Marshal(&f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(t.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (t *sequenceTable) create(name string, start, step int64) (*SequenceInfo, error) {
+	if step <= 0 {
+		return nil, errBadStep
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sequences[name] != nil {
+		return nil, ErrSequenceExists
+	}
+	seq := &sequence{next: start, step: step, ceiling: start}
+	t.sequences[name] = seq
+	if err := t.saveLocked(); err != nil {
+		delete(t.sequences, name)
+		return nil, err
+	}
+	return &SequenceInfo{Name: name, Next: seq.next, Step: seq.step}, nil
+}
+
+// next reserves count ids of the sequence name, creating it from 1 with a
+// step of 1 if needed.
+func (t *sequenceTable) next(name string, count int) (*IDBlock, error) {
+	if count < 1 || count > maxSequenceBlock {
+		return nil, errBadCount
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seq := t.sequences[name]
+	if seq == nil {
+		seq = &sequence{next: 1, step: 1, ceiling: 1}
+		t.sequences[name] = seq
+	}
+	end, ok := addSteps(seq.next, seq.step, count)
+	if !ok {
+		return nil, ErrSequenceExhausted
+	}
+	block := &IDBlock{First: seq.next, Step: seq.step, Count: count}
+	if end > seq.ceiling {
+		ceiling := seq.ceiling
+		if seq.ceiling, ok = addSteps(end, seq.step, sequenceReserve); !ok {
+			seq.ceiling = math.MaxInt64
+		}
+		if err := t.saveLocked(); err != nil {
+			seq.ceiling = ceiling
+			return nil, err
+		}
+	}
+	seq.next = end
+	return block, nil
+}
+
+// nextToken returns a fencing token greater than the ones handed out
+// before.
+func (t *sequenceTable) nextToken() (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seq := &t.tokens
+	if seq.next == math.MaxInt64 {
+		return 0, ErrSequenceExhausted
+	}
+	if seq.next >= seq.ceiling {
+		ceiling, ok := seq.ceiling, false
+		if seq.ceiling, ok = addSteps(seq.next, 1, sequenceReserve); !ok {
+			seq.ceiling = math.MaxInt64
+		}
+		if err := t.saveLocked(); err != nil {
+			seq.ceiling = ceiling
+			return 0, err
+		}
+	}
+	token := seq.next
+	seq.next++
+	return token, nil
+}
+
+// addSteps returns next+n*step, reporting false if it overflows. Step and
+// n must be positive.
+func addSteps(next, step int64, n int) (int64, bool) {
+	if step > math.MaxInt64/int64(n) {
+		return 0, false
+	}
+	if d := step * int64(n); next <= math.MaxInt64-d {
+		return next + d, true
+	}
+	return 0, false
+}
+
+func (t *sequenceTable) get(name string) *SequenceInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seq := t.sequences[name]
+	if seq == nil {
+		return nil
+	}
+	return &SequenceInfo{Name: name, Next: seq.next, Step: seq.step}
+}
+
+func (t *sequenceTable) delete(name string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seq := t.sequences[name]
+	if seq == nil {
+		return false, nil
+	}
+	delete(t.sequences, name)
+	if err := t.saveLocked(); err != nil {
+		t.sequences[name] = seq
+		return false, err
+	}
+	return true, nil
+}
+
+// SetSequenceFile makes the server keep the state of its sequences, id
+// generators and lock fencing tokens in the file at path, loading it if it exists. The file is
+// only written once they are used. Without it they start over after a
+// restart. It must be called before Start.
+func (s *Server) SetSequenceFile(path string) error {
+	return s.sequences.load(path)
+}
+
+func sequenceStatus(err error) int {
+	if err == ErrSequenceExists || err == ErrSequenceExhausted {
+		return http.StatusConflict
+	}
+	if err == errBadStep || err == errBadCount {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// POST /sequences/:name
+//
+// Creates a sequence from start, 1 by default, with the given step.
+func (s *Server) createSequence(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	start, step := int64(1), int64(1)
+	if reqBody.Start != nil {
+		start = *reqBody.Start
+	}
+	if reqBody.Step != nil {
+		step = *reqBody.Step
+	}
+	info, err := s.sequences.create(name, start, step)
+	if err != nil {
+		return errorResponse(c, sequenceStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Sequence: info})
+}
+
+// POST /sequences/:name/next
+//
+// Reserves a block of count ids, 1 by default.
+func (s *Server) nextSequence(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	count, err := idCount(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	block, err := s.sequences.next(name, count)
+	if err != nil {
+		return errorResponse(c, sequenceStatus(err), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Block: block})
+}
+
+// GET /sequences/:name
+func (s *Server) getSequence(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	info := s.sequences.get(name)
+	if info == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true, Sequence: info})
+}
+
+// DELETE /sequences/:name
+func (s *Server) deleteSequence(c echo.Context) error {
+	name, err := pathParam(c, "name")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	deleted, err := s.sequences.delete(name)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true})
+}
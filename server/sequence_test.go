@@ -0,0 +1,142 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"my-go-db/storage/enginetest"
+)
+
+func TestSequenceTable_Next(t *testing.T) {
+	tbl := newSequenceTable()
+	if _, err := tbl.create("a", 10, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.create("a", 1, 1); err != ErrSequenceExists {
+		t.Error("Must return ErrSequenceExists", err)
+	}
+	if _, err := tbl.create("b", 1, 0); err != errBadStep {
+		t.Error("Must return errBadStep", err)
+	}
+	if block, _ := tbl.next("a", 3); block.First != 10 || block.Step != 5 || block.Count != 3 {
+		t.Error("Must reserve 10, 15 and 20", block)
+	}
+	if block, _ := tbl.next("a", 1); block.First != 25 {
+		t.Error("Must continue at 25", block)
+	}
+	if block, _ := tbl.next("c", 2); block.First != 1 || block.Step != 1 {
+		t.Error("Must create a sequence from 1", block)
+	}
+	for _, count := range []int{0, maxSequenceBlock + 1} {
+		if _, err := tbl.next("a", count); err != errBadCount {
+			t.Error("Must return errBadCount", count, err)
+		}
+	}
+}
+
+func TestSequenceTable_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	tbl := newSequenceTable()
+	if err := tbl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	tbl.create("a", 100, 2)
+	tbl.next("a", 5)
+	tbl.create("b", 1, 1)
+	last, _ := tbl.next("a", 1)
+	ceiling := tbl.sequences["a"].ceiling
+	if ceiling <= last.First {
+		t.Fatal("Must reserve ids ahead", ceiling, last)
+	}
+
+	tbl = newSequenceTable()
+	if err := tbl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	block, err := tbl.next("a", 1)
+	if err != nil || block.First < ceiling || block.Step != 2 {
+		t.Error("Must resume at or past the saved ceiling", ceiling, block, err)
+	}
+	if info := tbl.get("b"); info == nil || info.Next != 1 {
+		t.Error("Must keep unused sequences", info)
+	}
+
+	tbl.delete("b")
+	tbl = newSequenceTable()
+	tbl.load(path)
+	if tbl.get("b") != nil {
+		t.Error("Must keep deletions")
+	}
+}
+
+func TestAddSteps(t *testing.T) {
+	tests := []struct {
+		next, step int64
+		n          int
+		want       int64
+		ok         bool
+	}{
+		{1, 1, 1, 2, true},
+		{10, 5, 3, 25, true},
+		{-10, 3, 4, 2, true},
+		{math.MaxInt64 - 4, 2, 2, math.MaxInt64, true},
+		{math.MaxInt64 - 4, 2, 3, 0, false},
+		{0, math.MaxInt64/2 + 1, 2, 0, false},
+		{math.MaxInt64, 1, 1, 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := addSteps(tt.next, tt.step, tt.n); got != tt.want || ok != tt.ok {
+			t.Error("Must be equal", tt, got, ok)
+		}
+	}
+}
+
+func TestServer_SequenceExhausted(t *testing.T) {
+	s := New(":0", enginetest.NewFake())
+	if _, err := s.sequences.create("a", math.MaxInt64-3, 1); err != nil {
+		t.Fatal(err)
+	}
+	status, resp := request(t, s, "POST", "/sequences/a/next", `{"count": 3}`)
+	if status != http.StatusOK || resp.Block.First != math.MaxInt64-3 {
+		t.Fatal("Must reserve the last ids", status, resp.Message)
+	}
+	if status, resp = request(t, s, "POST", "/sequences/a/next", `{"count": 2}`); status != http.StatusConflict || resp.Message != ErrSequenceExhausted.Error() {
+		t.Error("Must return ErrSequenceExhausted", status, resp.Message)
+	}
+	if info := s.sequences.get("a"); info.Next != math.MaxInt64 {
+		t.Error("Must not move the sequence", info)
+	}
+}
+
+func TestClient_Sequence(t *testing.T) {
+	s := New(":0", enginetest.NewFake())
+	ts := httptest.NewServer(s.echo)
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	c := NewClient(u.Hostname(), u.Port())
+
+	for _, name := range []string{"a/b%c", "a?b#c", "a b"} {
+		if _, err := c.CreateSequence(name, 10, 2); err != nil {
+			t.Fatal(name, err)
+		}
+		if s.sequences.get(name) == nil {
+			t.Fatal("Must create the sequence under its exact name", name)
+		}
+		if block, err := c.NextIDs(name, 3); err != nil || block.First != 10 || block.Count != 3 {
+			t.Error("Must reserve 10, 12 and 14", name, block, err)
+		}
+		if info, err := c.GetSequence(name); err != nil || info.Name != name || info.Next != 16 {
+			t.Error("Must continue at 16", name, info, err)
+		}
+		if err := c.DeleteSequence(name); err != nil || s.sequences.get(name) != nil {
+			t.Error("Must delete the sequence", name, err)
+		}
+	}
+	if _, err := c.CreateSequence("a/b", 1, 1); err != nil || s.sequences.get("a") != nil {
+		t.Error("Must not create a prefix of the name", err)
+	}
+}
@@ -6,30 +6,117 @@ import (
 	"github.com/labstack/gommon/log"
 	"my-go-db/storage"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 	"sync"
+	"errors"
 )
 
+// expireInterval is how often expired keys are deleted from the engine.
+const expireInterval = 100 * time.Millisecond
+
+var errNotSupported = errors.New("Not supported by the storage engine")
+
 type Server struct {
-	storage  *storage.Storage
-	bindAddr string
+	databases []storage.Engine
+	bindAddr  string
+	maxIdle   time.Duration
+	limits    Limits
+	backupMu   sync.Mutex
+	lastBackup *backupBase
+	scriptsMu     sync.RWMutex
+	scripts       map[string]*storage.Script
+	scriptTimeout time.Duration
+	locks         *lockTable
+	sequences     *sequenceTable
 	echo     *echo.Echo
 	wg       *sync.WaitGroup
 }
 
-func New(bindAddr string) *Server {
+// New returns a server keeping its data in engines, one per logical
+// database. Requests select a database with the db query parameter, 0 by
+// default.
+func New(bindAddr string, engines ...storage.Engine) *Server {
 	s := &Server{
-		storage:  storage.New(),
-		bindAddr: bindAddr,
-		echo:     echo.New(),
-		wg:       new(sync.WaitGroup),
+		databases:     engines,
+		bindAddr:      bindAddr,
+		scripts:       make(map[string]*storage.Script),
+		scriptTimeout: defaultScriptTimeout,
+		sequences:     newSequenceTable(),
+		echo:          echo.New(),
+		wg:            new(sync.WaitGroup),
 	}
+	s.locks = newLockTable(s.sequences.nextToken)
+	s.echo.Use(s.selectDatabase, s.limitBody)
 
 	g := s.echo.Group("/storage")
+	g.GET("", s.scanKeys)
 	g.GET("/", s.getKeys)
+	g.GET("/_bulk", s.bulkGet)
+	g.POST("/_bulk", s.bulkSet)
+	g.DELETE("/_bulk", s.bulkDelete)
+	g.GET("/_random", s.randomKeys)
 	g.GET("/:key", s.getValue)
 	g.POST("/:key", s.setValue)
 	g.DELETE("/:key", s.deleteValue)
+	g.GET("/:key/bit/:offset", s.getBit)
+	g.POST("/:key/bit/:offset", s.setBit)
+	g.GET("/:key/bitcount", s.bitCount)
+	g.GET("/:key/bitpos", s.bitPos)
+	g.POST("/:key/bitop", s.bitOp)
+	g.POST("/:key/geo", s.geoAdd)
+	g.GET("/:key/geo/pos", s.geoPos)
+	g.GET("/:key/geo/dist", s.geoDist)
+	g.GET("/:key/geo/search", s.geoSearch)
+	g.GET("/:key/compression", s.compressionStats)
+	g.GET("/:key/memory", s.keyMemory)
+	g.POST("/:key/rename", s.renameKey)
+	g.POST("/:key/copy", s.copyKey)
+	g.POST("/:key/move", s.moveKey)
+	g.GET("/:key/object", s.getObject)
+	g.POST("/:key/ts", s.tsAdd)
+	g.GET("/:key/ts", s.tsRange)
+	g.GET("/:key/ts/info", s.tsInfo)
+	g.POST("/:key/ts/rules", s.tsCreateRule)
+	g.DELETE("/:key/ts/rules/:dest", s.tsDeleteRule)
+	g.POST("/:key/ratelimit", s.rateLimit)
+	g.POST("/:key/crdt", s.crdtUpdate)
+	g.GET("/:key/crdt", s.crdtExport)
+	g.POST("/:key/crdt/merge", s.crdtMerge)
+
+	s.echo.POST("/script", s.loadScript)
+	s.echo.POST("/script/:sha", s.evalScript)
+
+	lg := s.echo.Group("/locks")
+	lg.GET("/:name", s.getLock)
+	lg.POST("/:name", s.acquireLock)
+	lg.POST("/:name/renew", s.renewLock)
+	lg.DELETE("/:name", s.releaseLock)
+
+	sg := s.echo.Group("/sequences")
+	sg.GET("/:name", s.getSequence)
+	sg.POST("/:name", s.createSequence)
+	sg.POST("/:name/next", s.nextSequence)
+	sg.DELETE("/:name", s.deleteSequence)
+	s.echo.POST("/ids/snowflake", s.snowflakeIDs)
+	s.echo.POST("/ids/ulid", s.ulidIDs)
+
+	s.echo.POST("/snapshots", s.openSnapshot)
+	s.echo.DELETE("/snapshots/:id", s.releaseSnapshot)
+
+	ig := s.echo.Group("/indexes")
+	ig.GET("", s.getIndexes)
+	ig.POST("/:name", s.createIndex)
+	ig.DELETE("/:name", s.dropIndex)
+	ig.GET("/:name/keys", s.lookupIndex)
+
+	ag := s.echo.Group("/admin")
+	ag.GET("/memory", s.memoryStats)
+	ag.GET("/memory/top", s.memoryTop)
+	ag.GET("/idle", s.idleKeys)
+	ag.POST("/evict", s.evictKeys)
+	ag.GET("/backup", s.backup)
 
 	s.echo.Logger.SetLevel(log.DEBUG)
 	return s
@@ -43,23 +130,40 @@ func (s *Server) Start() {
 		s.wg.Done()
 	}()
 	go func() {
-		for range time.Tick(1) {
-			s.storage.DeleteExpired()
+		for range time.Tick(expireInterval) {
+			for _, db := range s.databases {
+				if err := db.DeleteExpired(); err != nil {
+					s.echo.Logger.Error(err)
+				}
+			}
+			s.locks.deleteExpired()
 		}
 	}()
+	if s.maxIdle > 0 {
+		go s.evictIdle()
+	}
 
 }
 
+// WaitStop waits for the server to stop and closes the storage engines.
 func (s *Server) WaitStop() {
 	s.wg.Wait()
+	for _, db := range s.databases {
+		if err := db.Close(); err != nil {
+			fmt.Println("Could not close storage:", err.Error())
+		}
+	}
 }
 
-// GET /storage/:key
+// GET /storage/:key?snapshot=1
 func (s *Server) getValue(c echo.Context) error {
 	key := c.Param("key")
 
 	resp := new(ResponseBody)
-	item := s.storage.GetItem(key)
+	item, err := s.getItem(c, key)
+	if err != nil {
+		return errorResponse(c, snapshotStatus(err), err)
+	}
 	if item != nil {
 		if item.String != "" {
 			resp.Success = true
@@ -85,6 +189,22 @@ func (s *Server) getValue(c echo.Context) error {
 			resp.Success = true
 			resp.IntDict = item.IntMap
 			return c.JSON(http.StatusOK, resp)
+		} else if item.Bitmap != nil {
+			resp.Success = true
+			resp.Bitmap = item.Bitmap
+			return c.JSON(http.StatusOK, resp)
+		} else if item.Geo != nil {
+			resp.Success = true
+			resp.Locations = geoLocations(item.Geo.Members())
+			return c.JSON(http.StatusOK, resp)
+		} else if item.TimeSeries != nil {
+			resp.Success = true
+			resp.Samples = tsSamples(item.TimeSeries.Samples())
+			return c.JSON(http.StatusOK, resp)
+		} else if item.CRDT != nil {
+			resp.Success = true
+			resp.CRDT, _ = crdtInfo(item.CRDT, false)
+			return c.JSON(http.StatusOK, resp)
 		}
 	}
 	resp.Message = "Not found"
@@ -102,23 +222,27 @@ func (s *Server) setValue(c echo.Context) error {
 		})
 	}
 
-	key := c.Param("key")
-
-	if reqBody.String != "" {
-		s.storage.SetString(key, reqBody.String, reqBody.TTL)
-	} else if reqBody.Int > 0 {
-		s.storage.SetInt(key, reqBody.Int, reqBody.TTL)
-	} else if reqBody.StringList != nil {
-		s.storage.SetStringSlice(key, reqBody.StringList, reqBody.TTL)
-	} else if reqBody.IntList != nil {
-		s.storage.SetIntSlice(key, reqBody.IntList, reqBody.TTL)
-	} else if reqBody.StringDict != nil {
-		s.storage.SetStringMap(key, reqBody.StringDict, reqBody.TTL)
-	} else {
-		return c.JSON(http.StatusBadRequest, &ResponseBody{
-			Success: false,
-			Message: "Unsupported type",
-		})
+	entry := &BulkEntry{
+		Key:        c.Param("key"),
+		String:     reqBody.String,
+		Int:        reqBody.Int,
+		StringList: reqBody.StringList,
+		IntList:    reqBody.IntList,
+		StringDict: reqBody.StringDict,
+		IntDict:    reqBody.IntDict,
+		Bitmap:     reqBody.Bitmap,
+		TTL:        reqBody.TTL,
+	}
+	item, err := entryItem(entry)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	// Checked here too for the engines without limits of their own
+	if err := s.limits.Check(entry.Key, item); err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	if err := setEntry(s.engine(c), entry); err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusInternalServerError), err)
 	}
 
 	return c.JSON(http.StatusOK, &ResponseBody{
@@ -129,7 +253,7 @@ func (s *Server) setValue(c echo.Context) error {
 
 func (s *Server) deleteValue(c echo.Context) error {
 	key := c.Param("key")
-	if err := s.storage.Remove(key); err != nil {
+	if err := s.engine(c).Remove(key); err != nil {
 		return c.JSON(http.StatusNotFound, &ResponseBody{
 			Success: false,
 			Message: fmt.Sprintf("Key: %s does not exist", key),
@@ -140,14 +264,108 @@ func (s *Server) deleteValue(c echo.Context) error {
 	})
 }
 
-// GET /storage/
+// GET /storage/?snapshot=1
 func (s *Server) getKeys(c echo.Context) error {
-	keys := s.storage.Keys()
+	var keys []string
+	if snap, err := s.snapshot(c); err != nil {
+		return errorResponse(c, snapshotStatus(err), err)
+	} else if snap != nil {
+		keys, _ = snap.Keys()
+	} else if keys, err = s.engine(c).Keys(); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
 	return c.JSON(http.StatusOK, &ResponseBody{
 		Success: true,
 		Keys:   keys,
 	})
 }
 
+func errorResponse(c echo.Context, status int, err error) error {
+	return c.JSON(status, &ResponseBody{
+		Success: false,
+		Message: err.Error(),
+		Code:    errorCode(err),
+	})
+}
+
+// notSupported answers requests for optional features the engine lacks.
+func notSupported(c echo.Context) error {
+	return errorResponse(c, http.StatusNotImplemented, errNotSupported)
+}
+
+// pathParam returns the path parameter name unescaped. Echo matches the
+// escaped path when it has escapes of its own, such as %2F, and leaves them
+// in the parameters then.
+func pathParam(c echo.Context, name string) (string, error) {
+	value := c.Param(name)
+	if c.Request().URL.RawPath == "" {
+		return value, nil
+	}
+	return url.PathUnescape(value)
+}
+
+// queryInt reads an integer query parameter, falling back to def when absent.
+func queryInt(c echo.Context, name string, def int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Parameter %s must be integer", name)
+	}
+	return i, nil
+}
+
+// queryFloat reads a float query parameter, falling back to def when absent.
+func queryFloat(c echo.Context, name string, def float64) (float64, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Parameter %s must be number", name)
+	}
+	return f, nil
+}
+
+// GET /storage?prefix=user:&start=user:1000&end=user:2000&reverse=1&limit=100&cursor=user:1099&snapshot=1
+func (s *Server) scanKeys(c echo.Context) error {
+	limit, err := queryInt(c, "limit", 0)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	reverse, _ := strconv.ParseBool(c.QueryParam("reverse"))
+
+	opts := storage.ScanOptions{
+		Prefix:  c.QueryParam("prefix"),
+		Start:   c.QueryParam("start"),
+		End:     c.QueryParam("end"),
+		Reverse: reverse,
+		Limit:   limit,
+		Cursor:  c.QueryParam("cursor"),
+	}
+
+	var keys []string
+	var cursor string
+	if snap, err := s.snapshot(c); err != nil {
+		return errorResponse(c, snapshotStatus(err), err)
+	} else if snap != nil {
+		if keys, cursor, err = snap.Scan(opts); err != nil {
+			return errorResponse(c, snapshotStatus(err), err)
+		}
+	} else if scanner, ok := s.engine(c).(storage.ScanEngine); !ok {
+		return notSupported(c)
+	} else if keys, cursor, err = scanner.Scan(opts); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Keys:    keys,
+		Cursor:  cursor,
+	})
+}
+
 // todo getFromList
 // todo getFromDict
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"my-go-db/storage/enginetest"
+)
+
+// request sends a request to the server and decodes the response.
+func request(t *testing.T, s *Server, method, target, body string) (int, *ResponseBody) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	resp := &ResponseBody{}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatal("Must return a json body", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestServer_NotSupported(t *testing.T) {
+	s := New(":0", enginetest.NewFake())
+	tests := []struct {
+		method, target, body string
+	}{
+		{"GET", "/storage?prefix=a", ""},
+		{"GET", "/storage/k/bit/1", ""},
+		{"POST", "/storage/k/bit/1", `{"int": 1}`},
+		{"GET", "/storage/k/bitcount", ""},
+		{"POST", "/storage/k/geo", `{"locations": [{"member": "a", "longitude": 1, "latitude": 2}]}`},
+		{"GET", "/storage/k/geo/pos?member=a", ""},
+		{"GET", "/storage/k/compression", ""},
+		{"GET", "/storage/k/memory", ""},
+		{"POST", "/storage/k/rename", `{"destination": "n"}`},
+		{"GET", "/storage/k/object", ""},
+		{"POST", "/storage/k/ts", `{"samples": [{"timestamp": 1, "value": 1}]}`},
+		{"GET", "/storage/k/ts/info", ""},
+		{"POST", "/storage/k/ratelimit", `{"limit": 1, "window": 1}`},
+		{"POST", "/storage/k/crdt", `{"type": "gcounter", "op": "incr", "int": 1}`},
+		{"GET", "/storage/k/crdt", ""},
+		{"POST", "/storage/_bulk", `{"entries": [{"key": "a", "string": "x"}], "nx": true}`},
+		{"POST", "/snapshots", ""},
+		{"GET", "/indexes", ""},
+		{"GET", "/admin/memory", ""},
+		{"GET", "/admin/idle", ""},
+		{"GET", "/admin/backup", ""},
+	}
+	for _, tt := range tests {
+		status, resp := request(t, s, tt.method, tt.target, tt.body)
+		if status != http.StatusNotImplemented || resp.Success || resp.Message != errNotSupported.Error() {
+			t.Error("Must not be supported", tt.method, tt.target, status, resp.Message)
+		}
+	}
+}
+
+func TestServer_Fallbacks(t *testing.T) {
+	s := New(":0", enginetest.NewFake())
+
+	// Without bulk operations the entries are stored and read one by one.
+	status, resp := request(t, s, "POST", "/storage/_bulk",
+		`{"entries": [{"key": "a", "string": "x"}, {"key": "b", "int_list": [1, 2]}]}`)
+	if status != http.StatusOK || !resp.Success || resp.Count != 2 {
+		t.Fatal("Must store the entries", status, resp.Message)
+	}
+	status, resp = request(t, s, "GET", "/storage/_bulk?key=a&key=b&key=c", "")
+	if status != http.StatusOK || len(resp.Entries) != 3 {
+		t.Fatal("Must read the entries", status, resp.Message)
+	}
+	if e := resp.Entries[0]; !e.Found || e.String != "x" {
+		t.Error("Must be equal `x`", e)
+	}
+	if e := resp.Entries[1]; !e.Found || !reflect.DeepEqual(e.IntList, []int{1, 2}) {
+		t.Error("Must be equal [1 2]", e)
+	}
+	if e := resp.Entries[2]; e.Found {
+		t.Error("Must be not found", e)
+	}
+
+	// Without random sampling all the keys are shuffled.
+	status, resp = request(t, s, "GET", "/storage/_random?count=1", "")
+	if status != http.StatusOK || len(resp.Keys) != 1 || resp.Keys[0] != "a" && resp.Keys[0] != "b" {
+		t.Error("Must return a random key", status, resp.Keys)
+	}
+	_, resp = request(t, s, "GET", "/storage/_random?count=5", "")
+	sort.Strings(resp.Keys)
+	if !reflect.DeepEqual(resp.Keys, []string{"a", "b"}) {
+		t.Error("Must return every key", resp.Keys)
+	}
+}
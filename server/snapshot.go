@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+func snapshotStatus(err error) int {
+	switch err {
+	case storage.ErrSnapshotNotFound:
+		return http.StatusGone
+	case errNotSupported:
+		return http.StatusNotImplemented
+	}
+	return http.StatusBadRequest
+}
+
+// snapshot returns the snapshot selected by the snapshot query parameter, or
+// nil when reading the latest data.
+func (s *Server) snapshot(c echo.Context) (*storage.Snapshot, error) {
+	id := c.QueryParam("snapshot")
+	if id == "" {
+		return nil, nil
+	}
+	snapshots, ok := s.engine(c).(storage.SnapshotEngine)
+	if !ok {
+		return nil, errNotSupported
+	}
+	snapshotID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, storage.ErrSnapshotNotFound
+	}
+	return snapshots.GetSnapshot(snapshotID)
+}
+
+func (s *Server) getItem(c echo.Context, key string) (*storage.Item, error) {
+	snap, err := s.snapshot(c)
+	if err != nil {
+		return nil, err
+	}
+	if snap != nil {
+		return snap.GetItem(key)
+	}
+	return s.engine(c).Get(key)
+}
+
+// POST /snapshots
+func (s *Server) openSnapshot(c echo.Context) error {
+	snapshots, ok := s.engine(c).(storage.SnapshotEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	snap := snapshots.OpenSnapshot()
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success:  true,
+		Snapshot: snap.ID,
+		Revision: snap.Rev,
+	})
+}
+
+// DELETE /snapshots/:id
+func (s *Server) releaseSnapshot(c echo.Context) error {
+	snapshots, ok := s.engine(c).(storage.SnapshotEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err == nil {
+		err = snapshots.ReleaseSnapshot(id)
+	}
+	if err != nil {
+		return errorResponse(c, http.StatusNotFound, storage.ErrSnapshotNotFound)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+	})
+}
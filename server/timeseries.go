@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"my-go-db/storage"
+)
+
+var errNoRule = errors.New("No rule into this destination")
+
+func tsSamples(samples []storage.Sample) []*TSSample {
+	result := make([]*TSSample, len(samples))
+	for i, s := range samples {
+		result[i] = &TSSample{Timestamp: s.Time, Value: s.Value}
+	}
+	return result
+}
+
+// POST /storage/:key/ts
+//
+// Adds the samples of the request, after setting the retention of the
+// series if the request has one.
+func (s *Server) tsAdd(c echo.Context) error {
+	series, ok := s.engine(c).(storage.TimeSeriesEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	key := c.Param("key")
+	if reqBody.Retention != nil {
+		if err := series.TSCreate(key, *reqBody.Retention); err != nil {
+			return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+		}
+	}
+
+	samples := make([]storage.Sample, 0, len(reqBody.Samples))
+	for _, sample := range reqBody.Samples {
+		if sample == nil {
+			return errorResponse(c, http.StatusBadRequest, errors.New("Sample must not be null"))
+		}
+		samples = append(samples, storage.Sample{Time: sample.Timestamp, Value: sample.Value})
+	}
+	added, err := series.TSAdd(key, samples...)
+	if err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Count:   added,
+	})
+}
+
+// GET /storage/:key/ts?from=1000&to=2000
+// GET /storage/:key/ts?aggregation=avg&bucket=60000
+func (s *Server) tsRange(c echo.Context) error {
+	series, ok := s.engine(c).(storage.TimeSeriesEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	q := storage.TSQuery{Aggregation: c.QueryParam("aggregation")}
+	ints := []struct {
+		name  string
+		value *int64
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+		{"bucket", &q.Bucket},
+	}
+	for _, i := range ints {
+		v, err := queryInt(c, i.name, 0)
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, err)
+		}
+		*i.value = int64(v)
+	}
+
+	samples, err := series.TSRange(c.Param("key"), q)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		Samples: tsSamples(samples),
+	})
+}
+
+// GET /storage/:key/ts/info
+func (s *Server) tsInfo(c echo.Context) error {
+	series, ok := s.engine(c).(storage.TimeSeriesEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	info, err := series.TSInfo(c.Param("key"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if info == nil {
+		return c.JSON(http.StatusNotFound, &ResponseBody{Message: "Not found"})
+	}
+	rules := make([]*TSRule, len(info.Rules))
+	for i, r := range info.Rules {
+		rules[i] = &TSRule{Destination: r.Dest, Aggregation: r.Aggregation, Bucket: r.Bucket}
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{
+		Success: true,
+		TimeSeries: &TSInfo{
+			Retention: info.Retention,
+			Samples:   info.Samples,
+			First:     info.First,
+			Last:      info.Last,
+			Rules:     rules,
+		},
+	})
+}
+
+// POST /storage/:key/ts/rules
+func (s *Server) tsCreateRule(c echo.Context) error {
+	series, ok := s.engine(c).(storage.TimeSeriesEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	reqBody := RequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if reqBody.Destination == "" {
+		return errorResponse(c, http.StatusBadRequest, errNoDestination)
+	}
+	rule := storage.TSRule{
+		Dest:        reqBody.Destination,
+		Aggregation: reqBody.Aggregation,
+		Bucket:      reqBody.Bucket,
+	}
+	if err := series.TSCreateRule(c.Param("key"), rule); err != nil {
+		return errorResponse(c, limitStatus(err, http.StatusBadRequest), err)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true})
+}
+
+// DELETE /storage/:key/ts/rules/:dest
+func (s *Server) tsDeleteRule(c echo.Context) error {
+	series, ok := s.engine(c).(storage.TimeSeriesEngine)
+	if !ok {
+		return notSupported(c)
+	}
+	deleted, err := series.TSDeleteRule(c.Param("key"), c.Param("dest"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err)
+	}
+	if !deleted {
+		return errorResponse(c, http.StatusNotFound, errNoRule)
+	}
+	return c.JSON(http.StatusOK, &ResponseBody{Success: true})
+}
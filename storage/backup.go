@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// A backup is a stream of the keys of every database:
+//
+//	header: backupMagic | id | base | created | databases
+//	record: op | database | key | value
+//	end:    backupEnd | record count | crc32c of everything before it
+//
+// id, base and created are little endian uint64, the other numbers uvarints
+// and strings length prefixed. Full backups have base 0; incremental ones
+// have the id of the backup they follow and only hold the keys changed
+// since, with a delete record for every removed key. The value of a put is
+// the binary encoding of the item, including its expiration; deletes have
+// none.
+const backupMagic = 0x316b62626467796d // "mygdbbk1"
+
+const (
+	backupPut byte = iota + 1
+	backupDelete
+	backupEnd
+)
+
+var ErrCorruptBackup = errors.New("Corrupt or incomplete backup")
+
+var backupCRC = crc32.MakeTable(crc32.Castagnoli)
+
+type BackupHeader struct {
+	ID        uint64
+	Base      uint64 // 0 for full backups
+	Created   time.Time
+	Databases int
+}
+
+func (h *BackupHeader) Full() bool {
+	return h.Base == 0
+}
+
+// BackupRecord is a key of a backup; Item is nil if the key was removed.
+type BackupRecord struct {
+	DB   int
+	Key  string
+	Item *Item
+}
+
+// BackupWriter writes a backup stream.
+type BackupWriter struct {
+	w     *bufio.Writer
+	crc   hash.Hash32
+	buf   []byte
+	count uint64
+}
+
+func NewBackupWriter(w io.Writer, h BackupHeader) (*BackupWriter, error) {
+	bw := &BackupWriter{w: bufio.NewWriter(w), crc: crc32.New(backupCRC)}
+	b := binary.LittleEndian.AppendUint64(nil, backupMagic)
+	b = binary.LittleEndian.AppendUint64(b, h.ID)
+	b = binary.LittleEndian.AppendUint64(b, h.Base)
+	b = binary.LittleEndian.AppendUint64(b, uint64(h.Created.UnixNano()))
+	b = binary.AppendUvarint(b, uint64(h.Databases))
+	return bw, bw.write(b)
+}
+
+func (bw *BackupWriter) write(b []byte) error {
+	bw.crc.Write(b)
+	_, err := bw.w.Write(b)
+	return err
+}
+
+func (bw *BackupWriter) record(op byte, db int, key string, value []byte) error {
+	b := append(bw.buf[:0], op)
+	b = binary.AppendUvarint(b, uint64(db))
+	b = appendString(b, key)
+	if op == backupPut {
+		b = binary.AppendUvarint(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+	bw.buf = b
+	bw.count++
+	return bw.write(b)
+}
+
+// Put writes a key with value, the binary encoding of its item.
+func (bw *BackupWriter) Put(db int, key string, value []byte) error {
+	return bw.record(backupPut, db, key, value)
+}
+
+// Delete writes the removal of a key.
+func (bw *BackupWriter) Delete(db int, key string) error {
+	return bw.record(backupDelete, db, key, nil)
+}
+
+// Close ends the stream. Readers reject streams that were not closed.
+func (bw *BackupWriter) Close() error {
+	b := binary.AppendUvarint([]byte{backupEnd}, bw.count)
+	if err := bw.write(b); err != nil {
+		return err
+	}
+	if _, err := bw.w.Write(binary.LittleEndian.AppendUint32(nil, bw.crc.Sum32())); err != nil {
+		return err
+	}
+	return bw.w.Flush()
+}
+
+// BackupReader reads a backup stream, verifying it as it goes.
+type BackupReader struct {
+	r      *bufio.Reader
+	crc    hash.Hash32
+	header BackupHeader
+	count  uint64
+	done   bool
+}
+
+// crcReader feeds the checksum with the bytes read.
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c crcReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (br *BackupReader) reader() crcReader {
+	return crcReader{br.r, br.crc}
+}
+
+func (br *BackupReader) read(n uint64) ([]byte, error) {
+	if n > 1<<32 {
+		return nil, ErrCorruptBackup
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		return nil, ErrCorruptBackup
+	}
+	br.crc.Write(b)
+	return b, nil
+}
+
+func (br *BackupReader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(br.reader())
+	if err != nil {
+		return 0, ErrCorruptBackup
+	}
+	return v, nil
+}
+
+// NewBackupReader reads the header of a backup stream.
+func NewBackupReader(r io.Reader) (*BackupReader, error) {
+	br := &BackupReader{r: bufio.NewReader(r), crc: crc32.New(backupCRC)}
+	b, err := br.read(32)
+	if err != nil || binary.LittleEndian.Uint64(b) != backupMagic {
+		return nil, ErrCorruptBackup
+	}
+	br.header.ID = binary.LittleEndian.Uint64(b[8:])
+	br.header.Base = binary.LittleEndian.Uint64(b[16:])
+	br.header.Created = time.Unix(0, int64(binary.LittleEndian.Uint64(b[24:])))
+	databases, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	br.header.Databases = int(databases)
+	return br, nil
+}
+
+func (br *BackupReader) Header() BackupHeader {
+	return br.header
+}
+
+// Next returns the next record, or io.EOF once the end of the stream was
+// read and verified.
+func (br *BackupReader) Next() (*BackupRecord, error) {
+	if br.done {
+		return nil, io.EOF
+	}
+	op, err := br.reader().ReadByte()
+	if err != nil {
+		return nil, ErrCorruptBackup
+	}
+	if op == backupEnd {
+		return nil, br.end()
+	}
+	if op != backupPut && op != backupDelete {
+		return nil, ErrCorruptBackup
+	}
+	db, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	n, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	key, err := br.read(n)
+	if err != nil {
+		return nil, err
+	}
+	rec := &BackupRecord{DB: int(db), Key: string(key)}
+	if op == backupPut {
+		if n, err = br.uvarint(); err != nil {
+			return nil, err
+		}
+		value, err := br.read(n)
+		if err != nil {
+			return nil, err
+		}
+		rec.Item = new(Item)
+		if err := rec.Item.UnmarshalBinary(value); err != nil {
+			return nil, ErrCorruptBackup
+		}
+	}
+	br.count++
+	return rec, nil
+}
+
+func (br *BackupReader) end() error {
+	count, err := br.uvarint()
+	if err != nil || count != br.count {
+		return ErrCorruptBackup
+	}
+	sum := br.crc.Sum32()
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(br.r, b); err != nil || binary.LittleEndian.Uint32(b) != sum {
+		return ErrCorruptBackup
+	}
+	br.done = true
+	return io.EOF
+}
+
+// storageDump reads a snapshot pinned by OpenDump, so it does not expire
+// however long the dump takes.
+type storageDump struct {
+	snap *Snapshot
+}
+
+// OpenDump opens a snapshot of the live keys, so writes go on while they
+// are dumped.
+func (s *Storage) OpenDump() (Dump, error) {
+	return &storageDump{snap: s.openSnapshot(true)}, nil
+}
+
+// Each calls fn with a copy of every live key of the snapshot in key order.
+func (d *storageDump) Each(fn func(key string, item *Item) error) error {
+	snap := d.snap
+	keys, err := snap.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		item, err := snap.GetItem(key)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+		if err := fn(key, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *storageDump) Close() error {
+	return d.snap.Release()
+}
+
+// Restore stores a copy of item under key, keeping its expiration.
+func (s *Storage) Restore(key string, item *Item) error {
+	return s.setItem(key, item)
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func writeTestBackup(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w, err := NewBackupWriter(&buf, BackupHeader{ID: 20, Base: 10, Created: time.Unix(100, 0), Databases: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := NewItem(0)
+	item.StringMap = map[string]string{"a": "x"}
+	value, _ := item.MarshalBinary()
+	w.Put(0, "map", value)
+	w.Delete(1, "removed")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readTestBackup(b []byte) ([]*BackupRecord, error) {
+	r, err := NewBackupReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var records []*BackupRecord
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestBackup_RoundTrip(t *testing.T) {
+	b := writeTestBackup(t)
+	r, err := NewBackupReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Header()
+	if h.ID != 20 || h.Base != 10 || h.Full() || h.Databases != 2 || !h.Created.Equal(time.Unix(100, 0)) {
+		t.Error("Must read the header", h)
+	}
+	records, err := readTestBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatal("Must read every record", len(records))
+	}
+	if rec := records[0]; rec.DB != 0 || rec.Key != "map" || rec.Item == nil || rec.Item.StringMap["a"] != "x" {
+		t.Error("Must read puts", rec)
+	}
+	if rec := records[1]; rec.DB != 1 || rec.Key != "removed" || rec.Item != nil {
+		t.Error("Must read deletes", rec)
+	}
+}
+
+func TestBackup_Corrupt(t *testing.T) {
+	b := writeTestBackup(t)
+	for n := 0; n < len(b); n++ {
+		if _, err := readTestBackup(b[:n]); err != ErrCorruptBackup {
+			t.Fatal("Must reject truncated backups", n, err)
+		}
+	}
+	for i := range b {
+		damaged := append([]byte(nil), b...)
+		damaged[i] ^= 0x10
+		if _, err := readTestBackup(damaged); err != ErrCorruptBackup {
+			t.Fatal("Must reject damaged backups", i, err)
+		}
+	}
+}
+
+func dump(s *Storage, fn func(key string, item *Item) error) error {
+	d, err := s.OpenDump()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Each(fn)
+}
+
+func TestStorage_DumpRestore(t *testing.T) {
+	s := New()
+	s.SetString("a", "1", 0)
+	s.SetIntSlice("b", []int{1, 2}, 100)
+
+	dumped := map[string]*Item{}
+	err := dump(s, func(key string, item *Item) error {
+		s.SetString("a", "changed", 0)
+		s.SetString("c", "new", 0)
+		dumped[key] = item
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped) != 2 || dumped["a"].String != "1" {
+		t.Error("Must dump the keys as of the start", dumped)
+	}
+
+	restored := New()
+	for key, item := range dumped {
+		if err := restored.Restore(key, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if item, _ := restored.Get("b"); item == nil || len(item.IntSlice) != 2 {
+		t.Error("Must restore values", item)
+	}
+	if ttl, _ := restored.TTL("b"); ttl < 99 || ttl > 100 {
+		t.Error("Must restore the ttl", ttl)
+	}
+}
+
+func TestStorage_DumpOutlivesSnapshotTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { SnapshotTimeout = timeout }(SnapshotTimeout)
+	SnapshotTimeout = time.Millisecond
+
+	s := New()
+	for i := 0; i < 10; i++ {
+		s.SetInt(fmt.Sprintf("key%d", i), i+1, 0)
+	}
+	dumped := 0
+	err := dump(s, func(key string, item *Item) error {
+		if dumped == 0 {
+			for i := 0; i < 10; i++ {
+				s.SetInt(fmt.Sprintf("key%d", i), 100, 0)
+			}
+			time.Sleep(5 * time.Millisecond)
+			s.DeleteExpired()
+		}
+		if item.Int == 100 {
+			t.Error("Must dump the value as of the start", key)
+		}
+		dumped++
+		return nil
+	})
+	if err != nil || dumped != 10 {
+		t.Fatal("Must dump every key past the snapshot timeout", dumped, err)
+	}
+	if len(s.snapshots) != 0 || len(s.history) != 0 {
+		t.Error("Must release the snapshot of the dump", len(s.snapshots))
+	}
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+// Bits are addressed MSB first: offset 0 is the highest bit of the first byte.
+const maxBitOffset = 1<<32 - 1
+
+type BitOperation string
+
+const (
+	BitAnd BitOperation = "AND"
+	BitOr  BitOperation = "OR"
+	BitXor BitOperation = "XOR"
+	BitNot BitOperation = "NOT"
+)
+
+var (
+	ErrWrongType      = errors.New("Operation against a key holding the wrong kind of value")
+	ErrBitOffset      = errors.New("Bit offset is not an integer or out of range")
+	ErrBitValue       = errors.New("Bit is not an integer or out of range")
+	ErrUnknownBitOp   = errors.New("Unknown bit operation")
+	ErrBitOpNotSource = errors.New("BITOP NOT must be called with a single source key")
+)
+
+func (item *Item) isBitmap() bool {
+	return item.Bitmap != nil
+}
+
+func (s *Storage) bitmapItem(key string) (*Item, error) {
+	item, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item = item.unpack()
+	if !item.isBitmap() {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+func (s *Storage) SetBit(key string, offset, value int) (int, error) {
+	if offset < 0 || offset > maxBitOffset {
+		return 0, ErrBitOffset
+	}
+	if value != 0 && value != 1 {
+		return 0, ErrBitValue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.limits.CheckKey(key); err != nil {
+		return 0, err
+	}
+	if err := s.limits.checkSize(offset/8 + 1); err != nil {
+		return 0, err
+	}
+	item, err := s.bitmapItem(key)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		item = NewItem(0)
+		item.Bitmap = []byte{}
+	} else {
+		item = s.writableLocked(item)
+	}
+
+	byteIdx := offset / 8
+	if byteIdx >= len(item.Bitmap) {
+		grown := make([]byte, byteIdx+1)
+		copy(grown, item.Bitmap)
+		item.Bitmap = grown
+	}
+
+	mask := byte(1 << uint(7-offset%8))
+	old := 0
+	if item.Bitmap[byteIdx]&mask != 0 {
+		old = 1
+	}
+	if value == 1 {
+		item.Bitmap[byteIdx] |= mask
+	} else {
+		item.Bitmap[byteIdx] &^= mask
+	}
+	s.updateLocked(key, item)
+	return old, nil
+}
+
+func (s *Storage) GetBit(key string, offset int) (int, error) {
+	if offset < 0 || offset > maxBitOffset {
+		return 0, ErrBitOffset
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.bitmapItem(key)
+	if err != nil || item == nil {
+		return 0, err
+	}
+	item.touch(time.Now().UnixNano())
+	byteIdx := offset / 8
+	if byteIdx >= len(item.Bitmap) {
+		return 0, nil
+	}
+	if item.Bitmap[byteIdx]&byte(1<<uint(7-offset%8)) != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// byteRange converts an inclusive start/end pair, where negative values count
+// from the end, into a half-open range over a bitmap of size n.
+func byteRange(start, end, n int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end {
+		return 0, 0
+	}
+	return start, end + 1
+}
+
+// BitCount counts set bits in the bytes from start to end inclusive.
+// Use 0, -1 to count the whole bitmap.
+func (s *Storage) BitCount(key string, start, end int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.bitmapItem(key)
+	if err != nil || item == nil {
+		return 0, err
+	}
+	item.touch(time.Now().UnixNano())
+	from, to := byteRange(start, end, len(item.Bitmap))
+	count := 0
+	for _, b := range item.Bitmap[from:to] {
+		count += bits.OnesCount8(b)
+	}
+	return count, nil
+}
+
+// BitPos returns the offset of the first bit equal to bit in the bytes from
+// start to end inclusive, or -1 if there is none. When looking for a clear
+// bit up to the end of the bitmap (end == -1) the bits past the end count as
+// clear, so the offset right after the bitmap is returned.
+func (s *Storage) BitPos(key string, bit, start, end int) (int, error) {
+	if bit != 0 && bit != 1 {
+		return 0, ErrBitValue
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.bitmapItem(key)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		if bit == 0 {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	item.touch(time.Now().UnixNano())
+
+	from, to := byteRange(start, end, len(item.Bitmap))
+	for i := from; i < to; i++ {
+		b := item.Bitmap[i]
+		if bit == 0 {
+			b = ^b
+		}
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b), nil
+		}
+	}
+	if bit == 0 && end == -1 && from < to {
+		return to * 8, nil
+	}
+	return -1, nil
+}
+
+// BitOp stores the result of op over the source bitmaps in dest and returns
+// the size of the result in bytes. Missing keys are treated as empty bitmaps.
+func (s *Storage) BitOp(op BitOperation, dest string, keys ...string) (int, error) {
+	switch op {
+	case BitAnd, BitOr, BitXor:
+		if len(keys) == 0 {
+			return 0, fmt.Errorf("BITOP %s requires at least one source key", op)
+		}
+	case BitNot:
+		if len(keys) != 1 {
+			return 0, ErrBitOpNotSource
+		}
+	default:
+		return 0, ErrUnknownBitOp
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := make([][]byte, len(keys))
+	size := 0
+	for i, k := range keys {
+		item, err := s.bitmapItem(k)
+		if err != nil {
+			return 0, err
+		}
+		if item != nil {
+			item.touch(time.Now().UnixNano())
+			sources[i] = item.Bitmap
+		}
+		if len(sources[i]) > size {
+			size = len(sources[i])
+		}
+	}
+
+	if size == 0 {
+		s.deleteLocked(dest)
+		return 0, nil
+	}
+	if err := s.limits.CheckKey(dest); err != nil {
+		return 0, err
+	}
+	if err := s.limits.checkSize(size); err != nil {
+		return 0, err
+	}
+
+	result := make([]byte, size)
+	copy(result, sources[0])
+	switch op {
+	case BitNot:
+		for i := range result {
+			result[i] = ^result[i]
+		}
+	default:
+		for _, src := range sources[1:] {
+			for i := range result {
+				var b byte
+				if i < len(src) {
+					b = src[i]
+				}
+				switch op {
+				case BitAnd:
+					result[i] &= b
+				case BitOr:
+					result[i] |= b
+				case BitXor:
+					result[i] ^= b
+				}
+			}
+		}
+	}
+
+	item := NewItem(0)
+	item.Bitmap = result
+	s.putLocked(dest, item)
+	return size, nil
+}
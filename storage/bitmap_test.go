@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestStorage_SetBit(t *testing.T) {
+	s := New()
+
+	old, err := s.SetBit("key", 7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != 0 {
+		t.Error("Must be equal 0", old)
+	}
+	item := s.GetItem("key")
+	if item == nil || len(item.Bitmap) != 1 || item.Bitmap[0] != 0x01 {
+		t.Fatal("Must contains bitmap 0x01", item)
+	}
+
+	old, _ = s.SetBit("key", 7, 0)
+	if old != 1 {
+		t.Error("Must be equal 1", old)
+	}
+
+	s.SetBit("key", 100, 1)
+	if len(s.GetItem("key").Bitmap) != 13 {
+		t.Error("Must grow to 13 bytes", len(s.GetItem("key").Bitmap))
+	}
+
+	if _, err := s.SetBit("key", -1, 1); err != ErrBitOffset {
+		t.Error("Must return ErrBitOffset", err)
+	}
+	if _, err := s.SetBit("key", 1, 2); err != ErrBitValue {
+		t.Error("Must return ErrBitValue", err)
+	}
+
+	s.SetString("str", "val", 0)
+	if _, err := s.SetBit("str", 1, 1); err != ErrWrongType {
+		t.Error("Must return ErrWrongType", err)
+	}
+}
+
+func TestStorage_GetBit(t *testing.T) {
+	s := New()
+	s.SetBit("key", 3, 1)
+
+	for offset, want := range map[int]int{0: 0, 3: 1, 4: 0, 1000: 0} {
+		v, err := s.GetBit("key", offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Error("Must be equal", offset, v, want)
+		}
+	}
+
+	if v, _ := s.GetBit("missing", 3); v != 0 {
+		t.Error("Must be equal 0", v)
+	}
+}
+
+func TestStorage_BitCount(t *testing.T) {
+	s := New()
+	for _, offset := range []int{0, 1, 9, 17, 23} {
+		s.SetBit("key", offset, 1)
+	}
+
+	cases := []struct {
+		start, end, want int
+	}{
+		{0, -1, 5},
+		{0, 0, 2},
+		{1, 1, 1},
+		{-1, -1, 2},
+		{2, 1, 0},
+		{-100, 100, 5},
+	}
+	for _, c := range cases {
+		n, err := s.BitCount("key", c.start, c.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != c.want {
+			t.Error("Must be equal", c.start, c.end, n, c.want)
+		}
+	}
+}
+
+func TestStorage_BitPos(t *testing.T) {
+	s := New()
+	s.SetBit("key", 10, 1)
+
+	if pos, _ := s.BitPos("key", 1, 0, -1); pos != 10 {
+		t.Error("Must be equal 10", pos)
+	}
+	if pos, _ := s.BitPos("key", 0, 0, -1); pos != 0 {
+		t.Error("Must be equal 0", pos)
+	}
+	if pos, _ := s.BitPos("key", 1, 0, 0); pos != -1 {
+		t.Error("Must be equal -1", pos)
+	}
+
+	s.SetBit("ones", 7, 1)
+	for i := 0; i < 8; i++ {
+		s.SetBit("ones", i, 1)
+	}
+	if pos, _ := s.BitPos("ones", 0, 0, -1); pos != 8 {
+		t.Error("Must be equal 8", pos)
+	}
+	if pos, _ := s.BitPos("ones", 0, 0, 0); pos != -1 {
+		t.Error("Must be equal -1", pos)
+	}
+	if pos, _ := s.BitPos("missing", 1, 0, -1); pos != -1 {
+		t.Error("Must be equal -1", pos)
+	}
+}
+
+func TestStorage_BitOp(t *testing.T) {
+	s := New()
+	s.SetBit("a", 0, 1)
+	s.SetBit("a", 1, 1)
+	s.SetBit("b", 1, 1)
+	s.SetBit("b", 8, 1)
+
+	n, err := s.BitOp(BitAnd, "and", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("Must be equal 2", n)
+	}
+	if got := s.GetItem("and").Bitmap; got[0] != 0x40 || got[1] != 0x00 {
+		t.Error("Wrong AND result", got)
+	}
+
+	s.BitOp(BitOr, "or", "a", "b")
+	if got := s.GetItem("or").Bitmap; got[0] != 0xc0 || got[1] != 0x80 {
+		t.Error("Wrong OR result", got)
+	}
+
+	s.BitOp(BitXor, "xor", "a", "b")
+	if got := s.GetItem("xor").Bitmap; got[0] != 0x80 || got[1] != 0x80 {
+		t.Error("Wrong XOR result", got)
+	}
+
+	s.BitOp(BitNot, "not", "a")
+	if got := s.GetItem("not").Bitmap; got[0] != 0x3f {
+		t.Error("Wrong NOT result", got)
+	}
+
+	if _, err := s.BitOp(BitNot, "not", "a", "b"); err != ErrBitOpNotSource {
+		t.Error("Must return ErrBitOpNotSource", err)
+	}
+	if _, err := s.BitOp("NAND", "x", "a"); err != ErrUnknownBitOp {
+		t.Error("Must return ErrUnknownBitOp", err)
+	}
+
+	if n, _ := s.BitOp(BitOr, "or", "missing"); n != 0 {
+		t.Error("Must be equal 0", n)
+	}
+	if s.GetItem("or") != nil {
+		t.Error("Must be removed")
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import "time"
+
+// KeyValue is an entry of MSet. The item is created with NewItem, so it
+// carries its own ttl.
+type KeyValue struct {
+	Key  string
+	Item *Item
+}
+
+// MGet returns copies of the items stored under keys, with nil for missing
+// or expired keys.
+func (s *Storage) MGet(keys []string) ([]*Item, error) {
+	now := time.Now().UnixNano()
+	items := make([]*Item, len(keys))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i, key := range keys {
+		item := s.liveLocked(key, now)
+		item.touch(now)
+		items[i] = item.copyOut()
+	}
+	return items, nil
+}
+
+// MSet stores all entries at once, later entries winning for repeated keys.
+// With nx nothing is stored and false is returned if any key exists. Nothing
+// is stored either if any entry exceeds the limits.
+func (s *Storage) MSet(entries []KeyValue, nx bool) (bool, error) {
+	items := make([]*Item, len(entries))
+	for i, e := range entries {
+		items[i] = e.Item.clone()
+	}
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range entries {
+		if err := s.limits.Check(e.Key, items[i]); err != nil {
+			return false, err
+		}
+	}
+	if nx {
+		for _, e := range entries {
+			if s.liveLocked(e.Key, now) != nil {
+				return false, nil
+			}
+		}
+	}
+	for i, e := range entries {
+		s.putLocked(e.Key, items[i])
+	}
+	return true, nil
+}
+
+// MDel removes keys at once and reports which of them existed.
+func (s *Storage) MDel(keys []string) ([]bool, error) {
+	now := time.Now().UnixNano()
+	removed := make([]bool, len(keys))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, key := range keys {
+		removed[i] = s.liveLocked(key, now) != nil
+		s.deleteLocked(key)
+	}
+	return removed, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stringItem(value string, ttl int) *Item {
+	item := NewItem(ttl)
+	item.String = value
+	return item
+}
+
+func TestStorage_MGet(t *testing.T) {
+	s := New()
+	s.SetString("a", "1", 0)
+	s.SetIntSlice("b", []int{1, 2}, 0)
+	s.SetString("expired", "x", 0)
+	s.items["expired"].expiration = 1
+
+	items, _ := s.MGet([]string{"a", "missing", "b", "expired", "a"})
+	if len(items) != 5 || items[1] != nil || items[3] != nil {
+		t.Fatal("Must return nil for missing keys", items)
+	}
+	if items[0].String != "1" || items[4].String != "1" || !reflect.DeepEqual(items[2].IntSlice, []int{1, 2}) {
+		t.Error("Must return the values in order", items)
+	}
+	items[2].IntSlice[0] = 100
+	if item, _ := s.Get("b"); item.IntSlice[0] != 1 {
+		t.Error("Must return copies")
+	}
+}
+
+func TestStorage_MSet(t *testing.T) {
+	s := New()
+	ok, _ := s.MSet([]KeyValue{
+		{Key: "a", Item: stringItem("1", 0)},
+		{Key: "b", Item: stringItem("2", 100)},
+		{Key: "a", Item: stringItem("3", 0)},
+	}, false)
+	if !ok {
+		t.Fatal("Must store the entries")
+	}
+	if item, _ := s.Get("a"); item.String != "3" {
+		t.Error("Must be equal 3", item.String)
+	}
+	if ttl, _ := s.TTL("b"); ttl != 100 {
+		t.Error("Must set the ttl of each entry", ttl)
+	}
+
+	ok, _ = s.MSet([]KeyValue{
+		{Key: "c", Item: stringItem("4", 0)},
+		{Key: "b", Item: stringItem("5", 0)},
+	}, true)
+	if ok {
+		t.Error("Must not store anything if a key exists")
+	}
+	if item, _ := s.Get("c"); item != nil {
+		t.Error("Must be all or nothing", item)
+	}
+	if ok, _ := s.MSet([]KeyValue{{Key: "c", Item: stringItem("4", 0)}}, true); !ok {
+		t.Error("Must store new keys")
+	}
+}
+
+func TestStorage_MDel(t *testing.T) {
+	s := New()
+	s.SetString("a", "1", 0)
+	s.SetString("b", "2", 0)
+	removed, _ := s.MDel([]string{"a", "missing", "b", "a"})
+	if !reflect.DeepEqual(removed, []bool{true, false, true, false}) {
+		t.Error("Must report removed keys", removed)
+	}
+	if keys, _ := s.Keys(); len(keys) != 0 {
+		t.Error("Must be empty", keys)
+	}
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Items are encoded for engines keeping them outside of Go memory as
+//
+//	version | kind | expiration (varint) | payload
+//
+// Strings and byte slices are length prefixed with an uvarint, collections
+// start with their uvarint length and maps are written in key order, so the
+// same item always has the same encoding.
+const codecVersion = 1
+
+const (
+	kindString byte = iota + 1
+	kindInt
+	kindStringSlice
+	kindIntSlice
+	kindStringMap
+	kindIntMap
+	kindBitmap
+	kindGeo
+	kindTimeSeries
+	kindCRDT
+)
+
+var ErrCorruptItem = errors.New("Corrupt item encoding")
+
+func (item *Item) kind() byte {
+	switch {
+	case item.packed != nil:
+		return item.packedKind
+	case item.String != "":
+		return kindString
+	case item.StringSlice != nil:
+		return kindStringSlice
+	case item.IntSlice != nil:
+		return kindIntSlice
+	case item.StringMap != nil:
+		return kindStringMap
+	case item.IntMap != nil:
+		return kindIntMap
+	case item.Bitmap != nil:
+		return kindBitmap
+	case item.Geo != nil:
+		return kindGeo
+	case item.TimeSeries != nil:
+		return kindTimeSeries
+	case item.CRDT != nil:
+		return kindCRDT
+	}
+	return kindInt
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (item *Item) MarshalBinary() ([]byte, error) {
+	if item.packed != nil {
+		return item.unpack().MarshalBinary()
+	}
+	kind := item.kind()
+	b := []byte{codecVersion, kind}
+	b = binary.AppendVarint(b, item.expiration)
+
+	switch kind {
+	case kindString:
+		b = appendString(b, item.String)
+	case kindInt:
+		b = binary.AppendVarint(b, int64(item.Int))
+	case kindStringSlice:
+		b = binary.AppendUvarint(b, uint64(len(item.StringSlice)))
+		for _, s := range item.StringSlice {
+			b = appendString(b, s)
+		}
+	case kindIntSlice:
+		b = binary.AppendUvarint(b, uint64(len(item.IntSlice)))
+		for _, i := range item.IntSlice {
+			b = binary.AppendVarint(b, int64(i))
+		}
+	case kindStringMap:
+		b = binary.AppendUvarint(b, uint64(len(item.StringMap)))
+		keys := make([]string, 0, len(item.StringMap))
+		for k := range item.StringMap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = appendString(appendString(b, k), item.StringMap[k])
+		}
+	case kindIntMap:
+		b = binary.AppendUvarint(b, uint64(len(item.IntMap)))
+		keys := make([]string, 0, len(item.IntMap))
+		for k := range item.IntMap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = binary.AppendVarint(appendString(b, k), int64(item.IntMap[k]))
+		}
+	case kindBitmap:
+		b = appendString(b, string(item.Bitmap))
+	case kindGeo:
+		b = binary.AppendUvarint(b, uint64(len(item.Geo.entries)))
+		for _, e := range item.Geo.entries {
+			b = appendString(binary.AppendUvarint(b, e.hash), e.name)
+		}
+	case kindTimeSeries:
+		ts := item.TimeSeries
+		b = binary.AppendVarint(b, ts.retention)
+		b = binary.AppendUvarint(b, uint64(len(ts.rules)))
+		for _, r := range ts.rules {
+			b = appendString(appendString(b, r.Dest), r.Aggregation)
+			b = binary.AppendVarint(b, r.Bucket)
+		}
+		b = binary.AppendUvarint(b, uint64(len(ts.samples)))
+		prev := int64(0)
+		for _, s := range ts.samples {
+			b = binary.AppendUvarint(b, uint64(s.Time-prev))
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.Value))
+			prev = s.Time
+		}
+	case kindCRDT:
+		b = item.CRDT.appendTo(b)
+	}
+	return b, nil
+}
+
+// decoder reads the fields of an encoded item, remembering the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = ErrCorruptItem
+		d.b = nil
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = ErrCorruptItem
+		d.b = nil
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// length reads a collection length, each element taking at least one byte.
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.err = ErrCorruptItem
+		d.b = nil
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) float64() float64 {
+	if len(d.b) < 8 {
+		d.err = ErrCorruptItem
+		d.b = nil
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.length()
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (item *Item) UnmarshalBinary(data []byte) error {
+	if len(data) > 2 && data[0] == codecCompressed {
+		raw, err := decompress(data)
+		if err != nil {
+			return err
+		}
+		data = raw
+	}
+	if len(data) < 2 || data[0] != codecVersion {
+		return ErrCorruptItem
+	}
+	*item = Item{}
+	kind := data[1]
+	d := &decoder{b: data[2:]}
+	item.expiration = d.varint()
+
+	switch kind {
+	case kindString:
+		item.String = d.string()
+	case kindInt:
+		item.Int = int(d.varint())
+		item.containsNil = item.Int == 0
+	case kindStringSlice:
+		n := d.length()
+		item.StringSlice = make([]string, n)
+		for i := range item.StringSlice {
+			item.StringSlice[i] = d.string()
+		}
+	case kindIntSlice:
+		n := d.length()
+		item.IntSlice = make([]int, n)
+		for i := range item.IntSlice {
+			item.IntSlice[i] = int(d.varint())
+		}
+	case kindStringMap:
+		n := d.length()
+		item.StringMap = make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			k := d.string()
+			item.StringMap[k] = d.string()
+		}
+	case kindIntMap:
+		n := d.length()
+		item.IntMap = make(map[string]int, n)
+		for i := 0; i < n; i++ {
+			k := d.string()
+			item.IntMap[k] = int(d.varint())
+		}
+	case kindBitmap:
+		item.Bitmap = []byte(d.string())
+	case kindGeo:
+		n := d.length()
+		item.Geo = newGeoSet()
+		for i := 0; i < n; i++ {
+			hash := d.uvarint()
+			item.Geo.add(d.string(), hash)
+		}
+	case kindTimeSeries:
+		ts := &TimeSeries{retention: d.varint()}
+		ts.rules = make([]TSRule, d.length())
+		for i := range ts.rules {
+			ts.rules[i] = TSRule{Dest: d.string(), Aggregation: d.string(), Bucket: d.varint()}
+		}
+		ts.samples = make([]Sample, d.length())
+		prev := int64(0)
+		for i := range ts.samples {
+			delta := d.uvarint()
+			if i > 0 && delta == 0 || delta > math.MaxInt64-uint64(prev) {
+				d.err = ErrCorruptItem
+			}
+			prev += int64(delta)
+			ts.samples[i] = Sample{Time: prev, Value: d.float64()}
+		}
+		item.TimeSeries = ts
+	case kindCRDT:
+		item.CRDT = d.crdt()
+	default:
+		return ErrCorruptItem
+	}
+	if d.err == nil && len(d.b) > 0 {
+		d.err = ErrCorruptItem
+	}
+	return d.err
+}
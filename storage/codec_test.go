@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestItem_MarshalBinary(t *testing.T) {
+	geo := newGeoSet()
+	geo.add("a", geoEncode(13.36, 38.11))
+	geo.add("b", geoEncode(15.08, 37.50))
+	set, _ := NewCRDT(CRDTORSet)
+	set.add("a", "n1:1")
+	set.add("b", "n1:2")
+	set.remove("b")
+	register, _ := NewCRDT(CRDTLWWRegister)
+	register.value, register.time, register.node = "val", -5, "n1"
+
+	items := []*Item{
+		{String: "val"},
+		{Int: -42},
+		{Int: 0, containsNil: true},
+		{StringSlice: []string{"a", "", "c"}},
+		{IntSlice: []int{1, -2, 1 << 40}},
+		{StringMap: map[string]string{"a": "x", "b": ""}},
+		{IntMap: map[string]int{"a": 1, "b": -1}},
+		{Bitmap: []byte{0x80, 0, 1}},
+		{Geo: geo},
+		{TimeSeries: &TimeSeries{
+			retention: 60000,
+			rules:     []TSRule{{Dest: "ts:1m", Aggregation: "avg", Bucket: 60000}},
+			samples:   []Sample{{Time: 1000, Value: 1.5}, {Time: 2500, Value: -3}},
+		}},
+		{CRDT: set},
+		{CRDT: register},
+		{String: "volatile", expiration: 1700000000000000000},
+	}
+	for _, item := range items {
+		data, err := item.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := new(Item)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal("Must decode", item, err)
+		}
+		if !reflect.DeepEqual(decoded, item) {
+			t.Errorf("Must be equal %+v, got %+v", item, decoded)
+		}
+
+		for i := 0; i < len(data); i++ {
+			if err := new(Item).UnmarshalBinary(data[:i]); err != ErrCorruptItem {
+				t.Error("Must detect truncated data", item, i, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Compressed items are encoded as
+//
+//	codecCompressed | algorithm | raw length (uvarint) | data
+//
+// where data is the compressed plain encoding of the item.
+const codecCompressed = 2
+
+const DefaultCompressionThreshold = 4096
+
+// packIdle is how long an item updated in place stays unpacked after its
+// last update.
+const packIdle = 5 * time.Second
+
+var ErrCompression = errors.New("Unsupported compression algorithm")
+
+var compressionAlgorithms = []string{"none", "flate", "gzip", "zlib"}
+
+// Compression selects how large values are compressed. Algorithm is one of
+// none, flate, gzip or zlib, "" means none. Level is a compress/flate level,
+// 0 picks the default one. Values whose plain encoding has at least
+// Threshold bytes are compressed, 0 means DefaultCompressionThreshold.
+type Compression struct {
+	Algorithm string
+	Level     int
+	Threshold int
+}
+
+func (c Compression) algorithm() byte {
+	for i, name := range compressionAlgorithms {
+		if name == c.Algorithm {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+func (c Compression) Validate() error {
+	if c.Algorithm != "" && c.algorithm() == 0 && c.Algorithm != "none" {
+		return fmt.Errorf("%v: %s", ErrCompression, c.Algorithm)
+	}
+	if c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+		return fmt.Errorf("Compression level must be between %d and %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+	return nil
+}
+
+func (c Compression) enabled() bool {
+	return c.algorithm() != 0
+}
+
+func (c Compression) threshold() int {
+	if c.Threshold <= 0 {
+		return DefaultCompressionThreshold
+	}
+	return c.Threshold
+}
+
+func compressor(algorithm byte, w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	switch compressionAlgorithms[algorithm] {
+	case "flate":
+		return flate.NewWriter(w, level)
+	case "gzip":
+		return gzip.NewWriterLevel(w, level)
+	case "zlib":
+		return zlib.NewWriterLevel(w, level)
+	}
+	return nil, ErrCompression
+}
+
+func decompressor(algorithm byte, r io.Reader) (io.ReadCloser, error) {
+	if int(algorithm) >= len(compressionAlgorithms) {
+		return nil, ErrCorruptItem
+	}
+	switch compressionAlgorithms[algorithm] {
+	case "flate":
+		return flate.NewReader(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zlib":
+		return zlib.NewReader(r)
+	}
+	return nil, ErrCorruptItem
+}
+
+// Encode returns the binary encoding of item, compressed when it is large
+// enough and compression makes it smaller.
+func (c Compression) Encode(item *Item) ([]byte, error) {
+	data, err := item.MarshalBinary()
+	if err != nil || !c.enabled() || len(data) < c.threshold() {
+		return data, err
+	}
+
+	buf := bytes.NewBuffer([]byte{codecCompressed, c.algorithm()})
+	buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	w, err := compressor(c.algorithm(), buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	d := &decoder{b: data[2:]}
+	size := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	r, err := decompressor(data[1], bytes.NewReader(d.b))
+	if err != nil {
+		return nil, ErrCorruptItem
+	}
+	defer r.Close()
+	raw, err := io.ReadAll(io.LimitReader(r, int64(size)+1))
+	if err != nil || uint64(len(raw)) != size {
+		return nil, ErrCorruptItem
+	}
+	return raw, nil
+}
+
+type CompressionStats struct {
+	Algorithm string
+	RawSize   int // bytes of the plain encoding
+	Size      int // bytes stored
+}
+
+// EncodingStats describes an item encoded by Compression.Encode.
+func EncodingStats(data []byte) CompressionStats {
+	stats := CompressionStats{Algorithm: "none", RawSize: len(data), Size: len(data)}
+	if len(data) > 2 && data[0] == codecCompressed && int(data[1]) < len(compressionAlgorithms) {
+		d := &decoder{b: data[2:]}
+		if size := d.uvarint(); d.err == nil {
+			stats.Algorithm = compressionAlgorithms[data[1]]
+			stats.RawSize = int(size)
+		}
+	}
+	return stats
+}
+
+// SetCompression changes how values written from now on are compressed.
+func (s *Storage) SetCompression(c Compression) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.compression = c
+	s.mu.Unlock()
+	return nil
+}
+
+// packLocked replaces a large item by its compressed encoding. s.mu must be
+// held.
+func (s *Storage) packLocked(item *Item) *Item {
+	if !s.compression.enabled() || item.packed != nil {
+		return item
+	}
+	data, err := s.compression.Encode(item)
+	if err != nil || data[0] != codecCompressed {
+		return item
+	}
+	return &Item{packed: data, packedKind: item.kind(), expiration: item.expiration}
+}
+
+// packIdleLocked packs the items updated in place that stayed idle for
+// packIdle. s.mu must be held.
+func (s *Storage) packIdleLocked(now int64) {
+	for key := range s.hot {
+		item := s.items[key]
+		if now-item.updated < int64(packIdle) {
+			continue
+		}
+		delete(s.hot, key)
+		packed := s.packLocked(item)
+		if packed == item {
+			continue
+		}
+		packed.rev, packed.created, packed.updated, packed.access = item.rev, item.created, item.updated, item.access
+		packed.size = memorySize(key, packed)
+		s.accountLocked(item, -1)
+		s.accountLocked(packed, 1)
+		s.items[key] = packed
+	}
+}
+
+// unpack returns the item with its value decoded. Compressed items are
+// decoded into a new item on every call.
+func (item *Item) unpack() *Item {
+	if item == nil || item.packed == nil {
+		return item
+	}
+	u := new(Item)
+	if err := u.UnmarshalBinary(item.packed); err != nil {
+		// Items are only packed by packLocked, which just encoded them
+		panic(err)
+	}
+	u.expiration, u.rev = item.expiration, item.rev
+	u.created, u.updated, u.access = item.created, item.updated, item.access
+	return u
+}
+
+// CompressionStats returns how the value of key is stored, or nil if the
+// key does not exist. The lock is held while the item is encoded, as
+// in place updates change it under the write lock.
+func (s *Storage) CompressionStats(key string) (*CompressionStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item := s.items[key]
+	if item == nil {
+		return nil, nil
+	}
+	if item.packed != nil {
+		stats := EncodingStats(item.packed)
+		return &stats, nil
+	}
+	data, err := item.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	stats := EncodingStats(data)
+	return &stats, nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func repetitive(n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = strings.Repeat("lorem ipsum ", 10)
+	}
+	return values
+}
+
+func TestCompression_Encode(t *testing.T) {
+	item := &Item{StringSlice: repetitive(200), expiration: 1700000000000000000}
+	plain, _ := item.MarshalBinary()
+
+	for _, algorithm := range []string{"flate", "gzip", "zlib"} {
+		c := Compression{Algorithm: algorithm, Level: 9, Threshold: 1024}
+		data, err := c.Encode(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := EncodingStats(data)
+		if stats.Algorithm != algorithm || stats.RawSize != len(plain) || stats.Size >= len(plain)/10 {
+			t.Errorf("%s: must compress, got %+v", algorithm, stats)
+		}
+		decoded := new(Item)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal("Must decode", algorithm, err)
+		}
+		if !reflect.DeepEqual(decoded, item) {
+			t.Error("Must be equal", algorithm)
+		}
+		data[len(data)/2] ^= 0xff
+		if err := new(Item).UnmarshalBinary(data); err != ErrCorruptItem {
+			t.Error("Must detect corrupt data", algorithm, err)
+		}
+	}
+
+	c := Compression{Algorithm: "flate", Threshold: len(plain) + 1}
+	if data, _ := c.Encode(item); !reflect.DeepEqual(data, plain) {
+		t.Error("Must not compress values below the threshold")
+	}
+	random := &Item{Bitmap: []byte("\x8f\x12\xa9\x03\x77\xe1\x5c\x40")}
+	c = Compression{Algorithm: "flate", Threshold: 1}
+	if data, _ := c.Encode(random); data[0] != codecVersion {
+		t.Error("Must not compress when it does not save space")
+	}
+}
+
+func TestCompression_Validate(t *testing.T) {
+	for _, c := range []Compression{{}, {Algorithm: "none"}, {Algorithm: "zlib", Level: -2}} {
+		if err := c.Validate(); err != nil {
+			t.Error("Must be valid", c, err)
+		}
+	}
+	for _, c := range []Compression{{Algorithm: "lz4"}, {Algorithm: "flate", Level: 10}} {
+		if err := c.Validate(); err == nil {
+			t.Error("Must be invalid", c)
+		}
+	}
+}
+
+func TestStorage_Compression(t *testing.T) {
+	s := New()
+	if err := s.SetCompression(Compression{Algorithm: "flate", Threshold: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	values := repetitive(100)
+	dict := map[string]string{"name": "alice", "bio": strings.Repeat("x", 4096)}
+	s.SetStringSlice("list", values, 0)
+	s.SetStringMap("user:1", dict, 0)
+	s.SetString("small", "value", 0)
+
+	if s.items["list"].packed == nil || s.items["small"].packed != nil {
+		t.Fatal("Must compress only large values")
+	}
+	if item, _ := s.Get("list"); item == nil || !reflect.DeepEqual(item.StringSlice, values) {
+		t.Error("Must decompress on read")
+	}
+	stats, _ := s.CompressionStats("list")
+	if stats == nil || stats.Algorithm != "flate" || stats.Size >= stats.RawSize {
+		t.Error("Must report compression", stats)
+	}
+	if stats, _ := s.CompressionStats("small"); stats == nil || stats.Algorithm != "none" {
+		t.Error("Must report uncompressed values", stats)
+	}
+	if stats, _ := s.CompressionStats("missing"); stats != nil {
+		t.Error("Must be nil", stats)
+	}
+
+	s.CreateIndex(IndexSpec{Name: "name", Prefix: "user:", Field: "name"})
+	if keys, _ := s.Lookup("name", "alice"); !reflect.DeepEqual(keys, []string{"user:1"}) {
+		t.Error("Must index compressed values", keys)
+	}
+
+	snap := s.OpenSnapshot()
+	s.Expire("list", 100)
+	if ttl, _ := s.TTL("list"); ttl != 100 {
+		t.Error("Must be equal 100", ttl)
+	}
+	if item, _ := snap.GetItem("list"); item == nil || item.Expiration() != 0 || len(item.StringSlice) != 100 {
+		t.Error("Must keep the old version in the snapshot", item)
+	}
+	if item, _ := s.Get("list"); item == nil || item.Expiration() == 0 || len(item.StringSlice) != 100 {
+		t.Error("Must keep the value after Expire", item)
+	}
+}
+
+func TestStorage_Compression_Bitmap(t *testing.T) {
+	s := New()
+	s.SetCompression(Compression{Algorithm: "zlib", Threshold: 64})
+	s.SetBit("bits", 8000, 1)
+	s.SetBit("bits", 10, 1)
+	if s.items["bits"].packed != nil {
+		t.Fatal("Must not compress a bitmap being updated")
+	}
+
+	s.items["bits"].updated -= int64(packIdle)
+	s.DeleteExpired()
+	if s.items["bits"].packed == nil || len(s.hot) != 0 {
+		t.Fatal("Must compress the bitmap once idle")
+	}
+	if count, _ := s.BitCount("bits", 0, -1); count != 2 {
+		t.Error("Must be equal 2", count)
+	}
+	total := s.memory.total
+	s.SetBit("bits", 20, 1)
+	if s.items["bits"].packed != nil || !s.hot["bits"] || s.memory.total <= total {
+		t.Error("Must unpack the bitmap updated again")
+	}
+	if count, _ := s.BitCount("bits", 0, -1); count != 3 {
+		t.Error("Must be equal 3", count)
+	}
+}
+
+// TestStorage_CompressionStats_Concurrent reads the stats of a bitmap
+// updated in place meanwhile; run it with -race.
+func TestStorage_CompressionStats_Concurrent(t *testing.T) {
+	s := New()
+	s.SetCompression(Compression{Algorithm: "zlib", Threshold: 64})
+	s.SetBit("bits", 0, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s.SetBit("bits", i*8, 1)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if stats, err := s.CompressionStats("bits"); err != nil || stats == nil {
+			t.Fatal("Must return the stats", err)
+		}
+	}
+	<-done
+	if count, _ := s.BitCount("bits", 0, -1); count != 1000 {
+		t.Error("Must be equal 1000", count)
+	}
+}
@@ -0,0 +1,512 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CRDTs are values that replicas change independently and merge later in
+// any order, any number of times, converging to the same state. Every
+// replica writes under its own node id, see SetNodeID.
+//
+//   - a G-Counter keeps a count per node and only grows
+//   - a PN-Counter keeps increments and decrements per node
+//   - an OR-Set tags every add with a unique tag; a remove drops the tags
+//     the replica has seen, so an add concurrent to a remove wins. Removed
+//     tags are kept to merge with replicas that still have them
+//   - an LWW-Register keeps the value written last by time, then node id
+//
+// A merge takes the maximum of every count, the union of tags and the
+// latest register write.
+const (
+	CRDTGCounter    = "gcounter"
+	CRDTPNCounter   = "pncounter"
+	CRDTORSet       = "orset"
+	CRDTLWWRegister = "lwwregister"
+
+	crdtVersion = 1
+)
+
+var crdtTypes = []string{"", CRDTGCounter, CRDTPNCounter, CRDTORSet, CRDTLWWRegister}
+
+var (
+	ErrCRDTType     = errors.New("Unsupported CRDT type. Please use gcounter, pncounter, orset or lwwregister")
+	ErrCRDTMismatch = errors.New("CRDT types differ")
+	ErrCRDTNegative = errors.New("A gcounter can only be incremented")
+	ErrCRDTOp       = errors.New("Operation is not supported by this CRDT type")
+	ErrNodeID       = errors.New("Node id must not be empty")
+)
+
+type CRDT struct {
+	typ byte // index in crdtTypes
+
+	p map[string]uint64 // increments per node of counters
+	n map[string]uint64 // decrements per node of pncounters
+
+	adds    map[string]map[string]bool // live tags per member of orsets
+	removed map[string]bool            // removed tags of orsets
+
+	value string // lwwregister write
+	time  int64
+	node  string
+}
+
+// NewCRDT returns an empty CRDT of type typ.
+func NewCRDT(typ string) (*CRDT, error) {
+	for i, t := range crdtTypes {
+		if i > 0 && t == typ {
+			return &CRDT{
+				typ:     byte(i),
+				p:       make(map[string]uint64),
+				n:       make(map[string]uint64),
+				adds:    make(map[string]map[string]bool),
+				removed: make(map[string]bool),
+			}, nil
+		}
+	}
+	return nil, ErrCRDTType
+}
+
+func (c *CRDT) Type() string {
+	return crdtTypes[c.typ]
+}
+
+// Value returns the value of the CRDT: an int for counters, the sorted
+// members of an orset and the string of a register.
+func (c *CRDT) Value() interface{} {
+	switch crdtTypes[c.typ] {
+	case CRDTORSet:
+		members := make([]string, 0, len(c.adds))
+		for m := range c.adds {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		return members
+	case CRDTLWWRegister:
+		return c.value
+	}
+	var v uint64
+	for _, p := range c.p {
+		v += p
+	}
+	for _, n := range c.n {
+		v -= n
+	}
+	return int(int64(v))
+}
+
+// Len returns the number of nodes of counters and of members of orsets.
+func (c *CRDT) Len() int {
+	return len(c.p) + len(c.n) + len(c.adds)
+}
+
+func (c *CRDT) clone() *CRDT {
+	cp := *c
+	cp.p = make(map[string]uint64, len(c.p))
+	for k, v := range c.p {
+		cp.p[k] = v
+	}
+	cp.n = make(map[string]uint64, len(c.n))
+	for k, v := range c.n {
+		cp.n[k] = v
+	}
+	cp.adds = make(map[string]map[string]bool, len(c.adds))
+	for m, tags := range c.adds {
+		cp.adds[m] = make(map[string]bool, len(tags))
+		for t := range tags {
+			cp.adds[m][t] = true
+		}
+	}
+	cp.removed = make(map[string]bool, len(c.removed))
+	for t := range c.removed {
+		cp.removed[t] = true
+	}
+	return &cp
+}
+
+func (c *CRDT) increment(node string, delta int64) error {
+	typ := c.Type()
+	switch {
+	case typ != CRDTGCounter && typ != CRDTPNCounter:
+		return ErrCRDTOp
+	case typ == CRDTGCounter && delta < 0:
+		return ErrCRDTNegative
+	case delta >= 0:
+		c.p[node] += uint64(delta)
+	default:
+		c.n[node] += uint64(-delta)
+	}
+	return nil
+}
+
+// add adds member under tag and reports whether it was missing.
+func (c *CRDT) add(member, tag string) bool {
+	tags, ok := c.adds[member]
+	if !ok {
+		tags = make(map[string]bool)
+		c.adds[member] = tags
+	}
+	tags[tag] = true
+	return !ok
+}
+
+// remove drops member with the tags seen so far and reports whether it
+// was present.
+func (c *CRDT) remove(member string) bool {
+	tags, ok := c.adds[member]
+	for t := range tags {
+		c.removed[t] = true
+	}
+	delete(c.adds, member)
+	return ok
+}
+
+// later reports whether the register write (time, node, value) wins over
+// the one of c.
+func (c *CRDT) later(time int64, node, value string) bool {
+	if time != c.time {
+		return time > c.time
+	}
+	if node != c.node {
+		return node > c.node
+	}
+	return value > c.value
+}
+
+// Merge merges the state of o into c.
+func (c *CRDT) Merge(o *CRDT) error {
+	if c.typ != o.typ {
+		return ErrCRDTMismatch
+	}
+	for k, v := range o.p {
+		if cur, ok := c.p[k]; !ok || v > cur {
+			c.p[k] = v
+		}
+	}
+	for k, v := range o.n {
+		if cur, ok := c.n[k]; !ok || v > cur {
+			c.n[k] = v
+		}
+	}
+	for t := range o.removed {
+		c.removed[t] = true
+	}
+	for m, tags := range o.adds {
+		for t := range tags {
+			c.add(m, t)
+		}
+	}
+	for m, tags := range c.adds {
+		for t := range tags {
+			if c.removed[t] {
+				delete(tags, t)
+			}
+		}
+		if len(tags) == 0 {
+			delete(c.adds, m)
+		}
+	}
+	if c.later(o.time, o.node, o.value) {
+		c.value, c.time, c.node = o.value, o.time, o.node
+	}
+	return nil
+}
+
+func appendCounts(b []byte, counts map[string]uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(len(counts)))
+	for _, k := range sortedKeys(counts) {
+		b = binary.AppendUvarint(appendString(b, k), counts[k])
+	}
+	return b
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func appendTags(b []byte, tags map[string]bool) []byte {
+	sorted := make([]string, 0, len(tags))
+	for t := range tags {
+		sorted = append(sorted, t)
+	}
+	sort.Strings(sorted)
+	b = binary.AppendUvarint(b, uint64(len(sorted)))
+	for _, t := range sorted {
+		b = appendString(b, t)
+	}
+	return b
+}
+
+// appendTo appends the state of c, the same state always having the same
+// encoding.
+func (c *CRDT) appendTo(b []byte) []byte {
+	b = append(b, c.typ)
+	b = appendCounts(b, c.p)
+	b = appendCounts(b, c.n)
+	members := make([]string, 0, len(c.adds))
+	for m := range c.adds {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	b = binary.AppendUvarint(b, uint64(len(members)))
+	for _, m := range members {
+		b = appendTags(appendString(b, m), c.adds[m])
+	}
+	b = appendTags(b, c.removed)
+	b = appendString(b, c.value)
+	b = binary.AppendVarint(b, c.time)
+	return appendString(b, c.node)
+}
+
+func (d *decoder) counts() map[string]uint64 {
+	n := d.length()
+	counts := make(map[string]uint64, n)
+	for i := 0; i < n; i++ {
+		k := d.string()
+		counts[k] = d.uvarint()
+	}
+	return counts
+}
+
+func (d *decoder) tags() map[string]bool {
+	n := d.length()
+	tags := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		tags[d.string()] = true
+	}
+	return tags
+}
+
+func (d *decoder) crdt() *CRDT {
+	if len(d.b) == 0 || d.b[0] == 0 || int(d.b[0]) >= len(crdtTypes) {
+		d.err = ErrCorruptItem
+		d.b = nil
+		return nil
+	}
+	c := &CRDT{typ: d.b[0]}
+	d.b = d.b[1:]
+	c.p = d.counts()
+	c.n = d.counts()
+	n := d.length()
+	c.adds = make(map[string]map[string]bool, n)
+	for i := 0; i < n; i++ {
+		m := d.string()
+		c.adds[m] = d.tags()
+	}
+	c.removed = d.tags()
+	c.value = d.string()
+	c.time = d.varint()
+	c.node = d.string()
+	return c
+}
+
+// MarshalBinary exports the state of c, to be merged by another replica
+// after ParseCRDT.
+func (c *CRDT) MarshalBinary() ([]byte, error) {
+	return c.appendTo([]byte{crdtVersion}), nil
+}
+
+// ParseCRDT decodes a state exported with MarshalBinary.
+func ParseCRDT(data []byte) (*CRDT, error) {
+	if len(data) < 1 || data[0] != crdtVersion {
+		return nil, ErrCorruptItem
+	}
+	d := &decoder{b: data[1:]}
+	c := d.crdt()
+	if d.err == nil && len(d.b) > 0 {
+		d.err = ErrCorruptItem
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return c, nil
+}
+
+func newNodeID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// SetNodeID sets the id the storage writes CRDTs under. It must be unique
+// among the replicas, and not be reused by a replica that lost its data,
+// since its counts and tags would start over. By default it is random.
+func (s *Storage) SetNodeID(id string) error {
+	if id == "" {
+		return ErrNodeID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.node = id
+	return nil
+}
+
+// crdtLocked returns a writable copy of the CRDT of key, a new one of type
+// typ if key does not exist.
+func (s *Storage) crdtLocked(key, typ string, now int64) (*Item, error) {
+	item := s.liveLocked(key, now)
+	if item == nil {
+		if err := s.limits.CheckKey(key); err != nil {
+			return nil, err
+		}
+		c, err := NewCRDT(typ)
+		if err != nil {
+			return nil, err
+		}
+		item = NewItem(0)
+		item.CRDT = c
+		return item, nil
+	}
+	item = item.unpack()
+	if item.CRDT == nil {
+		return nil, ErrWrongType
+	}
+	if item.CRDT.Type() != typ {
+		return nil, ErrCRDTMismatch
+	}
+	return item.clone(), nil
+}
+
+// putCRDTLocked stores item after checking the limits.
+func (s *Storage) putCRDTLocked(key string, item *Item) error {
+	if err := s.limits.Check(key, item); err != nil {
+		return err
+	}
+	s.updateLocked(key, item)
+	return nil
+}
+
+// CRDTIncrement adds delta to the gcounter or pncounter of key, creating
+// it as a typ if needed, and returns the new value.
+func (s *Storage) CRDTIncrement(key, typ string, delta int64) (int, error) {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.crdtLocked(key, typ, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := item.CRDT.increment(s.node, delta); err != nil {
+		return 0, err
+	}
+	if err := s.putCRDTLocked(key, item); err != nil {
+		return 0, err
+	}
+	return item.CRDT.Value().(int), nil
+}
+
+// CRDTAdd adds members to the orset of key and returns the number of new
+// ones.
+func (s *Storage) CRDTAdd(key string, members ...string) (int, error) {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.crdtLocked(key, CRDTORSet, now)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		s.crdtTag++
+		if item.CRDT.add(m, s.node+":"+strconv.FormatUint(s.crdtTag, 36)) {
+			added++
+		}
+	}
+	if err := s.putCRDTLocked(key, item); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// CRDTRemove removes members from the orset of key and returns the number
+// of removed ones. Adds of other replicas not merged yet are not removed.
+func (s *Storage) CRDTRemove(key string, members ...string) (int, error) {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.crdtLocked(key, CRDTORSet, now)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, m := range members {
+		if item.CRDT.remove(m) {
+			removed++
+		}
+	}
+	if err := s.putCRDTLocked(key, item); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// CRDTSet writes value to the lwwregister of key. The write is timestamped
+// with the current time, or just after the current write if the clock is
+// behind it, so it always replaces the local value.
+func (s *Storage) CRDTSet(key, value string) error {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.crdtLocked(key, CRDTLWWRegister, now)
+	if err != nil {
+		return err
+	}
+	c := item.CRDT
+	t := now
+	if t <= c.time {
+		t = c.time + 1
+	}
+	c.value, c.time, c.node = value, t, s.node
+	return s.putCRDTLocked(key, item)
+}
+
+// CRDTGet returns a copy of the CRDT of key, or nil if it does not exist.
+func (s *Storage) CRDTGet(key string) (*CRDT, error) {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item := s.liveLocked(key, now)
+	if item == nil {
+		return nil, nil
+	}
+	item.touch(now)
+	item = item.unpack()
+	if item.CRDT == nil {
+		return nil, ErrWrongType
+	}
+	return item.CRDT.clone(), nil
+}
+
+// CRDTMerge merges the state of another replica into the CRDT of key,
+// storing it as is if key does not exist.
+func (s *Storage) CRDTMerge(key string, c *CRDT) error {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.crdtLocked(key, c.Type(), now)
+	if err != nil {
+		return err
+	}
+	if err := item.CRDT.Merge(c); err != nil {
+		return err
+	}
+	return s.putCRDTLocked(key, item)
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestStorage_CRDTCounters(t *testing.T) {
+	s1, s2 := New(), New()
+	s1.SetNodeID("n1")
+	s2.SetNodeID("n2")
+
+	s1.CRDTIncrement("visits", CRDTGCounter, 3)
+	s2.CRDTIncrement("visits", CRDTGCounter, 2)
+	if v, _ := s1.CRDTIncrement("visits", CRDTGCounter, 1); v != 4 {
+		t.Error("Must count the local increments", v)
+	}
+	if _, err := s1.CRDTIncrement("visits", CRDTGCounter, -1); err != ErrCRDTNegative {
+		t.Error("Must return ErrCRDTNegative", err)
+	}
+	if _, err := s1.CRDTIncrement("visits", CRDTPNCounter, 1); err != ErrCRDTMismatch {
+		t.Error("Must return ErrCRDTMismatch", err)
+	}
+
+	c, _ := s2.CRDTGet("visits")
+	s1.CRDTMerge("visits", c)
+	s1.CRDTMerge("visits", c)
+	c, _ = s1.CRDTGet("visits")
+	if v := c.Value(); v != 6 {
+		t.Error("Must sum the counts of both nodes once", v)
+	}
+
+	s1.CRDTIncrement("stock", CRDTPNCounter, 10)
+	s2.CRDTIncrement("stock", CRDTPNCounter, -4)
+	c, _ = s2.CRDTGet("stock")
+	s1.CRDTMerge("stock", c)
+	if v, _ := s1.CRDTIncrement("stock", CRDTPNCounter, -1); v != 5 {
+		t.Error("Must subtract the decrements", v)
+	}
+
+	if _, err := s1.CRDTIncrement("x", "counter", 1); err != ErrCRDTType {
+		t.Error("Must return ErrCRDTType", err)
+	}
+	s1.SetString("str", "val", 0)
+	if _, err := s1.CRDTIncrement("str", CRDTGCounter, 1); err != ErrWrongType {
+		t.Error("Must return ErrWrongType", err)
+	}
+	if err := s1.SetNodeID(""); err != ErrNodeID {
+		t.Error("Must return ErrNodeID", err)
+	}
+}
+
+func TestStorage_CRDTORSet(t *testing.T) {
+	s1, s2 := New(), New()
+	s1.SetNodeID("n1")
+	s2.SetNodeID("n2")
+
+	if n, _ := s1.CRDTAdd("tags", "a", "b", "a"); n != 2 {
+		t.Error("Must add 2 members", n)
+	}
+	c, _ := s1.CRDTGet("tags")
+	s2.CRDTMerge("tags", c)
+
+	// n1 removes a while n2 adds it again: the add it did not see wins.
+	if n, _ := s1.CRDTRemove("tags", "a", "c"); n != 1 {
+		t.Error("Must remove 1 member", n)
+	}
+	s2.CRDTAdd("tags", "a")
+	s2.CRDTRemove("tags", "b")
+
+	c1, _ := s1.CRDTGet("tags")
+	c2, _ := s2.CRDTGet("tags")
+	s1.CRDTMerge("tags", c2)
+	s2.CRDTMerge("tags", c1)
+	c1, _ = s1.CRDTGet("tags")
+	c2, _ = s2.CRDTGet("tags")
+	if v := c1.Value(); !reflect.DeepEqual(v, []string{"a"}) {
+		t.Error("Must keep the concurrent add and drop b", v)
+	}
+	if !reflect.DeepEqual(c1, c2) {
+		t.Errorf("Must converge, got %+v and %+v", c1, c2)
+	}
+}
+
+func TestStorage_CRDTLWWRegister(t *testing.T) {
+	s1, s2 := New(), New()
+	s1.SetNodeID("n1")
+	s2.SetNodeID("n2")
+
+	s1.CRDTSet("leader", "a")
+	c, _ := s1.CRDTGet("leader")
+	s2.CRDTMerge("leader", c)
+
+	// A write always replaces the local one, even with the clock behind.
+	item := s2.items["leader"]
+	item.CRDT.time += 1e12
+	s2.CRDTSet("leader", "b")
+	c2, _ := s2.CRDTGet("leader")
+	if c2.Value() != "b" || c2.time != c.time+1e12+1 {
+		t.Errorf("Must write after the current value, got %+v", c2)
+	}
+
+	s1.CRDTMerge("leader", c2)
+	c, _ = s1.CRDTGet("leader")
+	if c.Value() != "b" {
+		t.Error("Must keep the last write", c.Value())
+	}
+	if err := s1.CRDTMerge("leader", &CRDT{typ: 1}); err != ErrCRDTMismatch {
+		t.Error("Must return ErrCRDTMismatch", err)
+	}
+}
+
+func TestCRDT_MarshalBinary(t *testing.T) {
+	c, _ := NewCRDT(CRDTPNCounter)
+	c.increment("n1", 5)
+	c.increment("n2", -2)
+	data, _ := c.MarshalBinary()
+	parsed, err := ParseCRDT(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, c) {
+		t.Errorf("Must be equal %+v, got %+v", c, parsed)
+	}
+	if _, err := ParseCRDT(append(data, 0)); err != ErrCorruptItem {
+		t.Error("Must detect trailing data", err)
+	}
+	data[1] = byte(len(crdtTypes))
+	if _, err := ParseCRDT(data); err != ErrCorruptItem {
+		t.Error("Must detect unknown types", err)
+	}
+}
+
+// randomCRDT returns a CRDT of type typ after random operations of a few
+// nodes, with ties between their register writes.
+func randomCRDT(typ string, seed int64) *CRDT {
+	r := rand.New(rand.NewSource(seed))
+	c, _ := NewCRDT(typ)
+	nodes := []string{"n1", "n2", "n3"}
+	members := []string{"a", "b", "c", "d"}
+	for i := r.Intn(20); i >= 0; i-- {
+		node := nodes[r.Intn(len(nodes))]
+		switch typ {
+		case CRDTGCounter:
+			c.increment(node, r.Int63n(100))
+		case CRDTPNCounter:
+			c.increment(node, r.Int63n(200)-100)
+		case CRDTORSet:
+			m := members[r.Intn(len(members))]
+			if r.Intn(3) == 0 {
+				c.remove(m)
+			} else {
+				// Tags only need to be unique across the generated states.
+				c.add(m, fmt.Sprintf("%s:%d:%d", node, seed, i))
+			}
+		case CRDTLWWRegister:
+			t, v := r.Int63n(5), members[r.Intn(len(members))]
+			if c.later(t, node, v) {
+				c.value, c.time, c.node = v, t, node
+			}
+		}
+	}
+	return c
+}
+
+func merged(a, b *CRDT) *CRDT {
+	c := a.clone()
+	c.Merge(b)
+	return c
+}
+
+func equalCRDT(a, b *CRDT) bool {
+	return bytes.Equal(a.appendTo(nil), b.appendTo(nil))
+}
+
+func TestCRDT_MergeProperties(t *testing.T) {
+	for _, typ := range crdtTypes[1:] {
+		t.Run(typ, func(t *testing.T) {
+			commutative := func(s1, s2 int64) bool {
+				a, b := randomCRDT(typ, s1), randomCRDT(typ, s2)
+				return equalCRDT(merged(a, b), merged(b, a))
+			}
+			associative := func(s1, s2, s3 int64) bool {
+				a, b, c := randomCRDT(typ, s1), randomCRDT(typ, s2), randomCRDT(typ, s3)
+				return equalCRDT(merged(merged(a, b), c), merged(a, merged(b, c)))
+			}
+			idempotent := func(s1, s2 int64) bool {
+				a, b := randomCRDT(typ, s1), randomCRDT(typ, s2)
+				ab := merged(a, b)
+				return equalCRDT(merged(a, a), a) && equalCRDT(merged(ab, b), ab)
+			}
+			if err := quick.Check(commutative, nil); err != nil {
+				t.Error("Merge must be commutative", err)
+			}
+			if err := quick.Check(associative, nil); err != nil {
+				t.Error("Merge must be associative", err)
+			}
+			if err := quick.Check(idempotent, nil); err != nil {
+				t.Error("Merge must be idempotent", err)
+			}
+		})
+	}
+}
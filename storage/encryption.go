@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Sealed data is
+//
+//	key id | nonce | AES-GCM ciphertext and tag
+//
+// The key id, a little endian uint32 taken from the SHA-256 of the key,
+// tells which key sealed the data, so a wrong key is reported as such
+// instead of as damage, and data sealed with an old key can still be read
+// after a rotation.
+const (
+	keyIDSize = 4
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var (
+	ErrEncryptionKey = errors.New("Wrong encryption key: data was encrypted with another key")
+	ErrEncrypted     = errors.New("Data is encrypted, an encryption key is needed")
+	ErrDecrypt       = errors.New("Decryption failed, data is damaged")
+	ErrKeySize       = errors.New("Encryption key must be 16, 24 or 32 bytes, hex encoded")
+	errOldKeys       = errors.New("Old encryption keys need a current key")
+)
+
+// Encryption selects how disk based engines encrypt their files. Data is
+// written with Key; OldKeys are only read, so the engine can rewrite data
+// from before a key rotation. A nil Key disables encryption.
+type Encryption struct {
+	Key     []byte
+	OldKeys [][]byte
+}
+
+func (e Encryption) Validate() error {
+	if e.Key == nil && len(e.OldKeys) > 0 {
+		return errOldKeys
+	}
+	for _, key := range append([][]byte{e.Key}, e.OldKeys...) {
+		if key == nil {
+			continue
+		}
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return ErrKeySize
+		}
+	}
+	return nil
+}
+
+// Cipher returns the cipher of the keys, or nil if encryption is disabled.
+func (e Encryption) Cipher() (*Cipher, error) {
+	if err := e.Validate(); err != nil || e.Key == nil {
+		return nil, err
+	}
+	c := &Cipher{aeads: make(map[uint32]cipher.AEAD)}
+	for i, key := range append([][]byte{e.Key}, e.OldKeys...) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if i == 0 {
+			c.id = id
+		}
+		c.aeads[id] = aead
+	}
+	return c, nil
+}
+
+func keyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.LittleEndian.Uint32(sum[:])
+}
+
+// ParseEncryptionKey decodes a hex encoded key.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, ErrKeySize
+	}
+	if err := (Encryption{Key: key}).Validate(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ReadEncryptionKey reads a hex encoded key from a file.
+func ReadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEncryptionKey(string(data))
+}
+
+// Cipher seals data with the current key and opens data sealed with any of
+// the keys. It is safe for concurrent use.
+type Cipher struct {
+	id    uint32
+	aeads map[uint32]cipher.AEAD
+}
+
+// Overhead is the number of bytes sealing adds.
+func (c *Cipher) Overhead() int {
+	return keyIDSize + nonceSize + tagSize
+}
+
+// KeyID returns the id of the current key.
+func (c *Cipher) KeyID() uint32 {
+	return c.id
+}
+
+// Seal appends plaintext sealed with the current key to dst. ad is
+// authenticated but not stored; the same ad must be passed to Open.
+func (c *Cipher) Seal(dst, plaintext, ad []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, c.id)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	dst = append(dst, nonce...)
+	return c.aeads[c.id].Seal(dst, nonce, plaintext, ad)
+}
+
+// Open appends the plaintext of sealed to dst. It fails with
+// ErrEncryptionKey if sealed was sealed with none of the keys and with
+// ErrDecrypt if it was changed.
+func (c *Cipher) Open(dst, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < c.Overhead() {
+		return nil, ErrDecrypt
+	}
+	aead, ok := c.aeads[SealedKeyID(sealed)]
+	if !ok {
+		return nil, ErrEncryptionKey
+	}
+	plain, err := aead.Open(dst, sealed[keyIDSize:keyIDSize+nonceSize], sealed[keyIDSize+nonceSize:], ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// SealedKeyID returns the id of the key that sealed data.
+func SealedKeyID(sealed []byte) uint32 {
+	if len(sealed) < keyIDSize {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(sealed)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestCipher_SealOpen(t *testing.T) {
+	c, err := Encryption{Key: testKey(1)}.Cipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte("customer@example.com")
+	sealed := c.Seal(nil, plain, []byte("ad"))
+	if len(sealed) != len(plain)+c.Overhead() || bytes.Contains(sealed, plain) {
+		t.Error("Must hide the plaintext", sealed)
+	}
+	if bytes.Equal(sealed, c.Seal(nil, plain, []byte("ad"))) {
+		t.Error("Must use a new nonce for every seal")
+	}
+	if got, err := c.Open(nil, sealed, []byte("ad")); err != nil || !bytes.Equal(got, plain) {
+		t.Error("Must open sealed data", got, err)
+	}
+	if _, err := c.Open(nil, sealed, []byte("other")); err != ErrDecrypt {
+		t.Error("Must check the additional data", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Open(nil, sealed, []byte("ad")); err != ErrDecrypt {
+		t.Error("Must detect changes", err)
+	}
+	if _, err := c.Open(nil, sealed[:10], nil); err != ErrDecrypt {
+		t.Error("Must detect truncation", err)
+	}
+}
+
+func TestCipher_Keys(t *testing.T) {
+	old, _ := Encryption{Key: testKey(1)}.Cipher()
+	sealed := old.Seal(nil, []byte("v"), nil)
+
+	wrong, _ := Encryption{Key: testKey(2)}.Cipher()
+	if _, err := wrong.Open(nil, sealed, nil); err != ErrEncryptionKey {
+		t.Error("Must report a wrong key", err)
+	}
+	rotated, _ := Encryption{Key: testKey(2), OldKeys: [][]byte{testKey(1)}}.Cipher()
+	if got, err := rotated.Open(nil, sealed, nil); err != nil || string(got) != "v" {
+		t.Error("Must open data sealed with an old key", got, err)
+	}
+	if SealedKeyID(rotated.Seal(nil, []byte("v"), nil)) != wrong.KeyID() || SealedKeyID(sealed) != old.KeyID() {
+		t.Error("Must seal with the current key")
+	}
+
+	if c, err := (Encryption{}).Cipher(); c != nil || err != nil {
+		t.Error("Must disable encryption without a key", c, err)
+	}
+	if _, err := (Encryption{Key: []byte("short")}).Cipher(); err != ErrKeySize {
+		t.Error("Must reject bad key sizes", err)
+	}
+	if err := (Encryption{OldKeys: [][]byte{testKey(1)}}).Validate(); err == nil {
+		t.Error("Must require a current key with old keys")
+	}
+}
+
+func TestReadEncryptionKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte(strings.Repeat("ab", 32)+"\n"), 0600)
+	key, err := ReadEncryptionKey(path)
+	if err != nil || !bytes.Equal(key, bytes.Repeat([]byte{0xab}, 32)) {
+		t.Error("Must read hex keys", key, err)
+	}
+	if _, err := ParseEncryptionKey("not hex"); err != ErrKeySize {
+		t.Error("Must reject keys not hex encoded", err)
+	}
+	if _, err := ParseEncryptionKey("abcd"); err != ErrKeySize {
+		t.Error("Must reject short keys", err)
+	}
+}
@@ -0,0 +1,179 @@
+package storage
+
+import "time"
+
+// Engine is a storage backend of server.Server. Get returns nil for missing
+// or expired keys, TTL follows the conventions of Storage.TTL and Keys
+// returns the keys in lexicographical order. Setting an empty string, slice
+// or map is a no-op.
+type Engine interface {
+	SetString(key, value string, ttl int) error
+	SetInt(key string, value, ttl int) error
+	SetStringSlice(key string, value []string, ttl int) error
+	SetIntSlice(key string, value []int, ttl int) error
+	SetStringMap(key string, value map[string]string, ttl int) error
+	SetIntMap(key string, value map[string]int, ttl int) error
+	SetBitmap(key string, value []byte, ttl int) error
+
+	Get(key string) (*Item, error)
+	Remove(key string) error
+	Keys() ([]string, error)
+
+	Expire(key string, ttl int) error
+	TTL(key string) (int, error)
+	DeleteExpired() error
+
+	Close() error
+}
+
+// The interfaces below are optional features an Engine may provide.
+
+type BitmapEngine interface {
+	SetBit(key string, offset, value int) (int, error)
+	GetBit(key string, offset int) (int, error)
+	BitCount(key string, start, end int) (int, error)
+	BitPos(key string, bit, start, end int) (int, error)
+	BitOp(op BitOperation, dest string, keys ...string) (int, error)
+}
+
+type GeoEngine interface {
+	GeoAdd(key string, members ...GeoMember) (int, error)
+	GeoPos(key string, members ...string) ([]*GeoMember, error)
+	GeoDist(key, member1, member2, unit string) (float64, bool, error)
+	GeoSearch(key string, q GeoQuery) ([]GeoResult, error)
+}
+
+// TimeSeriesEngine keeps time series of samples, see Storage.TSAdd.
+type TimeSeriesEngine interface {
+	TSCreate(key string, retention int64) error
+	TSAdd(key string, samples ...Sample) (int, error)
+	TSRange(key string, q TSQuery) ([]Sample, error)
+	TSCreateRule(key string, rule TSRule) error
+	TSDeleteRule(key, dest string) (bool, error)
+	TSInfo(key string) (*TSInfo, error)
+}
+
+// CRDTEngine keeps conflict-free replicated counters, sets and registers,
+// see Storage.CRDTMerge.
+type CRDTEngine interface {
+	SetNodeID(id string) error
+	CRDTIncrement(key, typ string, delta int64) (int, error)
+	CRDTAdd(key string, members ...string) (int, error)
+	CRDTRemove(key string, members ...string) (int, error)
+	CRDTSet(key, value string) error
+	CRDTGet(key string) (*CRDT, error)
+	CRDTMerge(key string, c *CRDT) error
+}
+
+// RandomKeyEngine picks random keys, see Storage.RandomKeys.
+type RandomKeyEngine interface {
+	RandomKeys(count int) ([]string, error)
+}
+
+type ScanEngine interface {
+	Scan(opts ScanOptions) ([]string, string, error)
+}
+
+type IndexEngine interface {
+	CreateIndex(spec IndexSpec) error
+	DropIndex(name string) error
+	Indexes() []IndexSpec
+	Lookup(name, value string) ([]string, error)
+	LookupRange(name, min, max string, limit int) ([]string, error)
+}
+
+type SnapshotEngine interface {
+	OpenSnapshot() *Snapshot
+	GetSnapshot(id int64) (*Snapshot, error)
+	ReleaseSnapshot(id int64) error
+}
+
+type CompressionEngine interface {
+	CompressionStats(key string) (*CompressionStats, error)
+}
+
+type MemoryEngine interface {
+	MemoryUsage(key string) (*KeyMemory, error)
+	MemoryTop(n int) ([]KeyMemory, error)
+	MemoryStats() (*MemoryStats, error)
+}
+
+// KeyspaceEngine moves values between keys. Move only accepts databases of
+// the same engine type.
+type KeyspaceEngine interface {
+	Rename(src, dst string, nx bool) (bool, error)
+	Copy(src, dst string, replace bool) (bool, error)
+	Move(key string, dst Engine) (bool, error)
+}
+
+// BulkEngine reads and writes several keys in one atomic operation.
+type BulkEngine interface {
+	MGet(keys []string) ([]*Item, error)
+	MSet(entries []KeyValue, nx bool) (bool, error)
+	MDel(keys []string) ([]bool, error)
+}
+
+// ObjectEngine keeps access metadata of keys.
+type ObjectEngine interface {
+	Object(key string) (*ObjectInfo, error)
+	IdleKeys(n int) ([]KeyIdle, error)
+	EvictIdle(maxIdle time.Duration) (int, error)
+}
+
+// BackupEngine can be backed up while it serves requests, and restored.
+type BackupEngine interface {
+	// OpenDump captures the live keys as of now, to be read later while
+	// writes go on.
+	OpenDump() (Dump, error)
+	// Restore stores item under key as is, keeping its expiration.
+	Restore(key string, item *Item) error
+}
+
+// Dump is the content of an engine captured by BackupEngine.OpenDump.
+type Dump interface {
+	// Each calls fn for every key in key order. It may be called once.
+	Each(fn func(key string, item *Item) error) error
+	// Close releases the captured content. It must always be called.
+	Close() error
+}
+
+// ScriptEngine runs scripts atomically.
+type ScriptEngine interface {
+	// Eval runs sc with KEYS and ARGV bound to keys and args, and returns
+	// the value of its last form. It fails with ErrScriptTimeout if the
+	// script runs longer than timeout, 0 means no limit.
+	Eval(sc *Script, keys, args []string, timeout time.Duration) (interface{}, error)
+}
+
+// RateLimitEngine checks and consumes rate limits atomically, see
+// Storage.RateLimit.
+type RateLimitEngine interface {
+	RateLimit(key string, limiter RateLimiter, cost int) (*RateLimitResult, error)
+}
+
+// LimitEngine rejects keys and values exceeding its limits.
+type LimitEngine interface {
+	SetLimits(l Limits) error
+}
+
+var (
+	_ Engine         = (*Storage)(nil)
+	_ BitmapEngine   = (*Storage)(nil)
+	_ GeoEngine      = (*Storage)(nil)
+	_ ScanEngine     = (*Storage)(nil)
+	_ IndexEngine    = (*Storage)(nil)
+	_ SnapshotEngine = (*Storage)(nil)
+
+	_ CompressionEngine = (*Storage)(nil)
+	_ MemoryEngine      = (*Storage)(nil)
+	_ KeyspaceEngine    = (*Storage)(nil)
+	_ BulkEngine        = (*Storage)(nil)
+	_ ObjectEngine      = (*Storage)(nil)
+	_ LimitEngine       = (*Storage)(nil)
+	_ BackupEngine      = (*Storage)(nil)
+	_ TimeSeriesEngine  = (*Storage)(nil)
+	_ RateLimitEngine   = (*Storage)(nil)
+	_ ScriptEngine      = (*Storage)(nil)
+	_ CRDTEngine        = (*Storage)(nil)
+	_ RandomKeyEngine   = (*Storage)(nil)
+)
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"my-go-db/storage"
+	"my-go-db/storage/enginetest"
+)
+
+func TestStorage_Engine(t *testing.T) {
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return storage.New()
+	})
+}
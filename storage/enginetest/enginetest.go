@@ -0,0 +1,408 @@
+// Package enginetest is a conformance suite every storage.Engine must pass.
+package enginetest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"my-go-db/storage"
+)
+
+// Opener returns a new empty engine. Engines keeping state on disk should
+// place it under t.TempDir().
+type Opener func(t *testing.T) storage.Engine
+
+// Run runs the conformance suite against engines created by open.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, e storage.Engine)
+	}{
+		{"TypedValues", testTypedValues},
+		{"EmptyValues", testEmptyValues},
+		{"Overwrite", testOverwrite},
+		{"Remove", testRemove},
+		{"Keys", testKeys},
+		{"TTL", testTTL},
+		{"Expiration", testExpiration},
+		{"Concurrent", testConcurrent},
+		{"Isolation", testIsolation},
+		{"ConcurrentValues", testConcurrentValues},
+		{"Backup", testBackup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := open(t)
+			defer func() {
+				if err := e.Close(); err != nil {
+					t.Error("Close:", err)
+				}
+			}()
+			tt.fn(t, e)
+		})
+	}
+}
+
+func mustGet(t *testing.T, e storage.Engine, key string) *storage.Item {
+	t.Helper()
+	item, err := e.Get(key)
+	if err != nil {
+		t.Fatal("Get:", err)
+	}
+	if item == nil {
+		t.Fatal("Must contains key", key)
+	}
+	return item
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTypedValues(t *testing.T, e storage.Engine) {
+	check(t, e.SetString("string", "val", 0))
+	check(t, e.SetInt("int", 42, 0))
+	check(t, e.SetInt("zero", 0, 0))
+	check(t, e.SetStringSlice("string_slice", []string{"a", "b"}, 0))
+	check(t, e.SetIntSlice("int_slice", []int{1, -2, 3}, 0))
+	check(t, e.SetStringMap("string_map", map[string]string{"a": "x", "b": "y"}, 0))
+	check(t, e.SetIntMap("int_map", map[string]int{"a": 1, "b": -1}, 0))
+	check(t, e.SetBitmap("bitmap", []byte{0x80, 0x01}, 0))
+
+	if v := mustGet(t, e, "string").String; v != "val" {
+		t.Error("Must be equal `val`", v)
+	}
+	if v := mustGet(t, e, "int").Int; v != 42 {
+		t.Error("Must be equal 42", v)
+	}
+	if v := mustGet(t, e, "zero").Int; v != 0 {
+		t.Error("Must be equal 0", v)
+	}
+	if v := mustGet(t, e, "string_slice").StringSlice; !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Error("Must be equal [a b]", v)
+	}
+	if v := mustGet(t, e, "int_slice").IntSlice; !reflect.DeepEqual(v, []int{1, -2, 3}) {
+		t.Error("Must be equal [1 -2 3]", v)
+	}
+	if v := mustGet(t, e, "string_map").StringMap; !reflect.DeepEqual(v, map[string]string{"a": "x", "b": "y"}) {
+		t.Error("Must be equal map[a:x b:y]", v)
+	}
+	if v := mustGet(t, e, "int_map").IntMap; !reflect.DeepEqual(v, map[string]int{"a": 1, "b": -1}) {
+		t.Error("Must be equal map[a:1 b:-1]", v)
+	}
+	if v := mustGet(t, e, "bitmap").Bitmap; !reflect.DeepEqual(v, []byte{0x80, 0x01}) {
+		t.Error("Must be equal [128 1]", v)
+	}
+
+	item, err := e.Get("missing")
+	if err != nil || item != nil {
+		t.Error("Must be not found", item, err)
+	}
+}
+
+func testEmptyValues(t *testing.T, e storage.Engine) {
+	check(t, e.SetString("k", "", 0))
+	check(t, e.SetStringSlice("k", []string{}, 0))
+	check(t, e.SetIntSlice("k", nil, 0))
+	check(t, e.SetStringMap("k", map[string]string{}, 0))
+	check(t, e.SetIntMap("k", nil, 0))
+	check(t, e.SetBitmap("k", []byte{}, 0))
+
+	if item, _ := e.Get("k"); item != nil {
+		t.Error("Must be not found", item)
+	}
+}
+
+func testOverwrite(t *testing.T, e storage.Engine) {
+	check(t, e.SetString("k", "val", 0))
+	check(t, e.SetIntSlice("k", []int{1}, 0))
+
+	item := mustGet(t, e, "k")
+	if item.String != "" || !reflect.DeepEqual(item.IntSlice, []int{1}) {
+		t.Error("Must hold only the new value", item)
+	}
+}
+
+func testRemove(t *testing.T, e storage.Engine) {
+	check(t, e.SetString("k", "val", 0))
+	check(t, e.Remove("k"))
+
+	if item, _ := e.Get("k"); item != nil {
+		t.Error("Must be removed", item)
+	}
+	if err := e.Remove("k"); err == nil {
+		t.Error("Must fail for a missing key")
+	}
+
+	check(t, e.SetString("k", "again", 0))
+	if v := mustGet(t, e, "k").String; v != "again" {
+		t.Error("Must be set again", v)
+	}
+}
+
+func testKeys(t *testing.T, e storage.Engine) {
+	want := []string{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key:%03d", (i*37)%100)
+		check(t, e.SetInt(key, i, 0))
+		want = append(want, key)
+	}
+	check(t, e.Remove("key:050"))
+	sort.Strings(want)
+	want = append(want[:50], want[51:]...)
+
+	keys, err := e.Keys()
+	check(t, err)
+	if !reflect.DeepEqual(keys, want) {
+		t.Error("Must return sorted keys", keys)
+	}
+}
+
+func testTTL(t *testing.T, e storage.Engine) {
+	check(t, e.SetString("persistent", "v", 0))
+	check(t, e.SetString("volatile", "v", 100))
+
+	if ttl, _ := e.TTL("persistent"); ttl != -1 {
+		t.Error("Must be equal -1", ttl)
+	}
+	if ttl, _ := e.TTL("volatile"); ttl < 99 || ttl > 100 {
+		t.Error("Must be about 100", ttl)
+	}
+	if ttl, _ := e.TTL("missing"); ttl != -2 {
+		t.Error("Must be equal -2", ttl)
+	}
+
+	check(t, e.Expire("persistent", 50))
+	if ttl, _ := e.TTL("persistent"); ttl < 49 || ttl > 50 {
+		t.Error("Must be about 50", ttl)
+	}
+	check(t, e.Expire("volatile", 0))
+	if ttl, _ := e.TTL("volatile"); ttl != -1 {
+		t.Error("Must be equal -1", ttl)
+	}
+	if v := mustGet(t, e, "volatile").String; v != "v" {
+		t.Error("Must keep the value", v)
+	}
+
+	if err := e.Expire("missing", 10); err == nil {
+		t.Error("Must fail for a missing key")
+	}
+}
+
+func testExpiration(t *testing.T, e storage.Engine) {
+	check(t, e.SetString("short", "v", 1))
+	check(t, e.SetString("long", "v", 100))
+	check(t, e.SetInt("expired_later", 1, 0))
+	check(t, e.Expire("expired_later", 1))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if item, _ := e.Get("short"); item != nil {
+		t.Error("Must be expired", item)
+	}
+	if item, _ := e.Get("expired_later"); item != nil {
+		t.Error("Must be expired", item)
+	}
+	if ttl, _ := e.TTL("short"); ttl != -2 {
+		t.Error("Must be equal -2", ttl)
+	}
+
+	check(t, e.DeleteExpired())
+	keys, err := e.Keys()
+	check(t, err)
+	if !reflect.DeepEqual(keys, []string{"long"}) {
+		t.Error("Must keep only live keys", keys)
+	}
+}
+
+func testConcurrent(t *testing.T, e storage.Engine) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("w%d:%d", w, i)
+				if err := e.SetInt(key, i, 0); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := e.Get(fmt.Sprintf("w%d:%d", (w+1)%8, i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	keys, err := e.Keys()
+	check(t, err)
+	if len(keys) != 400 {
+		t.Error("Must be equal 400", len(keys))
+	}
+}
+
+// testIsolation checks that engines own their data: changing the values
+// passed to setters or returned by Get does not change what is stored.
+func testIsolation(t *testing.T, e storage.Engine) {
+	strs := []string{"a", "b"}
+	ints := []int{1, 2}
+	strMap := map[string]string{"a": "x"}
+	intMap := map[string]int{"a": 1}
+	bitmap := []byte{0x80}
+	check(t, e.SetStringSlice("string_slice", strs, 0))
+	check(t, e.SetIntSlice("int_slice", ints, 0))
+	check(t, e.SetStringMap("string_map", strMap, 0))
+	check(t, e.SetIntMap("int_map", intMap, 0))
+	check(t, e.SetBitmap("bitmap", bitmap, 0))
+	strs[0], ints[0], strMap["a"], intMap["a"], bitmap[0] = "changed", 100, "changed", 100, 0xff
+	strMap["new"], intMap["new"] = "new", 1
+
+	for i := 0; i < 2; i++ {
+		if v := mustGet(t, e, "string_slice").StringSlice; !reflect.DeepEqual(v, []string{"a", "b"}) {
+			t.Error("Must be equal [a b]", v)
+		}
+		if v := mustGet(t, e, "int_slice").IntSlice; !reflect.DeepEqual(v, []int{1, 2}) {
+			t.Error("Must be equal [1 2]", v)
+		}
+		if v := mustGet(t, e, "string_map").StringMap; !reflect.DeepEqual(v, map[string]string{"a": "x"}) {
+			t.Error("Must be equal map[a:x]", v)
+		}
+		if v := mustGet(t, e, "int_map").IntMap; !reflect.DeepEqual(v, map[string]int{"a": 1}) {
+			t.Error("Must be equal map[a:1]", v)
+		}
+		if v := mustGet(t, e, "bitmap").Bitmap; !reflect.DeepEqual(v, []byte{0x80}) {
+			t.Error("Must be equal [128]", v)
+		}
+
+		// Change the returned copies before reading again
+		mustGet(t, e, "string_slice").StringSlice[0] = "changed"
+		mustGet(t, e, "int_slice").IntSlice[0] = 100
+		mustGet(t, e, "string_map").StringMap["a"] = "changed"
+		mustGet(t, e, "int_map").IntMap["new"] = 1
+		mustGet(t, e, "bitmap").Bitmap[0] = 0xff
+	}
+}
+
+// testConcurrentValues has writers replace collections while readers
+// modify the copies they get; run it with -race.
+func testConcurrentValues(t *testing.T, e storage.Engine) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			m := map[string]int{}
+			for i := 0; i < 100; i++ {
+				m[fmt.Sprint(i%10)] = i
+				if err := e.SetIntMap("map", m, 0); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				item, err := e.Get("map")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if item != nil {
+					item.IntMap["reader"] = i
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, e, "map").IntMap; len(v) != 10 {
+		t.Error("Must be equal 10", v)
+	}
+}
+
+// testBackup checks that engines supporting backups dump the keys as of
+// when the dump is opened while writes go on, and restore them with their
+// ttl.
+func testBackup(t *testing.T, e storage.Engine) {
+	b, ok := e.(storage.BackupEngine)
+	if !ok {
+		t.Skip("Engine does not support backups")
+	}
+	want := map[string]string{}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		want[key] = fmt.Sprint("value", i)
+		check(t, e.SetString(key, want[key], 0))
+	}
+	check(t, e.SetString("volatile", "v", 1000))
+	want["volatile"] = "v"
+
+	dump, err := b.OpenDump()
+	check(t, err)
+	check(t, e.SetString("key000", "before each", 0))
+	check(t, e.SetString("late", "v", 0))
+
+	got := map[string]*storage.Item{}
+	var prev string
+	err = dump.Each(func(key string, item *storage.Item) error {
+		if key <= prev {
+			t.Error("Must dump keys in order", prev, key)
+		}
+		prev = key
+		if len(got) == 0 {
+			for i := 0; i < 1000; i++ {
+				check(t, e.SetString(fmt.Sprintf("key%03d", i%300), fmt.Sprint("new", i), 0))
+			}
+			check(t, e.Remove("volatile"))
+		}
+		got[key] = item
+		return nil
+	})
+	check(t, err)
+	check(t, dump.Close())
+	if len(got) != len(want) {
+		t.Fatal("Must dump the keys present when the dump was opened", len(got))
+	}
+	for key, value := range want {
+		if got[key] == nil || got[key].String != value {
+			t.Error("Must dump the value present when the dump was opened", key, got[key])
+		}
+	}
+
+	keys, err := e.Keys()
+	check(t, err)
+	for _, key := range keys {
+		check(t, e.Remove(key))
+	}
+	for key, item := range got {
+		check(t, b.Restore(key, item))
+	}
+	if v := mustGet(t, e, "key007").String; v != "value7" {
+		t.Error("Must restore values", v)
+	}
+	if ttl, _ := e.TTL("volatile"); ttl < 900 || ttl > 1000 {
+		t.Error("Must restore the ttl", ttl)
+	}
+	if keys, _ := e.Keys(); len(keys) != len(want) {
+		t.Error("Must restore every key", len(keys))
+	}
+}
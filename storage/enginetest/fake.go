@@ -0,0 +1,191 @@
+package enginetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"my-go-db/storage"
+)
+
+// Fake is a minimal storage.Engine keeping encoded items in a map. It
+// implements none of the optional engine interfaces, so it is used to test
+// how the server falls back without them.
+type Fake struct {
+	mu    sync.RWMutex
+	items map[string][]byte
+}
+
+var _ storage.Engine = (*Fake)(nil)
+
+func NewFake() *Fake {
+	return &Fake{items: make(map[string][]byte)}
+}
+
+func (f *Fake) put(key string, item *storage.Item) error {
+	data, err := item.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.items[key] = data
+	f.mu.Unlock()
+	return nil
+}
+
+// getLocked returns the live item of key or nil. f.mu must be held.
+func (f *Fake) getLocked(key string, now int64) (*storage.Item, error) {
+	data, ok := f.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := new(storage.Item)
+	if err := item.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if exp := item.Expiration(); exp > 0 && exp <= now {
+		return nil, nil
+	}
+	return item, nil
+}
+
+func (f *Fake) SetString(key, value string, ttl int) error {
+	if value == "" {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.String = value
+	return f.put(key, item)
+}
+
+func (f *Fake) SetInt(key string, value, ttl int) error {
+	item := storage.NewItem(ttl)
+	item.Int = value
+	return f.put(key, item)
+}
+
+func (f *Fake) SetStringSlice(key string, value []string, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.StringSlice = value
+	return f.put(key, item)
+}
+
+func (f *Fake) SetIntSlice(key string, value []int, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.IntSlice = value
+	return f.put(key, item)
+}
+
+func (f *Fake) SetStringMap(key string, value map[string]string, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.StringMap = value
+	return f.put(key, item)
+}
+
+func (f *Fake) SetIntMap(key string, value map[string]int, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.IntMap = value
+	return f.put(key, item)
+}
+
+func (f *Fake) SetBitmap(key string, value []byte, ttl int) error {
+	if len(value) == 0 {
+		return nil
+	}
+	item := storage.NewItem(ttl)
+	item.Bitmap = value
+	return f.put(key, item)
+}
+
+func (f *Fake) Get(key string) (*storage.Item, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.getLocked(key, time.Now().UnixNano())
+}
+
+func (f *Fake) Remove(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.items[key]; !ok {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	delete(f.items, key)
+	return nil
+}
+
+func (f *Fake) Keys() ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	keys := make([]string, 0, len(f.items))
+	for key := range f.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (f *Fake) Expire(key string, ttl int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, err := f.getLocked(key, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	item.SetTTL(ttl)
+	data, err := item.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	f.items[key] = data
+	return nil
+}
+
+func (f *Fake) TTL(key string) (int, error) {
+	now := time.Now().UnixNano()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	item, err := f.getLocked(key, now)
+	if err != nil || item == nil {
+		return -2, err
+	}
+	if item.Expiration() == 0 {
+		return -1, nil
+	}
+	return int((item.Expiration() - now + int64(time.Second) - 1) / int64(time.Second)), nil
+}
+
+func (f *Fake) DeleteExpired() error {
+	now := time.Now().UnixNano()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key := range f.items {
+		item, err := f.getLocked(key, now)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			delete(f.items, key)
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Close() error {
+	return nil
+}
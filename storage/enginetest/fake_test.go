@@ -0,0 +1,11 @@
+package enginetest
+
+import (
+	"testing"
+
+	"my-go-db/storage"
+)
+
+func TestFake(t *testing.T) {
+	Run(t, func(t *testing.T) storage.Engine { return NewFake() })
+}
@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Members of a geo set are stored as 52 bit interleaved geohashes kept in a
+// sorted slice, so every geohash cell maps to a contiguous range of entries.
+const (
+	geoStepMax   = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	earthRadiusM = 6372797.560856
+)
+
+var (
+	ErrGeoCoordinates = errors.New("Invalid longitude,latitude pair")
+	ErrGeoUnit        = errors.New("Unsupported unit provided. Please use m, km, ft, mi")
+	ErrGeoMember      = errors.New("Could not decode requested member")
+	ErrGeoShape       = errors.New("Either radius or width and height must be positive")
+)
+
+var geoUnits = map[string]float64{
+	"":   1,
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+type GeoMember struct {
+	Name      string
+	Longitude float64
+	Latitude  float64
+}
+
+type GeoResult struct {
+	GeoMember
+	Distance float64 // in the unit of the query
+}
+
+// GeoQuery describes a GeoSearch. The center is the position of Member when
+// it is set, otherwise Longitude/Latitude. A positive Radius searches a
+// circle, otherwise Width x Height describes a box around the center.
+type GeoQuery struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+
+	Radius float64
+	Width  float64
+	Height float64
+	Unit   string
+
+	Count int // 0 means no limit
+	Desc  bool
+}
+
+type geoEntry struct {
+	hash uint64
+	name string
+}
+
+type GeoSet struct {
+	hashes  map[string]uint64
+	entries []geoEntry // sorted by hash, then name
+}
+
+func newGeoSet() *GeoSet {
+	return &GeoSet{hashes: make(map[string]uint64)}
+}
+
+func (g *GeoSet) clone() *GeoSet {
+	c := &GeoSet{
+		hashes:  make(map[string]uint64, len(g.hashes)),
+		entries: append([]geoEntry{}, g.entries...),
+	}
+	for k, v := range g.hashes {
+		c.hashes[k] = v
+	}
+	return c
+}
+
+func (g *GeoSet) Len() int {
+	return len(g.entries)
+}
+
+func (g *GeoSet) Members() []GeoMember {
+	members := make([]GeoMember, 0, len(g.entries))
+	for _, e := range g.entries {
+		lon, lat := geoDecode(e.hash)
+		members = append(members, GeoMember{Name: e.name, Longitude: lon, Latitude: lat})
+	}
+	return members
+}
+
+func (g *GeoSet) search(hash uint64, name string) int {
+	return sort.Search(len(g.entries), func(i int) bool {
+		e := g.entries[i]
+		return e.hash > hash || (e.hash == hash && e.name >= name)
+	})
+}
+
+// add inserts or moves a member and reports whether it is new.
+func (g *GeoSet) add(name string, hash uint64) bool {
+	old, exists := g.hashes[name]
+	if exists {
+		if old == hash {
+			return false
+		}
+		i := g.search(old, name)
+		g.entries = append(g.entries[:i], g.entries[i+1:]...)
+	}
+	g.hashes[name] = hash
+	i := g.search(hash, name)
+	g.entries = append(g.entries, geoEntry{})
+	copy(g.entries[i+1:], g.entries[i:])
+	g.entries[i] = geoEntry{hash: hash, name: name}
+	return !exists
+}
+
+func geoValid(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+// interleave spreads the bits of x over the even bit positions.
+func interleave(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+func deinterleave(v uint64) uint32 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return uint32(v)
+}
+
+func geoCell(lon, lat float64, step uint) (uint32, uint32) {
+	cells := float64(uint64(1) << step)
+	lonIdx := uint32(math.Min((lon-geoLonMin)/(geoLonMax-geoLonMin)*cells, cells-1))
+	latIdx := uint32(math.Min((lat-geoLatMin)/(geoLatMax-geoLatMin)*cells, cells-1))
+	return lonIdx, latIdx
+}
+
+// geoEncodeStep returns the geohash of the cell containing lon/lat at the
+// given precision: step bits per coordinate, longitude in the odd bits.
+func geoEncodeStep(lon, lat float64, step uint) uint64 {
+	lonIdx, latIdx := geoCell(lon, lat, step)
+	return interleave(lonIdx)<<1 | interleave(latIdx)
+}
+
+func geoEncode(lon, lat float64) uint64 {
+	return geoEncodeStep(lon, lat, geoStepMax)
+}
+
+// geoDecode returns the center of the full precision cell.
+func geoDecode(hash uint64) (float64, float64) {
+	cells := float64(uint64(1) << geoStepMax)
+	lonIdx := float64(deinterleave(hash >> 1))
+	latIdx := float64(deinterleave(hash))
+	lon := geoLonMin + (lonIdx+0.5)/cells*(geoLonMax-geoLonMin)
+	lat := geoLatMin + (latIdx+0.5)/cells*(geoLatMax-geoLatMin)
+	return lon, lat
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// geoDistance returns the haversine distance in meters.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := toRadians(lat1), toRadians(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(toRadians(lon2-lon1) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+// geoStep picks the finest precision whose cells are at least radius meters
+// wide and high around the center, so the center cell and its eight
+// neighbours cover the whole search area.
+func geoStep(lat, radius float64) uint {
+	if radius <= 0 {
+		return geoStepMax
+	}
+	maxLat := math.Min(math.Abs(lat)+radius/earthRadiusM*180/math.Pi, 90)
+	cosLat := math.Cos(toRadians(maxLat))
+	degreeM := earthRadiusM * math.Pi / 180
+	step := uint(geoStepMax)
+	for step > 1 {
+		cells := float64(uint64(1) << step)
+		height := (geoLatMax - geoLatMin) / cells * degreeM
+		width := (geoLonMax - geoLonMin) / cells * degreeM * cosLat
+		if height >= radius && width >= radius {
+			break
+		}
+		step--
+	}
+	return step
+}
+
+// geoRanges returns the hash ranges [from, to) of the center cell and its
+// neighbours at the given step. Longitude wraps around the antimeridian.
+func geoRanges(lon, lat float64, step uint) [][2]uint64 {
+	cells := int64(1) << step
+	lonIdx, latIdx := geoCell(lon, lat, step)
+	shift := 2 * (geoStepMax - step)
+
+	seen := make(map[uint64]bool)
+	ranges := [][2]uint64{}
+	for dy := int64(-1); dy <= 1; dy++ {
+		y := int64(latIdx) + dy
+		if y < 0 || y >= cells {
+			continue
+		}
+		for dx := int64(-1); dx <= 1; dx++ {
+			x := (int64(lonIdx) + dx + cells) % cells
+			cell := interleave(uint32(x))<<1 | interleave(uint32(y))
+			if seen[cell] {
+				continue
+			}
+			seen[cell] = true
+			ranges = append(ranges, [2]uint64{cell << shift, (cell + 1) << shift})
+		}
+	}
+	return ranges
+}
+
+func (s *Storage) geoItem(key string) (*Item, error) {
+	item, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item = item.unpack()
+	if item.Geo == nil {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+// GeoAdd adds or updates members and returns the number of new members.
+func (s *Storage) GeoAdd(key string, members ...GeoMember) (int, error) {
+	for _, m := range members {
+		if !geoValid(m.Longitude, m.Latitude) {
+			return 0, ErrGeoCoordinates
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.geoItem(key)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		if len(members) == 0 {
+			return 0, nil
+		}
+		item = NewItem(0)
+		item.Geo = newGeoSet()
+	} else {
+		item = s.writableLocked(item)
+	}
+	if err := s.checkGeoLocked(key, item.Geo, members); err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, m := range members {
+		if item.Geo.add(m.Name, geoEncode(m.Longitude, m.Latitude)) {
+			added++
+		}
+	}
+	s.updateLocked(key, item)
+	return added, nil
+}
+
+// checkGeoLocked checks the limits before members are added to set, which
+// may be the stored one.
+func (s *Storage) checkGeoLocked(key string, set *GeoSet, members []GeoMember) error {
+	if err := s.limits.CheckKey(key); err != nil {
+		return err
+	}
+	added := make(map[string]bool)
+	for _, m := range members {
+		if err := s.limits.checkSize(len(m.Name)); err != nil {
+			return err
+		}
+		if _, ok := set.hashes[m.Name]; !ok {
+			added[m.Name] = true
+		}
+	}
+	return s.limits.checkElements(set.Len() + len(added))
+}
+
+// GeoPos returns the positions of members, with nil for missing ones.
+func (s *Storage) GeoPos(key string, members ...string) ([]*GeoMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.geoItem(key)
+	if err != nil {
+		return nil, err
+	}
+	item.touch(time.Now().UnixNano())
+
+	positions := make([]*GeoMember, len(members))
+	if item == nil {
+		return positions, nil
+	}
+	for i, name := range members {
+		if hash, ok := item.Geo.hashes[name]; ok {
+			lon, lat := geoDecode(hash)
+			positions[i] = &GeoMember{Name: name, Longitude: lon, Latitude: lat}
+		}
+	}
+	return positions, nil
+}
+
+// GeoDist returns the distance between two members in unit. The bool result
+// is false when either member does not exist.
+func (s *Storage) GeoDist(key, member1, member2, unit string) (float64, bool, error) {
+	factor, ok := geoUnits[unit]
+	if !ok {
+		return 0, false, ErrGeoUnit
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.geoItem(key)
+	if err != nil || item == nil {
+		return 0, false, err
+	}
+	item.touch(time.Now().UnixNano())
+	h1, ok1 := item.Geo.hashes[member1]
+	h2, ok2 := item.Geo.hashes[member2]
+	if !ok1 || !ok2 {
+		return 0, false, nil
+	}
+	lon1, lat1 := geoDecode(h1)
+	lon2, lat2 := geoDecode(h2)
+	return geoDistance(lon1, lat1, lon2, lat2) / factor, true, nil
+}
+
+// GeoSearch returns the members inside the area described by q sorted by
+// distance from its center.
+func (s *Storage) GeoSearch(key string, q GeoQuery) ([]GeoResult, error) {
+	factor, ok := geoUnits[q.Unit]
+	if !ok {
+		return nil, ErrGeoUnit
+	}
+	byRadius := q.Radius > 0
+	if !byRadius && (q.Width <= 0 || q.Height <= 0) {
+		return nil, ErrGeoShape
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.geoItem(key)
+	if err != nil {
+		return nil, err
+	}
+	item.touch(time.Now().UnixNano())
+
+	lon, lat := q.Longitude, q.Latitude
+	if q.Member != "" {
+		if item == nil {
+			return nil, ErrGeoMember
+		}
+		hash, ok := item.Geo.hashes[q.Member]
+		if !ok {
+			return nil, ErrGeoMember
+		}
+		lon, lat = geoDecode(hash)
+	} else if !geoValid(lon, lat) {
+		return nil, ErrGeoCoordinates
+	}
+	if item == nil {
+		return []GeoResult{}, nil
+	}
+
+	radius := q.Radius * factor
+	halfWidth, halfHeight := q.Width*factor/2, q.Height*factor/2
+	if !byRadius {
+		radius = math.Max(halfWidth, halfHeight)
+	}
+
+	results := []GeoResult{}
+	entries := item.Geo.entries
+	for _, r := range geoRanges(lon, lat, geoStep(lat, radius)) {
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].hash >= r[0] })
+		for ; i < len(entries) && entries[i].hash < r[1]; i++ {
+			mlon, mlat := geoDecode(entries[i].hash)
+			dist := geoDistance(lon, lat, mlon, mlat)
+			if byRadius {
+				if dist > radius {
+					continue
+				}
+			} else {
+				if geoDistance(lon, lat, lon, mlat) > halfHeight ||
+					geoDistance(lon, mlat, mlon, mlat) > halfWidth {
+					continue
+				}
+			}
+			results = append(results, GeoResult{
+				GeoMember: GeoMember{Name: entries[i].name, Longitude: mlon, Latitude: mlat},
+				Distance:  dist / factor,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance == results[j].Distance {
+			return results[i].Name < results[j].Name
+		}
+		if q.Desc {
+			return results[i].Distance > results[j].Distance
+		}
+		return results[i].Distance < results[j].Distance
+	})
+	if q.Count > 0 && len(results) > q.Count {
+		results = results[:q.Count]
+	}
+	return results, nil
+}
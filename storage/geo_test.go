@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func newSicily() *Storage {
+	s := New()
+	s.GeoAdd("Sicily",
+		GeoMember{Name: "Palermo", Longitude: 13.361389, Latitude: 38.115556},
+		GeoMember{Name: "Catania", Longitude: 15.087269, Latitude: 37.502669},
+		GeoMember{Name: "Agrigento", Longitude: 13.583333, Latitude: 37.316667},
+	)
+	return s
+}
+
+func TestStorage_GeoAdd(t *testing.T) {
+	s := newSicily()
+
+	item := s.GetItem("Sicily")
+	if item == nil || item.Geo == nil {
+		t.Fatal("Must contains geo set")
+	}
+	if item.Geo.Len() != 3 {
+		t.Error("Must be equal 3", item.Geo.Len())
+	}
+
+	added, err := s.GeoAdd("Sicily",
+		GeoMember{Name: "Palermo", Longitude: 13.4, Latitude: 38.1},
+		GeoMember{Name: "Messina", Longitude: 15.556, Latitude: 38.193},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Error("Must be equal 1", added)
+	}
+
+	if _, err := s.GeoAdd("Sicily", GeoMember{Name: "Pole", Longitude: 0, Latitude: 89}); err != ErrGeoCoordinates {
+		t.Error("Must return ErrGeoCoordinates", err)
+	}
+
+	s.SetString("str", "val", 0)
+	if _, err := s.GeoAdd("str", GeoMember{Name: "x"}); err != ErrWrongType {
+		t.Error("Must return ErrWrongType", err)
+	}
+}
+
+func TestStorage_GeoPos(t *testing.T) {
+	s := newSicily()
+
+	pos, err := s.GeoPos("Sicily", "Palermo", "Missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos[0] == nil {
+		t.Fatal("Must contains Palermo")
+	}
+	if math.Abs(pos[0].Longitude-13.361389) > 1e-5 || math.Abs(pos[0].Latitude-38.115556) > 1e-5 {
+		t.Error("Must be close to the original position", pos[0])
+	}
+	if pos[1] != nil {
+		t.Error("Must be nil", pos[1])
+	}
+}
+
+func TestStorage_GeoDist(t *testing.T) {
+	s := newSicily()
+
+	dist, ok, err := s.GeoDist("Sicily", "Palermo", "Catania", "km")
+	if err != nil || !ok {
+		t.Fatal("Must return distance", err)
+	}
+	if math.Abs(dist-166.2742) > 0.01 {
+		t.Error("Must be about 166.27 km", dist)
+	}
+
+	if _, ok, _ := s.GeoDist("Sicily", "Palermo", "Missing", "km"); ok {
+		t.Error("Must be not found")
+	}
+	if _, _, err := s.GeoDist("Sicily", "Palermo", "Catania", "yd"); err != ErrGeoUnit {
+		t.Error("Must return ErrGeoUnit", err)
+	}
+}
+
+func TestStorage_GeoSearch_Radius(t *testing.T) {
+	s := newSicily()
+
+	res, err := s.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, r := range res {
+		names = append(names, r.Name)
+	}
+	want := []string{"Catania", "Agrigento", "Palermo"}
+	if len(names) != len(want) {
+		t.Fatal("Must be equal", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Error("Must be sorted by distance", names, want)
+		}
+	}
+	if math.Abs(res[0].Distance-56.4413) > 0.01 {
+		t.Error("Must be about 56.44 km", res[0].Distance)
+	}
+
+	res, _ = s.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37, Radius: 100, Unit: "km"})
+	if len(res) != 1 || res[0].Name != "Catania" {
+		t.Error("Must contains only Catania", res)
+	}
+
+	res, _ = s.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Count: 1, Desc: true})
+	if len(res) != 1 || res[0].Name != "Palermo" {
+		t.Error("Must contains only Palermo", res)
+	}
+
+	res, _ = s.GeoSearch("Sicily", GeoQuery{Member: "Palermo", Radius: 1, Unit: "m"})
+	if len(res) != 1 || res[0].Name != "Palermo" || res[0].Distance != 0 {
+		t.Error("Must contains only Palermo", res)
+	}
+}
+
+func TestStorage_GeoSearch_Box(t *testing.T) {
+	s := newSicily()
+
+	res, err := s.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37, Width: 400, Height: 400, Unit: "km"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Error("Must be equal 3", res)
+	}
+
+	res, _ = s.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37, Width: 300, Height: 120, Unit: "km"})
+	if len(res) != 2 || res[0].Name != "Catania" || res[1].Name != "Agrigento" {
+		t.Error("Must contains Catania and Agrigento", res)
+	}
+
+	if _, err := s.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37}); err != ErrGeoShape {
+		t.Error("Must return ErrGeoShape", err)
+	}
+}
+
+func TestStorage_GeoSearch_Dense(t *testing.T) {
+	s := New()
+	// A grid of points 0.01 degree apart around the antimeridian.
+	for i := -20; i <= 20; i++ {
+		for j := -20; j <= 20; j++ {
+			lon := 180 + float64(i)*0.01
+			if lon > 180 {
+				lon -= 360
+			}
+			s.GeoAdd("grid", GeoMember{
+				Name:      string(rune('A'+i+20)) + string(rune('A'+j+20)),
+				Longitude: lon,
+				Latitude:  float64(j) * 0.01,
+			})
+		}
+	}
+
+	res, err := s.GeoSearch("grid", GeoQuery{Longitude: 180, Latitude: 0, Radius: 5, Unit: "km"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := 0
+	for _, m := range s.GetItem("grid").Geo.Members() {
+		if geoDistance(180, 0, m.Longitude, m.Latitude) <= 5000 {
+			want++
+		}
+	}
+	if len(res) != want || want == 0 {
+		t.Error("Must find every member in radius", len(res), want)
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import "fmt"
+
+// Recovery selects what disk based engines do when they are opened over
+// data files with a damaged tail, as left by a crash in the middle of a
+// write. Damage elsewhere always stops them from opening.
+type Recovery string
+
+const (
+	RecoverTruncate Recovery = "truncate" // drop the damaged tail and open the intact data
+	RecoverStrict   Recovery = "strict"   // refuse to open, see the check command
+)
+
+func (r Recovery) Validate() error {
+	switch r {
+	case "", RecoverTruncate, RecoverStrict:
+		return nil
+	}
+	return fmt.Errorf("Unknown recovery mode %q, expected truncate or strict", r)
+}
+
+// Strict tells whether damaged tails must be reported instead of dropped.
+// The zero value truncates.
+func (r Recovery) Strict() bool {
+	return r == RecoverStrict
+}
+
+// CheckReport is the result of verifying the data files of a disk based
+// engine. Records counts the verified log records, table entries or pages.
+type CheckReport struct {
+	Files    int
+	Records  int
+	Problems []string
+	Repaired bool
+}
+
+// Problemf records damage found by a check.
+func (r *CheckReport) Problemf(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
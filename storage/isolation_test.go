@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestStorage_Set_CopiesValues(t *testing.T) {
+	s := New()
+	slice := []string{"a"}
+	s.Set("key", slice, 0)
+	slice[0] = "changed"
+	if v := s.GetItem("key").StringSlice[0]; v != "a" {
+		t.Error("Must be equal a", v)
+	}
+}
+
+// TestStorage_ConcurrentReadersAndWriters mixes in-place updates, snapshots
+// and index maintenance with readers modifying what they get; run it with
+// -race.
+func TestStorage_ConcurrentReadersAndWriters(t *testing.T) {
+	s := New()
+	s.CreateIndex(IndexSpec{Name: "name", Prefix: "user:", Field: "name"})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			profile := map[string]string{}
+			for i := 0; i < 200; i++ {
+				profile["name"] = fmt.Sprint(i)
+				s.SetStringMap(fmt.Sprintf("user:%d", i%5), profile, 0)
+				s.SetBit("bits", i, 1)
+				s.GeoAdd("geo", GeoMember{Name: fmt.Sprint(w), Longitude: float64(i % 90), Latitude: 0})
+				if i%50 == 0 {
+					snap := s.OpenSnapshot()
+					s.ReleaseSnapshot(snap.ID)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			snap := s.OpenSnapshot()
+			defer s.ReleaseSnapshot(snap.ID)
+			for i := 0; i < 200; i++ {
+				if item, _ := s.Get("user:1"); item != nil {
+					item.StringMap["name"] = "reader"
+				}
+				if item, _ := snap.GetItem("user:1"); item != nil {
+					item.StringMap["name"] = "reader"
+				}
+				if item := s.GetItem("bits"); item != nil {
+					item.Bitmap[0] = 0
+				}
+				if item := s.GetItem("geo"); item != nil {
+					item.Geo.add("reader", 0)
+				}
+				s.Lookup("name", "reader")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if keys, _ := s.Lookup("name", "reader"); len(keys) != 0 {
+		t.Error("Must not see the changes of readers", keys)
+	}
+	if count, _ := s.BitCount("bits", 0, -1); count != 200 {
+		t.Error("Must be equal 200", count)
+	}
+	if pos, _ := s.GeoPos("geo", "reader"); pos[0] != nil {
+		t.Error("Must not see the changes of readers", pos[0])
+	}
+}
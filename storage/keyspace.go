@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrSameDatabase = errors.New("Source and destination databases are the same")
+	ErrMoveTarget   = errors.New("Keys can only be moved to another in-memory storage")
+)
+
+// lastStorageID numbers storages, so operations on two of them lock them in
+// the same order.
+var lastStorageID int64
+
+// liveLocked returns the item stored under key unless it is missing or
+// expired. s.mu must be held.
+func (s *Storage) liveLocked(key string, now int64) *Item {
+	item := s.items[key]
+	if item == nil || item.expired(now) {
+		return nil
+	}
+	return item
+}
+
+// Rename moves the value and ttl of src to dst, replacing dst. With nx it
+// does nothing and returns false if dst exists.
+func (s *Storage) Rename(src, dst string, nx bool) (bool, error) {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.liveLocked(src, now)
+	if item == nil {
+		return false, fmt.Errorf("Key: %s does not exist", src)
+	}
+	if nx && s.liveLocked(dst, now) != nil {
+		return false, nil
+	}
+	if err := s.limits.CheckKey(dst); err != nil {
+		return false, err
+	}
+	if src == dst {
+		return true, nil
+	}
+	// The stored item stays in the history of src for snapshots, so dst
+	// gets a copy
+	s.putLocked(dst, item.copyOut())
+	s.deleteLocked(src)
+	return true, nil
+}
+
+// Copy copies the value and ttl of src to dst. Unless replace is set it
+// does nothing and returns false if dst exists.
+func (s *Storage) Copy(src, dst string, replace bool) (bool, error) {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.liveLocked(src, now)
+	if item == nil {
+		return false, fmt.Errorf("Key: %s does not exist", src)
+	}
+	if src == dst || (!replace && s.liveLocked(dst, now) != nil) {
+		return false, nil
+	}
+	if err := s.limits.CheckKey(dst); err != nil {
+		return false, err
+	}
+	s.putLocked(dst, item.copyOut())
+	return true, nil
+}
+
+// Move moves key with its ttl to the storage dst. It does nothing and
+// returns false if key does not exist or already exists in dst.
+func (s *Storage) Move(key string, dst Engine) (bool, error) {
+	to, ok := dst.(*Storage)
+	if !ok {
+		return false, ErrMoveTarget
+	}
+	if to == s {
+		return false, ErrSameDatabase
+	}
+	first, second := s, to
+	if first.id > second.id {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	item := s.liveLocked(key, now)
+	if item == nil || to.liveLocked(key, now) != nil {
+		return false, nil
+	}
+	if err := to.limits.Check(key, item); err != nil {
+		return false, err
+	}
+	to.putLocked(key, item.copyOut())
+	s.deleteLocked(key)
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStorage_Rename(t *testing.T) {
+	s := New()
+	s.SetStringSlice("src", []string{"a"}, 100)
+	s.SetString("dst", "old", 0)
+	s.CreateIndex(IndexSpec{Name: "name", Field: "name"})
+	s.SetStringMap("user", map[string]string{"name": "alice"}, 0)
+
+	if ok, err := s.Rename("src", "dst", true); ok || err != nil {
+		t.Error("Must not replace with nx", ok, err)
+	}
+	if ok, err := s.Rename("src", "dst", false); !ok || err != nil {
+		t.Fatal("Must rename", ok, err)
+	}
+	if item, _ := s.Get("src"); item != nil {
+		t.Error("Must remove the source", item)
+	}
+	if item, _ := s.Get("dst"); item == nil || !reflect.DeepEqual(item.StringSlice, []string{"a"}) {
+		t.Error("Must move the value", item)
+	}
+	if ttl, _ := s.TTL("dst"); ttl != 100 {
+		t.Error("Must keep the ttl", ttl)
+	}
+	if _, err := s.Rename("missing", "dst", false); err == nil {
+		t.Error("Must fail for missing keys")
+	}
+
+	s.Rename("user", "person", true)
+	if keys, _ := s.Lookup("name", "alice"); !reflect.DeepEqual(keys, []string{"person"}) {
+		t.Error("Must update indexes", keys)
+	}
+}
+
+func TestStorage_Rename_Snapshot(t *testing.T) {
+	s := New()
+	s.SetString("a", "1", 0)
+	snap := s.OpenSnapshot()
+	s.Rename("a", "b", false)
+	s.SetString("b", "2", 0)
+
+	if item, _ := snap.GetItem("a"); item == nil || item.String != "1" {
+		t.Error("Must keep the old key in the snapshot", item)
+	}
+	if item, _ := snap.GetItem("b"); item != nil {
+		t.Error("Must not see the new key in the snapshot", item)
+	}
+}
+
+func TestStorage_Copy(t *testing.T) {
+	s := New()
+	s.SetIntMap("src", map[string]int{"a": 1}, 100)
+	s.SetInt("dst", 1, 0)
+
+	if ok, _ := s.Copy("src", "dst", false); ok {
+		t.Error("Must not replace without replace")
+	}
+	if ok, _ := s.Copy("src", "dst", true); !ok {
+		t.Error("Must copy")
+	}
+	s.SetIntMap("src", map[string]int{"b": 2}, 0)
+	if item, _ := s.Get("dst"); item == nil || !reflect.DeepEqual(item.IntMap, map[string]int{"a": 1}) {
+		t.Error("Must copy the value", item)
+	}
+	if ttl, _ := s.TTL("dst"); ttl != 100 {
+		t.Error("Must copy the ttl", ttl)
+	}
+	if ok, _ := s.Copy("src", "src", true); ok {
+		t.Error("Must not copy a key to itself")
+	}
+}
+
+func TestStorage_Move(t *testing.T) {
+	db0, db1 := New(), New()
+	db0.SetString("a", "1", 0)
+	db0.SetString("b", "1", 0)
+	db1.SetString("b", "2", 0)
+
+	if ok, _ := db0.Move("a", db1); !ok {
+		t.Error("Must move")
+	}
+	if item, _ := db1.Get("a"); item == nil || item.String != "1" {
+		t.Error("Must be moved", item)
+	}
+	if item, _ := db0.Get("a"); item != nil {
+		t.Error("Must be removed", item)
+	}
+	if ok, _ := db0.Move("b", db1); ok {
+		t.Error("Must not replace existing keys")
+	}
+	if ok, _ := db0.Move("missing", db1); ok {
+		t.Error("Must not move missing keys")
+	}
+	if _, err := db0.Move("b", db0); err != ErrSameDatabase {
+		t.Error("Must return ErrSameDatabase", err)
+	}
+}
+
+// TestStorage_Move_Concurrent moves keys both ways at once, which would
+// deadlock if the storages were not locked in a fixed order.
+func TestStorage_Move_Concurrent(t *testing.T) {
+	db0, db1 := New(), New()
+	for i := 0; i < 100; i++ {
+		db0.SetInt(fmt.Sprint(i), i, 0)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprint(i)
+				if w%2 == 0 {
+					db0.Move(key, db1)
+				} else {
+					db1.Move(key, db0)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	keys0, _ := db0.Keys()
+	keys1, _ := db1.Keys()
+	if len(keys0)+len(keys1) != 100 {
+		t.Error("Must keep every key once", len(keys0), len(keys1))
+	}
+}
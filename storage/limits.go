@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrKeyTooLong      = errors.New("Key is too long")
+	ErrValueTooLarge   = errors.New("Value is too large")
+	ErrTooManyElements = errors.New("Too many elements")
+	ErrNegativeLimit   = errors.New("Limits must not be negative")
+)
+
+// LimitError reports a write exceeding a limit. It wraps ErrKeyTooLong,
+// ErrValueTooLarge or ErrTooManyElements.
+type LimitError struct {
+	Err   error
+	Size  int
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %d, the limit is %d", e.Err, e.Size, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Limits bound what can be stored. MaxKeyLength is the length of keys in
+// bytes, MaxValueSize the size of strings, bitmaps and of each string in a
+// list or map, MaxElements the number of elements of lists, maps and geo
+// sets. Zero fields are unlimited.
+type Limits struct {
+	MaxKeyLength int
+	MaxValueSize int
+	MaxElements  int
+}
+
+func (l Limits) Validate() error {
+	if l.MaxKeyLength < 0 || l.MaxValueSize < 0 || l.MaxElements < 0 {
+		return ErrNegativeLimit
+	}
+	return nil
+}
+
+func check(err error, size, limit int) error {
+	if limit > 0 && size > limit {
+		return &LimitError{Err: err, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+func (l Limits) CheckKey(key string) error {
+	return check(ErrKeyTooLong, len(key), l.MaxKeyLength)
+}
+
+func (l Limits) checkSize(size int) error {
+	return check(ErrValueTooLarge, size, l.MaxValueSize)
+}
+
+func (l Limits) checkElements(n int) error {
+	return check(ErrTooManyElements, n, l.MaxElements)
+}
+
+func (l Limits) checkStrings(values ...string) error {
+	for _, v := range values {
+		if err := l.checkSize(len(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns a *LimitError if key or item exceed the limits.
+func (l Limits) Check(key string, item *Item) error {
+	if err := l.CheckKey(key); err != nil {
+		return err
+	}
+	item = item.unpack()
+	if err := l.checkSize(len(item.String)); err != nil {
+		return err
+	}
+	if err := l.checkSize(len(item.Bitmap)); err != nil {
+		return err
+	}
+	if err := l.checkStrings(item.StringSlice...); err != nil {
+		return err
+	}
+	for k, v := range item.StringMap {
+		if err := l.checkStrings(k, v); err != nil {
+			return err
+		}
+	}
+	for k := range item.IntMap {
+		if err := l.checkSize(len(k)); err != nil {
+			return err
+		}
+	}
+	n := len(item.StringSlice) + len(item.IntSlice) + len(item.StringMap) + len(item.IntMap)
+	if item.Geo != nil {
+		n += len(item.Geo.entries)
+	}
+	if item.TimeSeries != nil {
+		n += len(item.TimeSeries.samples)
+		for _, r := range item.TimeSeries.rules {
+			if err := l.CheckKey(r.Dest); err != nil {
+				return err
+			}
+		}
+	}
+	if c := item.CRDT; c != nil {
+		n += c.Len()
+		for m := range c.adds {
+			if err := l.checkSize(len(m)); err != nil {
+				return err
+			}
+		}
+		if err := l.checkSize(len(c.value)); err != nil {
+			return err
+		}
+	}
+	return l.checkElements(n)
+}
+
+// SetLimits changes the limits checked by writes from now on.
+func (s *Storage) SetLimits(l Limits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.limits = l
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStorage_Limits(t *testing.T) {
+	s := New()
+	if err := s.SetLimits(Limits{MaxKeyLength: -1}); err != ErrNegativeLimit {
+		t.Error("Must reject negative limits", err)
+	}
+	s.SetLimits(Limits{MaxKeyLength: 4, MaxValueSize: 5, MaxElements: 3})
+
+	if err := s.SetString("abcde", "x", 0); !errors.Is(err, ErrKeyTooLong) {
+		t.Error("Must reject long keys", err)
+	}
+	err := s.SetString("a", "123456", 0)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatal("Must reject large values", err)
+	}
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Size != 6 || limitErr.Limit != 5 {
+		t.Error("Must report the size and the limit", err)
+	}
+	if err := s.SetStringSlice("a", []string{"1", "2", "3", "4"}, 0); !errors.Is(err, ErrTooManyElements) {
+		t.Error("Must reject large lists", err)
+	}
+	if err := s.SetStringMap("a", map[string]string{"k": "123456"}, 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Must check each element", err)
+	}
+	if item, _ := s.Get("a"); item != nil {
+		t.Error("Must not store rejected values", item)
+	}
+
+	if err := s.SetStringSlice("abcd", []string{"1", "2", "3"}, 0); err != nil {
+		t.Error("Must accept values within the limits", err)
+	}
+	s.Set("abcdef", "x", 0)
+	if item, _ := s.Get("abcdef"); item != nil {
+		t.Error("Set must drop values exceeding the limits", item)
+	}
+
+	s.SetLimits(Limits{})
+	if err := s.SetString(strings.Repeat("k", 100), strings.Repeat("v", 100), 0); err != nil {
+		t.Error("Zero limits must be unlimited", err)
+	}
+}
+
+func TestStorage_LimitsWrites(t *testing.T) {
+	s := New()
+	s.SetString("a", "1", 0)
+	s.SetLimits(Limits{MaxKeyLength: 4, MaxValueSize: 2, MaxElements: 2})
+
+	if _, err := s.SetBit("b", 16, 1); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Must reject bitmaps growing too large", err)
+	}
+	if _, err := s.SetBit("b", 15, 1); err != nil {
+		t.Error("Must set bits within the limits", err)
+	}
+	if _, err := s.GeoAdd("g", GeoMember{Name: "a", Longitude: 1, Latitude: 1}, GeoMember{Name: "b", Longitude: 1, Latitude: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GeoAdd("g", GeoMember{Name: "a", Longitude: 2, Latitude: 2}); err != nil {
+		t.Error("Must update existing members", err)
+	}
+	if _, err := s.GeoAdd("g", GeoMember{Name: "c", Longitude: 1, Latitude: 1}); !errors.Is(err, ErrTooManyElements) {
+		t.Error("Must reject geo sets growing too large", err)
+	}
+	if set, _ := s.Get("g"); set.Geo.Len() != 2 {
+		t.Error("Must be equal 2", set.Geo.Len())
+	}
+
+	if _, err := s.Rename("a", "abcde", false); !errors.Is(err, ErrKeyTooLong) {
+		t.Error("Must check the destination of Rename", err)
+	}
+	if _, err := s.Copy("a", "abcde", false); !errors.Is(err, ErrKeyTooLong) {
+		t.Error("Must check the destination of Copy", err)
+	}
+	if _, err := s.MSet([]KeyValue{{Key: "c", Item: stringItem("1", 0)}, {Key: "d", Item: stringItem("123", 0)}}, false); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Must check every entry of MSet", err)
+	}
+	if item, _ := s.Get("c"); item != nil {
+		t.Error("MSet must store nothing if an entry is rejected", item)
+	}
+
+	dst := New()
+	s.SetLimits(Limits{})
+	s.SetString("long", "123", 0)
+	dst.SetLimits(Limits{MaxValueSize: 2})
+	if _, err := s.Move("long", dst); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Move must check the limits of the destination", err)
+	}
+	if item, _ := s.Get("long"); item == nil {
+		t.Error("Must keep keys that could not be moved")
+	}
+}
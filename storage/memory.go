@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// Sizes are estimates of the heap memory taken by an item: the key, the
+// value and a fixed overhead for the Item struct, its map slot and its skip
+// list node. Allocator rounding is ignored.
+var (
+	stringSize    = int(unsafe.Sizeof(""))
+	entryOverhead = int(unsafe.Sizeof(Item{})) + 2*stringSize + 48
+)
+
+// mapEntryOverhead approximates the tophash byte and bucket slack of a map
+// entry.
+const mapEntryOverhead = 8
+
+var typeNames = map[byte]string{
+	kindString:      "string",
+	kindInt:         "int",
+	kindStringSlice: "string_list",
+	kindIntSlice:    "int_list",
+	kindStringMap:   "string_dict",
+	kindIntMap:      "int_dict",
+	kindBitmap:      "bitmap",
+	kindGeo:         "geo",
+	kindTimeSeries:  "timeseries",
+	kindCRDT:        "crdt",
+}
+
+// Type returns the name of the value type: string, int, string_list,
+// int_list, string_dict, int_dict, bitmap, geo, timeseries or crdt.
+func (item *Item) Type() string {
+	return typeNames[item.kind()]
+}
+
+// memorySize estimates the bytes used by key and item.
+func memorySize(key string, item *Item) int {
+	size := entryOverhead + len(key)
+	if item.packed != nil {
+		return size + cap(item.packed)
+	}
+	size += len(item.String) + cap(item.Bitmap)
+	size += 8 * cap(item.IntSlice)
+	size += stringSize * cap(item.StringSlice)
+	for _, s := range item.StringSlice {
+		size += len(s)
+	}
+	for k, v := range item.StringMap {
+		size += 2*stringSize + mapEntryOverhead + len(k) + len(v)
+	}
+	for k := range item.IntMap {
+		size += stringSize + 8 + mapEntryOverhead + len(k)
+	}
+	if item.Geo != nil {
+		for _, e := range item.Geo.entries {
+			size += 2*(stringSize+8) + mapEntryOverhead + len(e.name)
+		}
+	}
+	if item.TimeSeries != nil {
+		size += 16 * cap(item.TimeSeries.samples)
+		for _, r := range item.TimeSeries.rules {
+			size += 2*stringSize + 8 + len(r.Dest) + len(r.Aggregation)
+		}
+	}
+	if c := item.CRDT; c != nil {
+		for k := range c.p {
+			size += stringSize + 8 + mapEntryOverhead + len(k)
+		}
+		for k := range c.n {
+			size += stringSize + 8 + mapEntryOverhead + len(k)
+		}
+		for m, tags := range c.adds {
+			size += 2*stringSize + mapEntryOverhead + len(m)
+			for t := range tags {
+				size += stringSize + mapEntryOverhead + len(t)
+			}
+		}
+		for t := range c.removed {
+			size += stringSize + mapEntryOverhead + len(t)
+		}
+		size += len(c.value) + len(c.node)
+	}
+	return size
+}
+
+// accountLocked adds the size of an item being stored (sign 1) or dropped
+// (sign -1) to the running totals. s.mu must be held.
+func (s *Storage) accountLocked(item *Item, sign int) {
+	kind := item.kind()
+	s.memory.total += int64(sign * item.size)
+	s.memory.bytes[kind] += int64(sign * item.size)
+	s.memory.keys[kind] += sign
+}
+
+type memoryTotals struct {
+	total int64
+	bytes [kindCRDT + 1]int64
+	keys  [kindCRDT + 1]int
+}
+
+// KeyMemory is the estimated memory used by a key and its value.
+type KeyMemory struct {
+	Key  string
+	Type string
+	Size int
+}
+
+type TypeMemory struct {
+	Keys  int
+	Bytes int64
+}
+
+// MemoryStats is the estimated memory used by the keyspace, in total and
+// per value type. Old versions kept for snapshots are not included.
+type MemoryStats struct {
+	Total int64
+	Keys  int
+	Types map[string]TypeMemory
+}
+
+// MemoryUsage returns the estimated memory used by key, or nil if it is
+// missing or expired.
+func (s *Storage) MemoryUsage(key string) (*KeyMemory, error) {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item := s.items[key]
+	if item == nil || item.expired(now) {
+		return nil, nil
+	}
+	return &KeyMemory{Key: key, Type: item.Type(), Size: item.size}, nil
+}
+
+type keyMemoryHeap []KeyMemory
+
+func (h keyMemoryHeap) Len() int { return len(h) }
+func (h keyMemoryHeap) Less(i, j int) bool {
+	if h[i].Size != h[j].Size {
+		return h[i].Size < h[j].Size
+	}
+	return h[i].Key > h[j].Key
+}
+func (h keyMemoryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyMemoryHeap) Push(x interface{}) { *h = append(*h, x.(KeyMemory)) }
+func (h *keyMemoryHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// MemoryTop returns the n largest keys, largest first.
+func (s *Storage) MemoryTop(n int) ([]KeyMemory, error) {
+	if n <= 0 {
+		return []KeyMemory{}, nil
+	}
+	now := time.Now().UnixNano()
+	h := make(keyMemoryHeap, 0, n)
+	s.mu.RLock()
+	for key, item := range s.items {
+		if item.expired(now) {
+			continue
+		}
+		km := KeyMemory{Key: key, Type: item.Type(), Size: item.size}
+		if h.Len() < n {
+			heap.Push(&h, km)
+		} else if (keyMemoryHeap{h[0], km}).Less(0, 1) {
+			h[0] = km
+			heap.Fix(&h, 0)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(sort.Reverse(h))
+	return h, nil
+}
+
+// MemoryStats returns the estimated memory used by all keys, including
+// expired keys not deleted yet.
+func (s *Storage) MemoryStats() (*MemoryStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := &MemoryStats{Total: s.memory.total, Types: make(map[string]TypeMemory)}
+	for kind, name := range typeNames {
+		if s.memory.keys[kind] > 0 {
+			stats.Types[name] = TypeMemory{Keys: s.memory.keys[kind], Bytes: s.memory.bytes[kind]}
+			stats.Keys += s.memory.keys[kind]
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStorage_MemoryUsage(t *testing.T) {
+	s := New()
+	s.SetString("small", "v", 0)
+	s.SetString("large", strings.Repeat("v", 10000), 0)
+
+	small, _ := s.MemoryUsage("small")
+	large, _ := s.MemoryUsage("large")
+	if small == nil || small.Type != "string" || small.Size < len("small")+1 {
+		t.Fatal("Must report the usage", small)
+	}
+	if large == nil || large.Size-small.Size != 9999+len("large")-len("small") {
+		t.Error("Must count the payload", small, large)
+	}
+	if m, _ := s.MemoryUsage("missing"); m != nil {
+		t.Error("Must be nil", m)
+	}
+
+	s.SetCompression(Compression{Algorithm: "flate"})
+	s.SetString("packed", strings.Repeat("v", 10000), 0)
+	if m, _ := s.MemoryUsage("packed"); m == nil || m.Type != "string" || m.Size > large.Size/10 {
+		t.Error("Must count the compressed size", m)
+	}
+}
+
+// TestStorage_MemoryStats checks the running totals against the sizes of
+// the stored items over random writes, in-place updates and removals.
+func TestStorage_MemoryStats(t *testing.T) {
+	s := New()
+	r := rand.New(rand.NewSource(1))
+	snap := s.OpenSnapshot()
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key:%d", r.Intn(300))
+		switch r.Intn(6) {
+		case 0:
+			s.Remove(key)
+		case 1:
+			s.SetStringSlice(key, strings.Fields(strings.Repeat("a b ", r.Intn(20)+1)), 0)
+		case 2:
+			s.SetIntMap(key, map[string]int{"x": i, key: 1}, 0)
+		case 3:
+			s.SetBit("bits:"+key, r.Intn(1000), 1)
+		case 4:
+			s.Expire(key, 100)
+		default:
+			s.SetInt(key, i, 0)
+		}
+		if i == 1500 {
+			s.ReleaseSnapshot(snap.ID)
+		}
+	}
+
+	want := &MemoryStats{Types: map[string]TypeMemory{}}
+	for key, item := range s.items {
+		m, _ := s.MemoryUsage(key)
+		if m.Size != memorySize(key, item) {
+			t.Fatal("Must be up to date", key, m.Size, memorySize(key, item))
+		}
+		want.Total += int64(m.Size)
+		want.Keys++
+		tm := want.Types[m.Type]
+		tm.Keys++
+		tm.Bytes += int64(m.Size)
+		want.Types[m.Type] = tm
+	}
+	stats, _ := s.MemoryStats()
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Must be equal %+v, got %+v", want, stats)
+	}
+
+	for key := range s.items {
+		s.Remove(key)
+	}
+	if stats, _ := s.MemoryStats(); stats.Total != 0 || stats.Keys != 0 || len(stats.Types) != 0 {
+		t.Error("Must be empty", stats)
+	}
+}
+
+func TestStorage_MemoryTop(t *testing.T) {
+	s := New()
+	for _, i := range rand.Perm(100) {
+		s.SetString(fmt.Sprintf("key:%02d", i), strings.Repeat("v", i+1), 0)
+	}
+	s.SetString("tie", strings.Repeat("v", 100), 0) // as large as key:96
+
+	top, _ := s.MemoryTop(5)
+	keys := []string{}
+	for _, m := range top {
+		keys = append(keys, m.Key)
+	}
+	if want := []string{"key:99", "key:98", "key:97", "key:96", "tie"}; !reflect.DeepEqual(keys, want) {
+		t.Error("Must be equal", want, keys)
+	}
+	if top, _ := s.MemoryTop(1000); len(top) != 101 {
+		t.Error("Must return every key", len(top))
+	}
+	if top, _ := s.MemoryTop(0); len(top) != 0 {
+		t.Error("Must be empty", top)
+	}
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Every write advances the storage revision and stamps the written item with
+// it. While snapshots are open, versions they can still see are moved to the
+// history instead of being dropped, and in-place updates work on a copy.
+
+var ErrSnapshotNotFound = errors.New("Snapshot does not exist or has expired")
+
+// SnapshotTimeout is how long a snapshot may stay unused before it is
+// released by DeleteExpired.
+var SnapshotTimeout = 5 * time.Minute
+
+type version struct {
+	item *Item
+	end  int64 // revision of the write that replaced or removed item
+}
+
+type snapshotState struct {
+	rev      int64
+	lastUsed time.Time
+	pinned   bool // held by a dump, which releases it, so it never expires
+}
+
+// Snapshot is a consistent read view of the storage at a revision.
+type Snapshot struct {
+	ID  int64
+	Rev int64
+
+	s *Storage
+}
+
+func (s *Storage) Revision() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rev
+}
+
+// visibleLocked reports whether an open snapshot may read item.
+func (s *Storage) visibleLocked(item *Item) bool {
+	for _, snap := range s.snapshots {
+		if snap.rev >= item.rev {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveLocked keeps the current version of key for open snapshots before
+// it is replaced or removed by the write at revision end.
+func (s *Storage) archiveLocked(key string, old *Item, end int64) {
+	if old != nil && s.visibleLocked(old) {
+		s.history[key] = append(s.history[key], version{item: old, end: end})
+	}
+}
+
+// writableLocked returns item itself if no snapshot can see it, otherwise a
+// copy that can be modified and stored with putLocked.
+func (s *Storage) writableLocked(item *Item) *Item {
+	if s.visibleLocked(item) {
+		return item.clone()
+	}
+	return item
+}
+
+func (s *Storage) OpenSnapshot() *Snapshot {
+	return s.openSnapshot(false)
+}
+
+func (s *Storage) openSnapshot(pinned bool) *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSnapshotID++
+	s.snapshots[s.lastSnapshotID] = &snapshotState{rev: s.rev, lastUsed: time.Now(), pinned: pinned}
+	return &Snapshot{ID: s.lastSnapshotID, Rev: s.rev, s: s}
+}
+
+// GetSnapshot returns an open snapshot and marks it as used.
+func (s *Storage) GetSnapshot(id int64) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return nil, ErrSnapshotNotFound
+	}
+	snap.lastUsed = time.Now()
+	return &Snapshot{ID: id, Rev: snap.rev, s: s}, nil
+}
+
+func (s *Storage) ReleaseSnapshot(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snapshots[id]; !ok {
+		return ErrSnapshotNotFound
+	}
+	delete(s.snapshots, id)
+	s.gcLocked()
+	return nil
+}
+
+// expireSnapshotsLocked releases snapshots unused for SnapshotTimeout,
+// except the ones pinned by dumps.
+func (s *Storage) expireSnapshotsLocked() {
+	deadline := time.Now().Add(-SnapshotTimeout)
+	expired := false
+	for id, snap := range s.snapshots {
+		if !snap.pinned && snap.lastUsed.Before(deadline) {
+			delete(s.snapshots, id)
+			expired = true
+		}
+	}
+	if expired {
+		s.gcLocked()
+	}
+}
+
+// gcLocked drops the versions no open snapshot can see anymore.
+func (s *Storage) gcLocked() {
+	if len(s.snapshots) == 0 {
+		s.history = make(map[string][]version)
+		return
+	}
+	for key, versions := range s.history {
+		kept := versions[:0]
+		for _, v := range versions {
+			for _, snap := range s.snapshots {
+				if snap.rev >= v.item.rev && snap.rev < v.end {
+					kept = append(kept, v)
+					break
+				}
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.history, key)
+		} else {
+			s.history[key] = kept
+		}
+	}
+}
+
+func (snap *Snapshot) open() bool {
+	_, ok := snap.s.snapshots[snap.ID]
+	return ok
+}
+
+// itemLocked returns the version of key visible at the snapshot.
+func (snap *Snapshot) itemLocked(key string, now int64) *Item {
+	s := snap.s
+	var item *Item
+	if cur, ok := s.items[key]; ok && cur.rev <= snap.Rev {
+		item = cur
+	} else {
+		for _, v := range s.history[key] {
+			if v.item.rev <= snap.Rev && snap.Rev < v.end {
+				item = v.item
+				break
+			}
+		}
+	}
+	if item == nil || item.expired(now) {
+		return nil
+	}
+	return item
+}
+
+// GetItem returns the value key had at the snapshot revision, or nil.
+func (snap *Snapshot) GetItem(key string) (*Item, error) {
+	snap.s.mu.RLock()
+	defer snap.s.mu.RUnlock()
+
+	if !snap.open() {
+		return nil, ErrSnapshotNotFound
+	}
+	return snap.itemLocked(key, time.Now().UnixNano()).copyOut(), nil
+}
+
+// Keys returns the keys that existed at the snapshot revision in order.
+func (snap *Snapshot) Keys() ([]string, error) {
+	keys, _, err := snap.Scan(ScanOptions{})
+	return keys, err
+}
+
+// Scan works like Storage.Scan against the snapshot revision.
+func (snap *Snapshot) Scan(opts ScanOptions) ([]string, string, error) {
+	s := snap.s
+	now := time.Now().UnixNano()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !snap.open() {
+		return nil, "", ErrSnapshotNotFound
+	}
+
+	// Candidates are the current keys in range plus the removed or replaced
+	// keys kept in the history.
+	unlimited := opts
+	unlimited.Limit = 0
+	candidates, _ := s.scanLocked(unlimited, func(string) bool { return true })
+	for key := range s.history {
+		if _, ok := s.items[key]; !ok && opts.Contains(key) {
+			candidates = append(candidates, key)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i] < candidates[j] != opts.Reverse
+	})
+
+	keys := []string{}
+	for _, key := range candidates {
+		if snap.itemLocked(key, now) == nil {
+			continue
+		}
+		if opts.Limit > 0 && len(keys) == opts.Limit {
+			return keys, keys[len(keys)-1], nil
+		}
+		keys = append(keys, key)
+	}
+	return keys, "", nil
+}
+
+func (snap *Snapshot) Release() error {
+	return snap.s.ReleaseSnapshot(snap.ID)
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshot_GetItem(t *testing.T) {
+	s := New()
+	s.SetString("a", "old", 0)
+	s.SetString("b", "old", 0)
+
+	snap := s.OpenSnapshot()
+	s.SetString("a", "new", 0)
+	s.Remove("b")
+	s.SetString("c", "new", 0)
+
+	if item, _ := snap.GetItem("a"); item == nil || item.String != "old" {
+		t.Error("Must see the old value of a", item)
+	}
+	if item, _ := snap.GetItem("b"); item == nil || item.String != "old" {
+		t.Error("Must see removed key b", item)
+	}
+	if item, _ := snap.GetItem("c"); item != nil {
+		t.Error("Must not see key c created later", item)
+	}
+	if s.GetItem("a").String != "new" {
+		t.Error("Must see the new value outside the snapshot")
+	}
+}
+
+func TestSnapshot_InPlaceUpdates(t *testing.T) {
+	s := New()
+	s.SetBit("bits", 0, 1)
+	s.GeoAdd("geo", GeoMember{Name: "a", Longitude: 1, Latitude: 1})
+
+	snap := s.OpenSnapshot()
+	s.SetBit("bits", 1, 1)
+	s.GeoAdd("geo", GeoMember{Name: "b", Longitude: 2, Latitude: 2})
+
+	if item, _ := snap.GetItem("bits"); item.Bitmap[0] != 0x80 {
+		t.Error("Must see the bitmap before SETBIT", item.Bitmap)
+	}
+	if item, _ := snap.GetItem("geo"); item.Geo.Len() != 1 {
+		t.Error("Must see the geo set before GEOADD", item.Geo.Len())
+	}
+	if s.GetItem("bits").Bitmap[0] != 0xc0 {
+		t.Error("Must be updated outside the snapshot", s.GetItem("bits").Bitmap)
+	}
+}
+
+func TestSnapshot_Scan(t *testing.T) {
+	s := New()
+	for _, k := range []string{"k1", "k2", "k3", "k4"} {
+		s.SetString(k, "v", 0)
+	}
+
+	snap := s.OpenSnapshot()
+	s.Remove("k2")
+	s.SetString("k0", "v", 0)
+	s.SetString("k5", "v", 0)
+
+	keys, _ := snap.Keys()
+	if !equalKeys(keys, []string{"k1", "k2", "k3", "k4"}) {
+		t.Error("Must list keys of the snapshot", keys)
+	}
+
+	keys, cursor, _ := snap.Scan(ScanOptions{Limit: 2, Reverse: true})
+	if !equalKeys(keys, []string{"k4", "k3"}) || cursor != "k3" {
+		t.Error("Must page in reverse", keys, cursor)
+	}
+	keys, cursor, _ = snap.Scan(ScanOptions{Limit: 2, Reverse: true, Cursor: cursor})
+	if !equalKeys(keys, []string{"k2", "k1"}) || cursor != "" {
+		t.Error("Must return the last page", keys, cursor)
+	}
+}
+
+func TestSnapshot_Release(t *testing.T) {
+	s := New()
+	s.SetString("a", "v1", 0)
+
+	snap1 := s.OpenSnapshot()
+	s.SetString("a", "v2", 0)
+	snap2 := s.OpenSnapshot()
+	s.SetString("a", "v3", 0)
+
+	if len(s.history["a"]) != 2 {
+		t.Fatal("Must keep two versions", len(s.history["a"]))
+	}
+
+	snap1.Release()
+	if len(s.history["a"]) != 1 {
+		t.Error("Must collect the version only snap1 could see", len(s.history["a"]))
+	}
+	if item, _ := snap2.GetItem("a"); item.String != "v2" {
+		t.Error("Must keep the version of snap2", item.String)
+	}
+
+	snap2.Release()
+	if len(s.history) != 0 {
+		t.Error("Must drop the history", s.history)
+	}
+	if _, err := snap2.GetItem("a"); err != ErrSnapshotNotFound {
+		t.Error("Must return ErrSnapshotNotFound", err)
+	}
+	if _, err := s.GetSnapshot(snap2.ID); err != ErrSnapshotNotFound {
+		t.Error("Must return ErrSnapshotNotFound", err)
+	}
+}
+
+func TestSnapshot_NoHistoryWithoutSnapshots(t *testing.T) {
+	s := New()
+	s.SetString("a", "v1", 0)
+	s.SetString("a", "v2", 0)
+	s.Remove("a")
+	if len(s.history) != 0 {
+		t.Error("Must not keep versions", s.history)
+	}
+	if s.Revision() != 3 {
+		t.Error("Must be equal 3", s.Revision())
+	}
+}
+
+func TestSnapshot_Timeout(t *testing.T) {
+	defer func(d time.Duration) { SnapshotTimeout = d }(SnapshotTimeout)
+	SnapshotTimeout = time.Millisecond
+
+	s := New()
+	s.SetString("a", "v1", 0)
+	snap := s.OpenSnapshot()
+	s.SetString("a", "v2", 0)
+
+	time.Sleep(5 * time.Millisecond)
+	s.DeleteExpired()
+
+	if _, err := snap.GetItem("a"); err != ErrSnapshotNotFound {
+		t.Error("Must be expired", err)
+	}
+	if len(s.history) != 0 {
+		t.Error("Must drop the history", s.history)
+	}
+}
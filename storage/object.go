@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// accessStats is shared by the versions of a key. Reads update it under a
+// read lock, so it is only accessed atomically.
+type accessStats struct {
+	last int64 // unix time in nanoseconds
+	hits int64
+}
+
+// touch records a read or write of the item.
+func (item *Item) touch(now int64) {
+	if item != nil && item.access != nil {
+		atomic.StoreInt64(&item.access.last, now)
+		atomic.AddInt64(&item.access.hits, 1)
+	}
+}
+
+func (item *Item) lastAccess() int64 {
+	return atomic.LoadInt64(&item.access.last)
+}
+
+// stampLocked sets the metadata of an item about to replace old, which is
+// nil for new keys. s.mu must be held.
+func stampLocked(item, old *Item, now int64) {
+	if old == nil {
+		item.created = now
+		item.access = &accessStats{}
+	} else {
+		item.created, item.access = old.created, old.access
+	}
+	item.updated = now
+	item.touch(now)
+}
+
+// ObjectInfo is the metadata of a key. Hits counts reads and writes since
+// the key was created; renaming or moving a key creates a new one.
+type ObjectInfo struct {
+	Type     string
+	Encoding string // compression algorithm or none
+	Size     int    // estimated memory
+	Created  time.Time
+	Updated  time.Time
+	Accessed time.Time
+	Hits     int64
+}
+
+// Object returns the metadata of key, or nil if it is missing or expired.
+// It does not count as an access.
+func (s *Storage) Object(key string) (*ObjectInfo, error) {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item := s.liveLocked(key, now)
+	if item == nil {
+		return nil, nil
+	}
+	info := &ObjectInfo{
+		Type:     item.Type(),
+		Encoding: "none",
+		Size:     item.size,
+		Created:  time.Unix(0, item.created),
+		Updated:  time.Unix(0, item.updated),
+		Accessed: time.Unix(0, item.lastAccess()),
+		Hits:     atomic.LoadInt64(&item.access.hits),
+	}
+	if item.packed != nil {
+		info.Encoding = EncodingStats(item.packed).Algorithm
+	}
+	return info, nil
+}
+
+type KeyIdle struct {
+	Key  string
+	Idle time.Duration
+}
+
+// IdleKeys returns the n keys not accessed for the longest time, most idle
+// first.
+func (s *Storage) IdleKeys(n int) ([]KeyIdle, error) {
+	if n <= 0 {
+		return []KeyIdle{}, nil
+	}
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	keys := make([]KeyIdle, 0, len(s.items))
+	for key, item := range s.items {
+		if !item.expired(now) {
+			keys = append(keys, KeyIdle{Key: key, Idle: time.Duration(now - item.lastAccess())})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Idle != keys[j].Idle {
+			return keys[i].Idle > keys[j].Idle
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys, nil
+}
+
+// EvictIdle removes the keys not accessed for at least maxIdle and returns
+// their number.
+func (s *Storage) EvictIdle(maxIdle time.Duration) (int, error) {
+	deadline := time.Now().UnixNano() - int64(maxIdle)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	evicted := 0
+	for key, item := range s.items {
+		if item.lastAccess() <= deadline {
+			s.deleteLocked(key)
+			evicted++
+		}
+	}
+	return evicted, nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorage_Object(t *testing.T) {
+	s := New()
+	before := time.Now()
+	s.SetString("key", "v", 0)
+	info, _ := s.Object("key")
+	if info == nil || info.Type != "string" || info.Encoding != "none" || info.Hits != 1 {
+		t.Fatal("Must return the metadata", info)
+	}
+	if info.Created.Before(before) || !info.Updated.Equal(info.Created) || !info.Accessed.Equal(info.Created) {
+		t.Error("Must set the times on creation", info)
+	}
+
+	time.Sleep(time.Millisecond)
+	s.Get("key")
+	s.GetString("key")
+	again, _ := s.Object("key")
+	if again.Hits != 3 || !again.Accessed.After(info.Accessed) || !again.Updated.Equal(info.Updated) {
+		t.Error("Must count reads", again)
+	}
+
+	time.Sleep(time.Millisecond)
+	s.SetString("key", "w", 0)
+	again, _ = s.Object("key")
+	if !again.Created.Equal(info.Created) || !again.Updated.After(info.Updated) || again.Hits != 4 {
+		t.Error("Must keep the creation time on updates", again)
+	}
+
+	if info, _ := s.Object("missing"); info != nil {
+		t.Error("Must be nil", info)
+	}
+}
+
+func TestStorage_Object_InPlaceAndCompressed(t *testing.T) {
+	s := New()
+	s.SetCompression(Compression{Algorithm: "gzip", Threshold: 64})
+	for i := 0; i < 10; i++ {
+		s.SetBit("bits", 8*i*100, 1)
+	}
+	s.items["bits"].updated -= int64(packIdle)
+	s.DeleteExpired()
+	s.BitCount("bits", 0, -1)
+	info, _ := s.Object("bits")
+	if info.Type != "bitmap" || info.Encoding != "gzip" || info.Hits != 11 {
+		t.Error("Must follow in-place updates of compressed items", info)
+	}
+}
+
+func TestStorage_IdleKeys(t *testing.T) {
+	s := New()
+	s.SetString("old", "v", 0)
+	s.SetString("read", "v", 0)
+	time.Sleep(20 * time.Millisecond)
+	s.SetString("new", "v", 0)
+	s.Get("read")
+
+	keys, _ := s.IdleKeys(2)
+	if len(keys) != 2 || keys[0].Key != "old" || keys[0].Idle < 20*time.Millisecond {
+		t.Error("Must return the most idle keys first", keys)
+	}
+	for _, n := range []int{0, -1} {
+		if keys, err := s.IdleKeys(n); err != nil || len(keys) != 0 {
+			t.Error("Must return no keys", n, keys, err)
+		}
+	}
+
+	evicted, _ := s.EvictIdle(10 * time.Millisecond)
+	if evicted != 1 {
+		t.Error("Must be equal 1", evicted)
+	}
+	if keys, _ := s.Keys(); len(keys) != 2 {
+		t.Error("Must keep recently used keys", keys)
+	}
+}
+
+// TestStorage_Object_Concurrent counts concurrent reads; run it with -race.
+func TestStorage_Object_Concurrent(t *testing.T) {
+	s := New()
+	s.SetString("key", "v", 0)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				s.Get("key")
+				s.Object("key")
+				if w == 0 && i%10 == 0 {
+					s.SetString(fmt.Sprint("other", i), "v", 0)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if info, _ := s.Object("key"); info.Hits != 801 {
+		t.Error("Must be equal 801", info.Hits)
+	}
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var ErrRandomCount = errors.New("Count must be positive")
+
+// keySet keeps the keys of the storage in a slice, so a key is picked
+// uniformly at random in O(1). A key is removed by moving the last key
+// into its slot.
+type keySet struct {
+	keys []string
+	slot map[string]int
+}
+
+func newKeySet() *keySet {
+	return &keySet{slot: make(map[string]int)}
+}
+
+func (ks *keySet) add(key string) {
+	ks.slot[key] = len(ks.keys)
+	ks.keys = append(ks.keys, key)
+}
+
+func (ks *keySet) remove(key string) {
+	i, ok := ks.slot[key]
+	if !ok {
+		return
+	}
+	last := len(ks.keys) - 1
+	ks.keys[i] = ks.keys[last]
+	ks.slot[ks.keys[i]] = i
+	ks.keys[last] = ""
+	ks.keys = ks.keys[:last]
+	delete(ks.slot, key)
+}
+
+// RandomKeys returns up to count distinct keys picked uniformly at random,
+// in random order. Expired keys not deleted yet are skipped; when most keys
+// are expired, or count is close to the number of keys, the keys are
+// shuffled instead.
+func (s *Storage) RandomKeys(count int) ([]string, error) {
+	if count <= 0 {
+		return nil, ErrRandomCount
+	}
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.random.keys
+	if count > len(keys)/2 {
+		return s.shuffledLocked(keys, count, now), nil
+	}
+	result := make([]string, 0, count)
+	seen := make(map[int]bool, count)
+	for tries := 0; len(result) < count; tries++ {
+		if tries == 4*count {
+			return s.shuffledLocked(keys, count, now), nil
+		}
+		i := rand.Intn(len(keys))
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		if !s.items[keys[i]].expired(now) {
+			result = append(result, keys[i])
+		}
+	}
+	return result, nil
+}
+
+// shuffledLocked returns up to count live keys of keys with a partial
+// Fisher-Yates shuffle of a copy. s.mu must be held.
+func (s *Storage) shuffledLocked(keys []string, count int, now int64) []string {
+	keys = append([]string{}, keys...)
+	result := make([]string, 0, count)
+	for i := 0; i < len(keys) && len(result) < count; i++ {
+		j := i + rand.Intn(len(keys)-i)
+		keys[i], keys[j] = keys[j], keys[i]
+		if !s.items[keys[i]].expired(now) {
+			result = append(result, keys[i])
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestStorage_RandomKeys(t *testing.T) {
+	s := New()
+	if keys, _ := s.RandomKeys(1); len(keys) != 0 {
+		t.Error("Must return no keys when empty", keys)
+	}
+	if _, err := s.RandomKeys(0); err != ErrRandomCount {
+		t.Error("Must return ErrRandomCount", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.SetInt(fmt.Sprintf("key%d", i), i+1, 0)
+	}
+	for i := 0; i < 100; i += 2 {
+		s.Remove(fmt.Sprintf("key%d", i))
+	}
+	if len(s.random.keys) != 50 || len(s.random.slot) != 50 {
+		t.Fatal("Must drop removed keys", len(s.random.keys))
+	}
+	for i, key := range s.random.keys {
+		if s.random.slot[key] != i || s.items[key] == nil {
+			t.Fatal("Must keep the slots of the keys", key, i)
+		}
+	}
+
+	for _, count := range []int{1, 10, 40, 50, 80} {
+		keys, err := s.RandomKeys(count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := count
+		if want > 50 {
+			want = 50
+		}
+		if len(keys) != want {
+			t.Errorf("Must return %d keys, got %d", want, len(keys))
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			if s.items[key] == nil || i > 0 && keys[i-1] == key {
+				t.Error("Must return distinct existing keys", keys)
+				break
+			}
+		}
+	}
+
+	// Expired keys not deleted yet are skipped.
+	for i := 1; i < 100; i += 2 {
+		if i != 51 {
+			s.items[fmt.Sprintf("key%d", i)].expiration = time.Now().UnixNano() - 1
+		}
+	}
+	if keys, _ := s.RandomKeys(1); len(keys) != 1 || keys[0] != "key51" {
+		t.Error("Must skip expired keys", keys)
+	}
+	if keys, _ := s.RandomKeys(10); len(keys) != 1 {
+		t.Error("Must return the live keys", keys)
+	}
+}
+
+func TestStorage_RandomKeysUniform(t *testing.T) {
+	s := New()
+	for i := 0; i < 10; i++ {
+		s.SetInt(fmt.Sprintf("key%d", i), i+1, 0)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		keys, _ := s.RandomKeys(1)
+		counts[keys[0]]++
+	}
+	for key, n := range counts {
+		if n < 800 || n > 1200 {
+			t.Errorf("Must pick every key about 1000 times, got %d for %s", n, key)
+		}
+	}
+	if len(counts) != 10 {
+		t.Error("Must pick every key", counts)
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Rate limiters keep their state in an int_dict under their key, whose
+// expiration is set to when the limiter is back to its initial state, so
+// idle limiters clean themselves up.
+//
+// A token bucket holds up to Limit tokens and refills at Limit per Period;
+// its dict has the tokens in millionths and the time of the last update.
+// A sliding window allows Limit per Period, estimating the requests of the
+// last Period from the count of the current fixed window plus the count of
+// the previous one weighted by how much it overlaps; its dict has the start
+// of the current window and both counts. Times are unix nanoseconds.
+const (
+	RateTokenBucket   = "token_bucket"
+	RateSlidingWindow = "sliding_window"
+
+	tokenScale = 1e6
+)
+
+var (
+	ErrRateAlgorithm = errors.New("Unsupported rate limit algorithm. Please use token_bucket or sliding_window")
+	ErrRateLimit     = errors.New("Rate limit and period must be positive")
+	ErrRateCost      = errors.New("Cost must be positive and not exceed the limit")
+)
+
+// RateLimiter describes a rate limit of Limit per Period.
+type RateLimiter struct {
+	Algorithm string
+	Limit     int
+	Period    time.Duration
+}
+
+func (l RateLimiter) Validate() error {
+	if l.Algorithm != RateTokenBucket && l.Algorithm != RateSlidingWindow {
+		return ErrRateAlgorithm
+	}
+	if l.Limit <= 0 || l.Period <= 0 {
+		return ErrRateLimit
+	}
+	return nil
+}
+
+// RateLimitResult tells whether a request was allowed, how many more are
+// allowed right now and, for denied ones, how long until it would be.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// RateLimit checks whether a request of cost fits the limiter of key and
+// consumes it if it does, atomically. Denied requests consume nothing. It
+// fails with ErrWrongType if key holds something else than the state of a
+// limiter of the same algorithm.
+func (s *Storage) RateLimit(key string, limiter RateLimiter, cost int) (*RateLimitResult, error) {
+	if err := limiter.Validate(); err != nil {
+		return nil, err
+	}
+	if cost <= 0 || cost > limiter.Limit {
+		return nil, ErrRateCost
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rateLimitLocked(key, limiter, cost, time.Now().UnixNano())
+}
+
+func (s *Storage) rateLimitLocked(key string, limiter RateLimiter, cost int, now int64) (*RateLimitResult, error) {
+	var state map[string]int
+	if item := s.liveLocked(key, now); item != nil {
+		item = item.unpack()
+		if item.IntMap == nil {
+			return nil, ErrWrongType
+		}
+		state = item.IntMap
+	}
+	var result *RateLimitResult
+	var next map[string]int
+	var expires int64
+	var err error
+	if limiter.Algorithm == RateTokenBucket {
+		result, next, expires, err = tokenBucket(state, limiter, cost, now)
+	} else {
+		result, next, expires, err = slidingWindow(state, limiter, cost, now)
+	}
+	if err != nil || !result.Allowed {
+		return result, err
+	}
+	item := &Item{IntMap: next, expiration: expires}
+	if err := s.limits.Check(key, item); err != nil {
+		return nil, err
+	}
+	s.updateLocked(key, item)
+	return result, nil
+}
+
+func tokenBucket(state map[string]int, limiter RateLimiter, cost int, now int64) (*RateLimitResult, map[string]int, int64, error) {
+	perNano := float64(limiter.Limit) / float64(limiter.Period)
+	tokens := float64(limiter.Limit)
+	if state != nil {
+		stored, ok1 := state["tokens"]
+		updated, ok2 := state["updated"]
+		if !ok1 || !ok2 || len(state) != 2 {
+			return nil, nil, 0, ErrWrongType
+		}
+		elapsed := math.Max(float64(now-int64(updated)), 0)
+		tokens = math.Min(float64(stored)/tokenScale+elapsed*perNano, tokens)
+	}
+	if tokens < float64(cost) {
+		return &RateLimitResult{
+			Remaining:  int(tokens),
+			RetryAfter: time.Duration(math.Ceil((float64(cost) - tokens) / perNano)),
+		}, nil, 0, nil
+	}
+	tokens -= float64(cost)
+	next := map[string]int{"tokens": int(tokens * tokenScale), "updated": int(now)}
+	full := now + int64(math.Ceil((float64(limiter.Limit)-tokens)/perNano))
+	return &RateLimitResult{Allowed: true, Remaining: int(tokens)}, next, full, nil
+}
+
+func slidingWindow(state map[string]int, limiter RateLimiter, cost int, now int64) (*RateLimitResult, map[string]int, int64, error) {
+	period := int64(limiter.Period)
+	window := now - now%period
+	count, previous := 0, 0
+	if state != nil {
+		start, ok1 := state["window"]
+		c, ok2 := state["count"]
+		p, ok3 := state["previous"]
+		if !ok1 || !ok2 || !ok3 || len(state) != 3 {
+			return nil, nil, 0, ErrWrongType
+		}
+		switch int64(start) {
+		case window:
+			count, previous = c, p
+		case window - period:
+			previous = c
+		}
+	}
+	overlap := 1 - float64(now-window)/float64(period)
+	estimate := float64(previous)*overlap + float64(count)
+	limit := float64(limiter.Limit)
+	if estimate+float64(cost) > limit {
+		return &RateLimitResult{
+			Remaining:  int(math.Max(limit-estimate, 0)),
+			RetryAfter: windowRetry(window, now, period, count, previous, cost, limiter.Limit),
+		}, nil, 0, nil
+	}
+	count += cost
+	next := map[string]int{"window": int(window), "count": count, "previous": previous}
+	remaining := int(limit - estimate - float64(cost))
+	return &RateLimitResult{Allowed: true, Remaining: remaining}, next, window + 2*period, nil
+}
+
+// windowRetry returns how long until a request of cost fits a sliding
+// window: the counts only change as the previous window slides out, or
+// once the current window becomes the previous one.
+func windowRetry(window, now, period int64, count, previous, cost, limit int) time.Duration {
+	room := float64(limit - cost - count)
+	if room >= 0 {
+		// previous*(1-(t-window)/period) <= room
+		t := window + int64(math.Ceil((1-room/float64(previous))*float64(period)))
+		return time.Duration(t - now)
+	}
+	// count*(1-(t-next)/period) <= limit-cost in the next window.
+	t := window + period + int64(math.Ceil((1-float64(limit-cost)/float64(count))*float64(period)))
+	return time.Duration(t - now)
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_RateLimitTokenBucket(t *testing.T) {
+	s := New()
+	limiter := RateLimiter{Algorithm: RateTokenBucket, Limit: 10, Period: time.Second}
+	now := time.Now().UnixNano()
+
+	for i := 0; i < 10; i++ {
+		res, err := s.rateLimitLocked("api", limiter, 1, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != 9-i {
+			t.Fatalf("Must allow the burst, got %+v at %d", res, i)
+		}
+	}
+	res, _ := s.rateLimitLocked("api", limiter, 1, now)
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+		t.Errorf("Must deny with a retry after one token, got %+v", res)
+	}
+
+	// Tokens refill at 10 per second.
+	res, _ = s.rateLimitLocked("api", limiter, 3, now+int64(250*time.Millisecond))
+	if res.Allowed || res.Remaining != 2 {
+		t.Errorf("Must deny a cost of 3 with 2.5 tokens, got %+v", res)
+	}
+	res, _ = s.rateLimitLocked("api", limiter, 2, now+int64(250*time.Millisecond))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Must allow a cost of 2, got %+v", res)
+	}
+
+	item := s.items["api"]
+	if want := now + int64(250*time.Millisecond) + int64(950*time.Millisecond); item.expiration != want {
+		t.Error("Must expire once the bucket is full again", item.expiration-want)
+	}
+
+	if _, err := s.RateLimit("api", limiter, 11); err != ErrRateCost {
+		t.Error("Must return ErrRateCost", err)
+	}
+	if _, err := s.RateLimit("api", RateLimiter{Algorithm: "leaky", Limit: 1, Period: 1}, 1); err != ErrRateAlgorithm {
+		t.Error("Must return ErrRateAlgorithm", err)
+	}
+	s.SetString("str", "val", 0)
+	if _, err := s.RateLimit("str", limiter, 1); err != ErrWrongType {
+		t.Error("Must return ErrWrongType", err)
+	}
+}
+
+func TestStorage_RateLimitSlidingWindow(t *testing.T) {
+	s := New()
+	limiter := RateLimiter{Algorithm: RateSlidingWindow, Limit: 10, Period: time.Second}
+	second := int64(time.Second)
+	window := time.Now().UnixNano()/second*second + 10*second
+
+	for i := 0; i < 8; i++ {
+		if res, _ := s.rateLimitLocked("api", limiter, 1, window+int64(500*time.Millisecond)); !res.Allowed {
+			t.Fatal("Must allow", i)
+		}
+	}
+	res, _ := s.rateLimitLocked("api", limiter, 3, window+int64(900*time.Millisecond))
+	if res.Allowed || res.Remaining != 2 || res.RetryAfter != 100*time.Millisecond+125*time.Millisecond {
+		t.Errorf("Must deny until the window slides, got %+v", res)
+	}
+
+	// A quarter into the next window the previous 8 count for 6.
+	res, _ = s.rateLimitLocked("api", limiter, 4, window+second+int64(250*time.Millisecond))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Must allow 4 more, got %+v", res)
+	}
+	res, _ = s.rateLimitLocked("api", limiter, 1, window+second+int64(250*time.Millisecond))
+	if res.Allowed || res.RetryAfter != 125*time.Millisecond {
+		t.Errorf("Must deny until the previous window slides out, got %+v", res)
+	}
+
+	if item := s.items["api"]; item.expiration != window+3*second {
+		t.Error("Must expire once both windows are over", item.expiration-window)
+	}
+	if _, err := s.rateLimitLocked("api", RateLimiter{Algorithm: RateTokenBucket, Limit: 10, Period: time.Second}, 1, window+second); err != ErrWrongType {
+		t.Error("Must refuse the state of another algorithm", err)
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// ScanOptions select a range of keys in lexicographical order. Start and End
+// are inclusive bounds and may be combined with Prefix. Cursor is the last key
+// of the previous page.
+type ScanOptions struct {
+	Prefix  string
+	Start   string
+	End     string
+	Reverse bool
+	Limit   int // 0 means no limit
+	Cursor  string
+}
+
+// prefixEnd returns the smallest string greater than every key with prefix,
+// or "" when there is none.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+func (o *ScanOptions) tooLow(key string) bool {
+	return key < o.Start || key < o.Prefix
+}
+
+func (o *ScanOptions) tooHigh(key string) bool {
+	if o.End != "" && key > o.End {
+		return true
+	}
+	return o.Prefix != "" && !strings.HasPrefix(key, o.Prefix) && key > o.Prefix
+}
+
+// Done reports whether key is past the range of o in the scan direction,
+// so no later key can belong to the page.
+func (o *ScanOptions) Done(key string) bool {
+	if o.Reverse {
+		return o.tooLow(key)
+	}
+	return o.tooHigh(key)
+}
+
+// SeekKey returns where an ordered walk over the keys may start: forward
+// scans at the first key >= SeekKey, reverse scans at the last key <=
+// SeekKey, or at the last key when it is empty. Keys not contained in the
+// page may follow, they are skipped with Contains.
+func (o *ScanOptions) SeekKey() string {
+	if !o.Reverse {
+		from := o.Start
+		if o.Prefix > from {
+			from = o.Prefix
+		}
+		if o.Cursor > from {
+			from = o.Cursor
+		}
+		return from
+	}
+	to := o.End
+	for _, bound := range []string{prefixEnd(o.Prefix), o.Cursor} {
+		if bound != "" && (to == "" || bound < to) {
+			to = bound
+		}
+	}
+	return to
+}
+
+func minNode(a, b *skipNode) *skipNode {
+	if a == nil || b == nil {
+		return nil
+	}
+	if b.key < a.key {
+		return b
+	}
+	return a
+}
+
+func maxNode(a, b *skipNode) *skipNode {
+	if a == nil || b == nil {
+		return nil
+	}
+	if b.key > a.key {
+		return b
+	}
+	return a
+}
+
+// scanStart returns the first node to visit, narrowing the range by every
+// bound that applies to the scan direction.
+func (s *Storage) scanStart(opts *ScanOptions) *skipNode {
+	if !opts.Reverse {
+		from := opts.Start
+		if opts.Prefix > from {
+			from = opts.Prefix
+		}
+		node := s.index.seek(from)
+		if opts.Cursor != "" {
+			node = maxNode(node, s.index.seekAfter(opts.Cursor))
+		}
+		return node
+	}
+
+	node := s.index.last()
+	if opts.End != "" {
+		node = minNode(node, s.index.seekLast(opts.End))
+	}
+	if end := prefixEnd(opts.Prefix); end != "" {
+		node = minNode(node, s.index.seekBefore(end))
+	}
+	if opts.Cursor != "" {
+		node = minNode(node, s.index.seekBefore(opts.Cursor))
+	}
+	return node
+}
+
+// Contains reports whether key belongs to the page selected by o.
+func (o *ScanOptions) Contains(key string) bool {
+	if o.tooLow(key) || o.tooHigh(key) {
+		return false
+	}
+	if o.Cursor != "" {
+		if o.Reverse {
+			return key < o.Cursor
+		}
+		return key > o.Cursor
+	}
+	return true
+}
+
+// Scan returns the keys selected by opts and the cursor of the next page,
+// which is empty when there are no more keys.
+func (s *Storage) Scan(opts ScanOptions) ([]string, string, error) {
+	now := time.Now().UnixNano()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys, cursor := s.scanLocked(opts, func(key string) bool {
+		return !s.items[key].expired(now)
+	})
+	return keys, cursor, nil
+}
+
+// scanLocked walks the ordered index over the range selected by opts and
+// collects the keys accepted by keep.
+func (s *Storage) scanLocked(opts ScanOptions, keep func(key string) bool) ([]string, string) {
+	keys := []string{}
+	for node := s.scanStart(&opts); node != nil; {
+		if opts.Done(node.key) {
+			break
+		}
+		if opts.Limit > 0 && len(keys) == opts.Limit {
+			return keys, keys[len(keys)-1]
+		}
+
+		if keep(node.key) {
+			keys = append(keys, node.key)
+		}
+
+		if opts.Reverse {
+			node = node.prev
+		} else {
+			node = node.next[0]
+		}
+	}
+	return keys, ""
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newUsers(n int) *Storage {
+	s := New()
+	for _, i := range rand.Perm(n) {
+		s.SetInt(fmt.Sprintf("user:%04d", i), i, 0)
+	}
+	s.SetString("order:2026-10:1", "a", 0)
+	s.SetString("order:2026-10:2", "b", 0)
+	s.SetString("order:2026-11:1", "c", 0)
+	return s
+}
+
+func equalKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStorage_Keys_Sorted(t *testing.T) {
+	s := newUsers(100)
+	s.Remove("user:0050")
+
+	keys, _ := s.Keys()
+	if len(keys) != 102 {
+		t.Fatal("Must be equal 102", len(keys))
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Error("Must be sorted", keys)
+	}
+}
+
+func TestStorage_Scan_Range(t *testing.T) {
+	s := newUsers(3000)
+
+	keys, cursor, _ := s.Scan(ScanOptions{Start: "user:1000", End: "user:2000"})
+	if len(keys) != 1001 || keys[0] != "user:1000" || keys[1000] != "user:2000" {
+		t.Error("Must return user:1000 through user:2000", len(keys))
+	}
+	if cursor != "" {
+		t.Error("Must be the last page", cursor)
+	}
+
+	keys, _, _ = s.Scan(ScanOptions{Start: "user:1000", End: "user:2000", Reverse: true, Limit: 3})
+	if !equalKeys(keys, []string{"user:2000", "user:1999", "user:1998"}) {
+		t.Error("Must return last keys first", keys)
+	}
+
+	keys, _, _ = s.Scan(ScanOptions{Start: "user:1000a", End: "user:1002"})
+	if !equalKeys(keys, []string{"user:1001", "user:1002"}) {
+		t.Error("Must start after user:1000a", keys)
+	}
+}
+
+func TestStorage_Scan_Prefix(t *testing.T) {
+	s := newUsers(10)
+
+	keys, _, _ := s.Scan(ScanOptions{Prefix: "order:2026-10:"})
+	if !equalKeys(keys, []string{"order:2026-10:1", "order:2026-10:2"}) {
+		t.Error("Must return keys with prefix", keys)
+	}
+
+	keys, _, _ = s.Scan(ScanOptions{Prefix: "order:", Reverse: true})
+	if !equalKeys(keys, []string{"order:2026-11:1", "order:2026-10:2", "order:2026-10:1"}) {
+		t.Error("Must return keys with prefix in reverse", keys)
+	}
+
+	keys, _, _ = s.Scan(ScanOptions{Prefix: "user:", Start: "user:0005", End: "user:0006"})
+	if !equalKeys(keys, []string{"user:0005", "user:0006"}) {
+		t.Error("Must combine prefix and range", keys)
+	}
+
+	keys, _, _ = s.Scan(ScanOptions{Prefix: "missing:"})
+	if len(keys) != 0 {
+		t.Error("Must be empty", keys)
+	}
+}
+
+func TestStorage_Scan_Cursor(t *testing.T) {
+	s := newUsers(25)
+
+	for _, reverse := range []bool{false, true} {
+		all := []string{}
+		opts := ScanOptions{Prefix: "user:", Limit: 10, Reverse: reverse}
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatal("Too many pages")
+			}
+			keys, cursor, _ := s.Scan(opts)
+			all = append(all, keys...)
+			if cursor == "" {
+				break
+			}
+			opts.Cursor = cursor
+		}
+
+		if len(all) != 25 {
+			t.Fatal("Must return every key once", len(all))
+		}
+		sorted := sort.SliceIsSorted(all, func(i, j int) bool {
+			return all[i] < all[j] != reverse
+		})
+		if !sorted || !strings.HasPrefix(all[0], "user:") {
+			t.Error("Must be in order", reverse, all)
+		}
+	}
+}
+
+func TestStorage_Scan_Expired(t *testing.T) {
+	s := New()
+	s.SetString("a", "1", 0)
+	s.SetString("b", "2", 0)
+	s.items["b"].expiration = 1
+
+	keys, _, _ := s.Scan(ScanOptions{})
+	if !equalKeys(keys, []string{"a"}) {
+		t.Error("Must skip expired keys", keys)
+	}
+
+	s.DeleteExpired()
+	if s.index.length != 1 {
+		t.Error("Must be removed from the index", s.index.length)
+	}
+}
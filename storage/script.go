@@ -0,0 +1,1023 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scripts run several commands atomically: Storage.Eval holds the write lock
+// for the whole script, and a script that fails or runs out of time leaves
+// no writes behind. The language is a small lisp:
+//
+//	; move n from one counter to another
+//	(let ((n (int (nth ARGV 0))))
+//	  (if (< (or (get (nth KEYS 0)) 0) n)
+//	    (error "not enough")
+//	    (do (incr (nth KEYS 0) (- n))
+//	        (incr (nth KEYS 1) n))))
+//
+// Values are nil, true and false, integers, strings, lists and dicts. KEYS
+// and ARGV are lists of strings. Special forms are if, do, let, set!, while,
+// and, or; every other form calls a builtin, see scriptBuiltins. The value
+// of the last form is the result of the script.
+const (
+	maxScriptDepth = 256     // nesting of forms
+	maxScriptValue = 1 << 20 // elements of lists and bytes of strings built by scripts
+	scriptCheck    = 1024    // forms evaluated between time checks
+)
+
+var (
+	ErrScriptTimeout = errors.New("Script exceeded its time limit")
+	errScriptDepth   = errors.New("Script is nested too deeply")
+	errScriptValue   = errors.New("Script value is too large")
+)
+
+// ScriptError is a syntax or runtime error of a script. Errors raised with
+// the error builtin are runtime errors. Err is the storage error a builtin
+// failed with, if any.
+type ScriptError struct {
+	Line    int
+	Message string
+	Syntax  bool
+	Err     error
+}
+
+func (e *ScriptError) Error() string {
+	if e.Syntax {
+		return fmt.Sprintf("Script syntax error at line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("Script error at line %d: %s", e.Line, e.Message)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	nodeLiteral byte = iota
+	nodeSymbol
+	nodeList
+)
+
+type scriptNode struct {
+	kind  byte
+	value interface{} // literal value or symbol name
+	list  []*scriptNode
+	line  int
+}
+
+// Script is a compiled script, safe for concurrent use.
+type Script struct {
+	sha  string
+	body []*scriptNode
+}
+
+// SHA returns the hex encoded SHA-1 of the source of the script, which
+// identifies it.
+func (sc *Script) SHA() string {
+	return sc.sha
+}
+
+// ScriptSHA returns the SHA of the script with source src.
+func ScriptSHA(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// CompileScript parses src.
+func CompileScript(src string) (*Script, error) {
+	p := &scriptParser{src: src, line: 1}
+	sc := &Script{sha: ScriptSHA(src)}
+	for {
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			break
+		}
+		n, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		sc.body = append(sc.body, n)
+	}
+	if len(sc.body) == 0 {
+		return nil, &ScriptError{Line: 1, Message: "empty script", Syntax: true}
+	}
+	return sc, nil
+}
+
+type scriptParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *scriptParser) errorf(format string, args ...interface{}) error {
+	return &ScriptError{Line: p.line, Message: fmt.Sprintf(format, args...), Syntax: true}
+}
+
+func (p *scriptParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '\n':
+			p.line++
+		case c == ';':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		case c != ' ' && c != '\t' && c != '\r':
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *scriptParser) parse(depth int) (*scriptNode, error) {
+	if depth > maxScriptDepth {
+		return nil, p.errorf("%v", errScriptDepth)
+	}
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return nil, p.errorf("unexpected end of script")
+	}
+	n := &scriptNode{line: p.line}
+	switch p.src[p.pos] {
+	case '(':
+		p.pos++
+		n.kind, n.list = nodeList, []*scriptNode{}
+		for {
+			p.skipSpace()
+			if p.pos == len(p.src) {
+				return nil, p.errorf("missing )")
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				return n, nil
+			}
+			child, err := p.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, child)
+		}
+	case ')':
+		return nil, p.errorf("unexpected )")
+	case '"':
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		n.kind, n.value = nodeLiteral, s
+		return n, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n();\"", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	token := p.src[start:p.pos]
+	if i, err := strconv.Atoi(token); err == nil {
+		n.kind, n.value = nodeLiteral, i
+		return n, nil
+	}
+	switch token {
+	case "nil":
+		n.kind, n.value = nodeLiteral, nil
+	case "true", "false":
+		n.kind, n.value = nodeLiteral, token == "true"
+	default:
+		if c := token[0]; c >= '0' && c <= '9' {
+			return nil, p.errorf("bad number %s", token)
+		}
+		n.kind, n.value = nodeSymbol, token
+	}
+	return n, nil
+}
+
+func (p *scriptParser) parseString() (string, error) {
+	var b strings.Builder
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\n':
+			p.line++
+		case '\\':
+			if p.pos == len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case '"', '\\':
+				c = e
+			default:
+				return "", p.errorf("unknown escape \\%c", e)
+			}
+			p.pos++
+		}
+		b.WriteByte(c)
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// Eval runs sc under the write lock of the storage. When it fails, the
+// keys it wrote get their previous values back.
+func (s *Storage) Eval(sc *Script, keys, args []string, timeout time.Duration) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in := &interp{
+		s:       s,
+		now:     time.Now().UnixNano(),
+		written: make(map[string]*Item),
+		scopes: []map[string]interface{}{{
+			"KEYS": stringList(keys),
+			"ARGV": stringList(args),
+		}},
+	}
+	if timeout > 0 {
+		in.deadline = time.Now().Add(timeout)
+	}
+	var result interface{}
+	var err error
+	for _, n := range sc.body {
+		if result, err = in.eval(n); err != nil {
+			in.rollback()
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func stringList(strs []string) []interface{} {
+	list := make([]interface{}, len(strs))
+	for i, s := range strs {
+		list[i] = s
+	}
+	return list
+}
+
+type interp struct {
+	s        *Storage
+	now      int64
+	deadline time.Time
+	steps    int
+	scopes   []map[string]interface{}
+	// written has the item of every key written by the script as it was
+	// before, nil if the key did not exist.
+	written map[string]*Item
+	line    int
+}
+
+func (in *interp) errorf(format string, args ...interface{}) error {
+	return &ScriptError{Line: in.line, Message: fmt.Sprintf(format, args...)}
+}
+
+func (in *interp) rollback() {
+	for key, old := range in.written {
+		if old == nil {
+			in.s.deleteLocked(key)
+		} else {
+			in.s.putLocked(key, old.copyOut())
+		}
+	}
+}
+
+func (in *interp) lookup(name string) (interface{}, error) {
+	for i := len(in.scopes) - 1; i >= 0; i-- {
+		if v, ok := in.scopes[i][name]; ok {
+			return v, nil
+		}
+	}
+	return nil, in.errorf("unknown variable %s", name)
+}
+
+func (in *interp) eval(n *scriptNode) (interface{}, error) {
+	if in.steps++; in.steps%scriptCheck == 0 && !in.deadline.IsZero() && time.Now().After(in.deadline) {
+		return nil, ErrScriptTimeout
+	}
+	in.line = n.line
+	switch n.kind {
+	case nodeLiteral:
+		return n.value, nil
+	case nodeSymbol:
+		return in.lookup(n.value.(string))
+	}
+	if len(n.list) == 0 {
+		return nil, in.errorf("empty form")
+	}
+	head := n.list[0]
+	if head.kind != nodeSymbol {
+		return nil, in.errorf("form must start with a name")
+	}
+	name, rest := head.value.(string), n.list[1:]
+	if form, ok := scriptForms[name]; ok {
+		return form(in, n, rest)
+	}
+	fn, ok := scriptBuiltins[name]
+	if !ok {
+		return nil, in.errorf("unknown function %s", name)
+	}
+	args := make([]interface{}, len(rest))
+	for i, arg := range rest {
+		v, err := in.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	in.line = n.line
+	if fn.min > len(args) || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, in.errorf("wrong number of arguments for %s", name)
+	}
+	v, err := fn.fn(in, args)
+	if err != nil {
+		if _, ok := err.(*ScriptError); !ok {
+			err = &ScriptError{Line: n.line, Message: name + ": " + err.Error(), Err: err}
+		}
+	}
+	return v, err
+}
+
+func (in *interp) evalBody(body []*scriptNode) (interface{}, error) {
+	var v interface{}
+	for _, n := range body {
+		var err error
+		if v, err = in.eval(n); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func truthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+type scriptForm func(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error)
+
+var scriptForms map[string]scriptForm
+
+func init() {
+	scriptForms = map[string]scriptForm{
+		"if":    formIf,
+		"do":    func(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) { return in.evalBody(args) },
+		"let":   formLet,
+		"set!":  formSet,
+		"while": formWhile,
+		"and":   formAnd,
+		"or":    formOr,
+	}
+}
+
+// (if cond then [else])
+func formIf(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, in.errorf("if takes a condition, a then and an optional else form")
+	}
+	cond, err := in.eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return in.eval(args[1])
+	}
+	if len(args) == 3 {
+		return in.eval(args[2])
+	}
+	return nil, nil
+}
+
+// (let ((name value) ...) body...)
+func formLet(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) {
+	if len(args) == 0 || args[0].kind != nodeList {
+		return nil, in.errorf("let takes a list of bindings")
+	}
+	scope := make(map[string]interface{})
+	for _, b := range args[0].list {
+		if b.kind != nodeList || len(b.list) != 2 || b.list[0].kind != nodeSymbol {
+			return nil, in.errorf("let bindings are (name value)")
+		}
+		v, err := in.eval(b.list[1])
+		if err != nil {
+			return nil, err
+		}
+		scope[b.list[0].value.(string)] = v
+	}
+	in.scopes = append(in.scopes, scope)
+	defer func() { in.scopes = in.scopes[:len(in.scopes)-1] }()
+	return in.evalBody(args[1:])
+}
+
+// (set! name value)
+func formSet(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) {
+	if len(args) != 2 || args[0].kind != nodeSymbol {
+		return nil, in.errorf("set! takes a variable and a value")
+	}
+	name := args[0].value.(string)
+	if _, err := in.lookup(name); err != nil {
+		return nil, err
+	}
+	v, err := in.eval(args[1])
+	if err != nil {
+		return nil, err
+	}
+	for i := len(in.scopes) - 1; i >= 0; i-- {
+		if _, ok := in.scopes[i][name]; ok {
+			in.scopes[i][name] = v
+			break
+		}
+	}
+	return v, nil
+}
+
+// (while cond body...)
+func formWhile(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, in.errorf("while takes a condition")
+	}
+	for {
+		cond, err := in.eval(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(cond) {
+			return nil, nil
+		}
+		if _, err := in.evalBody(args[1:]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// (and form...) returns the first false value or the last one.
+func formAnd(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) {
+	var v interface{} = true
+	for _, arg := range args {
+		var err error
+		if v, err = in.eval(arg); err != nil || !truthy(v) {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// (or form...) returns the first true value or the last one.
+func formOr(in *interp, n *scriptNode, args []*scriptNode) (interface{}, error) {
+	var v interface{}
+	for _, arg := range args {
+		var err error
+		if v, err = in.eval(arg); err != nil || truthy(v) {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+type scriptBuiltin struct {
+	min, max int // number of arguments, max -1 for any
+	fn       func(in *interp, args []interface{}) (interface{}, error)
+}
+
+var scriptBuiltins map[string]scriptBuiltin
+
+func init() {
+	scriptBuiltins = map[string]scriptBuiltin{
+		// Arithmetic and comparison
+		"+":   {1, -1, arith(func(a, b int) (int, error) { return a + b, nil })},
+		"-":   {1, -1, builtinMinus},
+		"*":   {1, -1, arith(func(a, b int) (int, error) { return a * b, nil })},
+		"/":   {2, -1, arith(divide(false))},
+		"%":   {2, -1, arith(divide(true))},
+		"=":   {2, 2, func(in *interp, a []interface{}) (interface{}, error) { return reflect.DeepEqual(a[0], a[1]), nil }},
+		"!=":  {2, 2, func(in *interp, a []interface{}) (interface{}, error) { return !reflect.DeepEqual(a[0], a[1]), nil }},
+		"<":   {2, 2, compare(func(c int) bool { return c < 0 })},
+		"<=":  {2, 2, compare(func(c int) bool { return c <= 0 })},
+		">":   {2, 2, compare(func(c int) bool { return c > 0 })},
+		">=":  {2, 2, compare(func(c int) bool { return c >= 0 })},
+		"not": {1, 1, func(in *interp, a []interface{}) (interface{}, error) { return !truthy(a[0]), nil }},
+
+		// Values
+		"str":    {0, -1, builtinStr},
+		"int":    {1, 1, builtinInt},
+		"type":   {1, 1, func(in *interp, a []interface{}) (interface{}, error) { return scriptType(a[0]), nil }},
+		"len":    {1, 1, builtinLen},
+		"list":   {0, -1, func(in *interp, a []interface{}) (interface{}, error) { return a, nil }},
+		"nth":    {2, 2, builtinNth},
+		"append": {1, -1, builtinAppend},
+		"dict":   {0, -1, builtinDict},
+		"dget":   {2, 2, builtinDget},
+		"dset":   {3, 3, builtinDset},
+		"error":  {1, 1, func(in *interp, a []interface{}) (interface{}, error) { return nil, in.errorf("%s", display(a[0])) }},
+
+		// Storage
+		"get":    {1, 1, builtinGet},
+		"set":    {2, 3, builtinSet},
+		"del":    {0, -1, builtinDel},
+		"exists": {1, 1, builtinExists},
+		"ttl":    {1, 1, builtinTTL},
+		"expire": {2, 2, builtinExpire},
+		"incr":   {1, 2, builtinIncr},
+		"keys":   {0, 1, builtinKeys},
+	}
+}
+
+func scriptType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "dict"
+	}
+	return "unknown"
+}
+
+func display(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+func intArg(v interface{}) (int, error) {
+	i, ok := v.(int)
+	if !ok {
+		return 0, fmt.Errorf("expected int, got %s", scriptType(v))
+	}
+	return i, nil
+}
+
+func stringArg(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected string, got %s", scriptType(v))
+	}
+	return s, nil
+}
+
+// arith folds op over int arguments.
+func arith(op func(a, b int) (int, error)) func(in *interp, args []interface{}) (interface{}, error) {
+	return func(in *interp, args []interface{}) (interface{}, error) {
+		ints := make([]int, len(args))
+		for i, arg := range args {
+			var err error
+			if ints[i], err = intArg(arg); err != nil {
+				return nil, err
+			}
+		}
+		r := ints[0]
+		for _, b := range ints[1:] {
+			var err error
+			if r, err = op(r, b); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	}
+}
+
+// (- n) negates n, (- a b...) subtracts.
+func builtinMinus(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) == 1 {
+		n, err := intArg(args[0])
+		return -n, err
+	}
+	return arith(func(a, b int) (int, error) { return a - b, nil })(in, args)
+}
+
+func divide(mod bool) func(a, b int) (int, error) {
+	return func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if mod {
+			return a % b, nil
+		}
+		return a / b, nil
+	}
+}
+
+func compare(ok func(int) bool) func(in *interp, args []interface{}) (interface{}, error) {
+	return func(in *interp, args []interface{}) (interface{}, error) {
+		switch a := args[0].(type) {
+		case int:
+			if b, isInt := args[1].(int); isInt {
+				switch {
+				case a < b:
+					return ok(-1), nil
+				case a > b:
+					return ok(1), nil
+				}
+				return ok(0), nil
+			}
+		case string:
+			if b, isString := args[1].(string); isString {
+				return ok(strings.Compare(a, b)), nil
+			}
+		}
+		return nil, fmt.Errorf("cannot compare %s and %s", scriptType(args[0]), scriptType(args[1]))
+	}
+}
+
+func builtinStr(in *interp, args []interface{}) (interface{}, error) {
+	var b strings.Builder
+	for _, arg := range args {
+		b.WriteString(display(arg))
+		if b.Len() > maxScriptValue {
+			return nil, errScriptValue
+		}
+	}
+	return b.String(), nil
+}
+
+func builtinInt(in *interp, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case int:
+		return v, nil
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", v)
+		}
+		return i, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to int", scriptType(args[0]))
+}
+
+func builtinLen(in *interp, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return 0, nil
+	case string:
+		return len(v), nil
+	case []interface{}:
+		return len(v), nil
+	case map[string]interface{}:
+		return len(v), nil
+	}
+	return nil, fmt.Errorf("%s has no length", scriptType(args[0]))
+}
+
+// (nth list i) returns element i counting from 0, or nil past the end.
+func builtinNth(in *interp, args []interface{}) (interface{}, error) {
+	list, ok := args[0].([]interface{})
+	if !ok && args[0] != nil {
+		return nil, fmt.Errorf("expected list, got %s", scriptType(args[0]))
+	}
+	i, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= len(list) {
+		return nil, nil
+	}
+	return list[i], nil
+}
+
+// (append list value...) returns a new list.
+func builtinAppend(in *interp, args []interface{}) (interface{}, error) {
+	list, ok := args[0].([]interface{})
+	if !ok && args[0] != nil {
+		return nil, fmt.Errorf("expected list, got %s", scriptType(args[0]))
+	}
+	if len(list)+len(args)-1 > maxScriptValue {
+		return nil, errScriptValue
+	}
+	return append(append([]interface{}{}, list...), args[1:]...), nil
+}
+
+// (dict key value ...)
+func builtinDict(in *interp, args []interface{}) (interface{}, error) {
+	if len(args)%2 != 0 {
+		return nil, errors.New("expected keys and values")
+	}
+	d := make(map[string]interface{}, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		k, err := stringArg(args[i])
+		if err != nil {
+			return nil, err
+		}
+		d[k] = args[i+1]
+	}
+	return d, nil
+}
+
+func dictArg(v interface{}) (map[string]interface{}, error) {
+	d, ok := v.(map[string]interface{})
+	if !ok && v != nil {
+		return nil, fmt.Errorf("expected dict, got %s", scriptType(v))
+	}
+	return d, nil
+}
+
+func builtinDget(in *interp, args []interface{}) (interface{}, error) {
+	d, err := dictArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	k, err := stringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return d[k], nil
+}
+
+// (dset dict key value) returns a new dict.
+func builtinDset(in *interp, args []interface{}) (interface{}, error) {
+	d, err := dictArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	k, err := stringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(d) >= maxScriptValue {
+		return nil, errScriptValue
+	}
+	c := make(map[string]interface{}, len(d)+1)
+	for key, v := range d {
+		c[key] = v
+	}
+	c[k] = args[2]
+	return c, nil
+}
+
+// itemValue converts a stored item to a script value.
+func itemValue(item *Item) (interface{}, error) {
+	switch item.kind() {
+	case kindString:
+		return item.String, nil
+	case kindInt:
+		return item.Int, nil
+	case kindStringSlice:
+		return stringList(item.StringSlice), nil
+	case kindIntSlice:
+		list := make([]interface{}, len(item.IntSlice))
+		for i, v := range item.IntSlice {
+			list[i] = v
+		}
+		return list, nil
+	case kindStringMap:
+		d := make(map[string]interface{}, len(item.StringMap))
+		for k, v := range item.StringMap {
+			d[k] = v
+		}
+		return d, nil
+	case kindIntMap:
+		d := make(map[string]interface{}, len(item.IntMap))
+		for k, v := range item.IntMap {
+			d[k] = v
+		}
+		return d, nil
+	}
+	return nil, ErrWrongType
+}
+
+// valueItem converts a script value to an item: strings, ints, lists and
+// dicts of either strings or ints.
+func valueItem(v interface{}) (*Item, error) {
+	item := new(Item)
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil, errors.New("cannot store an empty string")
+		}
+		item.String = v
+	case int:
+		item.Int = v
+		item.containsNil = v == 0
+	case []interface{}:
+		if len(v) > 0 {
+			if _, ok := v[0].(int); ok {
+				item.IntSlice = make([]int, len(v))
+				for i, e := range v {
+					n, ok := e.(int)
+					if !ok {
+						return nil, errors.New("lists must hold only strings or only ints")
+					}
+					item.IntSlice[i] = n
+				}
+				return item, nil
+			}
+		}
+		item.StringSlice = make([]string, len(v))
+		for i, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, errors.New("lists must hold only strings or only ints")
+			}
+			item.StringSlice[i] = s
+		}
+	case map[string]interface{}:
+		ints, strs := make(map[string]int), make(map[string]string)
+		for k, e := range v {
+			switch e := e.(type) {
+			case int:
+				ints[k] = e
+			case string:
+				strs[k] = e
+			default:
+				return nil, errors.New("dicts must hold only strings or only ints")
+			}
+		}
+		switch {
+		case len(ints) > 0 && len(strs) > 0:
+			return nil, errors.New("dicts must hold only strings or only ints")
+		case len(ints) > 0:
+			item.IntMap = ints
+		default:
+			item.StringMap = strs
+		}
+	default:
+		return nil, fmt.Errorf("cannot store %s", scriptType(v))
+	}
+	return item, nil
+}
+
+func (in *interp) item(key interface{}) (string, *Item, error) {
+	k, err := stringArg(key)
+	if err != nil {
+		return "", nil, err
+	}
+	item := in.s.liveLocked(k, in.now)
+	if item != nil {
+		item.touch(in.now)
+	}
+	return k, item, nil
+}
+
+// expiration is like calculateExpiration, as of the start of the script.
+func (in *interp) expiration(ttl int) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return in.now + int64(ttl)*int64(time.Second)
+}
+
+// put stores item, remembering the previous one for rollback.
+func (in *interp) put(key string, item *Item) error {
+	if err := in.s.limits.Check(key, item); err != nil {
+		return err
+	}
+	in.remember(key)
+	in.s.putLocked(key, item)
+	return nil
+}
+
+func (in *interp) remember(key string) {
+	if _, ok := in.written[key]; !ok {
+		in.written[key] = in.s.items[key]
+	}
+}
+
+// (get key) returns the value of key, nil if it does not exist.
+func builtinGet(in *interp, args []interface{}) (interface{}, error) {
+	_, item, err := in.item(args[0])
+	if err != nil || item == nil {
+		return nil, err
+	}
+	return itemValue(item.unpack())
+}
+
+// (set key value [ttl]) stores value with a ttl in seconds, without one by
+// default. nil removes the key.
+func builtinSet(in *interp, args []interface{}) (interface{}, error) {
+	key, err := stringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if args[1] == nil {
+		return builtinDel(in, args[:1])
+	}
+	item, err := valueItem(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 3 {
+		ttl, err := intArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+		item.expiration = in.expiration(ttl)
+	}
+	return args[1], in.put(key, item)
+}
+
+// (del key...) returns the number of keys removed.
+func builtinDel(in *interp, args []interface{}) (interface{}, error) {
+	n := 0
+	for _, arg := range args {
+		key, item, err := in.item(arg)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			in.remember(key)
+			in.s.deleteLocked(key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func builtinExists(in *interp, args []interface{}) (interface{}, error) {
+	_, item, err := in.item(args[0])
+	return item != nil, err
+}
+
+// (ttl key) returns the ttl in seconds, -1 without one, -2 for missing keys.
+func builtinTTL(in *interp, args []interface{}) (interface{}, error) {
+	_, item, err := in.item(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return -2, nil
+	}
+	return ttlSeconds(item.expiration, in.now), nil
+}
+
+// (expire key ttl) returns false if key does not exist.
+func builtinExpire(in *interp, args []interface{}) (interface{}, error) {
+	key, item, err := in.item(args[0])
+	if err != nil || item == nil {
+		return false, err
+	}
+	ttl, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	item = item.copyOut()
+	item.expiration = in.expiration(ttl)
+	return true, in.put(key, item)
+}
+
+// (incr key [by]) adds by, 1 by default, to the int of key, 0 if it does
+// not exist, keeping its ttl. It returns the new value.
+func builtinIncr(in *interp, args []interface{}) (interface{}, error) {
+	key, item, err := in.item(args[0])
+	if err != nil {
+		return nil, err
+	}
+	by := 1
+	if len(args) == 2 {
+		if by, err = intArg(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	next := new(Item)
+	if item != nil {
+		if item.kind() != kindInt {
+			return nil, ErrWrongType
+		}
+		next.Int, next.expiration = item.Int, item.expiration
+	}
+	next.Int += by
+	next.containsNil = next.Int == 0
+	return next.Int, in.put(key, next)
+}
+
+// (keys [prefix]) returns the existing keys in order.
+func builtinKeys(in *interp, args []interface{}) (interface{}, error) {
+	prefix := ""
+	if len(args) == 1 {
+		var err error
+		if prefix, err = stringArg(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	keys := []interface{}{}
+	for node := in.s.index.seek(prefix); node != nil && strings.HasPrefix(node.key, prefix); node = node.next[0] {
+		if in.s.liveLocked(node.key, in.now) != nil {
+			if len(keys) == maxScriptValue {
+				return nil, errScriptValue
+			}
+			keys = append(keys, node.key)
+		}
+	}
+	return keys, nil
+}
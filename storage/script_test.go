@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func eval(t *testing.T, s *Storage, src string, keys, args []string) (interface{}, error) {
+	t.Helper()
+	sc, err := CompileScript(src)
+	if err != nil {
+		t.Fatal("CompileScript:", err)
+	}
+	return s.Eval(sc, keys, args, time.Second)
+}
+
+func TestScript_Values(t *testing.T) {
+	s := New()
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`(+ 1 2 3)`, 6},
+		{`(- 5)`, -5},
+		{`(/ 7 2)`, 3},
+		{`(str "a" 1 nil)`, "a1nil"},
+		{`(int "42")`, 42},
+		{`(if (< 1 2) "yes" "no")`, "yes"},
+		{`(if false 1)`, nil},
+		{`(and 1 nil 2)`, nil},
+		{`(or nil false 2)`, 2},
+		{`(let ((x 1) (y 2)) (set! x (+ x y)) x)`, 3},
+		{`(let ((i 0) (l (list))) (while (< i 3) (set! l (append l i)) (set! i (+ i 1))) l)`, []interface{}{0, 1, 2}},
+		{`(nth (list "a" "b") 1)`, "b"},
+		{`(nth (list "a") 5)`, nil},
+		{`(dget (dset (dict "a" 1) "b" 2) "b")`, 2},
+		{`(len "abc")`, 3},
+		{`(= (list 1 "a") (list 1 "a"))`, true},
+		{"; comment\n(type (dict))", "dict"},
+		{`(nth KEYS 0) (nth ARGV 1)`, "y"},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, s, tt.src, []string{"k"}, []string{"x", "y"})
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s must be %v, got %v, %v", tt.src, tt.want, got, err)
+		}
+	}
+}
+
+func TestScript_Errors(t *testing.T) {
+	for _, src := range []string{``, `(+ 1`, `)`, `"abc`, `(a))`, `12ab`} {
+		var scriptErr *ScriptError
+		if _, err := CompileScript(src); !errors.As(err, &scriptErr) || !scriptErr.Syntax {
+			t.Errorf("%q must be a syntax error, got %v", src, err)
+		}
+	}
+	s := New()
+	for _, src := range []string{`(unknown)`, `x`, `(+ 1 "a")`, `(/ 1 0)`, `(error "boom")`, `(1 2)`, `(set "k" true)`} {
+		var scriptErr *ScriptError
+		if _, err := eval(t, s, src, nil, nil); !errors.As(err, &scriptErr) || scriptErr.Syntax {
+			t.Errorf("%q must be a runtime error, got %v", src, err)
+		}
+	}
+	_, err := eval(t, s, "(do\n(error \"boom\"))", nil, nil)
+	if scriptErr, ok := err.(*ScriptError); !ok || scriptErr.Line != 2 || scriptErr.Message != "boom" {
+		t.Error("Must report the line and message", err)
+	}
+}
+
+func TestScript_Storage(t *testing.T) {
+	s := New()
+	s.SetInt("from", 10, 0)
+	s.SetString("name", "x", 100)
+	transfer := `
+		(let ((n (int (nth ARGV 0))))
+		  (if (< (or (get (nth KEYS 0)) 0) n)
+		    (error "not enough")
+		    (do (incr (nth KEYS 0) (- n))
+		        (incr (nth KEYS 1) n))))`
+	if got, err := eval(t, s, transfer, []string{"from", "to"}, []string{"4"}); err != nil || got != 4 {
+		t.Fatal("Must run the script", got, err)
+	}
+	if v, _ := s.GetInt("from"); v != 6 {
+		t.Error("Must decrement from", v)
+	}
+	if v, _ := s.GetInt("to"); v != 4 {
+		t.Error("Must increment to", v)
+	}
+
+	got, err := eval(t, s, `(set "list" (list "a" "b") 50) (set "dict" (dict "k" 1)) (list (get "list") (get "dict") (ttl "list") (ttl "name") (exists "nope") (keys "l"))`, nil, nil)
+	want := []interface{}{
+		[]interface{}{"a", "b"}, map[string]interface{}{"k": 1}, 50, 100, false, []interface{}{"list"},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Error("Must read and write values", got, err)
+	}
+	if item, _ := s.Get("dict"); item == nil || item.IntMap["k"] != 1 {
+		t.Error("Must store dicts", item)
+	}
+	if got, _ := eval(t, s, `(del "list" "nope")`, nil, nil); got != 1 {
+		t.Error("Must count removed keys", got)
+	}
+	if got, _ := eval(t, s, `(expire "name" 0) (ttl "name")`, nil, nil); got != -1 {
+		t.Error("Must change the ttl", got)
+	}
+}
+
+func TestScript_Rollback(t *testing.T) {
+	s := New()
+	s.SetInt("from", 3, 0)
+	s.SetString("keep", "old", 0)
+	_, err := eval(t, s, `(incr "from" -5) (set "keep" "new") (set "created" 1) (del "from") (error "abort")`, nil, nil)
+	if err == nil {
+		t.Fatal("Must fail")
+	}
+	if v, ok := s.GetInt("from"); !ok || v != 3 {
+		t.Error("Must restore changed keys", v, ok)
+	}
+	if v, _ := s.GetString("keep"); v != "old" {
+		t.Error("Must restore overwritten keys", v)
+	}
+	if item, _ := s.Get("created"); item != nil {
+		t.Error("Must remove created keys", item)
+	}
+
+	s.SetLimits(Limits{MaxValueSize: 3})
+	if _, err := eval(t, s, `(set "a" "12") (set "b" "1234")`, nil, nil); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Must check the limits", err)
+	}
+	if item, _ := s.Get("a"); item != nil {
+		t.Error("Must not keep writes of failed scripts", item)
+	}
+}
+
+func TestScript_Timeout(t *testing.T) {
+	s := New()
+	sc, _ := CompileScript(`(set "k" 1) (while true (incr "k"))`)
+	start := time.Now()
+	if _, err := s.Eval(sc, nil, nil, 50*time.Millisecond); err != ErrScriptTimeout {
+		t.Fatal("Must stop scripts running too long", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error("Must stop soon after the limit", d)
+	}
+	if item, _ := s.Get("k"); item != nil {
+		t.Error("Must roll back timed out scripts", item)
+	}
+	if err := s.SetString("other", "v", 0); err != nil {
+		t.Error("Must release the lock", err)
+	}
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	IndexString = "string" // indexes a StringMap field
+	IndexInt    = "int"    // indexes an IntMap field
+)
+
+var (
+	ErrIndexExists   = errors.New("Index already exists")
+	ErrIndexNotFound = errors.New("Index does not exist")
+)
+
+// IndexSpec declares a secondary index over Field of the map values stored
+// under keys starting with Prefix.
+type IndexSpec struct {
+	Name   string
+	Prefix string
+	Field  string
+	Type   string // IndexString or IndexInt, IndexString when empty
+}
+
+// secondaryIndex keeps "value, key" entries in order. Entries are encoded so
+// that byte order matches (value, key) order: 0x00 bytes of the value are
+// escaped as 0x00 0xff and the value is terminated by 0x00 0x01.
+type secondaryIndex struct {
+	spec    IndexSpec
+	entries *skipList
+	byKey   map[string]string // key -> entry
+}
+
+func escapeIndexValue(value []byte) string {
+	var b strings.Builder
+	for _, c := range value {
+		b.WriteByte(c)
+		if c == 0x00 {
+			b.WriteByte(0xff)
+		}
+	}
+	b.WriteString("\x00\x01")
+	return b.String()
+}
+
+// encodeIndexInt maps v to 8 bytes whose byte order matches numeric order.
+func encodeIndexInt(v int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v)^(1<<63))
+	return b
+}
+
+func (idx *secondaryIndex) encodeBound(value string) (string, error) {
+	if idx.spec.Type == IndexInt {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("Index %s holds integers, got %q", idx.spec.Name, value)
+		}
+		return escapeIndexValue(encodeIndexInt(i)), nil
+	}
+	return escapeIndexValue([]byte(value)), nil
+}
+
+// entry returns the encoded entry of item under key, if the item has the field.
+func (idx *secondaryIndex) entry(key string, item *Item) (string, bool) {
+	if !strings.HasPrefix(key, idx.spec.Prefix) {
+		return "", false
+	}
+	if idx.spec.Type == IndexInt {
+		v, ok := item.IntMap[idx.spec.Field]
+		if !ok {
+			return "", false
+		}
+		return escapeIndexValue(encodeIndexInt(v)) + key, true
+	}
+	v, ok := item.StringMap[idx.spec.Field]
+	if !ok {
+		return "", false
+	}
+	return escapeIndexValue([]byte(v)) + key, true
+}
+
+func (idx *secondaryIndex) remove(key string) {
+	if old, ok := idx.byKey[key]; ok {
+		idx.entries.remove(old)
+		delete(idx.byKey, key)
+	}
+}
+
+func (idx *secondaryIndex) put(key string, item *Item) {
+	idx.remove(key)
+	if e, ok := idx.entry(key, item); ok {
+		idx.entries.insert(e)
+		idx.byKey[key] = e
+	}
+}
+
+func (idx *secondaryIndex) build(items map[string]*Item) {
+	idx.entries = newSkipList()
+	idx.byKey = make(map[string]string)
+	for key, item := range items {
+		idx.put(key, item.unpack())
+	}
+}
+
+// CreateIndex declares a secondary index and builds it from the stored items.
+// Indexes are not persisted: declaring them once the data is loaded, as the
+// server does on startup, rebuilds them.
+func (s *Storage) CreateIndex(spec IndexSpec) error {
+	if spec.Type == "" {
+		spec.Type = IndexString
+	}
+	if spec.Name == "" || spec.Field == "" {
+		return errors.New("Index name and field are required")
+	}
+	if spec.Type != IndexString && spec.Type != IndexInt {
+		return fmt.Errorf("Unsupported index type: %s", spec.Type)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.indexes[spec.Name]; ok {
+		return ErrIndexExists
+	}
+	idx := &secondaryIndex{spec: spec}
+	idx.build(s.items)
+	s.indexes[spec.Name] = idx
+	return nil
+}
+
+func (s *Storage) DropIndex(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.indexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(s.indexes, name)
+	return nil
+}
+
+func (s *Storage) Indexes() []IndexSpec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	specs := make([]IndexSpec, 0, len(s.indexes))
+	for _, idx := range s.indexes {
+		specs = append(specs, idx.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// Lookup returns the keys whose indexed field equals value, which may be
+// empty, ordered by key.
+func (s *Storage) Lookup(name, value string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.indexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	bound, err := idx.encodeBound(value)
+	if err != nil {
+		return nil, err
+	}
+	return s.lookupLocked(idx, bound, prefixEnd(bound), 0), nil
+}
+
+// LookupRange returns the keys whose indexed field is between min and max
+// inclusive, ordered by value then key. Empty bounds are open. Int indexes
+// expect decimal bounds.
+func (s *Storage) LookupRange(name, min, max string, limit int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.indexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	var from, to string
+	var err error
+	if min != "" {
+		if from, err = idx.encodeBound(min); err != nil {
+			return nil, err
+		}
+	}
+	if max != "" {
+		if to, err = idx.encodeBound(max); err != nil {
+			return nil, err
+		}
+		// Every entry with value max starts with the encoded bound.
+		to = prefixEnd(to)
+	}
+	return s.lookupLocked(idx, from, to, limit), nil
+}
+
+// lookupLocked returns up to limit live keys of the entries of idx from
+// from up to to, exclusive; an empty to is open and a limit of 0 means no
+// limit. Keys expired but not deleted yet are skipped. s.mu must be held.
+func (s *Storage) lookupLocked(idx *secondaryIndex, from, to string, limit int) []string {
+	now := time.Now().UnixNano()
+	keys := []string{}
+	for node := idx.entries.seek(from); node != nil; node = node.next[0] {
+		if to != "" && node.key >= to {
+			break
+		}
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		key := node.key[strings.Index(node.key, "\x00\x01")+2:]
+		if !s.items[key].expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
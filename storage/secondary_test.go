@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func newUserRecords() *Storage {
+	s := New()
+	s.SetStringMap("user:1", map[string]string{"country": "DE", "name": "Anna"}, 0)
+	s.SetStringMap("user:2", map[string]string{"country": "FR", "name": "Paul"}, 0)
+	s.SetStringMap("user:3", map[string]string{"country": "DE", "name": "Jonas"}, 0)
+	s.SetStringMap("order:1", map[string]string{"country": "DE"}, 0)
+	s.SetIntMap("user:4", map[string]int{"age": 30}, 0)
+	s.SetIntMap("user:5", map[string]int{"age": -5}, 0)
+	s.SetIntMap("user:6", map[string]int{"age": 300}, 0)
+	return s
+}
+
+func TestStorage_CreateIndex(t *testing.T) {
+	s := newUserRecords()
+
+	if err := s.CreateIndex(IndexSpec{Name: "country", Prefix: "user:", Field: "country"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateIndex(IndexSpec{Name: "country", Prefix: "user:", Field: "country"}); err != ErrIndexExists {
+		t.Error("Must return ErrIndexExists", err)
+	}
+	if err := s.CreateIndex(IndexSpec{Name: "bad", Field: "x", Type: "float"}); err == nil {
+		t.Error("Must reject unknown type")
+	}
+
+	keys, err := s.Lookup("country", "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalKeys(keys, []string{"user:1", "user:3"}) {
+		t.Error("Must find users from DE", keys)
+	}
+
+	if _, err := s.Lookup("missing", "DE"); err != ErrIndexNotFound {
+		t.Error("Must return ErrIndexNotFound", err)
+	}
+
+	if len(s.Indexes()) != 1 {
+		t.Error("Must be equal 1", s.Indexes())
+	}
+	s.DropIndex("country")
+	if len(s.Indexes()) != 0 {
+		t.Error("Must be dropped", s.Indexes())
+	}
+}
+
+func TestStorage_Index_Maintained(t *testing.T) {
+	s := newUserRecords()
+	s.CreateIndex(IndexSpec{Name: "country", Prefix: "user:", Field: "country"})
+
+	s.SetStringMap("user:2", map[string]string{"country": "DE"}, 0)
+	s.SetStringMap("user:7", map[string]string{"country": "DE"}, 0)
+	s.SetString("user:3", "overwritten", 0)
+	s.Remove("user:1")
+
+	keys, _ := s.Lookup("country", "DE")
+	if !equalKeys(keys, []string{"user:2", "user:7"}) {
+		t.Error("Must follow updates and removals", keys)
+	}
+	if keys, _ := s.Lookup("country", "FR"); len(keys) != 0 {
+		t.Error("Must drop the old value", keys)
+	}
+
+	s.items["user:7"].expiration = 1
+	s.DeleteExpired()
+	keys, _ = s.Lookup("country", "DE")
+	if !equalKeys(keys, []string{"user:2"}) {
+		t.Error("Must drop expired keys", keys)
+	}
+}
+
+func TestStorage_LookupRange(t *testing.T) {
+	s := newUserRecords()
+	s.CreateIndex(IndexSpec{Name: "age", Prefix: "user:", Field: "age", Type: IndexInt})
+	s.CreateIndex(IndexSpec{Name: "name", Prefix: "user:", Field: "name"})
+
+	keys, err := s.LookupRange("age", "-10", "100", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalKeys(keys, []string{"user:5", "user:4"}) {
+		t.Error("Must be ordered numerically", keys)
+	}
+
+	keys, _ = s.LookupRange("age", "", "", 0)
+	if !equalKeys(keys, []string{"user:5", "user:4", "user:6"}) {
+		t.Error("Must return everything", keys)
+	}
+
+	keys, _ = s.LookupRange("age", "30", "", 1)
+	if !equalKeys(keys, []string{"user:4"}) {
+		t.Error("Must apply limit", keys)
+	}
+
+	if _, err := s.LookupRange("age", "abc", "", 0); err == nil {
+		t.Error("Must reject non integer bounds")
+	}
+
+	keys, _ = s.LookupRange("name", "Anna", "Jonas", 0)
+	if !equalKeys(keys, []string{"user:1", "user:3"}) {
+		t.Error("Must be ordered by value", keys)
+	}
+}
+
+func TestStorage_LookupEmptyValue(t *testing.T) {
+	s := newUserRecords()
+	s.SetStringMap("user:7", map[string]string{"country": ""}, 0)
+	s.CreateIndex(IndexSpec{Name: "country", Prefix: "user:", Field: "country"})
+
+	if keys, _ := s.Lookup("country", ""); !equalKeys(keys, []string{"user:7"}) {
+		t.Error("Must only find the empty value", keys)
+	}
+	if keys, _ := s.LookupRange("country", "", "", 0); len(keys) != 4 {
+		t.Error("Must treat empty bounds as open", keys)
+	}
+}
+
+func TestStorage_LookupSkipsExpired(t *testing.T) {
+	s := newUserRecords()
+	s.CreateIndex(IndexSpec{Name: "country", Prefix: "user:", Field: "country"})
+	s.CreateIndex(IndexSpec{Name: "age", Prefix: "user:", Field: "age", Type: IndexInt})
+
+	// Expired, not deleted yet
+	s.items["user:1"].expiration = time.Now().UnixNano() - 1
+	s.items["user:4"].expiration = time.Now().UnixNano() - 1
+	if keys, _ := s.Lookup("country", "DE"); !equalKeys(keys, []string{"user:3"}) {
+		t.Error("Must skip expired keys", keys)
+	}
+	if keys, _ := s.LookupRange("age", "", "", 1); !equalKeys(keys, []string{"user:5"}) {
+		t.Error("Must skip expired keys", keys)
+	}
+	if keys, _ := s.LookupRange("age", "0", "", 1); !equalKeys(keys, []string{"user:6"}) {
+		t.Error("Must not count expired keys in the limit", keys)
+	}
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+type skipNode struct {
+	key  string
+	prev *skipNode // level 0 only, nil for the first node
+	next []*skipNode
+}
+
+// skipList keeps the keys of a Storage in lexicographical order.
+type skipList struct {
+	head   *skipNode
+	level  int
+	length int
+	rnd    *rand.Rand
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+func (l *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && l.rnd.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// findPrev fills update with the last node before key on every level.
+func (l *skipList) findPrev(key string, update []*skipNode) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x
+}
+
+func (l *skipList) insert(key string) {
+	update := make([]*skipNode, skipListMaxLevel)
+	x := l.findPrev(key, update)
+	if x.next[0] != nil && x.next[0].key == key {
+		return
+	}
+
+	level := l.randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	node := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	if update[0] != l.head {
+		node.prev = update[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	}
+	l.length++
+}
+
+func (l *skipList) remove(key string) {
+	update := make([]*skipNode, skipListMaxLevel)
+	x := l.findPrev(key, update).next[0]
+	if x == nil || x.key != key {
+		return
+	}
+
+	for i := 0; i < l.level && update[i].next[i] == x; i++ {
+		update[i].next[i] = x.next[i]
+	}
+	if x.next[0] != nil {
+		x.next[0].prev = x.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+}
+
+// seek returns the first node with a key >= key.
+func (l *skipList) seek(key string) *skipNode {
+	return l.findPrev(key, nil).next[0]
+}
+
+// seekLast returns the last node with a key <= key.
+func (l *skipList) seekLast(key string) *skipNode {
+	x := l.findPrev(key, nil)
+	if x.next[0] != nil && x.next[0].key == key {
+		return x.next[0]
+	}
+	if x == l.head {
+		return nil
+	}
+	return x
+}
+
+// seekAfter returns the first node with a key > key.
+func (l *skipList) seekAfter(key string) *skipNode {
+	x := l.seek(key)
+	if x != nil && x.key == key {
+		return x.next[0]
+	}
+	return x
+}
+
+// seekBefore returns the last node with a key < key.
+func (l *skipList) seekBefore(key string) *skipNode {
+	x := l.findPrev(key, nil)
+	if x == l.head {
+		return nil
+	}
+	return x
+}
+
+func (l *skipList) first() *skipNode {
+	return l.head.next[0]
+}
+
+func (l *skipList) last() *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil {
+			x = x.next[i]
+		}
+	}
+	if x == l.head {
+		return nil
+	}
+	return x
+}
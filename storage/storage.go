@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,21 +18,57 @@ type Item struct {
 	IntSlice    []int
 	StringMap   map[string]string
 	IntMap      map[string]int
+	Bitmap      []byte
+	Geo         *GeoSet
+	TimeSeries  *TimeSeries
+	CRDT        *CRDT
 
 	containsNil    bool   // for Int = 0
+	packed         []byte // compressed encoding replacing the value fields
+	packedKind     byte   // kind of the packed value
+	size           int    // estimated memory, see memorySize
 
 	expiration     int64
+	rev            int64  // revision of the write that stored the item
+	created        int64  // unix time in nanoseconds the key was created
+	updated        int64  // unix time in nanoseconds of the write
+	access         *accessStats
 }
 
 type Storage struct {
+	id         int64
 	mu         *sync.RWMutex
 	items      map[string]*Item
+	index      *skipList
+	random     *keySet
+	indexes    map[string]*secondaryIndex
+
+	rev            int64
+	snapshots      map[int64]*snapshotState
+	lastSnapshotID int64
+	history        map[string][]version
+	compression    Compression
+	hot            map[string]bool // keys updated in place and not packed yet
+	memory         memoryTotals
+	limits         Limits
+
+	node           string // id CRDTs are written under, see SetNodeID
+	crdtTag        uint64 // last orset tag
 }
 
 func New() *Storage {
 	return &Storage{
+		id:    atomic.AddInt64(&lastStorageID, 1),
 		mu:    new(sync.RWMutex),
 		items: make(map[string]*Item),
+		index: newSkipList(),
+		random: newKeySet(),
+		indexes: make(map[string]*secondaryIndex),
+		snapshots: make(map[int64]*snapshotState),
+		history: make(map[string][]version),
+		hot: make(map[string]bool),
+		node: newNodeID(),
+		crdtTag: uint64(time.Now().UnixNano()),
 	}
 }
 
@@ -43,65 +80,205 @@ func calculateExpiration(ttl int) int64 {
 	return exp
 }
 
-func newItem(ttl int) *Item {
+// NewItem returns an empty item expiring in ttl seconds, ttl <= 0 means it
+// never expires.
+func NewItem(ttl int) *Item {
 	item := new(Item)
 	item.expiration = calculateExpiration(ttl)
 	return item
 }
 
-func (s *Storage) setItem(key string, item *Item) {
-	s.mu.Lock()
+// Expiration returns the unix time in nanoseconds the item expires at, or 0.
+func (item *Item) Expiration() int64 {
+	return item.expiration
+}
+
+// SetTTL makes the item expire in ttl seconds, ttl <= 0 removes the expiry.
+func (item *Item) SetTTL(ttl int) {
+	item.expiration = calculateExpiration(ttl)
+}
+
+func (item *Item) expired(now int64) bool {
+	return item.expiration > 0 && item.expiration <= now
+}
+
+// copyOut returns a copy of a stored item that callers may keep and modify
+// without affecting the storage. Stored items are only modified under s.mu
+// and are never handed out.
+func (item *Item) copyOut() *Item {
+	if item == nil || item.packed != nil {
+		return item.unpack()
+	}
+	return item.clone()
+}
+
+// clone returns a deep copy of the item.
+func (item *Item) clone() *Item {
+	c := *item
+	if item.StringSlice != nil {
+		c.StringSlice = append([]string{}, item.StringSlice...)
+	}
+	if item.IntSlice != nil {
+		c.IntSlice = append([]int{}, item.IntSlice...)
+	}
+	if item.StringMap != nil {
+		c.StringMap = make(map[string]string, len(item.StringMap))
+		for k, v := range item.StringMap {
+			c.StringMap[k] = v
+		}
+	}
+	if item.IntMap != nil {
+		c.IntMap = make(map[string]int, len(item.IntMap))
+		for k, v := range item.IntMap {
+			c.IntMap[k] = v
+		}
+	}
+	if item.Bitmap != nil {
+		c.Bitmap = append([]byte{}, item.Bitmap...)
+	}
+	if item.Geo != nil {
+		c.Geo = item.Geo.clone()
+	}
+	if item.TimeSeries != nil {
+		c.TimeSeries = item.TimeSeries.clone()
+	}
+	if item.CRDT != nil {
+		c.CRDT = item.CRDT.clone()
+	}
+	return &c
+}
+
+// putLocked, updateLocked and deleteLocked are the only places where items
+// change, so structures derived from the keyspace are kept in sync. s.mu
+// must be held.
+func (s *Storage) putLocked(key string, item *Item) {
+	s.storeLocked(key, item, true)
+}
+
+// updateLocked stores an item changed in place, like a bitmap by SetBit.
+// It is not packed until it stays idle for packIdle, so a run of small
+// changes does not decompress and compress the whole value every time.
+func (s *Storage) updateLocked(key string, item *Item) {
+	s.storeLocked(key, item, false)
+}
+
+func (s *Storage) storeLocked(key string, item *Item, pack bool) {
+	s.rev++
+	old, ok := s.items[key]
+	if !ok {
+		s.index.insert(key)
+		s.random.add(key)
+	} else {
+		if old != item {
+			s.archiveLocked(key, old, s.rev)
+		}
+		s.accountLocked(old, -1)
+	}
+	for _, idx := range s.indexes {
+		idx.put(key, item)
+	}
+	if pack {
+		item = s.packLocked(item)
+		delete(s.hot, key)
+	} else if s.compression.enabled() && item.packed == nil {
+		s.hot[key] = true
+	}
+	item.rev = s.rev
+	stampLocked(item, old, time.Now().UnixNano())
+	item.size = memorySize(key, item)
+	s.accountLocked(item, 1)
 	s.items[key] = item
-	s.mu.Unlock()
 }
 
-func (s *Storage) SetString(key, value string, ttl int) {
+func (s *Storage) deleteLocked(key string) {
+	if old, ok := s.items[key]; ok {
+		s.rev++
+		s.archiveLocked(key, old, s.rev)
+		s.accountLocked(old, -1)
+		delete(s.items, key)
+		delete(s.hot, key)
+		s.index.remove(key)
+		s.random.remove(key)
+		for _, idx := range s.indexes {
+			idx.remove(key)
+		}
+	}
+}
+
+// setItem stores a copy of item, so callers keep ownership of the slices
+// and maps they pass in.
+func (s *Storage) setItem(key string, item *Item) error {
+	item = item.clone()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.limits.Check(key, item); err != nil {
+		return err
+	}
+	s.putLocked(key, item)
+	return nil
+}
+
+func (s *Storage) SetString(key, value string, ttl int) error {
 	if value != "" {
-		item := newItem(ttl)
+		item := NewItem(ttl)
 		item.String = value
-		s.setItem(key, item)
+		return s.setItem(key, item)
 	}
+	return nil
 }
 
-func (s *Storage) SetInt(key string, value, ttl int) {
-	item := newItem(ttl)
+func (s *Storage) SetInt(key string, value, ttl int) error {
+	item := NewItem(ttl)
 	item.Int = value
 	if value == 0 {
 		item.containsNil = true
 	}
-	s.setItem(key, item)
+	return s.setItem(key, item)
 }
 
-func (s *Storage) SetStringSlice(key string, value []string, ttl int) {
+func (s *Storage) SetStringSlice(key string, value []string, ttl int) error {
 	if value != nil && len(value) > 0 {
-		item := newItem(ttl)
+		item := NewItem(ttl)
 		item.StringSlice = value
-		s.setItem(key, item)
+		return s.setItem(key, item)
 	}
+	return nil
 }
 
-func (s *Storage) SetIntSlice(key string, value []int, ttl int) {
+func (s *Storage) SetIntSlice(key string, value []int, ttl int) error {
 	if value != nil && len(value) > 0 {
-		item := newItem(ttl)
+		item := NewItem(ttl)
 		item.IntSlice = value
-		s.setItem(key, item)
+		return s.setItem(key, item)
 	}
+	return nil
 }
 
-func (s *Storage) SetStringMap(key string, value map[string]string, ttl int) {
+func (s *Storage) SetStringMap(key string, value map[string]string, ttl int) error {
 	if value != nil && len(value) > 0 {
-		item := newItem(ttl)
+		item := NewItem(ttl)
 		item.StringMap = value
-		s.setItem(key, item)
+		return s.setItem(key, item)
 	}
+	return nil
 }
 
-func (s *Storage) SetIntMap(key string, value map[string]int, ttl int) {
+func (s *Storage) SetIntMap(key string, value map[string]int, ttl int) error {
 	if value != nil && len(value) > 0 {
-		item := newItem(ttl)
+		item := NewItem(ttl)
 		item.IntMap = value
-		s.setItem(key, item)
+		return s.setItem(key, item)
 	}
+	return nil
+}
+
+func (s *Storage) SetBitmap(key string, value []byte, ttl int) error {
+	if value != nil && len(value) > 0 {
+		item := NewItem(ttl)
+		item.Bitmap = value
+		return s.setItem(key, item)
+	}
+	return nil
 }
 
 func (s *Storage) Set(key string, value interface{}, ttl int) {
@@ -135,27 +312,48 @@ func (s *Storage) Set(key string, value interface{}, ttl int) {
 			return
 		}
 	default:
-		fmt.Println("Value is %v %T", value, value)
+		fmt.Printf("Value is %v %T\n", value, value)
 		return
 	}
 
-	s.items[key] = item
+	if s.limits.Check(key, item) != nil {
+		return
+	}
+	s.putLocked(key, item.clone())
 }
 
 
+// GetItem returns a copy of the item stored under key, or nil. Unlike Get it
+// also returns expired items not deleted yet.
 func (s *Storage) GetItem(key string) *Item {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.items[key]
+	item := s.items[key]
+	item.touch(time.Now().UnixNano())
+	return item.copyOut()
 }
 
 
+// Get returns the item stored under key, or nil if it is missing or expired.
+func (s *Storage) Get(key string) (*Item, error) {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item := s.items[key]
+	if item == nil || item.expired(now) {
+		return nil, nil
+	}
+	item.touch(now)
+	return item.copyOut(), nil
+}
+
 func (s *Storage) GetString(key string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	item := s.items[key]
 	if item != nil {
-		return item.String, true
+		item.touch(time.Now().UnixNano())
+		return item.unpack().String, true
 	}
 	return "", false
 }
@@ -165,6 +363,7 @@ func (s *Storage) GetInt(key string) (int, bool) {
 	defer s.mu.RUnlock()
 	item := s.items[key]
 	if item != nil {
+		item.touch(time.Now().UnixNano())
 		return item.Int, true
 	}
 	return 0, false
@@ -178,6 +377,8 @@ func (s *Storage) GetIntFromList(key string, idx int) (int, bool) {
 	if !ok {
 		return 0, false
 	}
+	item.touch(time.Now().UnixNano())
+	item = item.unpack()
 
 	if item.IntSlice != nil {
 		if 0 <= idx && idx < len(item.IntSlice) {
@@ -237,27 +438,71 @@ func (s *Storage) Remove(key string) error {
 	if !ok {
 		return fmt.Errorf("Key: %s does not exist", key)
 	}
-	delete(s.items, key)
+	s.deleteLocked(key)
 	return nil
 }
 
-func (s *Storage) Keys() []string {
+func (s *Storage) Keys() ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	keys := []string{}
-	for k := range s.items {
-		keys = append(keys, k)
+	keys := make([]string, 0, s.index.length)
+	for node := s.index.first(); node != nil; node = node.next[0] {
+		keys = append(keys, node.key)
 	}
-	return keys
+	return keys, nil
 }
 
-func (s *Storage) DeleteExpired() {
+func (s *Storage) DeleteExpired() error {
 	now := time.Now().UnixNano()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for k, item := range s.items {
-		if item.expiration > 0 && item.expiration <= now {
-			delete(s.items, k)
+		if item.expired(now) {
+			s.deleteLocked(k)
 		}
 	}
+	s.expireSnapshotsLocked()
+	s.packIdleLocked(now)
+	return nil
+}
+
+// Expire sets a new ttl in seconds on an existing key, ttl <= 0 removes it.
+func (s *Storage) Expire(key string, ttl int) error {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok || item.expired(now) {
+		return fmt.Errorf("Key: %s does not exist", key)
+	}
+	item = s.writableLocked(item)
+	item.SetTTL(ttl)
+	s.putLocked(key, item)
+	return nil
+}
+
+// TTL returns the remaining time to live of key in seconds, -1 if the key
+// does not expire and -2 if it does not exist.
+func (s *Storage) TTL(key string) (int, error) {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, ok := s.items[key]
+	if !ok || item.expired(now) {
+		return -2, nil
+	}
+	return ttlSeconds(item.expiration, now), nil
+}
+
+func ttlSeconds(expiration, now int64) int {
+	if expiration == 0 {
+		return -1
+	}
+	return int((expiration - now + int64(time.Second) - 1) / int64(time.Second))
+}
+
+// Close releases the resources of the storage. The in-memory storage has
+// nothing to release.
+func (s *Storage) Close() error {
+	return nil
 }
@@ -0,0 +1,481 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// A time series keeps samples sorted by timestamp, in milliseconds since the
+// epoch. With a retention, samples older than the newest one by more than
+// the retention are dropped. Rules downsample a series into others: every
+// bucket touched by TSAdd is aggregated again from the samples the source
+// still has and stored in the destination as one sample at the start of the
+// bucket. Buckets are aligned to the epoch.
+var (
+	ErrTSTimestamp   = errors.New("Timestamp must not be negative")
+	ErrTSValue       = errors.New("Sample value must be a finite number")
+	ErrTSTooOld      = errors.New("Sample is older than the retention of the series")
+	ErrTSRetention   = errors.New("Retention must not be negative")
+	ErrTSAggregation = errors.New("Unsupported aggregation. Please use avg, min, max, sum or count")
+	ErrTSBucket      = errors.New("Bucket duration must be positive")
+	ErrTSRule        = errors.New("Rule destination must be another series without rules")
+)
+
+var tsAggregations = map[string]bool{
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"sum":   true,
+	"count": true,
+}
+
+type Sample struct {
+	Time  int64 // milliseconds since the epoch
+	Value float64
+}
+
+// TSRule downsamples a series into Dest, one sample per Bucket
+// milliseconds.
+type TSRule struct {
+	Dest        string
+	Aggregation string
+	Bucket      int64
+}
+
+// TSQuery selects the samples from From to To inclusive, To 0 meaning no
+// upper bound. With an Aggregation the samples are aggregated over buckets
+// of Bucket milliseconds.
+type TSQuery struct {
+	From        int64
+	To          int64
+	Aggregation string
+	Bucket      int64
+}
+
+type TSInfo struct {
+	Retention int64 // milliseconds, 0 keeps samples forever
+	Samples   int
+	First     int64 // timestamps of the oldest and newest samples
+	Last      int64
+	Rules     []TSRule
+}
+
+type TimeSeries struct {
+	retention int64
+	samples   []Sample
+	rules     []TSRule
+}
+
+func (ts *TimeSeries) clone() *TimeSeries {
+	return &TimeSeries{
+		retention: ts.retention,
+		samples:   append([]Sample{}, ts.samples...),
+		rules:     append([]TSRule{}, ts.rules...),
+	}
+}
+
+func (ts *TimeSeries) Len() int {
+	return len(ts.samples)
+}
+
+// Samples returns a copy of the samples.
+func (ts *TimeSeries) Samples() []Sample {
+	return append([]Sample{}, ts.samples...)
+}
+
+func (ts *TimeSeries) search(t int64) int {
+	return sort.Search(len(ts.samples), func(i int) bool { return ts.samples[i].Time >= t })
+}
+
+// add inserts or replaces a sample and reports whether it is new.
+func (ts *TimeSeries) add(sample Sample) bool {
+	n := len(ts.samples)
+	if n == 0 || ts.samples[n-1].Time < sample.Time {
+		ts.samples = append(ts.samples, sample)
+		return true
+	}
+	i := ts.search(sample.Time)
+	if ts.samples[i].Time == sample.Time {
+		ts.samples[i] = sample
+		return false
+	}
+	ts.samples = append(ts.samples, Sample{})
+	copy(ts.samples[i+1:], ts.samples[i:])
+	ts.samples[i] = sample
+	return true
+}
+
+// last returns the timestamp of the newest sample, -1 without samples.
+func (ts *TimeSeries) last() int64 {
+	if len(ts.samples) == 0 {
+		return -1
+	}
+	return ts.samples[len(ts.samples)-1].Time
+}
+
+// cutoff returns the oldest timestamp kept once samples at times are
+// added.
+func (ts *TimeSeries) cutoff(times map[int64]bool) int64 {
+	if ts.retention == 0 {
+		return 0
+	}
+	newest := ts.last()
+	for t := range times {
+		if t > newest {
+			newest = t
+		}
+	}
+	return newest - ts.retention
+}
+
+// sizeAfter returns the number of samples once samples at times are added
+// and the series trimmed.
+func (ts *TimeSeries) sizeAfter(times map[int64]bool) int {
+	cutoff := ts.cutoff(times)
+	n := len(ts.samples) - ts.search(cutoff)
+	for t := range times {
+		if t < cutoff {
+			continue
+		}
+		if i := ts.search(t); i == len(ts.samples) || ts.samples[i].Time != t {
+			n++
+		}
+	}
+	return n
+}
+
+// trim drops the samples outside of the retention.
+func (ts *TimeSeries) trim() {
+	if ts.retention == 0 {
+		return
+	}
+	if i := ts.search(ts.last() - ts.retention); i > 0 {
+		ts.samples = ts.samples[i:]
+	}
+}
+
+// rule returns the index of the rule into dest, or -1.
+func (ts *TimeSeries) rule(dest string) int {
+	for i, r := range ts.rules {
+		if r.Dest == dest {
+			return i
+		}
+	}
+	return -1
+}
+
+func bucketStart(t, bucket int64) int64 {
+	return t - t%bucket
+}
+
+// aggregate returns the aggregation of a non-empty run of samples.
+func aggregate(aggregation string, samples []Sample) float64 {
+	switch aggregation {
+	case "count":
+		return float64(len(samples))
+	case "min":
+		min := samples[0].Value
+		for _, s := range samples[1:] {
+			min = math.Min(min, s.Value)
+		}
+		return min
+	case "max":
+		max := samples[0].Value
+		for _, s := range samples[1:] {
+			max = math.Max(max, s.Value)
+		}
+		return max
+	}
+	sum := 0.0
+	for _, s := range samples {
+		sum += s.Value
+	}
+	if aggregation == "avg" {
+		return sum / float64(len(samples))
+	}
+	return sum
+}
+
+func checkAggregation(aggregation string, bucket int64) error {
+	if !tsAggregations[aggregation] {
+		return ErrTSAggregation
+	}
+	if bucket <= 0 {
+		return ErrTSBucket
+	}
+	return nil
+}
+
+// tsItemLocked returns the live series of key unpacked, or nil.
+func (s *Storage) tsItemLocked(key string, now int64) (*Item, error) {
+	item := s.liveLocked(key, now)
+	if item == nil {
+		return nil, nil
+	}
+	item = item.unpack()
+	if item.TimeSeries == nil {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+// TSCreate creates an empty series with a retention in milliseconds, 0
+// keeping samples forever, or changes the retention of an existing one.
+func (s *Storage) TSCreate(key string, retention int64) error {
+	if retention < 0 {
+		return ErrTSRetention
+	}
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.tsItemLocked(key, now)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		if err := s.limits.CheckKey(key); err != nil {
+			return err
+		}
+		item = NewItem(0)
+		item.TimeSeries = &TimeSeries{}
+	} else {
+		item = s.writableLocked(item)
+	}
+	item.TimeSeries.retention = retention
+	item.TimeSeries.trim()
+	s.putLocked(key, item)
+	return nil
+}
+
+// TSAdd adds samples to the series of key, creating it if needed, and
+// returns the number of new timestamps: a sample with the timestamp of an
+// existing one replaces it. The rules of the series are applied to the
+// buckets of the samples. Samples outside of the retention are rejected
+// with ErrTSTooOld, and nothing is written then.
+func (s *Storage) TSAdd(key string, samples ...Sample) (int, error) {
+	for _, sample := range samples {
+		if sample.Time < 0 {
+			return 0, ErrTSTimestamp
+		}
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			return 0, ErrTSValue
+		}
+	}
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.tsItemLocked(key, now)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		if len(samples) == 0 {
+			return 0, nil
+		}
+		if err := s.limits.CheckKey(key); err != nil {
+			return 0, err
+		}
+		item = NewItem(0)
+		item.TimeSeries = &TimeSeries{}
+	}
+	ts := item.TimeSeries
+	times := make(map[int64]bool, len(samples))
+	for _, sample := range samples {
+		times[sample.Time] = true
+	}
+	if cutoff := ts.cutoff(times); cutoff > 0 {
+		for t := range times {
+			if t < cutoff {
+				return 0, ErrTSTooOld
+			}
+		}
+	}
+	if err := s.limits.checkElements(ts.sizeAfter(times)); err != nil {
+		return 0, err
+	}
+	dests := make([]*Item, len(ts.rules))
+	buckets := make([]map[int64]bool, len(ts.rules))
+	for i, r := range ts.rules {
+		if dests[i], err = s.tsItemLocked(r.Dest, now); err != nil {
+			return 0, err
+		}
+		if dests[i] == nil {
+			dests[i] = NewItem(0)
+			dests[i].TimeSeries = &TimeSeries{}
+		}
+		buckets[i] = make(map[int64]bool)
+		for t := range times {
+			buckets[i][bucketStart(t, r.Bucket)] = true
+		}
+		if err := s.limits.checkElements(dests[i].TimeSeries.sizeAfter(buckets[i])); err != nil {
+			return 0, err
+		}
+	}
+
+	item = s.writableLocked(item)
+	ts = item.TimeSeries
+	added := 0
+	for _, sample := range samples {
+		if ts.add(sample) {
+			added++
+		}
+	}
+	ts.trim()
+	s.updateLocked(key, item)
+	for i, r := range ts.rules {
+		dest := s.writableLocked(dests[i])
+		for start := range buckets[i] {
+			from, to := ts.search(start), ts.search(start+r.Bucket)
+			if from < to {
+				dest.TimeSeries.add(Sample{Time: start, Value: aggregate(r.Aggregation, ts.samples[from:to])})
+			}
+		}
+		dest.TimeSeries.trim()
+		s.updateLocked(r.Dest, dest)
+	}
+	return added, nil
+}
+
+// TSRange returns the samples selected by q, aggregated into one sample
+// per bucket at the start of the bucket if q has an aggregation.
+func (s *Storage) TSRange(key string, q TSQuery) ([]Sample, error) {
+	if q.Aggregation != "" {
+		if err := checkAggregation(q.Aggregation, q.Bucket); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.tsItemLocked(key, now)
+	if err != nil || item == nil {
+		return []Sample{}, err
+	}
+	item.touch(now)
+	ts := item.TimeSeries
+	from := ts.search(q.From)
+	to := len(ts.samples)
+	if q.To > 0 {
+		to = ts.search(q.To + 1)
+	}
+	if from >= to {
+		return []Sample{}, nil
+	}
+	if q.Aggregation == "" {
+		return append([]Sample{}, ts.samples[from:to]...), nil
+	}
+	result := []Sample{}
+	for i := from; i < to; {
+		start := bucketStart(ts.samples[i].Time, q.Bucket)
+		j := i + 1
+		for j < to && ts.samples[j].Time < start+q.Bucket {
+			j++
+		}
+		result = append(result, Sample{Time: start, Value: aggregate(q.Aggregation, ts.samples[i:j])})
+		i = j
+	}
+	return result, nil
+}
+
+// TSCreateRule downsamples the series of key into rule.Dest from now on,
+// replacing the rule into the same destination if any. The destination is
+// created if needed and must not have rules itself: samples written by
+// rules do not trigger further rules.
+func (s *Storage) TSCreateRule(key string, rule TSRule) error {
+	if err := checkAggregation(rule.Aggregation, rule.Bucket); err != nil {
+		return err
+	}
+	if rule.Dest == key {
+		return ErrTSRule
+	}
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.tsItemLocked(key, now)
+	if err != nil {
+		return err
+	}
+	dest, err := s.tsItemLocked(rule.Dest, now)
+	if err != nil {
+		return err
+	}
+	if dest != nil && len(dest.TimeSeries.rules) > 0 {
+		return ErrTSRule
+	}
+	if item == nil {
+		if err := s.limits.CheckKey(key); err != nil {
+			return err
+		}
+		item = NewItem(0)
+		item.TimeSeries = &TimeSeries{}
+	} else {
+		item = s.writableLocked(item)
+	}
+	if dest == nil {
+		if err := s.limits.CheckKey(rule.Dest); err != nil {
+			return err
+		}
+		dest = NewItem(0)
+		dest.TimeSeries = &TimeSeries{}
+		s.putLocked(rule.Dest, dest)
+	}
+	ts := item.TimeSeries
+	if i := ts.rule(rule.Dest); i >= 0 {
+		ts.rules[i] = rule
+	} else {
+		ts.rules = append(ts.rules, rule)
+	}
+	s.putLocked(key, item)
+	return nil
+}
+
+// TSDeleteRule stops downsampling the series of key into dest and reports
+// whether there was such a rule. The samples of dest are kept.
+func (s *Storage) TSDeleteRule(key, dest string) (bool, error) {
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.tsItemLocked(key, now)
+	if err != nil || item == nil {
+		return false, err
+	}
+	i := item.TimeSeries.rule(dest)
+	if i < 0 {
+		return false, nil
+	}
+	item = s.writableLocked(item)
+	ts := item.TimeSeries
+	ts.rules = append(ts.rules[:i:i], ts.rules[i+1:]...)
+	s.putLocked(key, item)
+	return true, nil
+}
+
+// TSInfo returns the retention, size and rules of the series of key, or nil
+// if it does not exist.
+func (s *Storage) TSInfo(key string) (*TSInfo, error) {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, err := s.tsItemLocked(key, now)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	ts := item.TimeSeries
+	info := &TSInfo{
+		Retention: ts.retention,
+		Samples:   len(ts.samples),
+		Rules:     append([]TSRule{}, ts.rules...),
+	}
+	if len(ts.samples) > 0 {
+		info.First, info.Last = ts.samples[0].Time, ts.last()
+	}
+	return info, nil
+}
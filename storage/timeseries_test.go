@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestStorage_TSAdd(t *testing.T) {
+	s := New()
+
+	added, err := s.TSAdd("temp", Sample{3000, 3}, Sample{1000, 1}, Sample{2000, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 {
+		t.Error("Must be equal 3", added)
+	}
+	if added, _ := s.TSAdd("temp", Sample{2000, 20}, Sample{4000, 4}); added != 1 {
+		t.Error("Must be equal 1", added)
+	}
+	want := []Sample{{1000, 1}, {2000, 20}, {3000, 3}, {4000, 4}}
+	if got := s.GetItem("temp").TimeSeries.Samples(); !reflect.DeepEqual(got, want) {
+		t.Error("Must be sorted with the sample replaced", got)
+	}
+
+	if _, err := s.TSAdd("temp", Sample{-1, 0}); err != ErrTSTimestamp {
+		t.Error("Must return ErrTSTimestamp", err)
+	}
+	if _, err := s.TSAdd("temp", Sample{5000, math.NaN()}); err != ErrTSValue {
+		t.Error("Must return ErrTSValue", err)
+	}
+	s.SetString("str", "val", 0)
+	if _, err := s.TSAdd("str", Sample{1, 1}); err != ErrWrongType {
+		t.Error("Must return ErrWrongType", err)
+	}
+	if item := s.GetItem("temp"); item.Type() != "timeseries" {
+		t.Error("Must be equal timeseries", item.Type())
+	}
+}
+
+func TestStorage_TSRetention(t *testing.T) {
+	s := New()
+	if err := s.TSCreate("temp", 1000); err != nil {
+		t.Fatal(err)
+	}
+	s.TSAdd("temp", Sample{1000, 1}, Sample{1500, 2}, Sample{2000, 3})
+	s.TSAdd("temp", Sample{2600, 4})
+	info, err := s.TSInfo("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Samples != 2 || info.First != 2000 || info.Last != 2600 {
+		t.Errorf("Must keep samples from 2000 to 2600, got %+v", info)
+	}
+
+	if _, err := s.TSAdd("temp", Sample{1000, 0}, Sample{3000, 5}); err != ErrTSTooOld {
+		t.Error("Must return ErrTSTooOld", err)
+	}
+	if info, _ := s.TSInfo("temp"); info.Last != 2600 {
+		t.Error("Must not write anything on error", info.Last)
+	}
+
+	if err := s.TSCreate("temp", 500); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := s.TSInfo("temp"); info.Samples != 1 || info.Retention != 500 {
+		t.Errorf("Must trim to the new retention, got %+v", info)
+	}
+	if err := s.TSCreate("temp", -1); err != ErrTSRetention {
+		t.Error("Must return ErrTSRetention", err)
+	}
+}
+
+func TestStorage_TSRange(t *testing.T) {
+	s := New()
+	for i := int64(0); i < 10; i++ {
+		s.TSAdd("temp", Sample{i * 100, float64(i)})
+	}
+
+	samples, err := s.TSRange("temp", TSQuery{From: 200, To: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Sample{{200, 2}, {300, 3}, {400, 4}}; !reflect.DeepEqual(samples, want) {
+		t.Error("Must return the samples from 200 to 400", samples)
+	}
+
+	tests := []struct {
+		aggregation string
+		want        []Sample
+	}{
+		{"avg", []Sample{{0, 1}, {300, 4}, {600, 7}, {900, 9}}},
+		{"sum", []Sample{{0, 3}, {300, 12}, {600, 21}, {900, 9}}},
+		{"min", []Sample{{0, 0}, {300, 3}, {600, 6}, {900, 9}}},
+		{"max", []Sample{{0, 2}, {300, 5}, {600, 8}, {900, 9}}},
+		{"count", []Sample{{0, 3}, {300, 3}, {600, 3}, {900, 1}}},
+	}
+	for _, tt := range tests {
+		samples, err := s.TSRange("temp", TSQuery{Aggregation: tt.aggregation, Bucket: 300})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(samples, tt.want) {
+			t.Error("Must aggregate by", tt.aggregation, samples)
+		}
+	}
+
+	if _, err := s.TSRange("temp", TSQuery{Aggregation: "median", Bucket: 300}); err != ErrTSAggregation {
+		t.Error("Must return ErrTSAggregation", err)
+	}
+	if _, err := s.TSRange("temp", TSQuery{Aggregation: "avg"}); err != ErrTSBucket {
+		t.Error("Must return ErrTSBucket", err)
+	}
+	if samples, err := s.TSRange("missing", TSQuery{}); err != nil || len(samples) != 0 {
+		t.Error("Must return no samples", samples, err)
+	}
+}
+
+func TestStorage_TSRules(t *testing.T) {
+	s := New()
+	if err := s.TSCreate("raw", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TSCreateRule("raw", TSRule{Dest: "avg", Aggregation: "avg", Bucket: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TSCreateRule("raw", TSRule{Dest: "max", Aggregation: "max", Bucket: 500}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.TSAdd("raw", Sample{100, 1}, Sample{600, 3})
+	s.TSAdd("raw", Sample{1200, 10})
+	s.TSAdd("raw", Sample{1400, 20}, Sample{2100, 5})
+
+	avg, _ := s.TSRange("avg", TSQuery{})
+	if want := []Sample{{0, 2}, {1000, 15}, {2000, 5}}; !reflect.DeepEqual(avg, want) {
+		t.Error("Must downsample to averages", avg)
+	}
+	max, _ := s.TSRange("max", TSQuery{})
+	if want := []Sample{{0, 1}, {500, 3}, {1000, 20}, {2000, 5}}; !reflect.DeepEqual(max, want) {
+		t.Error("Must downsample to maximums", max)
+	}
+	if info, _ := s.TSInfo("raw"); info.First != 1200 {
+		t.Error("Must keep the downsampled samples after the source dropped them", info.First)
+	}
+
+	if err := s.TSCreateRule("raw", TSRule{Dest: "raw", Aggregation: "avg", Bucket: 1}); err != ErrTSRule {
+		t.Error("Must return ErrTSRule", err)
+	}
+	if err := s.TSCreateRule("avg", TSRule{Dest: "raw", Aggregation: "avg", Bucket: 1}); err != ErrTSRule {
+		t.Error("Must refuse a destination with rules", err)
+	}
+	s.SetString("str", "val", 0)
+	if err := s.TSCreateRule("raw", TSRule{Dest: "str", Aggregation: "avg", Bucket: 1}); err != ErrWrongType {
+		t.Error("Must return ErrWrongType", err)
+	}
+
+	if ok, err := s.TSDeleteRule("raw", "max"); !ok || err != nil {
+		t.Error("Must delete the rule", ok, err)
+	}
+	if ok, _ := s.TSDeleteRule("raw", "max"); ok {
+		t.Error("Must not find the deleted rule")
+	}
+	s.TSAdd("raw", Sample{2200, 7})
+	if max, _ := s.TSRange("max", TSQuery{}); max[len(max)-1].Value != 5 {
+		t.Error("Must not downsample after the rule is deleted", max)
+	}
+	if info, _ := s.TSInfo("raw"); len(info.Rules) != 1 || info.Rules[0].Dest != "avg" {
+		t.Error("Must keep the other rule", info.Rules)
+	}
+}
+
+func TestStorage_TSLimits(t *testing.T) {
+	s := New()
+	s.SetLimits(Limits{MaxElements: 2})
+	s.TSCreate("raw", 15)
+	s.TSCreateRule("raw", TSRule{Dest: "down", Aggregation: "sum", Bucket: 10})
+
+	if _, err := s.TSAdd("raw", Sample{1, 1}, Sample{2, 1}, Sample{3, 1}); !errors.Is(err, ErrTooManyElements) {
+		t.Error("Must return ErrTooManyElements", err)
+	}
+	if _, err := s.TSAdd("raw", Sample{1, 1}, Sample{10, 1}); err != nil {
+		t.Fatal(err)
+	}
+	// raw keeps 2 samples within its retention, but down would have 3.
+	if _, err := s.TSAdd("raw", Sample{20, 1}); !errors.Is(err, ErrTooManyElements) {
+		t.Error("Must return ErrTooManyElements", err)
+	}
+	if info, _ := s.TSInfo("raw"); info.Last != 10 {
+		t.Error("Must not write the source on error", info.Last)
+	}
+	if down, _ := s.TSRange("down", TSQuery{}); len(down) != 2 {
+		t.Error("Must not write the destination on error", down)
+	}
+}